@@ -33,6 +33,9 @@ import (
 	"github.com/pterodactyl/wings/loggers/cli"
 	"github.com/pterodactyl/wings/remote"
 	"github.com/pterodactyl/wings/router"
+	"github.com/pterodactyl/wings/router/jobs"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/router/uploader"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/sftp"
 	"github.com/pterodactyl/wings/system"
@@ -134,6 +137,12 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 	if err := database.Initialize(); err != nil {
 		log.WithField("error", err).Fatal("failed to initialize database")
 	}
+	if err := tokens.LoadRevocations(cmd.Context()); err != nil {
+		log.WithField("error", err).Fatal("failed to load token revocations")
+	}
+	if err := jobs.MarkInterrupted(cmd.Context()); err != nil {
+		log.WithField("error", err).Error("failed to mark interrupted jobs as failed")
+	}
 
 	manager, err := server.NewManager(cmd.Context(), pclient)
 	if err != nil {
@@ -148,6 +157,10 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		log.WithField("error", err).Fatal("failed to write configuration to disk")
 	}
 
+	if err := uploader.Load(); err != nil {
+		log.WithField("error", err).Error("failed to load incomplete uploads from disk")
+	}
+
 	// Just for some nice log output.
 	for _, s := range manager.All() {
 		log.WithField("server", s.ID()).Info("finished loading configuration for server")
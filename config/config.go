@@ -53,6 +53,8 @@ var (
 	mu            sync.RWMutex
 	_config       *Configuration
 	_jwtAlgo      *jwt.HMACSHA
+	_jwtKeys      map[string]jwt.Algorithm
+	_networks     networks
 	_debugViaFlag bool
 )
 
@@ -68,6 +70,86 @@ type SftpConfiguration struct {
 	Port int `default:"2022" json:"bind_port" yaml:"bind_port"`
 	// If set to true, no write actions will be allowed on the SFTP server.
 	ReadOnly bool `default:"false" yaml:"read_only"`
+
+	// AllowedIPs is a list of IP addresses or CIDR ranges that are allowed to connect
+	// to the SFTP server. If empty, connections are accepted from any address.
+	AllowedIPs []string `json:"allowed_ips" yaml:"allowed_ips"`
+
+	// DeniedIPs is a list of IP addresses or CIDR ranges that are never allowed to
+	// connect to the SFTP server. This takes priority over AllowedIPs.
+	DeniedIPs []string `json:"denied_ips" yaml:"denied_ips"`
+
+	Limits SftpLimits `json:"limits" yaml:"limits"`
+
+	CredentialCache SftpCredentialCache `json:"credential_cache" yaml:"credential_cache"`
+}
+
+// SftpCredentialCache defines how successful SFTP logins are cached, which avoids
+// sending a request to the Panel every time the same user reconnects.
+type SftpCredentialCache struct {
+	// TTL is the amount of time in seconds that a successful login is reused for
+	// without asking the Panel to validate the credentials again. Set to 0 to disable
+	// caching entirely.
+	TTL int `default:"60" json:"ttl" yaml:"ttl"`
+
+	// StaleTTL is the amount of time in seconds that a cached login can still be used
+	// for if the Panel cannot be reached, allowing users to keep accessing their files
+	// during a Panel outage. Set to 0 to always require the Panel once the TTL passes.
+	StaleTTL int `default:"900" json:"stale_ttl" yaml:"stale_ttl"`
+
+	// Size is the maximum number of logins that are cached at once.
+	Size int `default:"1000" json:"size" yaml:"size"`
+}
+
+// SftpLimits defines the limits used to protect the SFTP server (and the Panel,
+// which validates every login attempt) from brute-force attempts and excessive
+// numbers of connections.
+type SftpLimits struct {
+	// MaxIPAttempts is the number of failed password attempts allowed from a single IP
+	// address within the AttemptWindow before that address is temporarily banned.
+	// Set to 0 to disable banning by IP address.
+	MaxIPAttempts int `default:"10" json:"max_ip_attempts" yaml:"max_ip_attempts"`
+
+	// MaxUserAttempts is the number of failed password attempts allowed for a single
+	// username within the AttemptWindow before that username is temporarily banned.
+	// Anyone that knows a username could use this to lock that user out of the SFTP
+	// server, so it is disabled by default. Set to 0 to disable banning by username.
+	MaxUserAttempts int `default:"0" json:"max_user_attempts" yaml:"max_user_attempts"`
+
+	// AttemptWindow is the amount of time in seconds that failed login attempts are
+	// counted for.
+	AttemptWindow int `default:"300" json:"attempt_window" yaml:"attempt_window"`
+
+	// BanDuration is the amount of time in seconds that an IP address or username is
+	// banned for after exceeding the number of allowed failed attempts.
+	BanDuration int `default:"900" json:"ban_duration" yaml:"ban_duration"`
+
+	// MaxSessionsPerUser is the maximum number of concurrent SFTP connections a single
+	// user can have open, across all servers. Set to 0 for no limit.
+	MaxSessionsPerUser int `default:"0" json:"max_sessions_per_user" yaml:"max_sessions_per_user"`
+
+	// MaxSessionsPerServer is the maximum number of concurrent SFTP connections that
+	// can be open for a single server, across all users. Set to 0 for no limit.
+	MaxSessionsPerServer int `default:"0" json:"max_sessions_per_server" yaml:"max_sessions_per_server"`
+}
+
+// ApiKey is an API key defined in the configuration file.
+type ApiKey struct {
+	// ID identifies the key in the logs and must be unique.
+	ID string `yaml:"id"`
+
+	// Token is the bearer token sent in the Authorization header of requests, and
+	// must not be empty.
+	Token string `yaml:"token"`
+
+	// Scopes are the groups of routes the key can access, any of "system.read",
+	// "power", "files" or "backups".
+	Scopes []string `yaml:"scopes"`
+
+	// Servers are the UUIDs of the servers the key can access. If empty the key can
+	// access every server, otherwise it can only access routes for these servers.
+	// Keys restricted to a set of servers cannot use the "backups" scope.
+	Servers []string `yaml:"servers"`
 }
 
 // ApiConfiguration defines the configuration for the internal API that is
@@ -91,13 +173,83 @@ type ApiConfiguration struct {
 	// servers.
 	DisableRemoteDownload bool `json:"-" yaml:"disable_remote_download"`
 
+	// Configuration for remote files pulled into server directories.
+	RemoteDownload RemoteDownloadConfiguration `json:"-" yaml:"remote_download"`
+
 	// The maximum size for files uploaded through the Panel in MB.
 	UploadLimit int64 `default:"100" json:"upload_limit" yaml:"upload_limit"`
 
+	// Keys are API keys that can be used instead of the node authentication token to
+	// access a limited set of routes, for example by monitoring or backup tools. Keys
+	// can also be created using the API, in which case they are stored in the database.
+	Keys []ApiKey `json:"-" yaml:"keys"`
+
+	// Configuration for resumable uploads made through the Panel, which allow
+	// files larger than the upload limit to be sent in multiple requests.
+	ResumableUploads ResumableUploadConfiguration `json:"resumable_uploads" yaml:"resumable_uploads"`
+
+	// The maximum size of a file in MB that can be hashed using the API. Set to 0 to
+	// allow files of any size to be hashed.
+	HashLimit int64 `default:"0" json:"hash_limit" yaml:"hash_limit"`
+
+	// The maximum size of each file in MB that can be compared using the API.
+	DiffLimit int64 `default:"2" json:"diff_limit" yaml:"diff_limit"`
+
 	// A list of IP address of proxies that may send a X-Forwarded-For header to set the true clients IP
 	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
 }
 
+// RemoteDownloadConfiguration defines the configuration for pulling remote files
+// into server directories.
+type RemoteDownloadConfiguration struct {
+	// The maximum number of remote downloads that can run at once across all the
+	// servers on this instance. Any additional downloads wait until an existing one
+	// has finished. Set to 0 to disable the limit.
+	MaxConcurrent int `default:"10" yaml:"max_concurrent"`
+
+	// The number of times a download is resumed after a network error or server
+	// error response before it is marked as failed.
+	MaxRetries int `default:"3" yaml:"max_retries"`
+
+	// The maximum size of a single remote download in MB. Set to 0 to only limit
+	// downloads by the disk space available to the server.
+	MaxSize int64 `default:"0" yaml:"max_size"`
+
+	// If set, files can only be downloaded from these domains, or any of their
+	// subdomains.
+	AllowedDomains []string `yaml:"allowed_domains"`
+
+	// Files can never be downloaded from these domains, or any of their subdomains.
+	DeniedDomains []string `yaml:"denied_domains"`
+
+	// IP addresses and CIDR ranges that downloads are allowed to connect to even
+	// though they are within a private network, such as an internal mirror.
+	AllowedIPs []string `yaml:"allowed_ips"`
+
+	// IP addresses and CIDR ranges that downloads can never connect to, in addition
+	// to the private network ranges that are always blocked.
+	DeniedIPs []string `yaml:"denied_ips"`
+
+	// Determines if redirects returned when downloading a file are followed. Every
+	// location is checked against the domain and IP rules before it is requested.
+	FollowRedirects bool `default:"false" yaml:"follow_redirects"`
+
+	// The maximum number of redirects that are followed for a single download.
+	MaxRedirects int `default:"5" yaml:"max_redirects"`
+}
+
+// ResumableUploadConfiguration defines the configuration for resumable uploads
+// made using the tus protocol.
+type ResumableUploadConfiguration struct {
+	// The maximum size of a single file uploaded in MB. Set to 0 to only limit
+	// uploads by the disk space available to the server.
+	MaxSize int64 `default:"0" json:"max_size" yaml:"max_size"`
+
+	// The amount of time in seconds after the last chunk was received that an
+	// incomplete upload is kept for before it is removed.
+	Expiration int `default:"86400" json:"expiration" yaml:"expiration"`
+}
+
 // RemoteQueryConfiguration defines the configuration settings for remote requests
 // from Wings to the Panel.
 type RemoteQueryConfiguration struct {
@@ -137,6 +289,10 @@ type SystemConfiguration struct {
 	// Directory where local backups will be stored on the machine.
 	BackupDirectory string `default:"/var/lib/pterodactyl/backups" json:"-" yaml:"backup_directory"`
 
+	// Directory where incomplete resumable uploads and remote downloads are stored
+	// until they have been completed and moved into the server's data directory.
+	UploadDirectory string `default:"/var/lib/pterodactyl/uploads" json:"-" yaml:"upload_directory"`
+
 	// TmpDirectory specifies where temporary files for Pterodactyl installation processes
 	// should be created. This supports environments running docker-in-docker.
 	TmpDirectory string `default:"/tmp/pterodactyl" json:"-" yaml:"tmp_directory"`
@@ -182,6 +338,12 @@ type SystemConfiguration struct {
 	// disk usage is not a concern.
 	DiskCheckInterval int64 `default:"150" yaml:"disk_check_interval"`
 
+	// DiskUsageConcurrency is the maximum number of servers that can have a breakdown of their disk
+	// usage calculated for the API at the same time. Any other requests wait until one of the running
+	// calculations has finished, which prevents the disk from being saturated when many are requested
+	// at once. The periodic disk usage checks are not limited. Set to 0 to remove the limit.
+	DiskUsageConcurrency int `default:"4" yaml:"disk_usage_concurrency"`
+
 	// ActivitySendInterval is the amount of time that should ellapse between aggregated server activity
 	// being sent to the Panel. By default this will send activity collected over the last minute. Keep
 	// in mind that only a fixed number of activity log entries, defined by ActivitySendCount, will be sent
@@ -204,6 +366,28 @@ type SystemConfiguration struct {
 	// The number of lines to send when a server connects to the websocket.
 	WebsocketLogCount int `default:"150" yaml:"websocket_log_count"`
 
+	// The number of console lines kept in memory for each server so that clients
+	// reconnecting to the websocket can be sent exactly the lines they missed.
+	// Setting this to 0 disables keeping the lines.
+	ConsoleHistorySize int `default:"1000" yaml:"console_history_size"`
+
+	// The amount of time in seconds that token revocations are kept for. Tokens issued before
+	// a revocation are denied until it is removed, so this should be at least as long as the
+	// lifetime of any token issued by the Panel.
+	TokenRevocationRetention int `default:"86400" yaml:"token_revocation_retention"`
+
+	// The amount of time in seconds that the history of finished jobs, such as backups and
+	// file compression, is kept for.
+	JobHistoryRetention int `default:"604800" yaml:"job_history_retention"`
+
+	// WebsocketLimits defines the limits used to protect servers from clients that
+	// flood the websocket with messages.
+	WebsocketLimits WebsocketLimits `yaml:"websocket_limits"`
+
+	// The maximum number of directories that can be watched for file changes at
+	// once for each server. Setting this to 0 disables watching for changes.
+	FileWatchLimit int `default:"256" yaml:"file_watch_limit"`
+
 	Sftp SftpConfiguration `yaml:"sftp"`
 
 	CrashDetection CrashDetection `yaml:"crash_detection"`
@@ -261,6 +445,40 @@ type Transfers struct {
 	DownloadLimit int `default:"0" yaml:"download_limit"`
 }
 
+// WebsocketLimits defines the rate limits applied to messages sent by clients
+// over a server websocket.
+type WebsocketLimits struct {
+	// Period is the amount of time in seconds that commands, power actions and
+	// violations are counted for.
+	Period int `default:"10" json:"period" yaml:"period"`
+
+	// CommandsPerConnection is the number of console commands a single websocket
+	// connection can send within the Period. Set to 0 for no limit.
+	CommandsPerConnection uint64 `default:"20" json:"commands_per_connection" yaml:"commands_per_connection"`
+
+	// CommandsPerUser is the number of console commands a single user can send to a
+	// server within the Period, across all of their connections. Set to 0 for no limit.
+	CommandsPerUser uint64 `default:"40" json:"commands_per_user" yaml:"commands_per_user"`
+
+	// PowerActionsPerConnection is the number of power actions a single websocket
+	// connection can send within the Period. Set to 0 for no limit.
+	PowerActionsPerConnection uint64 `default:"5" json:"power_actions_per_connection" yaml:"power_actions_per_connection"`
+
+	// PowerActionsPerUser is the number of power actions a single user can send to a
+	// server within the Period, across all of their connections. Set to 0 for no limit.
+	PowerActionsPerUser uint64 `default:"10" json:"power_actions_per_user" yaml:"power_actions_per_user"`
+
+	// MaxViolations is the number of messages that can be denied for exceeding the
+	// limits within the Period before the connection is closed. Set to 0 to never
+	// close the connection.
+	MaxViolations uint64 `default:"10" json:"max_violations" yaml:"max_violations"`
+
+	// MaxMessageSize is the largest message in bytes that a client can send over the
+	// websocket. The connection is closed if a larger message is received. Set to 0
+	// for no limit.
+	MaxMessageSize int64 `default:"16384" json:"max_message_size" yaml:"max_message_size"`
+}
+
 type ConsoleThrottles struct {
 	// Whether or not the throttler is enabled for this instance.
 	Enabled bool `json:"enabled" yaml:"enabled" default:"true"`
@@ -296,6 +514,9 @@ type Configuration struct {
 	// validate against it.
 	AuthenticationToken string `json:"token" yaml:"token"`
 
+	// Tokens defines how the JWTs issued by the Panel are verified.
+	Tokens TokenConfiguration `json:"tokens" yaml:"tokens"`
+
 	Api    ApiConfiguration    `json:"api" yaml:"api"`
 	System SystemConfiguration `json:"system" yaml:"system"`
 	Docker DockerConfiguration `json:"docker" yaml:"docker"`
@@ -351,6 +572,14 @@ func Set(c *Configuration) {
 	if _config == nil || _config.AuthenticationToken != c.AuthenticationToken {
 		_jwtAlgo = jwt.NewHS256([]byte(c.AuthenticationToken))
 	}
+	keys, err := c.Tokens.algorithms()
+	if err != nil {
+		// Without the keys no asymmetric tokens can be verified, rather than
+		// continuing to accept tokens signed with keys that may have been removed.
+		log.WithField("error", err).Error("failed to load public keys for verifying tokens")
+	}
+	_jwtKeys = keys
+	_networks = c.networks()
 	_config = c
 	mu.Unlock()
 }
@@ -398,6 +627,14 @@ func GetJwtAlgorithm() *jwt.HMACSHA {
 	return _jwtAlgo
 }
 
+// GetJwtKeys returns the algorithms used to verify asymmetric tokens, by the ID
+// of the key they were signed with. The returned map must not be modified.
+func GetJwtKeys() map[string]jwt.Algorithm {
+	mu.RLock()
+	defer mu.RUnlock()
+	return _jwtKeys
+}
+
 // WriteToDisk writes the configuration to the disk. This is a thread safe operation
 // and will only allow one write at a time. Additional calls while writing are
 // queued up.
@@ -512,6 +749,12 @@ func FromFile(path string) error {
 	if err := yaml.Unmarshal(b, c); err != nil {
 		return err
 	}
+	if err := c.Tokens.Validate(); err != nil {
+		return err
+	}
+	if err := validateApiKeys(c.Api.Keys); err != nil {
+		return err
+	}
 
 	// Store this configuration in the global state.
 	Set(c)
@@ -561,6 +804,11 @@ func ConfigureDirectories() error {
 		return err
 	}
 
+	log.WithField("path", _config.System.UploadDirectory).Debug("ensuring upload data directory exists")
+	if err := os.MkdirAll(_config.System.UploadDirectory, 0o700); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"slices"
+
+	"emperror.dev/errors"
+)
+
+// ApiKeyScopes are the scopes that can be granted to API keys.
+var ApiKeyScopes = []string{"system.read", "power", "files", "backups"}
+
+// validateApiKeys returns an error if any of the API keys defined in the
+// configuration could never be used, or would allow more access than intended.
+func validateApiKeys(keys []ApiKey) error {
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return errors.New("config: api key is missing an id")
+		}
+		if ids[k.ID] {
+			return errors.Errorf("config: duplicate api key \"%s\"", k.ID)
+		}
+		ids[k.ID] = true
+		if k.Token == "" {
+			return errors.Errorf("config: api key \"%s\" is missing a token", k.ID)
+		}
+		if len(k.Scopes) == 0 {
+			return errors.Errorf("config: api key \"%s\" is missing scopes", k.ID)
+		}
+		for _, s := range k.Scopes {
+			if !slices.Contains(ApiKeyScopes, s) {
+				return errors.Errorf("config: api key \"%s\" has unknown scope \"%s\"", k.ID, s)
+			}
+			if s == "backups" && len(k.Servers) > 0 {
+				return errors.Errorf("config: api key \"%s\" cannot be restricted to servers with the backups scope", k.ID)
+			}
+		}
+		for _, s := range k.Servers {
+			if s == "" {
+				return errors.Errorf("config: api key \"%s\" has an empty server", k.ID)
+			}
+		}
+	}
+	return nil
+}
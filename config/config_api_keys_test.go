@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestValidateApiKeys(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("validateApiKeys", func() {
+		g.It("accepts valid keys", func() {
+			g.Assert(validateApiKeys(nil)).IsNil()
+			g.Assert(validateApiKeys([]ApiKey{
+				{ID: "monitoring", Token: "a", Scopes: []string{"system.read", "power"}},
+				{ID: "files", Token: "b", Scopes: []string{"files"}, Servers: []string{"server-a"}},
+				{ID: "backups", Token: "c", Scopes: []string{"backups", "files"}},
+			})).IsNil()
+		})
+
+		g.It("rejects keys that cannot be used", func() {
+			cases := []struct {
+				name string
+				keys []ApiKey
+			}{
+				{"a key without an id", []ApiKey{{Token: "a", Scopes: []string{"power"}}}},
+				{"a key without a token", []ApiKey{{ID: "a", Scopes: []string{"power"}}}},
+				{"a key without scopes", []ApiKey{{ID: "a", Token: "a"}}},
+				{"an unknown scope", []ApiKey{{ID: "a", Token: "a", Scopes: []string{"power", "admin"}}}},
+				{"an empty scope", []ApiKey{{ID: "a", Token: "a", Scopes: []string{""}}}},
+				{"an empty server", []ApiKey{{ID: "a", Token: "a", Scopes: []string{"power"}, Servers: []string{""}}}},
+				{"backups restricted to servers", []ApiKey{{ID: "a", Token: "a", Scopes: []string{"backups"}, Servers: []string{"server-a"}}}},
+				{"duplicate ids", []ApiKey{{ID: "a", Token: "a", Scopes: []string{"power"}}, {ID: "a", Token: "b", Scopes: []string{"files"}}}},
+			}
+			for _, c := range cases {
+				g.Assert(validateApiKeys(c.keys) != nil).IsTrue(c.name)
+			}
+		})
+	})
+}
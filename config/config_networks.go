@@ -0,0 +1,86 @@
+package config
+
+import (
+	"net"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// Networks is a list of IP networks parsed from the IP addresses and CIDR ranges
+// in the configuration.
+type Networks []*net.IPNet
+
+// ParseNetworks parses a list of IP addresses and CIDR ranges. Single addresses
+// are treated as a range containing only that address. Invalid entries are logged
+// and skipped.
+func ParseNetworks(values []string) Networks {
+	out := make(Networks, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.WithField("value", v).Warn("ignoring invalid IP address or CIDR range in configuration")
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Contains returns true if the IP address is within any of the networks.
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPRules are the parsed allow and deny lists for a single part of Wings.
+type IPRules struct {
+	Allowed Networks
+	Denied  Networks
+}
+
+// networks holds the parsed IP rules of the configuration so that they are only
+// parsed, and any invalid entries logged, once when the configuration is set.
+type networks struct {
+	sftp     IPRules
+	download IPRules
+}
+
+func (c *Configuration) networks() networks {
+	return networks{
+		sftp: IPRules{
+			Allowed: ParseNetworks(c.System.Sftp.AllowedIPs),
+			Denied:  ParseNetworks(c.System.Sftp.DeniedIPs),
+		},
+		download: IPRules{
+			Allowed: ParseNetworks(c.Api.RemoteDownload.AllowedIPs),
+			Denied:  ParseNetworks(c.Api.RemoteDownload.DeniedIPs),
+		},
+	}
+}
+
+// GetSftpIPRules returns the parsed AllowedIPs and DeniedIPs of the SFTP server.
+func GetSftpIPRules() IPRules {
+	mu.RLock()
+	defer mu.RUnlock()
+	return _networks.sftp
+}
+
+// GetRemoteDownloadIPRules returns the parsed AllowedIPs and DeniedIPs used for
+// remote downloads.
+func GetRemoteDownloadIPRules() IPRules {
+	mu.RLock()
+	defer mu.RUnlock()
+	return _networks.download
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"emperror.dev/errors"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// The signatures that can be accepted on tokens issued by the Panel.
+const (
+	TokenModeHMAC       = "hmac"
+	TokenModeAsymmetric = "asymmetric"
+	TokenModeAny        = "any"
+)
+
+// TokenConfiguration defines how the JWTs issued by the Panel for websocket,
+// upload, download and transfer requests are verified.
+type TokenConfiguration struct {
+	// Mode determines which tokens are accepted. "hmac" only accepts tokens signed
+	// using the authentication token of the node, "asymmetric" only accepts tokens
+	// signed using the private key of one of the PublicKeys, and "any" accepts both,
+	// which allows moving from one to the other without rejecting any tokens.
+	Mode string `default:"hmac" json:"mode" yaml:"mode"`
+
+	// PublicKeys are the keys used to verify asymmetric tokens. Tokens include the
+	// ID of the key they were signed with in their "kid" header, so a new key can be
+	// added before the Panel starts signing tokens with it and the old key removed
+	// once no tokens signed with it remain.
+	PublicKeys []TokenPublicKey `json:"public_keys" yaml:"public_keys"`
+}
+
+// TokenPublicKey is a public key used to verify asymmetric tokens.
+type TokenPublicKey struct {
+	// ID is the key ID tokens signed with this key have in their "kid" header.
+	ID string `json:"id" yaml:"id"`
+
+	// Algorithm is the algorithm tokens are signed with when using an RSA key, one
+	// of RS256, RS384, RS512, PS256, PS384 or PS512. Defaults to RS256. Ed25519 keys
+	// always use EdDSA.
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+
+	// Key is the PEM encoded public key.
+	Key string `json:"key" yaml:"key"`
+}
+
+// Validate returns an error if the mode is unknown or any of the public keys
+// cannot be used.
+func (c TokenConfiguration) Validate() error {
+	_, err := c.algorithms()
+	return err
+}
+
+// algorithms returns the algorithm used to verify tokens signed with each of
+// the public keys, by key ID.
+func (c TokenConfiguration) algorithms() (map[string]jwt.Algorithm, error) {
+	switch c.Mode {
+	case TokenModeHMAC, TokenModeAsymmetric, TokenModeAny:
+	default:
+		return nil, errors.Errorf("config: unknown token mode \"%s\"", c.Mode)
+	}
+
+	out := make(map[string]jwt.Algorithm, len(c.PublicKeys))
+	for _, k := range c.PublicKeys {
+		if _, ok := out[k.ID]; ok {
+			return nil, errors.Errorf("config: duplicate token public key \"%s\"", k.ID)
+		}
+		alg, err := k.algorithm()
+		if err != nil {
+			return nil, errors.WrapIf(err, "config: invalid token public key \""+k.ID+"\"")
+		}
+		out[k.ID] = alg
+	}
+	if c.Mode == TokenModeAsymmetric && len(out) == 0 {
+		return nil, errors.New("config: asymmetric tokens require at least one public key")
+	}
+	return out, nil
+}
+
+// algorithm parses the public key and returns the algorithm used to verify
+// tokens signed with it.
+func (k TokenPublicKey) algorithm() (jwt.Algorithm, error) {
+	block, _ := pem.Decode([]byte(k.Key))
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	var pub any
+	var err error
+	if block.Type == "RSA PUBLIC KEY" {
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return jwt.NewEd25519(jwt.Ed25519PublicKey(pub)), nil
+	case *rsa.PublicKey:
+		opt := jwt.RSAPublicKey(pub)
+		switch k.Algorithm {
+		case "", "RS256":
+			return jwt.NewRS256(opt), nil
+		case "RS384":
+			return jwt.NewRS384(opt), nil
+		case "RS512":
+			return jwt.NewRS512(opt), nil
+		case "PS256":
+			return jwt.NewPS256(opt), nil
+		case "PS384":
+			return jwt.NewPS384(opt), nil
+		case "PS512":
+			return jwt.NewPS512(opt), nil
+		}
+		return nil, errors.Errorf("unsupported RSA algorithm \"%s\"", k.Algorithm)
+	}
+	return nil, errors.Errorf("unsupported key type %T", pub)
+}
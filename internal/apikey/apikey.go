@@ -0,0 +1,183 @@
+// Package apikey provides API keys that grant limited access to the Wings API,
+// in addition to the authentication token of the node which grants access to
+// every route.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// Scope is a group of routes that an API key can be allowed to access.
+type Scope string
+
+const (
+	// ScopeSystemRead allows reading information about the node and its servers.
+	ScopeSystemRead Scope = "system.read"
+	// ScopePower allows sending power actions to servers.
+	ScopePower Scope = "power"
+	// ScopeFiles allows reading and modifying the files of servers.
+	ScopeFiles Scope = "files"
+	// ScopeBackups allows creating, restoring and deleting backups of servers.
+	// Backups are stored for the whole node rather than for each server, so this
+	// scope cannot be granted to keys that are restricted to a set of servers.
+	ScopeBackups Scope = "backups"
+)
+
+var (
+	ErrNotFound     = errors.New("apikey: key does not exist")
+	ErrInvalidScope = errors.New("apikey: invalid scope")
+	ErrExists       = errors.New("apikey: a key with the same id already exists")
+	ErrServerScope  = errors.New("apikey: scope cannot be restricted to servers")
+)
+
+// Valid returns true if the scope is one of the known scopes.
+func (s Scope) Valid() bool {
+	return slices.Contains(config.ApiKeyScopes, string(s))
+}
+
+// Key is an API key, either defined in the configuration or stored in the
+// database.
+type Key struct {
+	ID      string
+	Scopes  []Scope
+	Servers []string
+}
+
+// Allows returns true if the key has the scope. If server is not empty the key
+// must also be allowed to access the server, and if it is empty the key must be
+// allowed to access every server. Keys restricted to a set of servers are never
+// allowed the backups scope.
+func (k *Key) Allows(scope Scope, server string) bool {
+	if !slices.Contains(k.Scopes, scope) {
+		return false
+	}
+	if len(k.Servers) == 0 {
+		return true
+	}
+	if scope == ScopeBackups {
+		return false
+	}
+	return server != "" && slices.Contains(k.Servers, server)
+}
+
+// Find returns the key for a bearer token, checking the keys defined in the
+// configuration before those stored in the database. ErrNotFound is returned if
+// no key matches the token.
+func Find(ctx context.Context, token string) (*Key, error) {
+	hash := hashToken(token)
+	for _, k := range config.Get().Api.Keys {
+		if k.Token == "" {
+			continue
+		}
+		h := hashToken(k.Token)
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			return &Key{ID: k.ID, Scopes: toScopes(k.Scopes), Servers: k.Servers}, nil
+		}
+	}
+
+	var m models.ApiKey
+	tx := database.Instance().WithContext(ctx).Where("token_hash = ?", hash).Limit(1).Find(&m)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &Key{ID: m.ID, Scopes: toScopes(m.Scopes), Servers: m.Servers}, nil
+}
+
+// List returns the keys stored in the database. Keys defined in the
+// configuration are not included.
+func List(ctx context.Context) ([]models.ApiKey, error) {
+	keys := []models.ApiKey{}
+	if tx := database.Instance().WithContext(ctx).Order("created_at").Find(&keys); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return keys, nil
+}
+
+// Create stores a new key in the database and returns it along with its token.
+// The token cannot be retrieved again once the key has been created. If id is
+// empty a random ID is used.
+func Create(ctx context.Context, id string, keyScopes []string, servers []string) (*models.ApiKey, string, error) {
+	if len(keyScopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, s := range keyScopes {
+		if !Scope(s).Valid() {
+			return nil, "", ErrInvalidScope
+		}
+		if Scope(s) == ScopeBackups && len(servers) > 0 {
+			return nil, "", ErrServerScope
+		}
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	for _, k := range config.Get().Api.Keys {
+		if k.ID == id {
+			return nil, "", ErrExists
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	token := hex.EncodeToString(b)
+	m := models.ApiKey{
+		ID:        id,
+		TokenHash: hashToken(token),
+		Scopes:    keyScopes,
+		Servers:   servers,
+		CreatedAt: time.Now().UTC(),
+	}
+	var count int64
+	if tx := database.Instance().WithContext(ctx).Model(&models.ApiKey{}).Where("id = ?", id).Count(&count); tx.Error != nil {
+		return nil, "", errors.WithStack(tx.Error)
+	} else if count > 0 {
+		return nil, "", ErrExists
+	}
+	if tx := database.Instance().WithContext(ctx).Create(&m); tx.Error != nil {
+		return nil, "", errors.WithStack(tx.Error)
+	}
+	return &m, token, nil
+}
+
+// Delete removes a key from the database.
+func Delete(ctx context.Context, id string) error {
+	tx := database.Instance().WithContext(ctx).Where("id = ?", id).Delete(&models.ApiKey{})
+	if tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func toScopes(s []string) []Scope {
+	out := make([]Scope, 0, len(s))
+	for _, v := range s {
+		out = append(out, Scope(v))
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"context"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+)
+
+func TestApiKeys(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.RootDirectory = t.TempDir()
+	cfg.Api.Keys = []config.ApiKey{
+		{ID: "config-key", Token: "config-token", Scopes: []string{"power", "files"}, Servers: []string{"server-a"}},
+		{ID: "empty-token", Scopes: []string{"power"}},
+	}
+	config.Set(cfg)
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+
+	g.Describe("Key", func() {
+		g.It("allows the scopes of the key", func() {
+			cases := []struct {
+				name    string
+				key     Key
+				scope   Scope
+				server  string
+				allowed bool
+			}{
+				{"a scope of the key", Key{Scopes: []Scope{ScopePower}}, ScopePower, "server-a", true},
+				{"a scope of the key without a server", Key{Scopes: []Scope{ScopeSystemRead}}, ScopeSystemRead, "", true},
+				{"a scope the key does not have", Key{Scopes: []Scope{ScopePower}}, ScopeFiles, "server-a", false},
+				{"a key without any scopes", Key{}, ScopePower, "server-a", false},
+				{"a server of a restricted key", Key{Scopes: []Scope{ScopePower}, Servers: []string{"server-a"}}, ScopePower, "server-a", true},
+				{"another server for a restricted key", Key{Scopes: []Scope{ScopePower}, Servers: []string{"server-a"}}, ScopePower, "server-b", false},
+				{"a route without a server for a restricted key", Key{Scopes: []Scope{ScopeSystemRead}, Servers: []string{"server-a"}}, ScopeSystemRead, "", false},
+				{"backups for an unrestricted key", Key{Scopes: []Scope{ScopeBackups}}, ScopeBackups, "server-a", true},
+				{"backups for a restricted key", Key{Scopes: []Scope{ScopeBackups}, Servers: []string{"server-a"}}, ScopeBackups, "server-a", false},
+			}
+			for _, c := range cases {
+				g.Assert(c.key.Allows(c.scope, c.server)).Equal(c.allowed, c.name)
+			}
+		})
+	})
+
+	g.Describe("Find", func() {
+		g.It("returns keys defined in the configuration", func() {
+			k, err := Find(ctx, "config-token")
+			g.Assert(err).IsNil()
+			g.Assert(k.ID).Equal("config-key")
+			g.Assert(k.Scopes).Equal([]Scope{ScopePower, ScopeFiles})
+			g.Assert(k.Servers).Equal([]string{"server-a"})
+		})
+
+		g.It("does not match keys without a token", func() {
+			_, err := Find(ctx, "")
+			g.Assert(errors.Is(err, ErrNotFound)).IsTrue()
+		})
+
+		g.It("returns an error for an unknown token", func() {
+			_, err := Find(ctx, "unknown-token")
+			g.Assert(errors.Is(err, ErrNotFound)).IsTrue()
+		})
+	})
+
+	g.Describe("Create", func() {
+		g.It("stores a key that can be found by its token", func() {
+			m, token, err := Create(ctx, "stored-key", []string{"files"}, []string{"server-b"})
+			g.Assert(err).IsNil()
+			g.Assert(m.ID).Equal("stored-key")
+			g.Assert(m.TokenHash == token).IsFalse()
+
+			k, err := Find(ctx, token)
+			g.Assert(err).IsNil()
+			g.Assert(k.ID).Equal("stored-key")
+			g.Assert(k.Allows(ScopeFiles, "server-b")).IsTrue()
+			g.Assert(k.Allows(ScopeFiles, "server-a")).IsFalse()
+		})
+
+		g.It("generates an ID if one is not provided", func() {
+			m, _, err := Create(ctx, "", []string{"power"}, nil)
+			g.Assert(err).IsNil()
+			g.Assert(m.ID == "").IsFalse()
+		})
+
+		g.It("requires valid scopes", func() {
+			_, _, err := Create(ctx, "", nil, nil)
+			g.Assert(errors.Is(err, ErrInvalidScope)).IsTrue()
+			_, _, err = Create(ctx, "", []string{"power", "admin"}, nil)
+			g.Assert(errors.Is(err, ErrInvalidScope)).IsTrue()
+		})
+
+		g.It("does not allow backups for keys restricted to servers", func() {
+			_, _, err := Create(ctx, "", []string{"backups"}, []string{"server-a"})
+			g.Assert(errors.Is(err, ErrServerScope)).IsTrue()
+			_, _, err = Create(ctx, "", []string{"backups"}, nil)
+			g.Assert(err).IsNil()
+		})
+
+		g.It("does not allow duplicate IDs", func() {
+			_, _, err := Create(ctx, "config-key", []string{"power"}, nil)
+			g.Assert(errors.Is(err, ErrExists)).IsTrue()
+			_, _, err = Create(ctx, "stored-key", []string{"power"}, nil)
+			g.Assert(errors.Is(err, ErrExists)).IsTrue()
+		})
+	})
+
+	g.Describe("Delete", func() {
+		g.It("removes a stored key", func() {
+			_, token, err := Create(ctx, "deleted-key", []string{"power"}, nil)
+			g.Assert(err).IsNil()
+			g.Assert(Delete(ctx, "deleted-key")).IsNil()
+
+			_, err = Find(ctx, token)
+			g.Assert(errors.Is(err, ErrNotFound)).IsTrue()
+			g.Assert(errors.Is(Delete(ctx, "deleted-key"), ErrNotFound)).IsTrue()
+		})
+	})
+}
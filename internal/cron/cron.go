@@ -9,6 +9,9 @@ import (
 	"github.com/go-co-op/gocron"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/router/jobs"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/router/uploader"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/system"
 )
@@ -69,5 +72,24 @@ func Scheduler(ctx context.Context, m *server.Manager) (*gocron.Scheduler, error
 		}
 	})
 
+	_, _ = s.Tag("uploads").Every(time.Minute * 5).Do(func() {
+		l.WithField("cron", "uploads").Debug("removing expired resumable uploads")
+		uploader.Expire()
+	})
+
+	_, _ = s.Tag("tokens").Every(time.Minute * 5).Do(func() {
+		l.WithField("cron", "tokens").Debug("removing expired token revocations")
+		if err := tokens.PruneRevocations(ctx); err != nil {
+			l.WithField("cron", "tokens").WithField("error", err).Error("failed to remove expired token revocations")
+		}
+	})
+
+	_, _ = s.Tag("jobs").Every(time.Hour).Do(func() {
+		l.WithField("cron", "jobs").Debug("removing expired job history")
+		if err := jobs.Prune(ctx); err != nil {
+			l.WithField("cron", "jobs").WithField("error", err).Error("failed to remove expired job history")
+		}
+	})
+
 	return s, nil
 }
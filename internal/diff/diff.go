@@ -0,0 +1,216 @@
+// Package diff generates unified diffs between two versions of a text file.
+package diff
+
+import (
+	"strconv"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines included around each change.
+const contextLines = 3
+
+// maxEdits is the largest number of line insertions and deletions that will be
+// searched for when finding the smallest diff between two files. If the files
+// differ by more than this, the remaining lines are reported as being entirely
+// replaced, which keeps the time and memory used bounded.
+const maxEdits = 1000
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+// op is a single line in the diff. The line positions are the index of the
+// line in each file at the point the operation is performed.
+type op struct {
+	kind opKind
+	a, b int
+}
+
+// Unified returns a unified diff between the old and new contents, using the
+// given names in the header of the diff. An empty string is returned if the
+// contents are identical.
+func Unified(oldName, newName string, old, new []byte) string {
+	a, b := splitLines(string(old)), splitLines(string(new))
+	ops := edits(a, b)
+
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		// Find the next change, and the end of the hunk that contains it. Changes
+		// that are close enough for their context to overlap share a hunk.
+		for i < len(ops) && ops[i].kind == opEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := max(i-contextLines, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != opEqual {
+				end = j + 1
+			} else if j-end >= contextLines*2 {
+				break
+			}
+		}
+		end = min(end+contextLines, len(ops))
+
+		if out.Len() == 0 {
+			out.WriteString("--- " + oldName + "\n+++ " + newName + "\n")
+		}
+		writeHunk(&out, a, b, ops[start:end])
+		i = end
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, a, b []string, ops []op) {
+	var alen, blen int
+	for _, o := range ops {
+		if o.kind != opInsert {
+			alen++
+		}
+		if o.kind != opDelete {
+			blen++
+		}
+	}
+	out.WriteString("@@ -" + hunkRange(ops[0].a, alen) + " +" + hunkRange(ops[0].b, blen) + " @@\n")
+	for _, o := range ops {
+		var line string
+		if o.kind == opInsert {
+			line = b[o.b]
+		} else {
+			line = a[o.a]
+		}
+		out.WriteByte(byte(o.kind))
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the start and length of a hunk. Line numbers start at one,
+// except for an empty range which refers to the line before it.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	if length == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(length)
+}
+
+// splitLines splits the contents into lines, keeping the line endings so that a
+// missing newline at the end of the file can be detected.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// edits returns the operations needed to turn a into b. Any lines that are the
+// same at the start and end of both are skipped before searching for the
+// smallest set of changes using the Myers diff algorithm.
+func edits(a, b []string) []op {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{opEqual, i, i})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix)...)
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, op{opEqual, len(a) - i, len(b) - i})
+	}
+	return ops
+}
+
+// myers finds the smallest set of changes needed to turn a into b, with the
+// offset added to the line positions of every operation. If the number of
+// changes is larger than maxEdits every line of a is deleted and every line of
+// b inserted instead.
+func myers(a, b []string, offset int) []op {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	off := limit + 1
+	v := make([]int32, 2*limit+3)
+	var trace [][]int32
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int32(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = int(v[off+k+1])
+			} else {
+				x = int(v[off+k-1]) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = int32(x)
+			if x >= n && y >= m {
+				return backtrack(trace, off, n, m, offset)
+			}
+		}
+	}
+
+	ops := make([]op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, op{opDelete, offset + i, offset})
+	}
+	for i := 0; i < m; i++ {
+		ops = append(ops, op{opInsert, offset + n, offset + i})
+	}
+	return ops
+}
+
+// backtrack walks back through the furthest reaching paths found by myers to
+// build the list of operations, which are returned in order.
+func backtrack(trace [][]int32, off, x, y, offset int) []op {
+	var ops []op
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := int(v[off+pk])
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+			ops = append(ops, op{opEqual, offset + x, offset + y})
+		}
+		if d > 0 {
+			if x == px {
+				ops = append(ops, op{opInsert, offset + px, offset + py})
+			} else {
+				ops = append(ops, op{opDelete, offset + px, offset + py})
+			}
+		}
+		x, y = px, py
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
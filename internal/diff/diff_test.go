@@ -0,0 +1,61 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Unified", func() {
+		g.It("returns nothing for identical contents", func() {
+			g.Assert(diff.Unified("a", "b", []byte("foo\nbar\n"), []byte("foo\nbar\n"))).Equal("")
+		})
+
+		g.It("generates a diff for changed lines", func() {
+			old := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+			new := "one\ntwo\nthree\nfour\nFIVE\nsix\nseven\neight\nnine\nten\neleven\n"
+			g.Assert(diff.Unified("a/file.txt", "b/file.txt", []byte(old), []byte(new))).Equal(strings.Join([]string{
+				"--- a/file.txt",
+				"+++ b/file.txt",
+				"@@ -2,9 +2,10 @@",
+				" two",
+				" three",
+				" four",
+				"-five",
+				"+FIVE",
+				" six",
+				" seven",
+				" eight",
+				" nine",
+				" ten",
+				"+eleven",
+				"",
+			}, "\n"))
+		})
+
+		g.It("splits changes that are far apart into separate hunks", func() {
+			var old, new []string
+			for i := 0; i < 20; i++ {
+				old = append(old, "line")
+				new = append(new, "line")
+			}
+			old[1], new[1] = "a", "b"
+			old[18], new[18] = "c", "d"
+			out := diff.Unified("a", "b", []byte(strings.Join(old, "\n")+"\n"), []byte(strings.Join(new, "\n")+"\n"))
+			g.Assert(strings.Count(out, "@@ -")).Equal(2)
+			g.Assert(strings.Contains(out, "@@ -1,5 +1,5 @@\n")).IsTrue()
+			g.Assert(strings.Contains(out, "@@ -16,5 +16,5 @@\n")).IsTrue()
+		})
+
+		g.It("handles empty files and missing trailing newlines", func() {
+			g.Assert(diff.Unified("a", "b", nil, []byte("foo"))).Equal("--- a\n+++ b\n@@ -0,0 +1 @@\n+foo\n\\ No newline at end of file\n")
+			g.Assert(diff.Unified("a", "b", []byte("foo\n"), []byte("foo"))).Equal("--- a\n+++ b\n@@ -1 +1 @@\n-foo\n+foo\n\\ No newline at end of file\n")
+		})
+	})
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// ApiKey is an API key that grants limited access to the Wings API, in addition
+// to the authentication token of the node which grants access to everything. Only
+// a hash of the key is stored.
+type ApiKey struct {
+	ID        string    `gorm:"primaryKey;not null" json:"id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes    []string  `gorm:"serializer:json;not null" json:"scopes"`
+	Servers   []string  `gorm:"serializer:json" json:"servers"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// LocalBackup records the server that a backup stored on this machine was
+// created for, since local backups are all kept in the same directory.
+type LocalBackup struct {
+	ID        string    `gorm:"primaryKey;not null"`
+	Server    string    `gorm:"index;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Job is the record of a long-running operation performed against a server,
+// such as creating a backup or compressing files. Records are kept after the
+// job has finished so that its outcome can still be looked up.
+type Job struct {
+	ID     string `gorm:"primaryKey;not null" json:"id"`
+	Server string `gorm:"index;not null" json:"server"`
+	Type   string `gorm:"index;not null" json:"type"`
+	Status string `gorm:"index;not null" json:"status"`
+	// Error is the reason the job failed, if it did. Unexpected errors are only
+	// described by a generic message, and the ErrorID can be used to find the
+	// actual error in the logs.
+	Error   string `json:"error,omitempty"`
+	ErrorID string `json:"error_id,omitempty"`
+	// Result is the JSON encoded value returned by a job once it has completed.
+	Result json.RawMessage `json:"result,omitempty"`
+	// Written and Total are the progress of the job in bytes, or in operations for
+	// batches of file operations. Total is zero if the job does not report its
+	// progress, or the total is not yet known.
+	Written     uint64     `json:"written"`
+	Total       uint64     `json:"total"`
+	Cancellable bool       `json:"cancellable"`
+	CreatedAt   time.Time  `gorm:"index;not null" json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
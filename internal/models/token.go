@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// TokenRevocation denies any token issued before RevokedAt that has the revoked
+// JTI, or that was issued for the revoked user or server. Revocations are kept
+// until ExpiresAt, by which time every token they apply to has expired.
+type TokenRevocation struct {
+	ID int `gorm:"primaryKey;not null" json:"-"`
+	// Type is what the revocation applies to, either "jti", "user" or "server".
+	Type string `gorm:"uniqueIndex:idx_token_revocations_type_value;not null" json:"type"`
+	// Value is the JTI, or the UUID of the user or server, that has been revoked.
+	Value     string    `gorm:"uniqueIndex:idx_token_revocations_type_value;not null" json:"value"`
+	RevokedAt time.Time `gorm:"not null" json:"revoked_at"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+}
+
+// UsedToken is the unique ID of a single use token that has already been used,
+// which is kept until the token expires.
+type UsedToken struct {
+	ID        string    `gorm:"primaryKey;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
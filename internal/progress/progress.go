@@ -54,6 +54,12 @@ func (p *Progress) Write(v []byte) (int, error) {
 	return n, nil
 }
 
+// Add adds to the progress without writing anything, for operations that are
+// tracked by something other than the number of bytes written.
+func (p *Progress) Add(n uint64) {
+	atomic.AddUint64(&p.written, n)
+}
+
 // Progress returns a formatted progress string for the current progress.
 func (p *Progress) Progress(width int) string {
 	// current = 100 (Progress, dynamic)
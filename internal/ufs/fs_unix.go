@@ -420,6 +420,17 @@ func (fs *UnixFS) unlinkat(dirfd int, name string, flags int) error {
 //
 // If there is an error, it will be of type *LinkError.
 func (fs *UnixFS) Rename(oldpath, newpath string) error {
+	return fs.rename(oldpath, newpath, false)
+}
+
+// RenameReplace is like Rename, except that if newpath already exists it is
+// atomically replaced, matching the behaviour of rename(2). If newpath is a
+// directory it must be empty.
+func (fs *UnixFS) RenameReplace(oldpath, newpath string) error {
+	return fs.rename(oldpath, newpath, true)
+}
+
+func (fs *UnixFS) rename(oldpath, newpath string, replace bool) error {
 	// Simple case: both paths are the same.
 	if oldpath == newpath {
 		return nil
@@ -480,13 +491,13 @@ func (fs *UnixFS) Rename(oldpath, newpath string) error {
 	// Stat the new target to return proper errors.
 	_, err = fs.Lstatat(newdirfd, newname)
 	switch {
-	case err == nil:
+	case err == nil && !replace:
 		return convertErrorType(&PathError{
 			Op:   "rename",
 			Path: newname,
 			Err:  ErrExist,
 		})
-	case !errors.Is(err, ErrNotExist):
+	case err != nil && !errors.Is(err, ErrNotExist):
 		return err
 	}
 	return unix.Renameat(olddirfd, oldname, newdirfd, newname)
@@ -541,6 +552,37 @@ func (fs *UnixFS) fstatat(dirfd int, name string, flags int) (FileInfo, error) {
 	return &s, nil
 }
 
+// Link creates newname as a hard link to the oldname file. Both paths must
+// resolve within the filesystem, and oldname is not followed if it is a
+// symbolic link.
+//
+// If there is an error, it will be of type *LinkError.
+func (fs *UnixFS) Link(oldpath, newpath string) error {
+	olddirfd, oldname, closeFd, err := fs.safePath(oldpath)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	newdirfd, newname, closeFd2, err := fs.safePath(newpath)
+	defer closeFd2()
+	if err != nil {
+		return err
+	}
+	if oldname == "." || newname == "." {
+		return convertErrorType(&PathError{
+			Op:   "link",
+			Path: newname,
+			Err:  ErrBadPathResolution,
+		})
+	}
+	if err := ignoringEINTR(func() error {
+		return unix.Linkat(olddirfd, oldname, newdirfd, newname, 0)
+	}); err != nil {
+		return &LinkError{Op: "link", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
 // Symlink creates newname as a symbolic link to oldname.
 //
 // On Windows, a symlink to a non-existent oldname creates a file symlink;
@@ -175,6 +175,101 @@ func TestUnixFS_Rename(t *testing.T) {
 	})
 }
 
+func TestUnixFS_RenameReplace(t *testing.T) {
+	t.Parallel()
+	fs, err := newTestUnixFS()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer fs.Cleanup()
+
+	for _, name := range []string{"source", "target"} {
+		if err := os.WriteFile(filepath.Join(fs.Root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+			return
+		}
+	}
+
+	t.Run("rename over existing file", func(t *testing.T) {
+		if err := fs.Rename("source", "target"); !errors.Is(err, ufs.ErrExist) {
+			t.Errorf("expected an exist error, but got: %v", err)
+			return
+		}
+		if err := fs.RenameReplace("source", "target"); err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+			return
+		}
+
+		// Sanity check
+		b, err := os.ReadFile(filepath.Join(fs.Root, "target"))
+		if err != nil {
+			t.Errorf("ReadFile errored when performing sanity check: %v", err)
+			return
+		}
+		if string(b) != "source" {
+			t.Errorf("expected target to contain \"source\", but got: %q", b)
+			return
+		}
+	})
+
+	t.Run("rename over base directory", func(t *testing.T) {
+		if err := fs.RenameReplace("target", ""); !errors.Is(err, ufs.ErrBadPathResolution) {
+			t.Errorf("expected an a bad path resolution error, but got: %v", err)
+			return
+		}
+	})
+}
+
+func TestUnixFS_Link(t *testing.T) {
+	t.Parallel()
+	fs, err := newTestUnixFS()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer fs.Cleanup()
+
+	if err := os.WriteFile(filepath.Join(fs.Root, "file"), []byte("file"), 0o644); err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Run("link file", func(t *testing.T) {
+		if err := fs.Link("file", "link"); err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+			return
+		}
+
+		// Sanity check
+		a, err := os.Stat(filepath.Join(fs.Root, "file"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, err := os.Stat(filepath.Join(fs.Root, "link"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !os.SameFile(a, b) {
+			t.Errorf("expected link to be the same file as the original")
+			return
+		}
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(fs.TmpDir, "outside"), []byte("outside"), 0o644); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := fs.Link("../outside", "outside"); !errors.Is(err, ufs.ErrBadPathResolution) {
+			t.Errorf("expected an a bad path resolution error, but got: %v", err)
+			return
+		}
+	})
+}
+
 func TestUnixFS_Touch(t *testing.T) {
 	t.Parallel()
 	fs, err := newTestUnixFS()
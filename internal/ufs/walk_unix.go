@@ -115,6 +115,27 @@ func ReadDirMap[T any](fs *UnixFS, path string, fn func(DirEntry) (T, error)) ([
 	return out, nil
 }
 
+// ReadDirFunc streams the entries of a directory, calling fn with each entry as
+// it is read from the filesystem. Unlike ReadDir the entries are never all held
+// in memory at once, and are returned in the order the filesystem stores them.
+// The entries may only be used until fn returns, as the directory is closed
+// once reading has finished. Returning SkipAll from fn stops reading without an
+// error.
+func (fs *UnixFS) ReadDirFunc(path string, fn func(DirEntry) error) error {
+	dirfd, name, closeFd, err := fs.safePath(path)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	fd, err := fs.openat(dirfd, name, O_DIRECTORY|O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	return fs.readDirFunc(fd, ".", nil, fn)
+}
+
 // nameOffset is a compile time constant
 const nameOffset = int(unsafe.Offsetof(unix.Dirent{}.Name))
 
@@ -204,12 +225,26 @@ func newScratchBuffer() []byte {
 }
 
 func (fs *UnixFS) readDir(fd int, name string, b []byte) ([]DirEntry, error) {
+	var entries []DirEntry
+	err := fs.readDirFunc(fd, name, b, func(e DirEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readDirFunc reads the entries of a directory in batches, calling fn with each
+// entry as it is read rather than collecting every entry first. If fn returns
+// an error reading stops and the error is returned, unless it is SkipAll.
+func (fs *UnixFS) readDirFunc(fd int, name string, b []byte, fn func(DirEntry) error) error {
 	scratchBuffer := b
 	if scratchBuffer == nil || len(scratchBuffer) < minimumScratchBufferSize {
 		scratchBuffer = newScratchBuffer()
 	}
 
-	var entries []DirEntry
 	var workBuffer []byte
 
 	var sde unix.Dirent
@@ -220,11 +255,11 @@ func (fs *UnixFS) readDir(fd int, name string, b []byte) ([]DirEntry, error) {
 				if err == unix.EINTR {
 					continue
 				}
-				return nil, convertErrorType(err)
+				return convertErrorType(err)
 			}
 			if n <= 0 {
 				// end of directory: normal exit
-				return entries, nil
+				return nil
 			}
 			workBuffer = scratchBuffer[:n] // trim work buffer to number of bytes read
 		}
@@ -247,9 +282,14 @@ func (fs *UnixFS) readDir(fd int, name string, b []byte) ([]DirEntry, error) {
 		childName := string(nameSlice)
 		mt, err := fs.modeTypeFromDirent(fd, &sde, name, childName)
 		if err != nil {
-			return nil, convertErrorType(err)
+			return convertErrorType(err)
+		}
+		if err := fn(&dirent{name: childName, path: name, modeType: mt, dirfd: fd, fs: fs}); err != nil {
+			if err == SkipAll {
+				return nil
+			}
+			return err
 		}
-		entries = append(entries, &dirent{name: childName, path: name, modeType: mt, dirfd: fd, fs: fs})
 	}
 }
 
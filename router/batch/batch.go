@@ -0,0 +1,206 @@
+package batch
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/internal/progress"
+	"github.com/pterodactyl/wings/internal/ufs"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/filesystem"
+)
+
+// The actions that can be performed as part of a batch.
+const (
+	ActionMove       = "move"
+	ActionCopy       = "copy"
+	ActionDelete     = "delete"
+	ActionChmod      = "chmod"
+	ActionChownReset = "chown-reset"
+	ActionTouch      = "touch"
+)
+
+const (
+	ErrUnknownAction   = errors.Sentinel("batch: unknown action")
+	ErrMissingPath     = errors.Sentinel("batch: a path must be provided")
+	ErrMissingTarget   = errors.Sentinel("batch: a destination must be provided")
+	ErrInvalidFileMode = errors.Sentinel("batch: invalid file mode")
+	ErrTooManyItems    = errors.Sentinel("batch: too many operations in a single batch")
+	ErrInvalidConflict = errors.Sentinel("batch: unknown conflict policy")
+)
+
+// MaxItems is the largest number of operations that can be performed in a
+// single batch.
+const MaxItems = 1000
+
+// Operation is a single action to perform on a file as part of a batch. All
+// paths are relative to the root directory of the batch.
+type Operation struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// To is the destination for move and copy actions.
+	To string `json:"to,omitempty"`
+	// Conflict determines what happens if the destination of a move or copy
+	// already exists, by default the operation fails.
+	Conflict filesystem.ConflictPolicy `json:"conflict,omitempty"`
+	// Mode is the octal file mode for chmod actions, for example "0644".
+	Mode string `json:"mode,omitempty"`
+}
+
+// Validate checks that the operation has everything needed to be performed.
+func (o Operation) Validate() error {
+	switch o.Action {
+	case ActionMove, ActionCopy:
+		if o.To == "" {
+			return ErrMissingTarget
+		}
+		if !o.Conflict.Valid() {
+			return ErrInvalidConflict
+		}
+	case ActionChmod:
+		if _, err := strconv.ParseUint(o.Mode, 8, 32); err != nil {
+			return ErrInvalidFileMode
+		}
+	case ActionDelete, ActionChownReset, ActionTouch:
+	default:
+		return ErrUnknownAction
+	}
+	if o.Path == "" {
+		return ErrMissingPath
+	}
+	return nil
+}
+
+// Result is the outcome of a single operation in a batch.
+type Result struct {
+	Action  string `json:"action"`
+	Path    string `json:"path"`
+	To      string `json:"to,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Batch is a set of file operations performed against a server. Operations are
+// run in the order they are provided, and a failed operation does not stop the
+// remaining operations from running.
+type Batch struct {
+	server *server.Server
+	root   string
+	ops    []Operation
+}
+
+// New validates the operations and returns a batch for the given server. The
+// operations are not performed until Execute is called.
+func New(s *server.Server, root string, ops []Operation) (*Batch, error) {
+	if len(ops) > MaxItems {
+		return nil, ErrTooManyItems
+	}
+	for _, o := range ops {
+		if err := o.Validate(); err != nil {
+			return nil, errors.WithDetails(err, "action", o.Action, "path", o.Path)
+		}
+	}
+	return &Batch{server: s, root: root, ops: ops}, nil
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Execute runs each of the operations in the batch, adding one to the progress
+// after each, if a progress tracker is provided. The results of every operation
+// are returned once the batch has completed. If the context is canceled the
+// remaining operations are not performed, and are returned as having failed.
+func (b *Batch) Execute(ctx context.Context, p *progress.Progress) []Result {
+	results := make([]Result, 0, len(b.ops))
+	for _, o := range b.ops {
+		res := Result{Action: o.Action, Path: o.Path, To: o.To}
+		err := ctx.Err()
+		if err == nil {
+			err = b.run(ctx, o)
+		}
+		if err != nil {
+			res.Error = errorMessage(err)
+			b.server.Log().WithField("action", o.Action).
+				WithField("path", o.Path).
+				WithField("error", err).
+				Debug("batch: failed to perform file operation")
+		} else {
+			res.Success = true
+		}
+		results = append(results, res)
+		if p != nil {
+			p.Add(1)
+		}
+	}
+	return results
+}
+
+// run performs a single operation against the server filesystem.
+func (b *Batch) run(ctx context.Context, o Operation) error {
+	fs := b.server.Filesystem()
+	p := path.Join(b.root, o.Path)
+	switch o.Action {
+	case ActionMove, ActionCopy:
+		to := path.Join(b.root, o.To)
+		if err := fs.IsIgnored(p, to); err != nil {
+			return err
+		}
+		if o.Action == ActionMove {
+			return fs.MoveTo(ctx, p, to, o.Conflict)
+		}
+		return fs.CopyTo(ctx, p, to, o.Conflict)
+	case ActionDelete:
+		if err := fs.IsIgnored(p); err != nil {
+			return err
+		}
+		return fs.Delete(p)
+	case ActionChmod:
+		mode, _ := strconv.ParseUint(o.Mode, 8, 32)
+		return fs.Chmod(p, os.FileMode(mode))
+	case ActionChownReset:
+		return fs.Chown(p)
+	case ActionTouch:
+		if err := fs.IsIgnored(p); err != nil {
+			return err
+		}
+		f, err := fs.Touch(p, ufs.O_WRONLY|ufs.O_CREATE)
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+		now := time.Now()
+		return fs.Chtimes(p, now, now)
+	}
+	return ErrUnknownAction
+}
+
+// errorMessage returns a short description of why an operation failed that is
+// safe to return to the caller.
+func errorMessage(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "The batch was cancelled before this operation was performed."
+	case filesystem.IsErrorCode(err, filesystem.ErrCodeDiskSpace):
+		return "There is not enough disk space available to perform this action."
+	case filesystem.IsErrorCode(err, filesystem.ErrCodeDenylistFile):
+		return "This file cannot be modified: present in egg denylist."
+	case filesystem.IsErrorCode(err, filesystem.ErrCodePathResolution):
+		return "The requested resource was not found on the system."
+	case filesystem.IsErrorCode(err, filesystem.ErrCodeIsDirectory):
+		return "Cannot perform that action: file is a directory."
+	case errors.Is(err, os.ErrNotExist), filesystem.IsErrorCode(err, filesystem.ErrNotExist):
+		return "The requested resource was not found on the system."
+	case errors.Is(err, os.ErrExist):
+		return "The destination already exists."
+	case errors.Is(err, filesystem.ErrCopyIntoSelf):
+		return "A directory cannot be moved or copied into itself."
+	}
+	return "An unexpected error was encountered while performing this action."
+}
@@ -1,14 +1,19 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +22,9 @@ import (
 	"github.com/goccy/go-json"
 	"github.com/google/uuid"
 
+	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/filesystem"
 )
 
 var client *http.Client
@@ -36,20 +43,17 @@ func init() {
 
 		ipStr, _, err := net.SplitHostPort(c.RemoteAddr().String())
 		if err != nil {
-			return c, errors.WithStack(err)
+			_ = c.Close()
+			return nil, errors.WithStack(err)
 		}
 		ip := net.ParseIP(ipStr)
 		if ip == nil {
-			return c, errors.WithStack(ErrInvalidIPAddress)
-		}
-		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
-			return c, errors.WithStack(ErrInternalResolution)
+			_ = c.Close()
+			return nil, errors.WithStack(ErrInvalidIPAddress)
 		}
-		for _, block := range internalRanges {
-			if !block.Contains(ip) {
-				continue
-			}
-			return c, errors.WithStack(ErrInternalResolution)
+		if err := checkIP(ip); err != nil {
+			_ = c.Close()
+			return nil, errors.WithStack(err)
 		}
 		return c, nil
 	}
@@ -59,18 +63,11 @@ func init() {
 
 		Transport: trnspt,
 
-		// Disallow any redirect on an HTTP call. This is a security requirement: do not modify
-		// this logic without first ensuring that the new target location IS NOT within the current
-		// instance's local network.
-		//
-		// This specific error response just causes the client to not follow the redirect and
-		// returns the actual redirect response to the caller. Not perfect, but simple and most
-		// people won't be using URLs that redirect anyways hopefully?
-		//
-		// We'll re-evaluate this down the road if needed.
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+		// Redirects are only followed if enabled in the configuration, in which case
+		// each location is validated against the configured rules. The address that
+		// every location resolves to is validated when the connection is made, so a
+		// redirect can never be used to reach the local network.
+		CheckRedirect: checkRedirect,
 	}
 }
 
@@ -84,7 +81,7 @@ var instance = &Downloader{
 }
 
 // Internal IP ranges that should be blocked if the resource requested resolves within.
-var internalRanges = []*net.IPNet{
+var internalRanges = config.Networks{
 	mustParseCIDR("127.0.0.1/8"),
 	mustParseCIDR("10.0.0.0/8"),
 	mustParseCIDR("172.16.0.0/12"),
@@ -99,8 +96,21 @@ const (
 	ErrInternalResolution = errors.Sentinel("downloader: destination resolves to internal network location")
 	ErrInvalidIPAddress   = errors.Sentinel("downloader: invalid IP address")
 	ErrDownloadFailed     = errors.Sentinel("downloader: download request failed")
+	ErrInvalidChecksum    = errors.Sentinel("downloader: invalid checksum format")
+	ErrChecksumMismatch   = errors.Sentinel("downloader: checksum of downloaded file does not match")
+	ErrDownloadTooLarge   = errors.Sentinel("downloader: file is larger than the maximum download size")
 )
 
+// errRetryable wraps an error that was caused by a temporary failure, which allows
+// the download to be resumed.
+type errRetryable struct {
+	error
+}
+
+func (e errRetryable) Unwrap() error {
+	return e.error
+}
+
 type Counter struct {
 	total   int
 	onWrite func(total int)
@@ -118,6 +128,13 @@ type DownloadRequest struct {
 	URL       *url.URL
 	FileName  string
 	UseHeader bool
+	// Checksum is the expected hash of the downloaded file in the format of
+	// "algorithm:hex", for example "sha256:e3b0c4...". If set the file is only
+	// written to the server once the hash has been verified.
+	Checksum string
+	// Extract causes the downloaded file to be extracted into the directory, after
+	// which the archive itself is removed.
+	Extract bool
 }
 
 type Download struct {
@@ -142,6 +159,24 @@ func New(s *server.Server, r DownloadRequest) *Download {
 	return &dl
 }
 
+// ParseChecksum parses a checksum in the format of "algorithm:hex", returning the
+// hash to use for the algorithm and the expected sum.
+func ParseChecksum(checksum string) (hash.Hash, []byte, error) {
+	algorithm, sum, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, nil, ErrInvalidChecksum
+	}
+	h, ok := filesystem.NewHash(strings.ToLower(algorithm))
+	if !ok {
+		return nil, nil, ErrInvalidChecksum
+	}
+	b, err := hex.DecodeString(sum)
+	if err != nil || len(b) != h.Size() {
+		return nil, nil, ErrInvalidChecksum
+	}
+	return h, b, nil
+}
+
 // ByServer returns all the tracked downloads for a given server instance.
 func ByServer(sid string) []*Download {
 	instance.mu.Lock()
@@ -176,38 +211,185 @@ func (dl Download) MarshalJSON() ([]byte, error) {
 
 // Execute executes a given download for the server and begins writing the file to the disk. Once
 // completed the download will be removed from the cache.
+//
+// The file is first downloaded outside the server's data directory, resuming the download
+// if it is interrupted by a network error, and is only written into the server once it has
+// been completely downloaded and the checksum (if provided) matches.
 func (dl *Download) Execute() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*12)
 	dl.cancelFunc = &cancel
 	defer dl.Cancel()
 
-	// At this point we have verified the destination is not within the local network, so we can
-	// now make a request to that URL and pull down the file, saving it to the server's data
-	// directory.
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dl.req.URL.String(), nil)
+	var expected []byte
+	var h hash.Hash
+	if dl.req.Checksum != "" {
+		var err error
+		if h, expected, err = ParseChecksum(dl.req.Checksum); err != nil {
+			return err
+		}
+	}
+
+	// Wait for any other downloads to finish if the instance is already running the
+	// maximum number of downloads at once.
+	if err := instance.acquire(ctx); err != nil {
+		return errors.WrapIf(err, "downloader: failed to acquire download slot")
+	}
+	defer instance.release()
+
+	f, err := os.CreateTemp(config.Get().System.UploadDirectory, "download-*")
 	if err != nil {
-		return errors.WrapIf(err, "downloader: failed to create request")
+		return errors.WrapIf(err, "downloader: failed to create temporary file")
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	size, err := dl.fetch(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	if h != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return errors.WrapIf(err, "downloader: failed to calculate checksum")
+		}
+		if !bytes.Equal(h.Sum(nil), expected) {
+			return ErrChecksumMismatch
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p := dl.Path()
+	dl.server.Log().WithField("path", p).Debug("writing remote file to disk")
+
+	// Write will check that the size of the file won't exceed the disk limit.
+	fs := dl.server.Filesystem()
+	if err := fs.Write(p, f, size, 0o644); err != nil {
+		return errors.WrapIf(err, "downloader: failed to write file to server directory")
+	}
+
+	if dl.req.Extract {
+		// Check that the contents of the archive fit on the server before extracting
+		// anything, rather than leaving a partially extracted archive behind once the
+		// disk limit is reached.
+		if err := fs.SpaceAvailableForDecompression(ctx, dl.req.Directory, dl.path); err != nil {
+			_ = fs.Delete(p)
+			return errors.WrapIf(err, "downloader: failed to extract downloaded file")
+		}
+		if err := fs.DecompressFile(ctx, dl.req.Directory, dl.path); err != nil {
+			return errors.WrapIf(err, "downloader: failed to extract downloaded file")
+		}
+		if err := fs.Delete(p); err != nil {
+			return errors.WrapIf(err, "downloader: failed to remove downloaded archive")
+		}
+	}
+	return nil
+}
+
+// fetch downloads the file into the provided file, returning the size of the file
+// once it has been completely downloaded. If the download fails because of a network
+// error or a server error response it is resumed from where it left off, if the remote
+// server supports range requests, or otherwise started again.
+func (dl *Download) fetch(ctx context.Context, f *os.File) (int64, error) {
+	var size, offset int64
+	var validator string
+	for attempt := 0; ; attempt++ {
+		res, err := dl.request(ctx, offset, validator)
+		if err == nil {
+			switch {
+			case offset == 0 && res.StatusCode == http.StatusOK:
+				size, err = dl.start(res)
+				validator = res.Header.Get("ETag")
+				if validator == "" {
+					validator = res.Header.Get("Last-Modified")
+				}
+			case offset > 0 && res.StatusCode == http.StatusPartialContent && rangeStart(res) == offset:
+			case offset > 0 && res.StatusCode == http.StatusOK && res.ContentLength == size:
+				// The remote server does not support range requests, or the file has changed,
+				// so the download has to be started again.
+				offset = 0
+				dl.setProgress(0, size)
+			case res.StatusCode >= http.StatusInternalServerError:
+				err = errRetryable{errors.New("downloader: got bad response status from endpoint: " + res.Status)}
+			default:
+				err = errors.New("downloader: got bad response status from endpoint: " + res.Status)
+			}
+			if err == nil {
+				offset, err = dl.copy(f, res.Body, offset, size)
+			}
+			res.Body.Close()
+			if err == nil {
+				return size, nil
+			}
+		}
+
+		var retryable errRetryable
+		if ctx.Err() != nil || !errors.As(err, &retryable) || attempt >= config.Get().Api.RemoteDownload.MaxRetries {
+			return 0, err
+		}
+		dl.server.Log().WithField("download_id", dl.Identifier).WithField("offset", offset).WithField("error", err).Warn("remote file download failed, retrying")
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
 	}
+}
 
+// request makes a request for the remote file starting at the given offset.
+func (dl *Download) request(ctx context.Context, offset int64, validator string) (*http.Response, error) {
+	if err := CheckURL(dl.req.URL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dl.req.URL.String(), nil)
+	if err != nil {
+		return nil, errors.WrapIf(err, "downloader: failed to create request")
+	}
 	req.Header.Set("User-Agent", "Pterodactyl Panel (https://pterodactyl.io)")
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
 	res, err := client.Do(req)
 	if err != nil {
-		return ErrDownloadFailed
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return errors.New("downloader: got bad response status from endpoint: " + res.Status)
+		// Requests that were rejected because they resolve to an internal address
+		// should never be retried.
+		if errors.Is(err, ErrInternalResolution) || errors.Is(err, ErrInvalidIPAddress) || ctx.Err() != nil {
+			return nil, ErrDownloadFailed
+		}
+		for _, e := range []error{ErrDomainNotAllowed, ErrInvalidScheme, ErrTooManyRedirects} {
+			if errors.Is(err, e) {
+				return nil, e
+			}
+		}
+		return nil, errRetryable{ErrDownloadFailed}
 	}
+	return res, nil
+}
 
+// start handles the initial response for a download, determining the name of the
+// file and checking that there is enough space for it.
+func (dl *Download) start(res *http.Response) (int64, error) {
 	if res.ContentLength < 1 {
-		return errors.New("downloader: request is missing ContentLength")
+		return 0, errors.New("downloader: request is missing ContentLength")
+	}
+	if max := config.Get().Api.RemoteDownload.MaxSize; max > 0 && res.ContentLength > max*1024*1024 {
+		return 0, ErrDownloadTooLarge
 	}
 
 	if dl.req.UseHeader {
 		if contentDisposition := res.Header.Get("Content-Disposition"); contentDisposition != "" {
 			_, params, err := mime.ParseMediaType(contentDisposition)
 			if err != nil {
-				return errors.WrapIf(err, "downloader: invalid \"Content-Disposition\" header")
+				return 0, errors.WrapIf(err, "downloader: invalid \"Content-Disposition\" header")
 			}
 
 			if v, ok := params["filename"]; ok {
@@ -224,16 +406,40 @@ func (dl *Download) Execute() error {
 		}
 	}
 
-	p := dl.Path()
-	dl.server.Log().WithField("path", p).Debug("writing remote file to disk")
+	fs := dl.server.Filesystem()
+	if err := fs.IsIgnored(dl.Path()); err != nil {
+		return 0, err
+	}
+	if err := fs.HasSpaceFor(res.ContentLength); err != nil {
+		return 0, err
+	}
+	return res.ContentLength, nil
+}
 
-	// Write the file while tracking the progress, Write will check that the
-	// size of the file won't exceed the disk limit.
-	r := io.TeeReader(res.Body, dl.counter(res.ContentLength))
-	if err := dl.server.Filesystem().Write(p, r, res.ContentLength, 0o644); err != nil {
-		return errors.WrapIf(err, "downloader: failed to write file to server directory")
+// copy writes the response body to the file at the given offset, returning the
+// offset that has been written up to. Errors while reading the body are treated
+// as retryable.
+func (dl *Download) copy(f *os.File, body io.Reader, offset int64, size int64) (int64, error) {
+	if err := f.Truncate(offset); err != nil {
+		return offset, errors.WithStack(err)
 	}
-	return nil
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, errors.WithStack(err)
+	}
+	c := dl.counter(offset, size)
+	n, err := io.Copy(f, io.TeeReader(io.LimitReader(body, size-offset), c))
+	offset += n
+	if err != nil {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			return offset, errors.WithStack(err)
+		}
+		return offset, errRetryable{errors.WithStack(err)}
+	}
+	if offset != size {
+		return offset, errRetryable{io.ErrUnexpectedEOF}
+	}
+	return offset, nil
 }
 
 // Cancel cancels a running download and frees up the associated resources. If a file is being
@@ -264,23 +470,56 @@ func (dl *Download) Path() string {
 
 // Handles a write event by updating the progress completed percentage and firing off
 // events to the server websocket as needed.
-func (dl *Download) counter(contentLength int64) *Counter {
+func (dl *Download) counter(offset int64, contentLength int64) *Counter {
 	onWrite := func(t int) {
-		dl.mu.Lock()
-		defer dl.mu.Unlock()
-		dl.progress = float64(t) / float64(contentLength)
+		dl.setProgress(offset+int64(t), contentLength)
 	}
 	return &Counter{
 		onWrite: onWrite,
 	}
 }
 
+func (dl *Download) setProgress(written int64, contentLength int64) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.progress = float64(written) / float64(contentLength)
+}
+
 // Downloader represents a global downloader that keeps track of all currently processing downloads
 // for the machine.
 type Downloader struct {
 	mu            sync.RWMutex
 	downloadCache map[string]*Download
 	serverCache   map[string][]string
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+}
+
+// acquire waits until there are fewer than the configured maximum number of
+// downloads running on the instance.
+func (d *Downloader) acquire(ctx context.Context) error {
+	d.slotsOnce.Do(func() {
+		if max := config.Get().Api.RemoteDownload.MaxConcurrent; max > 0 {
+			d.slots = make(chan struct{}, max)
+		}
+	})
+	if d.slots == nil {
+		return nil
+	}
+	select {
+	case d.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees up the download slot acquired by a download.
+func (d *Downloader) release() {
+	if d.slots != nil {
+		<-d.slots
+	}
 }
 
 // track tracks a download in the internal cache for this instance.
@@ -335,3 +574,18 @@ func mustParseCIDR(ip string) *net.IPNet {
 	}
 	return block
 }
+
+// rangeStart returns the first byte of the range returned in a partial content
+// response, or -1 if the Content-Range header cannot be parsed.
+func rangeStart(res *http.Response) int64 {
+	v, ok := strings.CutPrefix(res.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(v, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,335 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+)
+
+// newTestServer returns a server with an empty data directory and the given disk
+// space limit in MB.
+func newTestServer(disk int64) *server.Server {
+	id := uuid.Must(uuid.NewRandom()).String()
+	if err := os.MkdirAll(filepath.Join(config.Get().System.Data, id), 0o755); err != nil {
+		panic(err)
+	}
+	s, err := server.NewEmptyManager(nil).InitServer(remote.ServerConfigurationResponse{
+		Settings: []byte(fmt.Sprintf(`{"uuid":"%s","build":{"disk_space":%d}}`, id, disk)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// serverFiles returns the names of the files in the root directory of a server.
+func serverFiles(s *server.Server) []string {
+	entries, err := os.ReadDir(s.Filesystem().Path())
+	if err != nil {
+		panic(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// testRemote is a remote server that responds to each request for a file using
+// the next handler, recording the requests that were made.
+type testRemote struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers []http.HandlerFunc
+	requests []*http.Request
+}
+
+func newTestRemote(handlers ...http.HandlerFunc) *testRemote {
+	r := &testRemote{handlers: handlers}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		i := len(r.requests)
+		r.requests = append(r.requests, req)
+		r.mu.Unlock()
+		r.handlers[min(i, len(r.handlers)-1)](w, req)
+	}))
+	return r
+}
+
+func (r *testRemote) url(p string) *url.URL {
+	u, err := url.Parse(r.URL + p)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// respond returns a handler that sends the content with the given status code
+// and headers.
+func respond(status int, content string, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for i := 0; i < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(content))
+	}
+}
+
+// interrupt returns a handler that starts sending the content but closes the
+// connection after the given number of bytes.
+func interrupt(content string, n int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for i := 0; i < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write([]byte(content[:n]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func TestDownloader(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.Data = t.TempDir()
+	cfg.System.UploadDirectory = t.TempDir()
+	cfg.Api.RemoteDownload.AllowedIPs = []string{"127.0.0.1", "::1"}
+	cfg.Api.RemoteDownload.MaxRetries = 1
+	config.Set(cfg)
+
+	content := strings.Repeat("0123456789", 100)
+	sum := sha256.Sum256([]byte(content))
+
+	download := func(s *server.Server, r DownloadRequest) error {
+		err := New(s, r).Execute()
+		uploads, rerr := os.ReadDir(config.Get().System.UploadDirectory)
+		g.Assert(rerr).IsNil()
+		g.Assert(len(uploads)).Equal(0)
+		return err
+	}
+
+	g.Describe("Download#Execute", func() {
+		g.It("downloads a file into the server", func() {
+			remote := newTestRemote(respond(http.StatusOK, content))
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/files/a.txt")})
+			g.Assert(err).IsNil()
+			b, err := os.ReadFile(filepath.Join(s.Filesystem().Path(), "a.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal(content)
+		})
+
+		g.It("resumes an interrupted download", func() {
+			remote := newTestRemote(
+				interrupt(content, 400, "ETag", `"v1"`),
+				respond(http.StatusPartialContent, content[400:], "Content-Range", "bytes 400-999/1000"),
+			)
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.txt")})
+			g.Assert(err).IsNil()
+			b, err := os.ReadFile(filepath.Join(s.Filesystem().Path(), "a.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal(content)
+
+			g.Assert(len(remote.requests)).Equal(2)
+			g.Assert(remote.requests[0].Header.Get("Range")).Equal("")
+			g.Assert(remote.requests[1].Header.Get("Range")).Equal("bytes=400-")
+			g.Assert(remote.requests[1].Header.Get("If-Range")).Equal(`"v1"`)
+		})
+
+		g.It("starts again if the remote server sends the entire file", func() {
+			remote := newTestRemote(
+				interrupt(content, 400, "Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT"),
+				respond(http.StatusOK, content),
+			)
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.txt")})
+			g.Assert(err).IsNil()
+			b, err := os.ReadFile(filepath.Join(s.Filesystem().Path(), "a.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal(content)
+			g.Assert(remote.requests[1].Header.Get("If-Range")).Equal("Mon, 02 Jan 2006 15:04:05 GMT")
+		})
+
+		g.It("fails if the remote server resumes from the wrong offset", func() {
+			remote := newTestRemote(
+				interrupt(content, 400),
+				respond(http.StatusPartialContent, content[300:], "Content-Range", "bytes 300-999/1000"),
+			)
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.txt")})
+			g.Assert(err).IsNotNil()
+			g.Assert(len(remote.requests)).Equal(2)
+			g.Assert(serverFiles(s)).Equal([]string{})
+		})
+
+		g.It("stops retrying once the retry limit is reached", func() {
+			remote := newTestRemote(respond(http.StatusBadGateway, "bad gateway"))
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.txt")})
+			g.Assert(err).IsNotNil()
+			g.Assert(len(remote.requests)).Equal(2)
+			g.Assert(serverFiles(s)).Equal([]string{})
+		})
+
+		g.It("does not retry client error responses", func() {
+			remote := newTestRemote(respond(http.StatusNotFound, "not found"))
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.txt")})
+			g.Assert(err).IsNotNil()
+			g.Assert(len(remote.requests)).Equal(1)
+		})
+
+		g.It("writes the file once the checksum matches", func() {
+			remote := newTestRemote(respond(http.StatusOK, content))
+			defer remote.Close()
+			s := newTestServer(0)
+			err := download(s, DownloadRequest{
+				Directory: "/",
+				URL:       remote.url("/a.txt"),
+				FileName:  "b.txt",
+				Checksum:  "SHA256:" + hex.EncodeToString(sum[:]),
+			})
+			g.Assert(err).IsNil()
+			g.Assert(serverFiles(s)).Equal([]string{"b.txt"})
+		})
+
+		g.It("does not write anything to the server if the checksum does not match", func() {
+			remote := newTestRemote(respond(http.StatusOK, content))
+			defer remote.Close()
+			s := newTestServer(0)
+			other := sha256.Sum256([]byte("other"))
+			err := download(s, DownloadRequest{
+				Directory: "/",
+				URL:       remote.url("/a.txt"),
+				Checksum:  "sha256:" + hex.EncodeToString(other[:]),
+			})
+			g.Assert(err).Equal(ErrChecksumMismatch)
+			g.Assert(serverFiles(s)).Equal([]string{})
+		})
+
+		g.Describe("extracting archives", func() {
+			archive := func(name string, size int) string {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				tw := tar.NewWriter(gz)
+				if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(size), Typeflag: tar.TypeReg}); err != nil {
+					panic(err)
+				}
+				if _, err := tw.Write(make([]byte, size)); err != nil {
+					panic(err)
+				}
+				_ = tw.Close()
+				_ = gz.Close()
+				return buf.String()
+			}
+
+			g.It("extracts the archive and removes it", func() {
+				remote := newTestRemote(respond(http.StatusOK, archive("inside.bin", 1024)))
+				defer remote.Close()
+				s := newTestServer(1)
+				err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.tar.gz"), Extract: true})
+				g.Assert(err).IsNil()
+				g.Assert(serverFiles(s)).Equal([]string{"inside.bin"})
+			})
+
+			g.It("does not extract an archive that does not fit on the server", func() {
+				remote := newTestRemote(respond(http.StatusOK, archive("inside.bin", 2*1024*1024)))
+				defer remote.Close()
+				s := newTestServer(1)
+				err := download(s, DownloadRequest{Directory: "/", URL: remote.url("/a.tar.gz"), Extract: true})
+				g.Assert(err).IsNotNil()
+				g.Assert(serverFiles(s)).Equal([]string{})
+			})
+		})
+	})
+
+	g.Describe("ParseChecksum", func() {
+		g.It("parses checksums for each algorithm", func() {
+			for _, c := range []struct {
+				checksum string
+				size     int
+			}{
+				{"sha256:" + hex.EncodeToString(sum[:]), 32},
+				{"SHA256:" + strings.ToUpper(hex.EncodeToString(sum[:])), 32},
+				{"md5:" + strings.Repeat("ab", 16), 16},
+				{"sha1:" + strings.Repeat("ab", 20), 20},
+				{"sha512:" + strings.Repeat("ab", 64), 64},
+				{"crc32:deadbeef", 4},
+			} {
+				h, b, err := ParseChecksum(c.checksum)
+				g.Assert(err).IsNil(c.checksum)
+				g.Assert(h.Size()).Equal(c.size, c.checksum)
+				g.Assert(len(b)).Equal(c.size, c.checksum)
+			}
+		})
+
+		g.It("rejects invalid checksums", func() {
+			for _, c := range []string{
+				"",
+				hex.EncodeToString(sum[:]),
+				"sha3:" + hex.EncodeToString(sum[:]),
+				"sha256:" + hex.EncodeToString(sum[:16]),
+				"sha256:" + strings.Repeat("zz", 32),
+				"sha256:",
+			} {
+				_, _, err := ParseChecksum(c)
+				g.Assert(err).Equal(ErrInvalidChecksum, c)
+			}
+		})
+	})
+
+	g.Describe("rangeStart", func() {
+		g.It("returns the start of the range in the response", func() {
+			for _, c := range []struct {
+				header string
+				start  int64
+			}{
+				{"bytes 400-999/1000", 400},
+				{"bytes 0-0/*", 0},
+				{"bytes 12345678901-12345678999/12345679000", 12345678901},
+				{"", -1},
+				{"bytes */1000", -1},
+				{"items 400-999/1000", -1},
+				{"bytes abc-999/1000", -1},
+			} {
+				res := &http.Response{Header: http.Header{}}
+				res.Header.Set("Content-Range", c.header)
+				g.Assert(rangeStart(res)).Equal(c.start, c.header)
+			}
+		})
+	})
+}
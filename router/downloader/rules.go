@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/config"
+)
+
+const (
+	ErrDomainNotAllowed = errors.Sentinel("downloader: downloads from this domain are not allowed")
+	ErrInvalidScheme    = errors.Sentinel("downloader: only http and https URLs can be downloaded")
+	ErrTooManyRedirects = errors.Sentinel("downloader: too many redirects")
+)
+
+// CheckURL returns an error if the URL cannot be downloaded from because of the
+// configured domain rules. The address the domain resolves to is checked when
+// the connection is made.
+func CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrInvalidScheme
+	}
+	cfg := config.Get().Api.RemoteDownload
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return ErrDomainNotAllowed
+	}
+	if matchesDomain(cfg.DeniedDomains, host) {
+		return ErrDomainNotAllowed
+	}
+	if len(cfg.AllowedDomains) > 0 && !matchesDomain(cfg.AllowedDomains, host) {
+		return ErrDomainNotAllowed
+	}
+	return nil
+}
+
+// checkIP returns an error if a connection to the given address is not allowed.
+// Addresses in the denied ranges are always rejected, while addresses in the
+// allowed ranges can be used even if they are within the local network.
+func checkIP(ip net.IP) error {
+	rules := config.GetRemoteDownloadIPRules()
+	if rules.Denied.Contains(ip) {
+		return ErrInternalResolution
+	}
+	if rules.Allowed.Contains(ip) {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return ErrInternalResolution
+	}
+	if internalRanges.Contains(ip) {
+		return ErrInternalResolution
+	}
+	return nil
+}
+
+// checkRedirect is called before following a redirect. Redirects are refused
+// unless they have been enabled, in which case each location is checked against
+// the domain rules in the same way as the original URL.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	cfg := config.Get().Api.RemoteDownload
+	if !cfg.FollowRedirects {
+		// This specific error response just causes the client to not follow the redirect
+		// and returns the actual redirect response to the caller.
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= cfg.MaxRedirects {
+		return ErrTooManyRedirects
+	}
+	return CheckURL(req.URL)
+}
+
+// matchesDomain returns true if the host is one of the domains, or a subdomain of
+// any of them. Domains may optionally be prefixed with "*." or ".".
+func matchesDomain(domains []string, host string) bool {
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(d), "*"), ".")
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package downloader
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+)
+
+// setRemoteDownloadConfig sets a configuration with the default remote download
+// settings, after applying the given changes to them.
+func setRemoteDownloadConfig(fn func(c *config.RemoteDownloadConfiguration)) {
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	fn(&cfg.Api.RemoteDownload)
+	config.Set(cfg)
+}
+
+func mustParseURL(v string) *url.URL {
+	u, err := url.Parse(v)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestRules(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("CheckURL", func() {
+		g.It("allows any domain by default", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {})
+			for _, u := range []string{"https://example.com/a.jar", "http://cdn.example.com:8080/a.jar", "https://203.0.113.10/a.jar"} {
+				g.Assert(CheckURL(mustParseURL(u))).IsNil(u)
+			}
+		})
+
+		g.It("checks the allowed and denied domains", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.AllowedDomains = []string{"curseforge.com", "*.github.com", ".papermc.io"}
+				c.DeniedDomains = []string{"evil.curseforge.com"}
+			})
+			cases := []struct {
+				url string
+				err error
+			}{
+				{"https://curseforge.com/a.jar", nil},
+				{"https://edge.curseforge.com/a.jar", nil},
+				{"https://CurseForge.COM./a.jar", nil},
+				{"https://objects.github.com/a.jar", nil},
+				{"https://github.com/a.jar", nil},
+				{"https://api.papermc.io/a.jar", nil},
+				{"https://evil.curseforge.com/a.jar", ErrDomainNotAllowed},
+				{"https://cdn.evil.curseforge.com/a.jar", ErrDomainNotAllowed},
+				{"https://evilcurseforge.com/a.jar", ErrDomainNotAllowed},
+				{"https://curseforge.com.evil.com/a.jar", ErrDomainNotAllowed},
+				{"https://example.com/curseforge.com", ErrDomainNotAllowed},
+				{"https://203.0.113.10/a.jar", ErrDomainNotAllowed},
+				{"https:///a.jar", ErrDomainNotAllowed},
+				{"ftp://curseforge.com/a.jar", ErrInvalidScheme},
+				{"file:///etc/passwd", ErrInvalidScheme},
+			}
+			for _, c := range cases {
+				g.Assert(CheckURL(mustParseURL(c.url))).Equal(c.err, c.url)
+			}
+		})
+
+		g.It("only checks the denied domains if no domains are allowed", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.DeniedDomains = []string{"example.com"}
+			})
+			g.Assert(CheckURL(mustParseURL("https://example.com/a.jar"))).Equal(ErrDomainNotAllowed)
+			g.Assert(CheckURL(mustParseURL("https://www.example.com/a.jar"))).Equal(ErrDomainNotAllowed)
+			g.Assert(CheckURL(mustParseURL("https://example.org/a.jar"))).IsNil()
+		})
+	})
+
+	g.Describe("matchesDomain", func() {
+		g.It("matches domains and their subdomains", func() {
+			domains := []string{"curseforge.com", "*.Modrinth.com", ".papermc.io", "", "*"}
+			cases := []struct {
+				host    string
+				matches bool
+			}{
+				{"curseforge.com", true},
+				{"media.forgecdn.curseforge.com", true},
+				{"modrinth.com", true},
+				{"cdn.modrinth.com", true},
+				{"papermc.io", true},
+				{"api.papermc.io", true},
+				{"evilcurseforge.com", false},
+				{"curseforge.com.evil.com", false},
+				{"curseforge.co", false},
+				{"com", false},
+				{"", false},
+			}
+			for _, c := range cases {
+				g.Assert(matchesDomain(domains, c.host)).Equal(c.matches, c.host)
+			}
+		})
+	})
+
+	g.Describe("checkIP", func() {
+		g.It("blocks addresses within the local network", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {})
+			cases := []struct {
+				ip  string
+				err error
+			}{
+				{"203.0.113.10", nil},
+				{"2001:db8::1", nil},
+				{"127.0.0.1", ErrInternalResolution},
+				{"10.1.2.3", ErrInternalResolution},
+				{"172.16.0.1", ErrInternalResolution},
+				{"192.168.1.1", ErrInternalResolution},
+				{"169.254.169.254", ErrInternalResolution},
+				{"0.0.0.0", ErrInternalResolution},
+				{"::1", ErrInternalResolution},
+				{"::", ErrInternalResolution},
+				{"fe80::1", ErrInternalResolution},
+				{"fd00::1", ErrInternalResolution},
+				{"::ffff:127.0.0.1", ErrInternalResolution},
+			}
+			for _, c := range cases {
+				g.Assert(checkIP(net.ParseIP(c.ip))).Equal(c.err, c.ip)
+			}
+		})
+
+		g.It("allows an internal mirror and denies other addresses", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.AllowedIPs = []string{"10.0.5.0/24", "invalid", "fd00::10"}
+				c.DeniedIPs = []string{"10.0.5.66", "203.0.113.0/24"}
+			})
+			cases := []struct {
+				ip  string
+				err error
+			}{
+				{"10.0.5.10", nil},
+				{"fd00::10", nil},
+				{"10.0.5.66", ErrInternalResolution},
+				{"10.0.6.10", ErrInternalResolution},
+				{"fd00::11", ErrInternalResolution},
+				{"203.0.113.10", ErrInternalResolution},
+				{"198.51.100.1", nil},
+			}
+			for _, c := range cases {
+				g.Assert(checkIP(net.ParseIP(c.ip))).Equal(c.err, c.ip)
+			}
+		})
+
+		g.It("uses the rules from the configuration that was last set", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.AllowedIPs = []string{"10.0.5.10"}
+			})
+			g.Assert(checkIP(net.ParseIP("10.0.5.10"))).IsNil()
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {})
+			g.Assert(checkIP(net.ParseIP("10.0.5.10"))).Equal(ErrInternalResolution)
+		})
+	})
+
+	g.Describe("checkRedirect", func() {
+		req := func(u string) *http.Request {
+			return &http.Request{URL: mustParseURL(u)}
+		}
+		via := func(n int) []*http.Request {
+			out := make([]*http.Request, n)
+			for i := range out {
+				out[i] = req("https://curseforge.com/a.jar")
+			}
+			return out
+		}
+
+		g.It("does not follow redirects unless enabled", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {})
+			g.Assert(checkRedirect(req("https://curseforge.com/b.jar"), via(1))).Equal(http.ErrUseLastResponse)
+		})
+
+		g.It("checks every location against the domain rules", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.FollowRedirects = true
+				c.AllowedDomains = []string{"curseforge.com"}
+				c.DeniedDomains = []string{"evil.curseforge.com"}
+			})
+			g.Assert(checkRedirect(req("https://edge.curseforge.com/b.jar"), via(1))).IsNil()
+			g.Assert(checkRedirect(req("https://evil.curseforge.com/b.jar"), via(1))).Equal(ErrDomainNotAllowed)
+			g.Assert(checkRedirect(req("https://example.com/b.jar"), via(1))).Equal(ErrDomainNotAllowed)
+			g.Assert(checkRedirect(req("file:///etc/passwd"), via(1))).Equal(ErrInvalidScheme)
+		})
+
+		g.It("limits the number of redirects", func() {
+			setRemoteDownloadConfig(func(c *config.RemoteDownloadConfiguration) {
+				c.FollowRedirects = true
+				c.MaxRedirects = 3
+			})
+			g.Assert(checkRedirect(req("https://curseforge.com/b.jar"), via(2))).IsNil()
+			g.Assert(checkRedirect(req("https://curseforge.com/b.jar"), via(3))).Equal(ErrTooManyRedirects)
+			g.Assert(checkRedirect(req("https://curseforge.com/b.jar"), via(10))).Equal(ErrTooManyRedirects)
+		})
+	})
+}
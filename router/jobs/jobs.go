@@ -0,0 +1,386 @@
+// Package jobs tracks long-running operations performed against a server, such
+// as creating a backup or compressing files. Each job is given an identifier that
+// can be used to check on its progress, or to cancel it, and a record of every
+// job is kept in the database once it has finished.
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/progress"
+	"github.com/pterodactyl/wings/router/middleware"
+	"github.com/pterodactyl/wings/server"
+)
+
+// Type is the kind of operation a job performs.
+type Type string
+
+const (
+	TypeCompress   Type = "compress"
+	TypeDecompress Type = "decompress"
+	TypeBatch      Type = "batch"
+	TypeBackup     Type = "backup"
+	TypeRestore    Type = "restore"
+	TypeInstall    Type = "install"
+	TypeReinstall  Type = "reinstall"
+	TypeTransfer   Type = "transfer"
+)
+
+// Status is the state of a job.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+const (
+	ErrNotFound       = errors.Sentinel("jobs: job does not exist")
+	ErrNotRunning     = errors.Sentinel("jobs: job is not running")
+	ErrNotCancellable = errors.Sentinel("jobs: job cannot be cancelled")
+)
+
+// MaxListLimit is the largest number of jobs that can be returned by List.
+const MaxListLimit = 500
+
+// How often the progress of a running job is published to the server websocket.
+const progressInterval = time.Second * 2
+
+var instance = &tracker{
+	jobs: make(map[string]*Job),
+}
+
+// Func is the operation performed by a job. The context is canceled if the job
+// is cancelled or the server is deleted. The value returned is stored as the
+// result of the job once it has completed, or been cancelled.
+type Func func(ctx context.Context, j *Job) (interface{}, error)
+
+// Job is a long-running operation performed against a server. Jobs are kept in
+// memory while they are running, and are only available from the database once
+// they have finished.
+type Job struct {
+	ID   string
+	Type Type
+
+	server *server.Server
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.RWMutex
+	status      Status
+	err         string
+	errID       string
+	result      json.RawMessage
+	progress    *progress.Progress
+	cancellable bool
+	cancelled   bool
+	onCancel    func()
+	createdAt   time.Time
+	startedAt   *time.Time
+	completedAt *time.Time
+}
+
+// New creates a new job for the given server. The job is not started until Run
+// is called.
+func New(s *server.Server, t Type) *Job {
+	ctx, cancel := context.WithCancel(s.Context())
+	j := &Job{
+		ID:        uuid.Must(uuid.NewRandom()).String(),
+		Type:      t,
+		server:    s,
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    StatusPending,
+		progress:  progress.NewProgress(0),
+		createdAt: time.Now().UTC(),
+	}
+	instance.track(j)
+	j.save()
+	return j
+}
+
+// ByID returns the running job matching the given identifier, or nil if there
+// is no such job.
+func ByID(id string) *Job {
+	return instance.find(id)
+}
+
+// Find returns the job matching the given identifier, either from the jobs that
+// are running or from the history of jobs that have finished.
+func Find(ctx context.Context, id string) (*models.Job, error) {
+	if j := ByID(id); j != nil {
+		m := j.Model()
+		return &m, nil
+	}
+	var m models.Job
+	tx := database.Instance().WithContext(ctx).Where("id = ?", id).Limit(1).Find(&m)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &m, nil
+}
+
+// Filter limits the jobs returned by List.
+type Filter struct {
+	Server string
+	Type   string
+	Status string
+	Limit  int
+}
+
+// List returns the most recent jobs matching the filter, newest first. Running
+// jobs are returned with their current progress.
+func List(ctx context.Context, f Filter) ([]models.Job, error) {
+	tx := database.Instance().WithContext(ctx)
+	if f.Server != "" {
+		tx = tx.Where("server = ?", f.Server)
+	}
+	if f.Type != "" {
+		tx = tx.Where("type = ?", f.Type)
+	}
+	if f.Status != "" {
+		tx = tx.Where("status = ?", f.Status)
+	}
+	if f.Limit < 1 || f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+	rows := []models.Job{}
+	if tx = tx.Order("created_at DESC").Limit(f.Limit).Find(&rows); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	for i, r := range rows {
+		if j := ByID(r.ID); j != nil {
+			rows[i] = j.Model()
+		}
+	}
+	return rows, nil
+}
+
+// MarkInterrupted marks any jobs that were still running when Wings was last
+// stopped as having failed, since they will never finish.
+func MarkInterrupted(ctx context.Context) error {
+	tx := database.Instance().WithContext(ctx).Model(&models.Job{}).
+		Where("status IN ?", []Status{StatusPending, StatusRunning}).
+		Updates(map[string]interface{}{
+			"status":       StatusFailed,
+			"error":        "Wings was stopped before the job finished.",
+			"completed_at": time.Now().UTC(),
+		})
+	return errors.WithStack(tx.Error)
+}
+
+// Prune removes the jobs that finished longer ago than the configured retention
+// period from the database.
+func Prune(ctx context.Context) error {
+	before := time.Now().Add(-time.Duration(config.Get().System.JobHistoryRetention) * time.Second)
+	tx := database.Instance().WithContext(ctx).Where("completed_at < ?", before).Delete(&models.Job{})
+	return errors.WithStack(tx.Error)
+}
+
+// Cancellable allows the job to be cancelled, which cancels the context passed
+// to the job. If fn is not nil it is also called when the job is cancelled, for
+// operations that need to do more than watch the context to stop.
+func (j *Job) Cancellable(fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cancellable = true
+	j.onCancel = fn
+}
+
+// Progress returns the progress tracker of the job, which operations can write
+// through or update the total of as they run.
+func (j *Job) Progress() *progress.Progress {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.progress
+}
+
+// SetProgress replaces the progress tracker of the job, for operations that
+// already track their own progress.
+func (j *Job) SetProgress(p *progress.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = p
+}
+
+// Run performs the operation of the job, publishing the status and progress of
+// the job to the server websocket as it runs. This function blocks until the
+// operation has finished.
+func (j *Job) Run(fn Func) {
+	defer j.cancel()
+	defer instance.remove(j.ID)
+
+	now := time.Now().UTC()
+	j.mu.Lock()
+	j.status = StatusRunning
+	j.startedAt = &now
+	j.mu.Unlock()
+	j.save()
+	j.publish()
+
+	done := make(chan struct{})
+	go j.publishProgress(done)
+	v, err := fn(j.ctx, j)
+	close(done)
+
+	j.finish(v, err)
+}
+
+// Cancel stops a running job. ErrNotCancellable is returned if the operation
+// performed by the job cannot be stopped once it has started.
+func (j *Job) Cancel() error {
+	j.mu.Lock()
+	if j.status != StatusPending && j.status != StatusRunning {
+		j.mu.Unlock()
+		return ErrNotRunning
+	}
+	if !j.cancellable {
+		j.mu.Unlock()
+		return ErrNotCancellable
+	}
+	j.cancelled = true
+	fn := j.onCancel
+	j.mu.Unlock()
+
+	j.cancel()
+	if fn != nil {
+		fn()
+	}
+	return nil
+}
+
+// Model returns the current state of the job.
+func (j *Job) Model() models.Job {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return models.Job{
+		ID:          j.ID,
+		Server:      j.server.ID(),
+		Type:        string(j.Type),
+		Status:      string(j.status),
+		Error:       j.err,
+		ErrorID:     j.errID,
+		Result:      j.result,
+		Written:     j.progress.Written(),
+		Total:       j.progress.Total(),
+		Cancellable: j.cancellable,
+		CreatedAt:   j.createdAt,
+		StartedAt:   j.startedAt,
+		CompletedAt: j.completedAt,
+	}
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Model())
+}
+
+// finish records the outcome of the job once the operation has returned.
+func (j *Job) finish(v interface{}, err error) {
+	now := time.Now().UTC()
+	j.mu.Lock()
+	j.completedAt = &now
+	if v != nil {
+		if b, merr := json.Marshal(v); merr == nil {
+			j.result = b
+		}
+	}
+	switch {
+	case err == nil:
+		j.status = StatusCompleted
+	case j.cancelled, errors.Is(err, context.Canceled):
+		j.status = StatusCancelled
+	default:
+		// The error is only logged, since it can contain paths on the machine and
+		// other details that should not be shown to users.
+		j.status = StatusFailed
+		j.errID = uuid.Must(uuid.NewRandom()).String()
+		if j.err = middleware.FilesystemErrorMessage(err); j.err == "" {
+			j.err = "An unexpected error was encountered while running this job."
+		}
+	}
+	j.mu.Unlock()
+
+	if j.status == StatusFailed {
+		j.server.Log().WithField("job_id", j.ID).
+			WithField("type", j.Type).
+			WithField("error_identifier", j.errID).
+			WithField("error", err).
+			Warn("jobs: job failed")
+	}
+	j.save()
+	j.publish()
+}
+
+// publishProgress publishes the status of the job to the server websocket as
+// its progress changes, until done is closed.
+func (j *Job) publishProgress(done chan struct{}) {
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+
+	var last uint64
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if w := j.Progress().Written(); w != last {
+				last = w
+				j.publish()
+			}
+		}
+	}
+}
+
+func (j *Job) publish() {
+	j.server.Events().Publish(server.JobStatusEvent, j.Model())
+}
+
+// save stores the current state of the job in the database. A failure to save
+// the job does not stop it from running, it is only missing from the history.
+func (j *Job) save() {
+	m := j.Model()
+	if tx := database.Instance().Save(&m); tx.Error != nil {
+		j.server.Log().WithField("job_id", j.ID).
+			WithField("error", tx.Error).
+			Warn("jobs: failed to save job to database")
+	}
+}
+
+// tracker keeps track of all the running jobs on this instance.
+type tracker struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func (t *tracker) track(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[j.ID] = j
+}
+
+func (t *tracker) find(id string) *Job {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.jobs[id]
+}
+
+func (t *tracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.jobs, id)
+}
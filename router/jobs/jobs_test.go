@@ -0,0 +1,291 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/events"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+)
+
+// newTestServer returns a server with an empty data directory and a disk space
+// limit of 1MB.
+func newTestServer() *server.Server {
+	id := uuid.Must(uuid.NewRandom()).String()
+	if err := os.MkdirAll(filepath.Join(config.Get().System.Data, id), 0o755); err != nil {
+		panic(err)
+	}
+	s, err := server.NewEmptyManager(nil).InitServer(remote.ServerConfigurationResponse{
+		Settings: []byte(fmt.Sprintf(`{"uuid":"%s","build":{"disk_space":1}}`, id)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// savedJob returns the job stored in the database.
+func savedJob(id string) models.Job {
+	var m models.Job
+	if tx := database.Instance().Where("id = ?", id).First(&m); tx.Error != nil {
+		panic(tx.Error)
+	}
+	return m
+}
+
+// statuses returns the statuses of the jobs published to the server websocket
+// while fn runs.
+func statuses(s *server.Server, fn func()) []string {
+	ch := make(chan []byte, 16)
+	s.Events().On(ch)
+	fn()
+	s.Events().Off(ch)
+	out := []string{}
+	for b := range ch {
+		var e struct {
+			Topic string
+			Data  models.Job
+		}
+		if err := events.DecodeTo(b, &e); err == nil && e.Topic == server.JobStatusEvent {
+			out = append(out, e.Data.Status)
+		}
+	}
+	return out
+}
+
+func TestJobs(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.RootDirectory = t.TempDir()
+	cfg.System.Data = t.TempDir()
+	config.Set(cfg)
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	g.Describe("Job#Run", func() {
+		g.It("saves the job and publishes its status", func() {
+			s := newTestServer()
+			j := New(s, TypeCompress)
+			g.Assert(savedJob(j.ID).Status).Equal(string(StatusPending))
+			g.Assert(ByID(j.ID) == j).IsTrue()
+
+			var running models.Job
+			published := statuses(s, func() {
+				j.Run(func(_ context.Context, j *Job) (interface{}, error) {
+					running = savedJob(j.ID)
+					j.Progress().SetTotal(10)
+					_, _ = j.Progress().Write(make([]byte, 10))
+					return map[string]string{"file": "archive.tar.gz"}, nil
+				})
+			})
+			g.Assert(published).Equal([]string{string(StatusRunning), string(StatusCompleted)})
+			g.Assert(running.Status).Equal(string(StatusRunning))
+			g.Assert(running.StartedAt != nil).IsTrue()
+
+			g.Assert(ByID(j.ID) == nil).IsTrue()
+			m, err := Find(ctx, j.ID)
+			g.Assert(err).IsNil()
+			g.Assert(m.Status).Equal(string(StatusCompleted))
+			g.Assert(m.Server).Equal(s.ID())
+			g.Assert(string(m.Result)).Equal(`{"file":"archive.tar.gz"}`)
+			g.Assert(m.Written).Equal(uint64(10))
+			g.Assert(m.Total).Equal(uint64(10))
+			g.Assert(m.Error).Equal("")
+			g.Assert(m.CompletedAt != nil).IsTrue()
+		})
+
+		g.It("does not expose the error of a failed job", func() {
+			s := newTestServer()
+			j := New(s, TypeDecompress)
+			j.Run(func(_ context.Context, _ *Job) (interface{}, error) {
+				return nil, errors.New("open /var/lib/pterodactyl/volumes/secret.zip: permission denied")
+			})
+
+			m := savedJob(j.ID)
+			g.Assert(m.Status).Equal(string(StatusFailed))
+			g.Assert(m.Error).Equal("An unexpected error was encountered while running this job.")
+			g.Assert(strings.Contains(m.Error, "/var/lib")).IsFalse()
+			_, err := uuid.Parse(m.ErrorID)
+			g.Assert(err).IsNil()
+		})
+
+		g.It("describes filesystem errors", func() {
+			s := newTestServer()
+			j := New(s, TypeDecompress)
+			j.Run(func(_ context.Context, _ *Job) (interface{}, error) {
+				return nil, s.Filesystem().HasSpaceFor(10 * 1024 * 1024)
+			})
+
+			m := savedJob(j.ID)
+			g.Assert(m.Status).Equal(string(StatusFailed))
+			g.Assert(m.Error).Equal("There is not enough disk space available to perform that action.")
+			g.Assert(m.ErrorID == "").IsFalse()
+		})
+	})
+
+	g.Describe("Job#Cancel", func() {
+		g.It("does not cancel jobs that cannot be stopped", func() {
+			s := newTestServer()
+			j := New(s, TypeInstall)
+			done := make(chan struct{})
+			release := make(chan struct{})
+			go func() {
+				defer close(done)
+				j.Run(func(ctx context.Context, _ *Job) (interface{}, error) {
+					<-release
+					return nil, ctx.Err()
+				})
+			}()
+			g.Assert(j.Cancel()).Equal(ErrNotCancellable)
+			close(release)
+			<-done
+			g.Assert(savedJob(j.ID).Status).Equal(string(StatusCompleted))
+		})
+
+		g.It("cancels a running job", func() {
+			s := newTestServer()
+			j := New(s, TypeBatch)
+			called := make(chan struct{})
+			j.Cancellable(func() { close(called) })
+			started := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				j.Run(func(ctx context.Context, _ *Job) (interface{}, error) {
+					close(started)
+					<-ctx.Done()
+					return []string{"a.txt"}, ctx.Err()
+				})
+			}()
+			<-started
+			g.Assert(j.Cancel()).IsNil()
+			<-called
+			<-done
+
+			m := savedJob(j.ID)
+			g.Assert(m.Status).Equal(string(StatusCancelled))
+			g.Assert(m.Error).Equal("")
+			g.Assert(string(m.Result)).Equal(`["a.txt"]`)
+			g.Assert(j.Cancel()).Equal(ErrNotRunning)
+		})
+
+		g.It("is cancelled if the operation stops with another error", func() {
+			s := newTestServer()
+			j := New(s, TypeBatch)
+			j.Cancellable(nil)
+			started := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				j.Run(func(ctx context.Context, _ *Job) (interface{}, error) {
+					close(started)
+					<-ctx.Done()
+					return nil, errors.New("batch: stopped")
+				})
+			}()
+			<-started
+			g.Assert(j.Cancel()).IsNil()
+			<-done
+			g.Assert(savedJob(j.ID).Status).Equal(string(StatusCancelled))
+		})
+	})
+
+	g.Describe("Find", func() {
+		g.It("returns an error for unknown jobs", func() {
+			_, err := Find(ctx, uuid.Must(uuid.NewRandom()).String())
+			g.Assert(errors.Is(err, ErrNotFound)).IsTrue()
+		})
+	})
+
+	g.Describe("List", func() {
+		g.It("filters the jobs", func() {
+			s := newTestServer()
+			a := New(s, TypeCompress)
+			a.Run(func(_ context.Context, _ *Job) (interface{}, error) { return nil, nil })
+			b := New(s, TypeBackup)
+			b.Run(func(_ context.Context, _ *Job) (interface{}, error) { return nil, errors.New("failed") })
+			New(newTestServer(), TypeCompress)
+
+			rows, err := List(ctx, Filter{Server: s.ID()})
+			g.Assert(err).IsNil()
+			g.Assert(len(rows)).Equal(2)
+			g.Assert(rows[0].ID).Equal(b.ID)
+			g.Assert(rows[1].ID).Equal(a.ID)
+
+			rows, err = List(ctx, Filter{Server: s.ID(), Status: string(StatusFailed)})
+			g.Assert(err).IsNil()
+			g.Assert(len(rows)).Equal(1)
+			g.Assert(rows[0].ID).Equal(b.ID)
+
+			rows, err = List(ctx, Filter{Server: s.ID(), Type: string(TypeCompress), Limit: 1})
+			g.Assert(err).IsNil()
+			g.Assert(len(rows)).Equal(1)
+			g.Assert(rows[0].ID).Equal(a.ID)
+		})
+	})
+
+	g.Describe("MarkInterrupted", func() {
+		g.It("fails the jobs that had not finished", func() {
+			s := newTestServer()
+			pending := New(s, TypeCompress)
+			running := New(s, TypeBackup)
+			running.mu.Lock()
+			running.status = StatusRunning
+			running.mu.Unlock()
+			running.save()
+			completed := New(s, TypeCompress)
+			completed.Run(func(_ context.Context, _ *Job) (interface{}, error) { return nil, nil })
+
+			g.Assert(MarkInterrupted(ctx)).IsNil()
+			for _, id := range []string{pending.ID, running.ID} {
+				m := savedJob(id)
+				g.Assert(m.Status).Equal(string(StatusFailed))
+				g.Assert(m.Error).Equal("Wings was stopped before the job finished.")
+				g.Assert(m.CompletedAt != nil).IsTrue()
+			}
+			g.Assert(savedJob(completed.ID).Status).Equal(string(StatusCompleted))
+		})
+	})
+
+	g.Describe("Prune", func() {
+		g.It("removes the jobs that finished before the retention period", func() {
+			config.Update(func(c *config.Configuration) {
+				c.System.JobHistoryRetention = 3600
+			})
+			s := newTestServer()
+			old := New(s, TypeCompress)
+			old.Run(func(_ context.Context, _ *Job) (interface{}, error) { return nil, nil })
+			before := time.Now().Add(-2 * time.Hour).UTC()
+			g.Assert(database.Instance().Model(&models.Job{}).Where("id = ?", old.ID).Update("completed_at", before).Error).IsNil()
+			recent := New(s, TypeCompress)
+			recent.Run(func(_ context.Context, _ *Job) (interface{}, error) { return nil, nil })
+			unfinished := New(s, TypeCompress)
+
+			g.Assert(Prune(ctx)).IsNil()
+			_, err := Find(ctx, old.ID)
+			g.Assert(errors.Is(err, ErrNotFound)).IsTrue()
+			g.Assert(savedJob(recent.ID).Status).Equal(string(StatusCompleted))
+			g.Assert(savedJob(unfinished.ID).Status).Equal(string(StatusPending))
+		})
+	})
+}
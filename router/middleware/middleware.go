@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"crypto/subtle"
-	"io"
 	"net/http"
 	"strings"
 
@@ -12,6 +11,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/apikey"
 	"github.com/pterodactyl/wings/remote"
 	"github.com/pterodactyl/wings/server"
 )
@@ -64,6 +64,7 @@ func CaptureAndAbort(c *gin.Context, err error) {
 // c.Error() to be returned in a standardized format with tracking UUIDs on them
 // for easier log searching.
 func CaptureErrors() gin.HandlerFunc {
+	useJsonFieldNames()
 	return func(c *gin.Context) {
 		c.Next()
 		err := c.Errors.Last()
@@ -75,8 +76,10 @@ func CaptureErrors() gin.HandlerFunc {
 		if c.Writer.Status() != 200 {
 			status = c.Writer.Status()
 		}
-		if err.Error() == io.EOF.Error() {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The data passed in the request was not in a parsable format. Please try again."})
+		// Errors caused by the body of the request not being valid are returned
+		// along with the fields that caused them, rather than being logged.
+		if msg, fields, ok := bindingError(err.Err); ok {
+			abortWithBindingError(c, msg, fields)
 			return
 		}
 		captured := NewError(err.Err)
@@ -99,8 +102,9 @@ func SetAccessControlHeaders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", location)
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Accept, Accept-Encoding, Authorization, Cache-Control, Content-Type, Content-Length, Origin, X-Real-IP, X-CSRF-Token")
+		c.Header("Access-Control-Allow-Methods", "GET, HEAD, POST, PATCH, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Accept, Accept-Encoding, Authorization, Cache-Control, Content-Type, Content-Length, Origin, X-Real-IP, X-CSRF-Token, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		c.Header("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Upload-Expires, Upload-Length, Upload-Offset")
 
 		// CORS for Private Networks (RFC1918)
 		// @see https://developer.chrome.com/blog/private-network-access-update/?utm_source=devtools
@@ -163,7 +167,11 @@ func ServerExists() gin.HandlerFunc {
 // permission string, ensuring that if it is a server permission, the token has
 // control over that server. If it is a global token, this will ensure that the
 // request is using a properly signed global token.
-func RequireAuthorization() gin.HandlerFunc {
+//
+// Requests can also be authorized using a scoped API key, but only for the
+// routes listed in scopes, which maps the method and path of each route to the
+// scope the key needs to access it.
+func RequireAuthorization(scopes map[string]apikey.Scope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// We don't put this value outside this function since the node's authentication
 		// token can be changed on the fly and the config.Get() call returns a copy, so
@@ -179,10 +187,31 @@ func RequireAuthorization() gin.HandlerFunc {
 		// All requests to Wings must be authorized with the authentication token present in
 		// the Wings configuration file. Remeber, all requests to Wings come from the Panel
 		// backend, or using a signed JWT for temporary authentication.
-		if subtle.ConstantTimeCompare([]byte(auth[1]), []byte(token)) != 1 {
+		if subtle.ConstantTimeCompare([]byte(auth[1]), []byte(token)) == 1 {
+			c.Next()
+			return
+		}
+
+		// Otherwise the request may be using one of the API keys, which can only access
+		// the routes their scopes allow.
+		key, err := apikey.Find(c.Request.Context(), auth[1])
+		if err != nil {
+			if !errors.Is(err, apikey.ErrNotFound) {
+				CaptureAndAbort(c, err)
+				return
+			}
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not authorized to access this endpoint."})
 			return
 		}
+		c.Set("api_key", key.ID)
+		c.Set("logger", ExtractLogger(c).WithField("api_key", key.ID))
+
+		scope, ok := scopes[c.Request.Method+" "+c.FullPath()]
+		if !ok || !key.Allows(scope, c.Param("server")) {
+			ExtractLogger(c).WithField("method", c.Request.Method).WithField("path", c.FullPath()).Warn("api key is not allowed to access route")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API key is not allowed to access this endpoint."})
+			return
+		}
 		c.Next()
 	}
 }
@@ -211,6 +240,12 @@ func ExtractLogger(c *gin.Context) *log.Entry {
 	return v.(*log.Entry)
 }
 
+// ExtractApiKey returns the ID of the API key used to authorize the request, or
+// an empty string if the request was made using the node's authentication token.
+func ExtractApiKey(c *gin.Context) string {
+	return c.GetString("api_key")
+}
+
 // ExtractServer will return the server from the gin.Context or panic if it is
 // not present.
 func ExtractServer(c *gin.Context) *server.Server {
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/apikey"
+	"github.com/pterodactyl/wings/internal/database"
+)
+
+func TestRequireAuthorization(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.RootDirectory = t.TempDir()
+	cfg.Api.Keys = []config.ApiKey{
+		{ID: "power", Token: "power-token", Scopes: []string{"power", "system.read"}},
+		{ID: "restricted", Token: "restricted-token", Scopes: []string{"power", "backups"}, Servers: []string{"server-a"}},
+		{ID: "backups", Token: "backups-token", Scopes: []string{"backups"}},
+	}
+	config.Set(cfg)
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	scopes := map[string]apikey.Scope{
+		"GET /api/system":                       apikey.ScopeSystemRead,
+		"POST /api/servers/:server/power":       apikey.ScopePower,
+		"POST /api/servers/:server/backup":      apikey.ScopeBackups,
+		"DELETE /api/servers/:server/backup/:b": apikey.ScopeBackups,
+	}
+	engine := gin.New()
+	engine.Use(AttachRequestID(), RequireAuthorization(scopes))
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	engine.GET("/api/system", ok)
+	engine.POST("/api/update", ok)
+	engine.POST("/api/servers/:server/power", ok)
+	engine.POST("/api/servers/:server/backup", ok)
+	engine.DELETE("/api/servers/:server/backup/:b", ok)
+
+	request := func(method string, path string, auth string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	g.Describe("RequireAuthorization", func() {
+		g.It("checks the token used for each route", func() {
+			cases := []struct {
+				name   string
+				method string
+				path   string
+				auth   string
+				code   int
+			}{
+				{"no token", http.MethodGet, "/api/system", "", http.StatusUnauthorized},
+				{"not a bearer token", http.MethodGet, "/api/system", "Basic node-token", http.StatusUnauthorized},
+				{"an unknown token", http.MethodGet, "/api/system", "Bearer unknown", http.StatusForbidden},
+				{"the node token", http.MethodPost, "/api/update", "Bearer node-token", http.StatusNoContent},
+				{"the node token for a server", http.MethodPost, "/api/servers/server-a/backup", "Bearer node-token", http.StatusNoContent},
+				{"a key with the scope", http.MethodGet, "/api/system", "Bearer power-token", http.StatusNoContent},
+				{"a key for a server route", http.MethodPost, "/api/servers/server-b/power", "Bearer power-token", http.StatusNoContent},
+				{"a key for a route without a scope", http.MethodPost, "/api/update", "Bearer power-token", http.StatusForbidden},
+				{"a key without the scope", http.MethodPost, "/api/servers/server-a/backup", "Bearer power-token", http.StatusForbidden},
+				{"a restricted key for its server", http.MethodPost, "/api/servers/server-a/power", "Bearer restricted-token", http.StatusNoContent},
+				{"a restricted key for another server", http.MethodPost, "/api/servers/server-b/power", "Bearer restricted-token", http.StatusForbidden},
+				{"a restricted key for a route without a server", http.MethodGet, "/api/system", "Bearer restricted-token", http.StatusForbidden},
+				{"a restricted key for backups", http.MethodPost, "/api/servers/server-a/backup", "Bearer restricted-token", http.StatusForbidden},
+				{"a restricted key for deleting backups", http.MethodDelete, "/api/servers/server-a/backup/backup", "Bearer restricted-token", http.StatusForbidden},
+				{"an unrestricted key for backups", http.MethodDelete, "/api/servers/server-a/backup/backup", "Bearer backups-token", http.StatusNoContent},
+			}
+			for _, c := range cases {
+				g.Assert(request(c.method, c.path, c.auth)).Equal(c.code, c.name)
+			}
+		})
+	})
+}
@@ -112,6 +112,13 @@ func (re *RequestError) Error() string {
 //
 // If the error passed into this call is nil or does not match empty values will
 // be returned to the caller.
+// FilesystemErrorMessage returns the message shown to users for an error caused
+// by the server filesystem, or an empty string if the error is not one of them.
+func FilesystemErrorMessage(err error) string {
+	_, msg := NewError(err).asFilesystemError()
+	return msg
+}
+
 func (re *RequestError) asFilesystemError() (int, string) {
 	err := re.Cause()
 	if err == nil {
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes why the value passed for a field in the body of a
+// request is not valid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var registerTagName sync.Once
+
+// useJsonFieldNames configures the validator used when binding request bodies
+// to report fields using the name they have in the JSON body, rather than the
+// name of the struct field.
+func useJsonFieldNames() {
+	registerTagName.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return f.Name
+			}
+			return name
+		})
+	})
+}
+
+// bindingError returns the message and fields to respond with when the body of
+// a request could not be bound. If ok is false the error was not caused by the
+// body of the request.
+func bindingError(err error) (msg string, fields []FieldError, ok bool) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &syntaxErr):
+		return "The data passed in the request was not in a parsable format. Please try again.", nil, true
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "(body)"
+		}
+		return "The data passed in the request is not valid.", []FieldError{{
+			Field:   field,
+			Message: fmt.Sprintf("must be of type %s, not %s", jsonType(typeErr.Type), typeErr.Value),
+		}}, true
+	case errors.As(err, &validationErrs):
+		fields = make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fieldName(fe), Message: validationMessage(fe)})
+		}
+		return "The data passed in the request is not valid.", fields, true
+	}
+	return "", nil, false
+}
+
+// fieldName returns the path of the field in the request body, without the
+// name of the struct the body was bound to.
+func fieldName(fe validator.FieldError) string {
+	if _, name, ok := strings.Cut(fe.Namespace(), "."); ok {
+		return name
+	}
+	return fe.Field()
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return fmt.Sprintf("is required when %s is not present", strings.ToLower(fe.Param()))
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "min", "gte":
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		return "must be at most " + fe.Param()
+	case "url":
+		return "must be a valid URL"
+	}
+	return fmt.Sprintf("failed the %q validation", fe.Tag())
+}
+
+// jsonType returns the name of the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	if t == nil {
+		return "unknown"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+// abortWithBindingError responds to a request whose body could not be bound
+// with a HTTP/400 error describing which fields are not valid.
+func abortWithBindingError(c *gin.Context, msg string, fields []FieldError) {
+	body := gin.H{"error": msg, "request_id": c.Writer.Header().Get("X-Request-Id")}
+	if len(fields) > 0 {
+		body["errors"] = fields
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, body)
+}
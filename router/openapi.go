@@ -0,0 +1,426 @@
+package router
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/router/middleware"
+	"github.com/pterodactyl/wings/server/installer"
+	"github.com/pterodactyl/wings/system"
+)
+
+// apiAuth is the way a route of the API is authenticated.
+type apiAuth int
+
+const (
+	// authNode routes require the authentication token of the node, or an API
+	// key with the scope listed for the route in apiKeyScopes.
+	authNode apiAuth = iota
+	// authSignedURL routes are authenticated by a JWT signed by the Panel that
+	// is passed in the token query parameter.
+	authSignedURL
+	// authBearerJWT routes are authenticated by a JWT signed by the Panel that
+	// is passed in the Authorization header.
+	authBearerJWT
+	// authSocket routes are websockets, which are authenticated by a JWT sent
+	// over the socket once it has been connected.
+	authSocket
+	// authUpload routes are authenticated by the ID of a resumable upload, which
+	// is only known to the client that started it.
+	authUpload
+	// authNone routes are not authenticated.
+	authNone
+)
+
+// apiParam is a query parameter accepted by a route.
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiOperation describes a route of the API. Every route registered in
+// Configure must have an operation so that it is included in the OpenAPI
+// document served by Wings.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Auth    apiAuth
+	Query   []apiParam
+	// Body is the value the JSON body of the request is bound to, or nil if the
+	// route does not accept a JSON body. BodyType is set instead for routes that
+	// accept any other kind of body.
+	Body     any
+	BodyType string
+	Status   int
+}
+
+var fileParam = apiParam{"file", "The path of the file, relative to the root of the server."}
+
+// apiOperations is every route of the API, in the order they are registered.
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "System", Summary: "Returns the OpenAPI document describing this API.", Auth: authNone, Status: http.StatusOK},
+
+	{Method: http.MethodGet, Path: "/download/backup", Tag: "Downloads", Summary: "Downloads a local backup of a server.", Auth: authSignedURL, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/download/file", Tag: "Downloads", Summary: "Downloads a file from a server.", Auth: authSignedURL, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/upload/file", Tag: "Uploads", Summary: "Uploads files to a server.", Auth: authSignedURL, Query: []apiParam{{"directory", "The directory to upload the files to."}}, BodyType: "multipart/form-data", Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/upload/tus", Tag: "Uploads", Summary: "Starts a resumable upload using the tus protocol.", Auth: authSignedURL, Query: []apiParam{{"directory", "The directory to upload the file to."}}, Status: http.StatusCreated},
+	{Method: http.MethodHead, Path: "/upload/tus/:upload", Tag: "Uploads", Summary: "Returns the current offset of a resumable upload.", Auth: authUpload, Status: http.StatusOK},
+	{Method: http.MethodPatch, Path: "/upload/tus/:upload", Tag: "Uploads", Summary: "Appends data to a resumable upload.", Auth: authUpload, BodyType: "application/offset+octet-stream", Status: http.StatusNoContent},
+	{Method: http.MethodDelete, Path: "/upload/tus/:upload", Tag: "Uploads", Summary: "Cancels a resumable upload.", Auth: authUpload, Status: http.StatusNoContent},
+
+	{Method: http.MethodGet, Path: "/api/servers/:server/ws", Tag: "Websockets", Summary: "Opens a websocket for the console and events of a server.", Auth: authSocket, Status: http.StatusSwitchingProtocols},
+	{Method: http.MethodGet, Path: "/api/ws", Tag: "Websockets", Summary: "Opens a websocket for the events of the node.", Auth: authSocket, Status: http.StatusSwitchingProtocols},
+	{Method: http.MethodPost, Path: "/api/transfers", Tag: "Transfers", Summary: "Receives the archive of a server being transferred from another node.", Auth: authBearerJWT, BodyType: "multipart/form-data", Status: http.StatusOK},
+
+	{Method: http.MethodPost, Path: "/api/update", Tag: "System", Summary: "Updates the configuration of the node.", Body: config.Configuration{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/system", Tag: "System", Summary: "Returns information about the node.", Query: []apiParam{{"v", "The version of the response, 2 includes more detailed information."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/system/sftp/bans", Tag: "System", Summary: "Returns the current SFTP login bans.", Status: http.StatusOK},
+	{Method: http.MethodDelete, Path: "/api/system/sftp/bans", Tag: "System", Summary: "Removes an SFTP login ban, or every ban if no body is sent.", Body: sftpBanRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/system/tokens/revocations", Tag: "Tokens", Summary: "Returns the active token revocations.", Query: []apiParam{{"type", "Only return revocations of this type."}, {"value", "Only return revocations for this value."}}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/system/tokens/revocations", Tag: "Tokens", Summary: "Revokes every token issued before now for a JTI, user or server.", Body: tokenRevocationRequest{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/system/keys", Tag: "API Keys", Summary: "Returns the API keys stored on the node.", Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/system/keys", Tag: "API Keys", Summary: "Creates an API key.", Body: apiKeyRequest{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/api/system/keys/:key", Tag: "API Keys", Summary: "Deletes an API key.", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/servers", Tag: "Servers", Summary: "Returns every server on the node.", Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/servers", Tag: "Servers", Summary: "Creates and installs a server.", Body: installer.ServerDetails{}, Status: http.StatusAccepted},
+	{Method: http.MethodDelete, Path: "/api/transfers/:server", Tag: "Transfers", Summary: "Cancels an incoming transfer of a server.", Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/jobs", Tag: "Jobs", Summary: "Returns the most recent jobs, including those that have finished.", Query: []apiParam{{"server", "Only return jobs for this server."}, {"type", "Only return jobs of this type."}, {"status", "Only return jobs with this status."}, {"limit", "The number of jobs to return, defaults to 50."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/jobs/:job", Tag: "Jobs", Summary: "Returns the status and progress of a job.", Status: http.StatusOK},
+	{Method: http.MethodDelete, Path: "/api/jobs/:job", Tag: "Jobs", Summary: "Cancels a running job.", Status: http.StatusAccepted},
+
+	{Method: http.MethodGet, Path: "/api/servers/:server", Tag: "Servers", Summary: "Returns a server.", Status: http.StatusOK},
+	{Method: http.MethodDelete, Path: "/api/servers/:server", Tag: "Servers", Summary: "Deletes a server and its files.", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/servers/:server/logs", Tag: "Servers", Summary: "Returns the most recent console output of a server.", Query: []apiParam{{"size", "The number of lines to return, defaults to 100."}}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/servers/:server/power", Tag: "Servers", Summary: "Sends a power action to a server.", Body: serverPowerRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/servers/:server/commands", Tag: "Servers", Summary: "Sends console commands to a running server.", Body: serverCommandsRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/install", Tag: "Servers", Summary: "Runs the install process for a server.", Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/servers/:server/reinstall", Tag: "Servers", Summary: "Reinstalls a server.", Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/servers/:server/sync", Tag: "Servers", Summary: "Syncs the configuration of a server with the Panel.", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/ws/deny", Tag: "Tokens", Summary: "Denies websocket tokens with the given JTIs.", Body: serverDenyTokensRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/transfer", Tag: "Transfers", Summary: "Starts transferring a server to another node.", Body: serverTransferRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodDelete, Path: "/api/servers/:server/transfer", Tag: "Transfers", Summary: "Cancels an outgoing transfer of a server.", Status: http.StatusAccepted},
+
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/contents", Tag: "Files", Summary: "Returns the contents of a file.", Query: []apiParam{fileParam, {"download", "Send the file as an attachment if set."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/list-directory", Tag: "Files", Summary: "Lists the contents of a directory, optionally a page at a time.", Query: []apiParam{{"directory", "The directory to list."}, {"cursor", "The cursor of the page to return."}, {"per_page", "The number of entries per page."}, {"sort", "One of name, size or modified."}, {"order", "The order to sort in, asc or desc."}, {"filter", "Only return entries with a name containing this value."}, {"mime", "Set to false to skip detecting the mimetype of files."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/hash", Tag: "Files", Summary: "Returns the hash of a file.", Query: []apiParam{fileParam, {"algorithm", "The hash algorithm to use, defaults to sha256."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/diff", Tag: "Files", Summary: "Returns the differences between a file and another file or a backup.", Query: []apiParam{fileParam, {"backup", "The backup to compare the file with."}, {"entry", "The path of the file in the backup."}, {"compare", "The file to compare the file with."}}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/usage", Tag: "Files", Summary: "Returns the disk usage of a directory.", Query: []apiParam{{"directory", "The directory to return the usage of."}, {"depth", "The depth of the tree, between 1 and 10."}, {"limit", "The number of entries per directory, between 1 and 100."}}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: "/api/servers/:server/files/rename", Tag: "Files", Summary: "Renames or moves files.", Body: renameFilesRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/copy", Tag: "Files", Summary: "Copies a file.", Body: copyFileRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/write", Tag: "Files", Summary: "Writes the body of the request to a file.", Query: []apiParam{fileParam}, BodyType: "application/octet-stream", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/create-directory", Tag: "Files", Summary: "Creates a directory.", Body: createDirectoryRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/delete", Tag: "Files", Summary: "Deletes files.", Body: deleteFilesRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/compress", Tag: "Files", Summary: "Compresses files into an archive, optionally in a job.", Body: compressFilesRequest{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/decompress", Tag: "Files", Summary: "Decompresses an archive, optionally in a job.", Body: decompressFileRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/archive", Tag: "Files", Summary: "Lists the contents of an archive.", Query: []apiParam{fileParam}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/chmod", Tag: "Files", Summary: "Changes the mode of files.", Body: chmodFilesRequest{}, Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/batch", Tag: "Files", Summary: "Runs a batch of file operations.", Body: serverBatchRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/servers/:server/files/pull", Tag: "Files", Summary: "Returns the remote files being downloaded.", Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/api/servers/:server/files/pull", Tag: "Files", Summary: "Downloads a remote file to the server.", Body: pullRemoteFileRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodDelete, Path: "/api/servers/:server/files/pull/:download", Tag: "Files", Summary: "Cancels a remote file download.", Status: http.StatusNoContent},
+
+	{Method: http.MethodPost, Path: "/api/servers/:server/backup", Tag: "Backups", Summary: "Creates a backup of a server.", Body: serverBackupRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/servers/:server/backup/:backup/restore", Tag: "Backups", Summary: "Restores a backup of a server.", Body: serverRestoreBackupRequest{}, Status: http.StatusAccepted},
+	{Method: http.MethodDelete, Path: "/api/servers/:server/backup/:backup", Tag: "Backups", Summary: "Deletes a local backup of a server.", Status: http.StatusNoContent},
+}
+
+var pathParamRegex = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+var openapi struct {
+	sync.Once
+	b   []byte
+	err error
+}
+
+// Returns the OpenAPI document describing the API.
+func getOpenApiDocument(c *gin.Context) {
+	openapi.Do(func() {
+		openapi.b, openapi.err = json.Marshal(openApiDocument())
+	})
+	if openapi.err != nil {
+		middleware.CaptureAndAbort(c, openapi.err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", openapi.b)
+}
+
+// openApiDocument builds an OpenAPI 3 document from apiOperations.
+func openApiDocument() map[string]any {
+	paths := map[string]any{}
+	for _, op := range apiOperations {
+		path := pathParamRegex.ReplaceAllString(op.Path, "{$1}")
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = op.document()
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Wings",
+			"version": system.Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"nodeToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The authentication token of the node, as \"<token_id>.<token>\" or \"<token>\".",
+				},
+				"apiKey": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A scoped API key. The scope required by an operation is listed in x-api-key-scope.",
+				},
+				"signedUrl": map[string]any{
+					"type":        "apiKey",
+					"in":          "query",
+					"name":        "token",
+					"description": "A JWT signed by the Panel.",
+				},
+				"panelJwt": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+					"description":  "A JWT signed by the Panel.",
+				},
+			},
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": map[string]any{
+						"error":      map[string]any{"type": "string"},
+						"request_id": map[string]any{"type": "string"},
+						"errors": map[string]any{
+							"type":        "array",
+							"description": "The fields in the body of the request that are not valid.",
+							"items":       schemaOf(reflect.TypeOf(middleware.FieldError{}), nil),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (op apiOperation) document() map[string]any {
+	doc := map[string]any{
+		"operationId": op.id(),
+		"summary":     op.Summary,
+		"tags":        []string{op.Tag},
+	}
+
+	var params []any
+	for _, m := range pathParamRegex.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	for _, q := range op.Query {
+		params = append(params, map[string]any{
+			"name":        q.Name,
+			"in":          "query",
+			"description": q.Description,
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	if op.Auth == authSignedURL {
+		params = append(params, map[string]any{
+			"name":        "token",
+			"in":          "query",
+			"required":    true,
+			"description": "The JWT signed by the Panel.",
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.Body != nil {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.Body), nil)},
+			},
+		}
+	} else if op.BodyType != "" {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				op.BodyType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			},
+		}
+	}
+
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+			},
+		}
+	}
+	responses := map[string]any{
+		strconv.Itoa(op.Status): map[string]any{"description": http.StatusText(op.Status)},
+		"default":               errorResponse("An error occurred while processing the request."),
+	}
+	if op.Body != nil {
+		responses["400"] = errorResponse("The body of the request is not valid.")
+	}
+
+	switch op.Auth {
+	case authNode:
+		security := []any{map[string]any{"nodeToken": []string{}}}
+		if scope, ok := apiKeyScopes[op.Method+" "+op.Path]; ok {
+			security = append(security, map[string]any{"apiKey": []string{}})
+			doc["x-api-key-scope"] = scope
+		}
+		doc["security"] = security
+		responses["401"] = errorResponse("The request is not authenticated.")
+		responses["403"] = errorResponse("The request is not allowed to access this route.")
+	case authSignedURL:
+		doc["security"] = []any{map[string]any{"signedUrl": []string{}}}
+	case authBearerJWT:
+		doc["security"] = []any{map[string]any{"panelJwt": []string{}}}
+	default:
+		doc["security"] = []any{}
+	}
+	if strings.Contains(op.Path, ":server") && op.Auth == authNode {
+		responses["404"] = errorResponse("The server does not exist.")
+	}
+	doc["responses"] = responses
+
+	return doc
+}
+
+// id returns a unique ID for the operation built from its method and path, such
+// as "getApiServersServerFilesContents".
+func (op apiOperation) id() string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, word := range strings.FieldsFunc(op.Path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '_' || r == '.' || r == ':'
+	}) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns the JSON schema of the values of a type when encoded using
+// their JSON struct tags. Any fields with a "required" binding are marked as
+// required, and any with a "oneof" binding have the values allowed listed.
+func schemaOf(t reflect.Type, seen []reflect.Type) map[string]any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, s := range seen {
+		if s == t {
+			return map[string]any{"type": "object"}
+		}
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]any{"type": "integer"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return map[string]any{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		properties := map[string]any{}
+		var required []string
+		addFields(t, append(seen, t), properties, &required)
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
+
+// addFields adds the schema of each field of a struct to properties, including
+// the fields of any embedded structs.
+func addFields(t reflect.Type, seen []reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, seen, properties, required)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		schema := schemaOf(f.Type, seen)
+		for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+			if rule == "required" {
+				*required = append(*required, name)
+			}
+			if values, ok := strings.CutPrefix(rule, "oneof="); ok {
+				schema["enum"] = strings.Fields(values)
+			}
+		}
+		properties[name] = schema
+	}
+}
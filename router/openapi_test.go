@@ -0,0 +1,140 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/goccy/go-json"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/router/middleware"
+)
+
+func TestOpenApiDocument(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	config.Set(cfg)
+
+	engine := Configure(nil, nil)
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer node-token")
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	g.Describe("OpenAPI document", func() {
+		g.It("describes every route that is registered", func() {
+			var routes, operations []string
+			for _, r := range engine.Routes() {
+				routes = append(routes, r.Method+" "+r.Path)
+			}
+			for _, op := range apiOperations {
+				operations = append(operations, op.Method+" "+op.Path)
+			}
+			sort.Strings(routes)
+			sort.Strings(operations)
+
+			g.Assert(operations).Equal(routes)
+		})
+
+		g.It("only lists scoped routes that exist", func() {
+			for route := range apiKeyScopes {
+				found := false
+				for _, op := range apiOperations {
+					if op.Method+" "+op.Path == route {
+						found = op.Auth == authNode
+						break
+					}
+				}
+				g.Assert(found).IsTrue(route)
+			}
+		})
+
+		g.It("has a unique ID for every operation", func() {
+			ids := map[string]bool{}
+			for _, op := range apiOperations {
+				g.Assert(ids[op.id()]).IsFalse(op.id())
+				ids[op.id()] = true
+			}
+		})
+
+		g.It("is served without authentication", func() {
+			r := httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, r)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			var doc struct {
+				OpenAPI string                               `json:"openapi"`
+				Paths   map[string]map[string]map[string]any `json:"paths"`
+			}
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &doc)).IsNil()
+			g.Assert(doc.OpenAPI).Equal("3.0.3")
+
+			op := doc.Paths["/api/servers/{server}/backup/{backup}/restore"]["post"]
+			g.Assert(op).IsNotNil()
+			g.Assert(op["x-api-key-scope"]).Equal("backups")
+			g.Assert(len(op["parameters"].([]any))).Equal(2)
+		})
+
+		g.It("includes the validation rules of request bodies", func() {
+			schema := openApiDocument()["paths"].(map[string]any)["/api/system/tokens/revocations"].(map[string]any)["post"].(map[string]any)["requestBody"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+
+			g.Assert(schema["required"]).Equal([]string{"type", "value"})
+			g.Assert(schema["properties"].(map[string]any)["type"].(map[string]any)["enum"]).Equal([]string{"jti", "user", "server"})
+		})
+	})
+
+	g.Describe("Request validation", func() {
+		type response struct {
+			Error  string                  `json:"error"`
+			Errors []middleware.FieldError `json:"errors"`
+		}
+
+		g.It("returns the fields that failed validation", func() {
+			w := request(http.MethodPost, "/api/system/tokens/revocations", `{"type":"token"}`)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			var res response
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &res)).IsNil()
+			g.Assert(res.Errors).Equal([]middleware.FieldError{
+				{Field: "type", Message: "must be one of: jti, user, server"},
+				{Field: "value", Message: "is required"},
+			})
+		})
+
+		g.It("returns fields with the wrong type", func() {
+			w := request(http.MethodPost, "/api/update", `{"debug":"yes"}`)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			var res response
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &res)).IsNil()
+			g.Assert(res.Errors).Equal([]middleware.FieldError{
+				{Field: "debug", Message: "must be of type boolean, not string"},
+			})
+		})
+
+		g.It("returns an error for bodies that cannot be parsed", func() {
+			w := request(http.MethodPost, "/api/update", `{"debug":`)
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			var res response
+			g.Assert(json.Unmarshal(w.Body.Bytes(), &res)).IsNil()
+			g.Assert(res.Error).Equal("The data passed in the request was not in a parsable format. Please try again.")
+			g.Assert(len(res.Errors)).Equal(0)
+		})
+	})
+}
@@ -6,11 +6,43 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/apikey"
 	"github.com/pterodactyl/wings/remote"
 	"github.com/pterodactyl/wings/router/middleware"
 	wserver "github.com/pterodactyl/wings/server"
 )
 
+// apiKeyScopes maps the routes that can be accessed using a scoped API key to the
+// scope the key needs. Any route that is not listed can only be accessed using the
+// authentication token of the node.
+var apiKeyScopes = map[string]apikey.Scope{
+	"GET /api/system":                 apikey.ScopeSystemRead,
+	"GET /api/servers":                apikey.ScopeSystemRead,
+	"GET /api/servers/:server":        apikey.ScopeSystemRead,
+	"GET /api/servers/:server/logs":   apikey.ScopeSystemRead,
+	"POST /api/servers/:server/power": apikey.ScopePower,
+
+	"GET /api/servers/:server/files/contents":          apikey.ScopeFiles,
+	"GET /api/servers/:server/files/list-directory":    apikey.ScopeFiles,
+	"GET /api/servers/:server/files/hash":              apikey.ScopeFiles,
+	"GET /api/servers/:server/files/diff":              apikey.ScopeFiles,
+	"GET /api/servers/:server/files/usage":             apikey.ScopeFiles,
+	"PUT /api/servers/:server/files/rename":            apikey.ScopeFiles,
+	"POST /api/servers/:server/files/copy":             apikey.ScopeFiles,
+	"POST /api/servers/:server/files/write":            apikey.ScopeFiles,
+	"POST /api/servers/:server/files/create-directory": apikey.ScopeFiles,
+	"POST /api/servers/:server/files/delete":           apikey.ScopeFiles,
+	"POST /api/servers/:server/files/compress":         apikey.ScopeFiles,
+	"POST /api/servers/:server/files/decompress":       apikey.ScopeFiles,
+	"GET /api/servers/:server/files/archive":           apikey.ScopeFiles,
+	"POST /api/servers/:server/files/chmod":            apikey.ScopeFiles,
+	"POST /api/servers/:server/files/batch":            apikey.ScopeFiles,
+
+	"POST /api/servers/:server/backup":                 apikey.ScopeBackups,
+	"POST /api/servers/:server/backup/:backup/restore": apikey.ScopeBackups,
+	"DELETE /api/servers/:server/backup/:backup":       apikey.ScopeBackups,
+}
+
 // Configure configures the routing infrastructure for this daemon instance.
 func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	gin.SetMode("release")
@@ -34,20 +66,30 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			"status":     params.StatusCode,
 			"latency":    params.Latency,
 			"request_id": params.Keys["request_id"],
+			"api_key":    params.Keys["api_key"],
 		}).Debugf("%s %s", params.MethodColor()+params.Method+params.ResetColor(), params.Path)
 
 		return ""
 	}))
 
+	// The OpenAPI document describing this API is public so that it can be used
+	// to generate clients without needing to authenticate first.
+	router.GET("/api/openapi.json", getOpenApiDocument)
+
 	// These routes use signed URLs to validate access to the resource being requested.
 	router.GET("/download/backup", getDownloadBackup)
 	router.GET("/download/file", getDownloadFile)
 	router.POST("/upload/file", postServerUploadFiles)
+	router.POST("/upload/tus", postTusUpload)
+	router.HEAD("/upload/tus/:upload", headTusUpload)
+	router.PATCH("/upload/tus/:upload", patchTusUpload)
+	router.DELETE("/upload/tus/:upload", deleteTusUpload)
 
 	// This route is special it sits above all the other requests because we are
 	// using a JWT to authorize access to it, therefore it needs to be publicly
 	// accessible.
 	router.GET("/api/servers/:server/ws", middleware.ServerExists(), getServerWebsocket)
+	router.GET("/api/ws", getNodeWebsocket)
 
 	// This request is called by another daemon when a server is going to be transferred out.
 	// This request does not need the AuthorizationMiddleware as the panel should never call it
@@ -56,17 +98,27 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 
 	// All the routes beyond this mount will use an authorization middleware
 	// and will not be accessible without the correct Authorization header provided.
-	protected := router.Use(middleware.RequireAuthorization())
+	protected := router.Use(middleware.RequireAuthorization(apiKeyScopes))
 	protected.POST("/api/update", postUpdateConfiguration)
 	protected.GET("/api/system", getSystemInformation)
+	protected.GET("/api/system/sftp/bans", getSftpBans)
+	protected.DELETE("/api/system/sftp/bans", deleteSftpBans)
+	protected.GET("/api/system/tokens/revocations", getTokenRevocations)
+	protected.POST("/api/system/tokens/revocations", postTokenRevocations)
+	protected.GET("/api/system/keys", getApiKeys)
+	protected.POST("/api/system/keys", postApiKeys)
+	protected.DELETE("/api/system/keys/:key", deleteApiKey)
 	protected.GET("/api/servers", getAllServers)
 	protected.POST("/api/servers", postCreateServer)
 	protected.DELETE("/api/transfers/:server", deleteTransfer)
+	protected.GET("/api/jobs", getJobs)
+	protected.GET("/api/jobs/:job", getJob)
+	protected.DELETE("/api/jobs/:job", deleteJob)
 
-	// These are server specific routes, and require that the request be authorized, and
-	// that the server exist on the Daemon.
+	// These are server specific routes, and require that the server exist on the Daemon.
+	// They are already authorized by the middleware added to the router above.
 	server := router.Group("/api/servers/:server")
-	server.Use(middleware.RequireAuthorization(), middleware.ServerExists())
+	server.Use(middleware.ServerExists())
 	{
 		server.GET("", getServer)
 		server.DELETE("", deleteServer)
@@ -88,6 +140,9 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 		{
 			files.GET("/contents", getServerFileContents)
 			files.GET("/list-directory", getServerListDirectory)
+			files.GET("/hash", getServerFileHash)
+			files.GET("/diff", getServerFileDiff)
+			files.GET("/usage", getServerFileUsage)
 			files.PUT("/rename", putServerRenameFiles)
 			files.POST("/copy", postServerCopyFile)
 			files.POST("/write", postServerWriteFile)
@@ -95,7 +150,9 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 			files.POST("/delete", postServerDeleteFiles)
 			files.POST("/compress", postServerCompressFiles)
 			files.POST("/decompress", postServerDecompressFiles)
+			files.GET("/archive", getServerArchiveContents)
 			files.POST("/chmod", postServerChmodFile)
+			files.POST("/batch", postServerBatchFiles)
 
 			files.GET("/pull", middleware.RemoteDownloadEnabled(), getServerPullingFiles)
 			files.POST("/pull", middleware.RemoteDownloadEnabled(), postServerPullRemoteFile)
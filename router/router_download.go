@@ -1,11 +1,14 @@
 package router
 
 import (
-	"bufio"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -28,7 +31,7 @@ func getDownloadBackup(c *gin.Context) {
 	}
 
 	// Get the server using the UUID from the token.
-	if _, ok := manager.Get(token.ServerUuid); !ok || !token.IsUniqueRequest() {
+	if _, ok := manager.Get(token.ServerUuid); !ok {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "The requested resource was not found on this server.",
 		})
@@ -65,11 +68,19 @@ func getDownloadBackup(c *gin.Context) {
 	}
 	defer f.Close()
 
-	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
+	// Once the token has been used it can only be used again by the same client to
+	// resume the download.
+	if !token.IsDownloadRequest(c.ClientIP(), isResumeRequest(c, st)) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
 	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
 	c.Header("Content-Type", "application/octet-stream")
 
-	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+	serveContent(c, st, f)
 }
 
 // Handles downloading a specific file for a server.
@@ -82,7 +93,7 @@ func getDownloadFile(c *gin.Context) {
 	}
 
 	s, ok := manager.Get(token.ServerUuid)
-	if !ok || !token.IsUniqueRequest() {
+	if !ok {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "The requested resource was not found on this server.",
 		})
@@ -95,16 +106,46 @@ func getDownloadFile(c *gin.Context) {
 		return
 	}
 	defer f.Close()
-	if st.IsDir() {
+	if st.IsDir() || !token.IsDownloadRequest(c.ClientIP(), isResumeRequest(c, st)) {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "The requested resource was not found on this server.",
 		})
 		return
 	}
 
-	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
 	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
 	c.Header("Content-Type", "application/octet-stream")
 
-	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
+	serveContent(c, st, f)
+}
+
+// isResumeRequest returns true if the request is resuming an earlier download of
+// the file. This requires a single range that does not start at the beginning of
+// the file, along with the current ETag of the file in the If-Range header, since
+// the range would otherwise be ignored and the entire file sent again.
+func isResumeRequest(c *gin.Context, st fs.FileInfo) bool {
+	if c.GetHeader("If-Range") != etag(st) {
+		return false
+	}
+	r, ok := strings.CutPrefix(c.GetHeader("Range"), "bytes=")
+	if !ok || strings.Contains(r, ",") {
+		return false
+	}
+	start, _, ok := strings.Cut(r, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return ok && err == nil && n > 0
+}
+
+// etag returns the ETag of a file, which is generated from its size and
+// modification time.
+func etag(st fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, st.ModTime().UnixNano(), st.Size())
+}
+
+// serveContent writes the contents of a file to the response. This supports range
+// requests, allowing interrupted downloads to be resumed, as well as conditional
+// requests using the ETag of the file.
+func serveContent(c *gin.Context, st fs.FileInfo, r io.ReadSeeker) {
+	c.Header("ETag", etag(st))
+	http.ServeContent(c.Writer, c.Request, st.Name(), st.ModTime(), r)
 }
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/router/tokens"
+)
+
+func TestDownloadFile(t *testing.T) {
+	g := Goblin(t)
+
+	engine, s := newTestEngine(t)
+	if err := s.Filesystem().Write("/a.txt", strings.NewReader("hello world"), 11, 0o644); err != nil {
+		panic(err)
+	}
+
+	sign := func() string {
+		token, err := jwt.Sign(tokens.FilePayload{
+			Payload: jwt.Payload{
+				ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:       jwt.NumericDate(time.Now()),
+			},
+			FilePath:   "/a.txt",
+			ServerUuid: s.ID(),
+			UniqueId:   uuid.Must(uuid.NewRandom()).String(),
+		}, config.GetJwtAlgorithm())
+		if err != nil {
+			panic(err)
+		}
+		return string(token)
+	}
+	request := func(token string, client string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/download/file?token="+token, nil)
+		r.RemoteAddr = client + ":4000"
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	g.Describe("GET /download/file", func() {
+		g.It("resumes a download from the same client", func() {
+			token := sign()
+			w := request(token, "192.0.2.1", nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Body.String()).Equal("hello world")
+			etag := w.Header().Get("ETag")
+			g.Assert(etag == "").IsFalse()
+
+			w = request(token, "192.0.2.1", map[string]string{"Range": "bytes=6-", "If-Range": etag})
+			g.Assert(w.Code).Equal(http.StatusPartialContent)
+			g.Assert(w.Body.String()).Equal("world")
+
+			w = request(token, "192.0.2.1", map[string]string{"Range": "bytes=2-4", "If-Range": etag})
+			g.Assert(w.Code).Equal(http.StatusPartialContent)
+			g.Assert(w.Body.String()).Equal("llo")
+		})
+
+		g.It("does not allow a download to be repeated", func() {
+			token := sign()
+			w := request(token, "192.0.2.1", nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			etag := w.Header().Get("ETag")
+
+			cases := []struct {
+				name    string
+				headers map[string]string
+			}{
+				{"no range", nil},
+				{"a range starting at the beginning", map[string]string{"Range": "bytes=0-", "If-Range": etag}},
+				{"a range of the last bytes", map[string]string{"Range": "bytes=-11", "If-Range": etag}},
+				{"multiple ranges", map[string]string{"Range": "bytes=1-4,0-0", "If-Range": etag}},
+				{"an invalid range", map[string]string{"Range": "bytes=a-", "If-Range": etag}},
+				{"a range without If-Range", map[string]string{"Range": "bytes=6-"}},
+				{"a range with a different ETag", map[string]string{"Range": "bytes=6-", "If-Range": `"other"`}},
+				{"a range with a date in If-Range", map[string]string{"Range": "bytes=6-", "If-Range": time.Now().UTC().Format(http.TimeFormat)}},
+			}
+			for _, c := range cases {
+				w := request(token, "192.0.2.1", c.headers)
+				g.Assert(w.Code).Equal(http.StatusNotFound, c.name)
+				g.Assert(strings.Contains(w.Body.String(), "hello")).IsFalse(c.name)
+			}
+		})
+
+		g.It("does not allow other clients to resume a download", func() {
+			token := sign()
+			w := request(token, "192.0.2.1", nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+
+			w = request(token, "192.0.2.2", map[string]string{"Range": "bytes=6-", "If-Range": w.Header().Get("ETag")})
+			g.Assert(w.Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("allows the first request to be for a range", func() {
+			w := request(sign(), "192.0.2.1", map[string]string{"Range": "bytes=0-4"})
+			g.Assert(w.Code).Equal(http.StatusPartialContent)
+			g.Assert(w.Body.String()).Equal("hello")
+		})
+	})
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/router/jobs"
+	"github.com/pterodactyl/wings/router/middleware"
+)
+
+// Returns the most recent jobs on this instance, optionally only those for a
+// server, or of a given type or status. Running jobs are included along with
+// their current progress.
+func getJobs(c *gin.Context) {
+	f := jobs.Filter{
+		Server: c.Query("server"),
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+		Limit:  50,
+	}
+	if v, ok := c.GetQuery("limit"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > jobs.MaxListLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The limit must be between 1 and " + strconv.Itoa(jobs.MaxListLimit) + ".",
+			})
+			return
+		}
+		f.Limit = limit
+	}
+
+	out, err := jobs.List(c.Request.Context(), f)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// Returns the status and progress of a job, or the outcome of the job if it has
+// already finished.
+func getJob(c *gin.Context) {
+	job, err := jobs.Find(c.Request.Context(), c.Param("job"))
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "The requested job was not found.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancels a running job. Not every job can be cancelled, such as backups and
+// installations, in which case a HTTP/409 error is returned.
+func deleteJob(c *gin.Context) {
+	job := jobs.ByID(c.Param("job"))
+	if job == nil {
+		if _, err := jobs.Find(c.Request.Context(), c.Param("job")); err != nil {
+			if errors.Is(err, jobs.ErrNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": "The requested job was not found.",
+				})
+				return
+			}
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "The job has already finished.",
+		})
+		return
+	}
+
+	if err := job.Cancel(); err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotCancellable):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "This job cannot be cancelled once it has started.",
+			})
+		case errors.Is(err, jobs.ErrNotRunning):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The job has already finished.",
+			})
+		default:
+			middleware.CaptureAndAbort(c, err)
+		}
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -10,11 +10,15 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/pterodactyl/wings/internal/models"
 	"github.com/pterodactyl/wings/router/downloader"
+	"github.com/pterodactyl/wings/router/jobs"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/router/uploader"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/server/transfer"
+	"github.com/pterodactyl/wings/sftp"
 )
 
 // Returns a single server from the collection of servers.
@@ -42,6 +46,11 @@ func getServerLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": out})
 }
 
+type serverPowerRequest struct {
+	Action      server.PowerAction `json:"action"`
+	WaitSeconds int                `json:"wait_seconds"`
+}
+
 // Handles a request to control the power state of a server. If the action being passed
 // through is invalid a 404 is returned. Otherwise, a HTTP/202 Accepted response is returned
 // and the actual power action is run asynchronously so that we don't have to block the
@@ -53,10 +62,7 @@ func getServerLogs(c *gin.Context) {
 func postServerPower(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Action      server.PowerAction `json:"action"`
-		WaitSeconds int                `json:"wait_seconds"`
-	}
+	var data serverPowerRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -104,6 +110,14 @@ func postServerPower(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+type serverCommandsRequest struct {
+	Commands []string `json:"commands"`
+	// The rules restricting the commands the user sending them is allowed to
+	// send, along with the user so denied commands can be recorded.
+	Rules *tokens.CommandRules `json:"rules"`
+	User  string               `json:"user"`
+}
+
 // Sends an array of commands to a running server instance.
 func postServerCommands(c *gin.Context) {
 	s := ExtractServer(c)
@@ -118,14 +132,32 @@ func postServerCommands(c *gin.Context) {
 		return
 	}
 
-	var data struct {
-		Commands []string `json:"commands"`
-	}
+	var data serverCommandsRequest
 	// BindJSON sends 400 if the request fails, all we need to do is return
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
 
+	// Nothing is sent unless every command is allowed, rather than sending only
+	// some of them.
+	var denied []string
+	for _, command := range data.Commands {
+		if !data.Rules.Allowed(command) {
+			denied = append(denied, command)
+		}
+	}
+	if len(denied) > 0 {
+		ra := s.NewRequestActivity(data.User, c.ClientIP())
+		for _, command := range denied {
+			s.SaveActivity(ra, server.ActivityConsoleDenied, models.ActivityMeta{"command": command})
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":  "One or more of the commands are not allowed to be sent to this server.",
+			"denied": denied,
+		})
+		return
+	}
+
 	for _, command := range data.Commands {
 		if err := s.Environment.SendCommand(command); err != nil {
 			s.Log().WithFields(log.Fields{"command": command, "error": err}).Warn("failed to send command to server instance")
@@ -142,6 +174,10 @@ func postServerCommands(c *gin.Context) {
 func postServerSync(c *gin.Context) {
 	s := ExtractServer(c)
 
+	// Permissions for the server may have changed, so make sure any cached SFTP
+	// logins are validated by the Panel again.
+	sftp.InvalidateServer(s.ID())
+
 	if err := s.Sync(); err != nil {
 		middleware.CaptureAndAbort(c, err)
 	} else {
@@ -149,26 +185,29 @@ func postServerSync(c *gin.Context) {
 	}
 }
 
-// Performs a server installation in a background thread.
+// Performs a server installation in a job, the ID of which is returned.
 func postServerInstall(c *gin.Context) {
 	s := ExtractServer(c)
 
-	go func(s *server.Server) {
+	job := jobs.New(s, jobs.TypeInstall)
+	go job.Run(func(_ context.Context, _ *jobs.Job) (interface{}, error) {
 		s.Log().Info("syncing server state with remote source before executing installation process")
 		if err := s.Sync(); err != nil {
 			s.Log().WithField("error", err).Error("failed to sync server state with Panel")
-			return
+			return nil, err
 		}
 
 		if err := s.Install(); err != nil {
 			s.Log().WithField("error", err).Error("failed to execute server installation process")
+			return nil, err
 		}
-	}(s)
+		return nil, nil
+	})
 
-	c.Status(http.StatusAccepted)
+	c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
 }
 
-// Reinstalls a server.
+// Reinstalls a server in a job, the ID of which is returned.
 func postServerReinstall(c *gin.Context) {
 	s := ExtractServer(c)
 
@@ -179,13 +218,16 @@ func postServerReinstall(c *gin.Context) {
 		return
 	}
 
-	go func(s *server.Server) {
+	job := jobs.New(s, jobs.TypeReinstall)
+	go job.Run(func(_ context.Context, _ *jobs.Job) (interface{}, error) {
 		if err := s.Reinstall(); err != nil {
 			s.Log().WithField("error", err).Error("failed to complete server re-install process")
+			return nil, err
 		}
-	}(s)
+		return nil, nil
+	})
 
-	c.Status(http.StatusAccepted)
+	c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
 }
 
 // Deletes a server from the wings daemon and dissociate its objects.
@@ -195,6 +237,7 @@ func deleteServer(c *gin.Context) {
 	// Immediately suspend the server to prevent a user from attempting
 	// to start it while this process is running.
 	s.Config().SetSuspended(true)
+	sftp.InvalidateServer(s.ID())
 
 	// Notify all websocket clients that the server is being deleted.
 	// This is useful for two reasons, one to tell clients not to bother
@@ -212,6 +255,10 @@ func deleteServer(c *gin.Context) {
 	for _, dl := range downloader.ByServer(s.ID()) {
 		dl.Cancel()
 	}
+	// Remove any incomplete resumable uploads for the server.
+	for _, u := range uploader.ByServer(s.ID()) {
+		u.Remove()
+	}
 
 	// Destroy the environment; in Docker this will handle a running container and
 	// forcibly terminate it before removing the container, so we do not need to handle
@@ -243,21 +290,29 @@ func deleteServer(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type serverDenyTokensRequest struct {
+	JTIs []string `json:"jtis"`
+}
+
 // Adds any of the JTIs passed through in the body to the deny list for the websocket
 // preventing any JWT generated before the current time from being used to connect to
 // the socket or send along commands.
 func postServerDenyWSTokens(c *gin.Context) {
-	var data struct {
-		JTIs []string `json:"jtis"`
-	}
+	var data serverDenyTokensRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
 
 	for _, jti := range data.JTIs {
-		tokens.DenyJTI(jti)
+		if _, err := tokens.Revoke(c.Request.Context(), tokens.RevokeJTI, jti); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
 	}
+	// Tokens are denied when a user's access to the server is revoked, so do the
+	// same for any cached SFTP logins.
+	sftp.InvalidateServer(middleware.ExtractServer(c).ID())
 
 	c.Status(http.StatusNoContent)
 }
@@ -1,30 +1,35 @@
 package router
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"os"
 	"strings"
 
 	"emperror.dev/errors"
-	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/pterodactyl/wings/router/jobs"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/server/backup"
 )
 
+type serverBackupRequest struct {
+	Adapter backup.AdapterType `json:"adapter"`
+	Uuid    string             `json:"uuid"`
+	Ignore  string             `json:"ignore"`
+}
+
 // postServerBackup performs a backup against a given server instance using the
-// provided backup adapter.
+// provided backup adapter. The backup is created in a job, the ID of which is
+// returned.
 func postServerBackup(c *gin.Context) {
 	s := middleware.ExtractServer(c)
 	client := middleware.ExtractApiClient(c)
 	logger := middleware.ExtractLogger(c)
-	var data struct {
-		Adapter backup.AdapterType `json:"adapter"`
-		Uuid    string             `json:"uuid"`
-		Ignore  string             `json:"ignore"`
-	}
+	var data serverBackupRequest
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
@@ -47,13 +52,24 @@ func postServerBackup(c *gin.Context) {
 		"request_id": c.GetString("request_id"),
 	})
 
-	go func(b backup.BackupInterface, s *server.Server, logger *log.Entry) {
-		if err := s.Backup(b); err != nil {
+	job := jobs.New(s, jobs.TypeBackup)
+	go job.Run(func(_ context.Context, _ *jobs.Job) (interface{}, error) {
+		if err := s.Backup(adapter); err != nil {
 			logger.WithField("error", errors.WithStackIf(err)).Error("router: failed to generate server backup")
+			return nil, err
 		}
-	}(adapter, s, logger)
+		return nil, nil
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
+}
 
-	c.Status(http.StatusAccepted)
+type serverRestoreBackupRequest struct {
+	Adapter           backup.AdapterType `binding:"required,oneof=wings s3" json:"adapter"`
+	TruncateDirectory bool               `json:"truncate_directory"`
+	// A UUID is always required for this endpoint, however the download URL
+	// is only present when the given adapter type is s3.
+	DownloadUrl string `json:"download_url"`
 }
 
 // postServerRestoreBackup handles restoring a backup for a server by downloading
@@ -61,8 +77,7 @@ func postServerBackup(c *gin.Context) {
 // the server's data directory. If the TruncateDirectory field is provided and
 // is true all of the files will be deleted for the server.
 //
-// This endpoint will block until the backup is fully restored allowing for a
-// spinner to be displayed in the Panel UI effectively.
+// The backup is restored in a job, the ID of which is returned.
 //
 // TODO: stop the server if it is running
 func postServerRestoreBackup(c *gin.Context) {
@@ -70,13 +85,7 @@ func postServerRestoreBackup(c *gin.Context) {
 	client := middleware.ExtractApiClient(c)
 	logger := middleware.ExtractLogger(c)
 
-	var data struct {
-		Adapter           backup.AdapterType `binding:"required,oneof=wings s3" json:"adapter"`
-		TruncateDirectory bool               `json:"truncate_directory"`
-		// A UUID is always required for this endpoint, however the download URL
-		// is only present when the given adapter type is s3.
-		DownloadUrl string `json:"download_url"`
-	}
+	var data serverRestoreBackupRequest
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
@@ -112,18 +121,21 @@ func postServerRestoreBackup(c *gin.Context) {
 			middleware.CaptureAndAbort(c, err)
 			return
 		}
-		go func(s *server.Server, b backup.BackupInterface, logger *log.Entry) {
+		job := jobs.New(s, jobs.TypeRestore)
+		go job.Run(func(_ context.Context, _ *jobs.Job) (interface{}, error) {
 			logger.Info("starting restoration process for server backup using local driver")
-			if err := s.RestoreBackup(b, nil); err != nil {
+			err := s.RestoreBackup(b, nil)
+			if err != nil {
 				logger.WithField("error", err).Error("failed to restore local backup to server")
 			}
 			s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from local backup.")
 			s.Events().Publish(server.BackupRestoreCompletedEvent, "")
 			logger.Info("completed server restoration from local backup")
 			s.SetRestoring(false)
-		}(s, b, logger)
+			return nil, err
+		})
 		hasError = false
-		c.Status(http.StatusAccepted)
+		c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
 		return
 	}
 
@@ -156,19 +168,31 @@ func postServerRestoreBackup(c *gin.Context) {
 		return
 	}
 
-	go func(s *server.Server, uuid string, logger *log.Entry) {
+	uuid := c.Param("backup")
+	job := jobs.New(s, jobs.TypeRestore)
+	if res.ContentLength > 0 {
+		job.Progress().SetTotal(uint64(res.ContentLength))
+	}
+	go job.Run(func(_ context.Context, j *jobs.Job) (interface{}, error) {
 		logger.Info("starting restoration process for server backup using S3 driver")
-		if err := s.RestoreBackup(backup.NewS3(client, uuid, ""), res.Body); err != nil {
+		// Track the progress of the download through the job.
+		body := struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(res.Body, j.Progress()), res.Body}
+		err := s.RestoreBackup(backup.NewS3(client, uuid, ""), body)
+		if err != nil {
 			logger.WithField("error", errors.WithStack(err)).Error("failed to restore remote S3 backup to server")
 		}
 		s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from S3 backup.")
 		s.Events().Publish(server.BackupRestoreCompletedEvent, "")
 		logger.Info("completed server restoration from S3 backup")
 		s.SetRestoring(false)
-	}(s, c.Param("backup"), logger)
+		return nil, err
+	})
 
 	hasError = false
-	c.Status(http.StatusAccepted)
+	c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
 }
 
 // deleteServerBackup deletes a local backup of a server. If the backup is not
@@ -195,5 +219,8 @@ func deleteServerBackup(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if err := middleware.ExtractServer(c).ForgetLocalBackup(c.Request.Context(), b.Identifier()); err != nil {
+		middleware.ExtractLogger(c).WithField("error", err).Warn("failed to remove local backup from database")
+	}
 	c.Status(http.StatusNoContent)
 }
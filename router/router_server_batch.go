@@ -0,0 +1,75 @@
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/router/batch"
+	"github.com/pterodactyl/wings/router/jobs"
+	"github.com/pterodactyl/wings/router/middleware"
+)
+
+type serverBatchRequest struct {
+	Root       string            `json:"root"`
+	Operations []batch.Operation `json:"operations"`
+	Background bool              `json:"background"`
+}
+
+// Performs a batch of file operations against a server. Every operation is
+// attempted even if an earlier one fails, and the result of each is returned.
+// Batches can be run in the background, in which case the ID of the job running
+// the batch is returned, and the results are the result of the job once it has
+// completed.
+func postServerBatchFiles(c *gin.Context) {
+	s := ExtractServer(c)
+
+	var data serverBatchRequest
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	if len(data.Operations) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No file operations were provided.",
+		})
+		return
+	}
+
+	b, err := batch.New(s, data.Root, data.Operations)
+	if err != nil {
+		var msg string
+		switch {
+		case errors.Is(err, batch.ErrTooManyItems):
+			msg = "Too many file operations were provided in a single batch."
+		case errors.Is(err, batch.ErrUnknownAction):
+			msg = "An unknown file operation was provided."
+		case errors.Is(err, batch.ErrInvalidFileMode):
+			msg = "Invalid file mode."
+		case errors.Is(err, batch.ErrInvalidConflict):
+			msg = "The conflict policy must be one of skip, overwrite or rename."
+		case errors.Is(err, batch.ErrMissingPath), errors.Is(err, batch.ErrMissingTarget):
+			msg = "Every file operation must include a path, and a destination when moving or copying."
+		default:
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": msg})
+		return
+	}
+
+	if data.Background {
+		job := jobs.New(s, jobs.TypeBatch)
+		job.Cancellable(nil)
+		job.Progress().SetTotal(uint64(b.Len()))
+		go job.Run(func(ctx context.Context, j *jobs.Job) (interface{}, error) {
+			return gin.H{"results": b.Execute(ctx, j.Progress())}, ctx.Err()
+		})
+		c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": b.Execute(c.Request.Context(), nil)})
+}
@@ -2,7 +2,9 @@ package router
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/hex"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -12,18 +14,23 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/diff"
 	"github.com/pterodactyl/wings/internal/models"
 	"github.com/pterodactyl/wings/router/downloader"
+	"github.com/pterodactyl/wings/router/jobs"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/router/tokens"
 	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/backup"
 	"github.com/pterodactyl/wings/server/filesystem"
 )
 
@@ -48,13 +55,18 @@ func getServerFileContents(c *gin.Context) {
 	}
 
 	c.Header("X-Mime-Type", st.Mimetype)
-	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
 	// If a download parameter is included in the URL go ahead and attach the necessary headers
-	// so that the file can be downloaded.
+	// so that the file can be downloaded. Downloads support range and conditional requests,
+	// whereas the contents of a file opened in the editor are always returned in full.
 	if c.Query("download") != "" {
 		c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
 		c.Header("Content-Type", "application/octet-stream")
+		// Only send the number of bytes the file contained when it was opened, even if
+		// another process writes to it while it is being downloaded.
+		serveContent(c, st, io.NewSectionReader(f, 0, st.Size()))
+		return
 	}
+	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
 	defer c.Writer.Flush()
 	// If you don't do a limited reader here you will trigger a panic on write when
 	// a different server process writes content to the file after you've already
@@ -78,11 +90,269 @@ func getServerFileContents(c *gin.Context) {
 func getServerListDirectory(c *gin.Context) {
 	s := ExtractServer(c)
 	dir := c.Query("directory")
-	if stats, err := s.Filesystem().ListDirectory(dir); err != nil {
+
+	// Only return a page of the directory if one of the pagination options was
+	// provided, so that existing clients continue to receive every entry.
+	paginate := false
+	for _, k := range []string{"cursor", "per_page", "sort", "order", "filter", "mime"} {
+		if _, ok := c.GetQuery(k); ok {
+			paginate = true
+			break
+		}
+	}
+	if !paginate {
+		if stats, err := s.Filesystem().ListDirectory(dir); err != nil {
+			middleware.CaptureAndAbort(c, err)
+		} else {
+			c.JSON(http.StatusOK, stats)
+		}
+		return
+	}
+
+	opts := filesystem.ListOptions{
+		Cursor:       c.Query("cursor"),
+		Sort:         filesystem.ListSort(c.DefaultQuery("sort", string(filesystem.ListSortName))),
+		Descending:   c.Query("order") == "desc",
+		Filter:       c.Query("filter"),
+		SkipMimetype: c.Query("mime") == "false" || c.Query("mime") == "0",
+	}
+	if !opts.Sort.Valid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The sort must be one of name, size or modified.",
+		})
+		return
+	}
+	if v, ok := c.GetQuery("per_page"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > filesystem.MaxListLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The number of entries per page must be between 1 and " + strconv.Itoa(filesystem.MaxListLimit) + ".",
+			})
+			return
+		}
+		opts.Limit = limit
+	}
+
+	page, err := s.Filesystem().ListDirectoryPage(dir, opts)
+	if err != nil {
+		if errors.Is(err, filesystem.ErrInvalidCursor) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The provided cursor is not valid for this listing.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// Returns a breakdown of the disk space used by a directory on the server, with
+// the largest files and directories first. The usage is calculated in the
+// background and cached, so it may be slightly out of date.
+func getServerFileUsage(c *gin.Context) {
+	s := ExtractServer(c)
+
+	depth, limit := 1, 20
+	for _, q := range []struct {
+		key string
+		v   *int
+		max int
+	}{{"depth", &depth, 10}, {"limit", &limit, 100}} {
+		v, ok := c.GetQuery(q.key)
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i > q.max {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The " + q.key + " must be between 1 and " + strconv.Itoa(q.max) + ".",
+			})
+			return
+		}
+		*q.v = i
+	}
+
+	tree, updated, err := s.Filesystem().UsageTree(c.Request.Context(), c.Query("directory"), depth, limit)
+	if err != nil {
 		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"updated_at": updated,
+		"usage":      tree,
+	})
+}
+
+// Returns the checksum of a file on the server, calculated using the requested
+// algorithm. This allows a file to be verified without downloading it.
+func getServerFileHash(c *gin.Context) {
+	s := ExtractServer(c)
+	p := "/" + strings.TrimLeft(c.Query("file"), "/")
+	algorithm := strings.ToLower(c.DefaultQuery("algorithm", "sha256"))
+	h, ok := filesystem.NewHash(algorithm)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The requested hash algorithm is not supported.",
+		})
+		return
+	}
+	if err := s.Filesystem().IsIgnored(p); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	f, st, err := s.Filesystem().File(p)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	defer f.Close()
+	if !st.Mode().IsRegular() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Cannot open files of this type.",
+		})
+		return
+	}
+	if limit := config.Get().Api.HashLimit; limit > 0 && st.Size() > limit*1024*1024 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The file is too large to be hashed.",
+		})
+		return
+	}
+	if _, err := io.Copy(h, io.LimitReader(f, st.Size())); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm": algorithm,
+		"hash":      hex.EncodeToString(h.Sum(nil)),
+		"size":      st.Size(),
+	})
+}
+
+var (
+	errDiffTooLarge = errors.New("file is too large to compare")
+	errDiffBinary   = errors.New("file is not a text file")
+)
+
+// Returns a unified diff showing the changes made to a file on the server. The
+// file is compared against another file on the server, or against a file in
+// one of the local backups for the server. By default the file at the same path
+// in the backup is used, but a different file can be chosen using the "entry"
+// parameter.
+func getServerFileDiff(c *gin.Context) {
+	s := ExtractServer(c)
+	limit := config.Get().Api.DiffLimit * 1024 * 1024
+	p := "/" + strings.TrimLeft(c.Query("file"), "/")
+
+	var from string
+	var old []byte
+	var err error
+	if c.Query("backup") != "" {
+		id, perr := uuid.Parse(c.Query("backup"))
+		if perr != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The backup must be a valid UUID.",
+			})
+			return
+		}
+		b, _, lerr := backup.LocateLocal(middleware.ExtractApiClient(c), id.String())
+		if lerr != nil {
+			if errors.Is(lerr, os.ErrNotExist) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": "The requested backup was not found on this server.",
+				})
+				return
+			}
+			middleware.CaptureAndAbort(c, lerr)
+			return
+		}
+		owned, oerr := s.HasLocalBackup(c.Request.Context(), id.String())
+		// Backups created before they were recorded are not known to belong to any
+		// server, so trust the Panel to only request the backups of the server. API
+		// keys cannot use these backups since they may be limited to some servers.
+		if oerr == nil && !owned && middleware.ExtractApiKey(c) == "" {
+			owned, oerr = s.ClaimLocalBackup(c.Request.Context(), id.String())
+		}
+		if oerr != nil {
+			middleware.CaptureAndAbort(c, oerr)
+			return
+		}
+		if !owned {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "The requested backup was not found on this server.",
+			})
+			return
+		}
+		from = "/" + strings.TrimLeft(c.DefaultQuery("entry", p), "/")
+		if err = s.Filesystem().IsIgnored(from); err == nil {
+			old, err = b.ReadFile(c.Request.Context(), from, limit)
+			if errors.Is(err, backup.ErrFileTooLarge) {
+				err = errDiffTooLarge
+			}
+		}
 	} else {
-		c.JSON(http.StatusOK, stats)
+		if c.Query("compare") == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "A file or backup to compare against must be provided.",
+			})
+			return
+		}
+		from = "/" + strings.TrimLeft(c.Query("compare"), "/")
+		old, err = readDiffFile(s, from, limit)
+	}
+	var current []byte
+	if err == nil {
+		current, err = readDiffFile(s, p, limit)
+	}
+	if err == nil && (!isTextContent(old) || !isTextContent(current)) {
+		err = errDiffBinary
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errDiffTooLarge):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The files are too large to be compared.",
+			})
+		case errors.Is(err, errDiffBinary):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Only text files can be compared.",
+			})
+		default:
+			middleware.CaptureAndAbort(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"diff": diff.Unified(from, p, old, current),
+	})
+}
+
+// readDiffFile reads the contents of a file on the server that is being compared,
+// returning an error if the file cannot be compared.
+func readDiffFile(s *server.Server, p string, limit int64) ([]byte, error) {
+	if err := s.Filesystem().IsIgnored(p); err != nil {
+		return nil, err
 	}
+	f, st, err := s.Filesystem().File(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !st.Mode().IsRegular() {
+		return nil, errDiffBinary
+	}
+	if st.Size() > limit {
+		return nil, errDiffTooLarge
+	}
+	return io.ReadAll(io.LimitReader(f, st.Size()))
+}
+
+// isTextContent returns true if the contents appear to be text, rather than
+// binary data.
+func isTextContent(b []byte) bool {
+	return bytes.IndexByte(b, 0) == -1 && utf8.Valid(b)
 }
 
 type renameFile struct {
@@ -90,14 +360,16 @@ type renameFile struct {
 	From string `json:"from"`
 }
 
+type renameFilesRequest struct {
+	Root  string       `json:"root"`
+	Files []renameFile `json:"files"`
+}
+
 // Renames (or moves) files for a server.
 func putServerRenameFiles(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Root  string       `json:"root"`
-		Files []renameFile `json:"files"`
-	}
+	var data renameFilesRequest
 	// BindJSON sends 400 if the request fails, all we need to do is return
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -158,13 +430,15 @@ func putServerRenameFiles(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type copyFileRequest struct {
+	Location string `json:"location"`
+}
+
 // Copies a server file.
 func postServerCopyFile(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Location string `json:"location"`
-	}
+	var data copyFileRequest
 	// BindJSON sends 400 if the request fails, all we need to do is return
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -182,14 +456,16 @@ func postServerCopyFile(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type deleteFilesRequest struct {
+	Root  string   `json:"root"`
+	Files []string `json:"files"`
+}
+
 // Deletes files from a server.
 func postServerDeleteFiles(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Root  string   `json:"root"`
-		Files []string `json:"files"`
-	}
+	var data deleteFilesRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -273,18 +549,22 @@ func getServerPullingFiles(c *gin.Context) {
 	})
 }
 
+type pullRemoteFileRequest struct {
+	// Deprecated
+	Directory  string `binding:"required_without=RootPath,omitempty" json:"directory"`
+	RootPath   string `binding:"required_without=Directory,omitempty" json:"root"`
+	URL        string `binding:"required" json:"url"`
+	FileName   string `json:"file_name"`
+	UseHeader  bool   `json:"use_header"`
+	Foreground bool   `json:"foreground"`
+	Checksum   string `json:"checksum"`
+	Extract    bool   `json:"extract"`
+}
+
 // Writes the contents of the remote URL to a file on a server.
 func postServerPullRemoteFile(c *gin.Context) {
 	s := ExtractServer(c)
-	var data struct {
-		// Deprecated
-		Directory  string `binding:"required_without=RootPath,omitempty" json:"directory"`
-		RootPath   string `binding:"required_without=Directory,omitempty" json:"root"`
-		URL        string `binding:"required" json:"url"`
-		FileName   string `json:"file_name"`
-		UseHeader  bool   `json:"use_header"`
-		Foreground bool   `json:"foreground"`
-	}
+	var data pullRemoteFileRequest
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
@@ -306,6 +586,22 @@ func postServerPullRemoteFile(c *gin.Context) {
 		return
 	}
 
+	if err := downloader.CheckURL(u); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Files cannot be downloaded from the provided URL.",
+		})
+		return
+	}
+
+	if data.Checksum != "" {
+		if _, _, err := downloader.ParseChecksum(data.Checksum); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The checksum must be in the format \"algorithm:hash\" using a supported algorithm such as sha256.",
+			})
+			return
+		}
+	}
+
 	if err := s.Filesystem().HasSpaceErr(true); err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -323,6 +619,8 @@ func postServerPullRemoteFile(c *gin.Context) {
 		URL:       u,
 		FileName:  data.FileName,
 		UseHeader: data.UseHeader,
+		Checksum:  data.Checksum,
+		Extract:   data.Extract,
 	})
 
 	download := func() error {
@@ -349,6 +647,12 @@ func postServerPullRemoteFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	// The downloaded file is removed once it has been extracted, so there is nothing
+	// left to return.
+	if data.Extract {
+		c.Status(http.StatusNoContent)
+		return
+	}
 
 	st, err := s.Filesystem().Stat(dl.Path())
 	if err != nil {
@@ -367,14 +671,16 @@ func deleteServerPullRemoteFile(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type createDirectoryRequest struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
 // Create a directory on a server.
 func postServerCreateDirectory(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Name string `json:"name"`
-		Path string `json:"path"`
-	}
+	var data createDirectoryRequest
 	// BindJSON sends 400 if the request fails, all we need to do is return
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -395,13 +701,21 @@ func postServerCreateDirectory(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type compressFilesRequest struct {
+	RootPath string   `json:"root"`
+	Files    []string `json:"files"`
+	// Background creates the archive in a job rather than waiting for it to be
+	// created before responding.
+	Background bool `json:"background"`
+}
+
+// Compresses files on the server into an archive. If the archive is created in
+// the background the ID of the job creating it is returned, and the archive is
+// the result of the job once it has completed.
 func postServerCompressFiles(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		RootPath string   `json:"root"`
-		Files    []string `json:"files"`
-	}
+	var data compressFilesRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		return
@@ -421,7 +735,21 @@ func postServerCompressFiles(c *gin.Context) {
 		return
 	}
 
-	f, err := s.Filesystem().CompressFiles(data.RootPath, data.Files)
+	if data.Background {
+		job := jobs.New(s, jobs.TypeCompress)
+		job.Cancellable(nil)
+		go job.Run(func(ctx context.Context, j *jobs.Job) (interface{}, error) {
+			f, err := s.Filesystem().CompressFiles(ctx, data.RootPath, data.Files, j.Progress())
+			if err != nil {
+				return nil, err
+			}
+			return &filesystem.Stat{FileInfo: f, Mimetype: "application/tar+gzip"}, nil
+		})
+		c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
+		return
+	}
+
+	f, err := s.Filesystem().CompressFiles(context.Background(), data.RootPath, data.Files, nil)
 	if err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -433,22 +761,60 @@ func postServerCompressFiles(c *gin.Context) {
 	})
 }
 
+// Lists the files and directories inside an archive on the server without
+// extracting it.
+func getServerArchiveContents(c *gin.Context) {
+	s := ExtractServer(c)
+	p := "/" + strings.TrimLeft(c.Query("file"), "/")
+	if err := s.Filesystem().IsIgnored(p); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	entries, truncated, err := s.Filesystem().ListArchive(c.Request.Context(), path.Dir(p), path.Base(p))
+	if err != nil {
+		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The archive provided is in a format Wings does not understand."})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"entries":   entries,
+		"truncated": truncated,
+	})
+}
+
+type decompressFileRequest struct {
+	RootPath string `json:"root"`
+	File     string `json:"file"`
+	// The directory to extract the archive into, defaults to the root.
+	Target string `json:"target"`
+	// The entries inside the archive to extract, if empty everything is extracted.
+	Entries []string `json:"entries"`
+	// Background extracts the archive in a job rather than waiting for it to be
+	// extracted before responding.
+	Background bool `json:"background"`
+}
+
 // postServerDecompressFiles receives the HTTP request and starts the process
 // of unpacking an archive that exists on the server into the provided RootPath
-// for the server.
+// for the server. A target directory and a list of entries can be provided to
+// only extract part of the archive somewhere else. If the archive is extracted
+// in the background the ID of the job extracting it is returned.
 func postServerDecompressFiles(c *gin.Context) {
-	var data struct {
-		RootPath string `json:"root"`
-		File     string `json:"file"`
-	}
+	var data decompressFileRequest
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
+	if data.Target == "" {
+		data.Target = data.RootPath
+	}
 
 	s := middleware.ExtractServer(c)
-	lg := middleware.ExtractLogger(c).WithFields(log.Fields{"root_path": data.RootPath, "file": data.File})
+	lg := middleware.ExtractLogger(c).WithFields(log.Fields{"root_path": data.RootPath, "file": data.File, "target": data.Target})
 	lg.Debug("checking if space is available for file decompression")
-	err := s.Filesystem().SpaceAvailableForDecompression(context.Background(), data.RootPath, data.File)
+	err := s.Filesystem().SpaceAvailableForDecompression(context.Background(), data.RootPath, data.File, data.Entries...)
 	if err != nil {
 		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
 			lg.WithField("error", err).Warn("failed to decompress file: unknown archive format")
@@ -459,8 +825,19 @@ func postServerDecompressFiles(c *gin.Context) {
 		return
 	}
 
+	if data.Background {
+		job := jobs.New(s, jobs.TypeDecompress)
+		job.Cancellable(nil)
+		go job.Run(func(ctx context.Context, _ *jobs.Job) (interface{}, error) {
+			lg.WithField("job_id", job.ID).Info("starting file decompression")
+			return nil, s.Filesystem().DecompressFileTo(ctx, data.RootPath, data.File, data.Target, data.Entries)
+		})
+		c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
+		return
+	}
+
 	lg.Info("starting file decompression")
-	if err := s.Filesystem().DecompressFile(context.Background(), data.RootPath, data.File); err != nil {
+	if err := s.Filesystem().DecompressFileTo(context.Background(), data.RootPath, data.File, data.Target, data.Entries); err != nil {
 		// If the file is busy for some reason just return a nicer error to the user since there is not
 		// much we specifically can do. They'll need to stop the running server process in order to overwrite
 		// a file like this.
@@ -484,13 +861,15 @@ type chmodFile struct {
 
 var errInvalidFileMode = errors.New("invalid file mode")
 
+type chmodFilesRequest struct {
+	Root  string      `json:"root"`
+	Files []chmodFile `json:"files"`
+}
+
 func postServerChmodFile(c *gin.Context) {
 	s := ExtractServer(c)
 
-	var data struct {
-		Root  string      `json:"root"`
-		Files []chmodFile `json:"files"`
-	}
+	var data chmodFilesRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		log.Debug(err.Error())
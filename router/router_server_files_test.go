@@ -0,0 +1,125 @@
+package router
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/apikey"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// writeLocalBackup creates a local backup containing a single file, without
+// recording the server it belongs to.
+func writeLocalBackup(name string, content string) string {
+	id := uuid.Must(uuid.NewRandom()).String()
+	f, err := os.Create(filepath.Join(config.Get().System.BackupDirectory, id+".tar.gz"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		panic(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		panic(err)
+	}
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	if err := gz.Close(); err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// backupOwner returns the server recorded for a local backup.
+func backupOwner(id string) string {
+	var m models.LocalBackup
+	if tx := database.Instance().Where("id = ?", id).Limit(1).Find(&m); tx.Error != nil {
+		panic(tx.Error)
+	}
+	return m.Server
+}
+
+func TestServerFileDiff(t *testing.T) {
+	g := Goblin(t)
+
+	engine, s := newTestEngine(t)
+	if err := s.Filesystem().Write("/a.txt", strings.NewReader("hello world\n"), 12, 0o644); err != nil {
+		panic(err)
+	}
+	_, key, err := apikey.Create(context.Background(), "", []string{"files"}, []string{s.ID()})
+	if err != nil {
+		panic(err)
+	}
+
+	request := func(token string, backup string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/servers/"+s.ID()+"/files/diff?file=a.txt&backup="+backup, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	g.Describe("GET /api/servers/:server/files/diff", func() {
+		g.It("compares a file with a backup of the server", func() {
+			id := writeLocalBackup("a.txt", "hello\n")
+			m := models.LocalBackup{ID: id, Server: s.ID(), CreatedAt: time.Now().UTC()}
+			g.Assert(database.Instance().Create(&m).Error).IsNil()
+
+			for _, token := range []string{"node-token", key} {
+				w := request(token, id)
+				g.Assert(w.Code).Equal(http.StatusOK)
+				g.Assert(strings.Contains(w.Body.String(), `+hello world`)).IsTrue(w.Body.String())
+			}
+		})
+
+		g.It("claims backups that have not been recorded for the Panel", func() {
+			id := writeLocalBackup("a.txt", "hello\n")
+			g.Assert(request(key, id).Code).Equal(http.StatusNotFound)
+			g.Assert(backupOwner(id)).Equal("")
+
+			g.Assert(request("node-token", id).Code).Equal(http.StatusOK)
+			g.Assert(backupOwner(id)).Equal(s.ID())
+			g.Assert(request(key, id).Code).Equal(http.StatusOK)
+		})
+
+		g.It("does not use the backups of other servers", func() {
+			id := writeLocalBackup("a.txt", "hello\n")
+			m := models.LocalBackup{ID: id, Server: uuid.Must(uuid.NewRandom()).String(), CreatedAt: time.Now().UTC()}
+			g.Assert(database.Instance().Create(&m).Error).IsNil()
+
+			for _, token := range []string{"node-token", key} {
+				w := request(token, id)
+				g.Assert(w.Code).Equal(http.StatusNotFound)
+				g.Assert(strings.Contains(w.Body.String(), "hello")).IsFalse()
+			}
+			g.Assert(backupOwner(id)).Equal(m.Server)
+		})
+
+		g.It("does not claim backups that do not exist", func() {
+			id := uuid.Must(uuid.NewRandom()).String()
+			g.Assert(request("node-token", id).Code).Equal(http.StatusNotFound)
+			g.Assert(backupOwner(id)).Equal("")
+		})
+
+		g.It("rejects invalid backup IDs", func() {
+			g.Assert(request("node-token", "../../etc/passwd").Code).Equal(http.StatusBadRequest)
+		})
+	})
+}
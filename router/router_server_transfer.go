@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/pterodactyl/wings/environment"
+	"github.com/pterodactyl/wings/router/jobs"
 	"github.com/pterodactyl/wings/router/middleware"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/server/installer"
@@ -23,7 +24,8 @@ type serverTransferRequest struct {
 	Server installer.ServerDetails `json:"server"`
 }
 
-// postServerTransfer handles the start of a transfer for a server.
+// postServerTransfer handles the start of a transfer for a server. The transfer
+// is run in a job, the ID of which is returned.
 func postServerTransfer(c *gin.Context) {
 	var data serverTransferRequest
 	if err := c.BindJSON(&data); err != nil {
@@ -77,20 +79,28 @@ func postServerTransfer(c *gin.Context) {
 	trnsfr := transfer.New(context.Background(), s)
 	transfer.Outgoing().Add(trnsfr)
 
-	go func() {
+	// The transfer is run in a job, which can be cancelled in the same way as the
+	// transfer itself.
+	job := jobs.New(s, jobs.TypeTransfer)
+	job.Cancellable(trnsfr.Cancel)
+	go job.Run(func(_ context.Context, j *jobs.Job) (interface{}, error) {
 		defer transfer.Outgoing().Remove(trnsfr)
 
+		// Report the progress of streaming the archive through the job.
+		if a, err := trnsfr.Archive(); err == nil {
+			j.SetProgress(a.Progress())
+		}
 		if _, err := trnsfr.PushArchiveToTarget(data.URL, data.Token); err != nil {
 			notifyPanelOfFailure()
 
 			if err == context.Canceled {
 				trnsfr.Log().Debug("canceled")
 				trnsfr.SendMessage("Canceled.")
-				return
+				return nil, err
 			}
 
 			trnsfr.Log().WithError(err).Error("failed to push archive to target")
-			return
+			return nil, err
 		}
 
 		// DO NOT NOTIFY THE PANEL OF SUCCESS HERE. The only node that should send
@@ -100,9 +110,10 @@ func postServerTransfer(c *gin.Context) {
 		// we clean up our statuses for failure.
 
 		trnsfr.Log().Debug("transfer complete")
-	}()
+		return nil, nil
+	})
 
-	c.Status(http.StatusAccepted)
+	c.JSON(http.StatusAccepted, gin.H{"job": job.ID})
 }
 
 // deleteServerTransfer cancels an outgoing transfer for a server.
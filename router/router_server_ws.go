@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"emperror.dev/errors"
 	"github.com/gin-gonic/gin"
 	"github.com/goccy/go-json"
 	ws "github.com/gorilla/websocket"
@@ -45,6 +46,7 @@ func getServerWebsocket(c *gin.Context) {
 	handler.Logger().Debug("opening connection to server websocket")
 
 	defer func() {
+		handler.ReleaseWatches()
 		s.Websockets().Remove(handler.Uuid())
 		handler.Logger().Debug("closing connection to server websocket")
 	}()
@@ -68,6 +70,9 @@ func getServerWebsocket(c *gin.Context) {
 
 		_, p, err := handler.Connection.ReadMessage()
 		if err != nil {
+			if errors.Is(err, ws.ErrReadLimit) {
+				handler.Logger().Warn("closing websocket connection after receiving a message that was too large")
+			}
 			if ws.IsUnexpectedCloseError(err, expectedCloseCodes...) {
 				handler.Logger().WithField("error", err).Warn("error handling websocket message for server")
 			}
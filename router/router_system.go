@@ -10,9 +10,12 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/apikey"
 	"github.com/pterodactyl/wings/router/middleware"
+	"github.com/pterodactyl/wings/router/tokens"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/server/installer"
+	"github.com/pterodactyl/wings/sftp"
 	"github.com/pterodactyl/wings/system"
 )
 
@@ -44,6 +47,151 @@ func getSystemInformation(c *gin.Context) {
 	})
 }
 
+// Returns the IP addresses and usernames that are currently banned from the SFTP
+// server for making too many failed login attempts.
+func getSftpBans(c *gin.Context) {
+	c.JSON(http.StatusOK, sftp.Bans())
+}
+
+type sftpBanRequest struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Removes a ban from the SFTP server. If no type and value are provided all the
+// bans are removed.
+func deleteSftpBans(c *gin.Context) {
+	var data sftpBanRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&data); err != nil {
+			return
+		}
+	}
+
+	if data.Type == "" && data.Value == "" {
+		sftp.ClearBans()
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if data.Type != sftp.BanTypeIP && data.Type != sftp.BanTypeUsername {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "The ban type must be one of \"ip\" or \"username\".",
+		})
+		return
+	}
+	if !sftp.Unban(data.Type, data.Value) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "There is no active ban matching the provided value.",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Returns the API keys stored in the database. Keys defined in the configuration
+// file are not returned.
+func getApiKeys(c *gin.Context) {
+	keys, err := apikey.List(c.Request.Context())
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+type apiKeyRequest struct {
+	ID      string   `json:"id"`
+	Scopes  []string `json:"scopes"`
+	Servers []string `json:"servers"`
+}
+
+// Creates a new API key with the given scopes, optionally restricted to a set of
+// servers. The token for the key is only returned in this response.
+func postApiKeys(c *gin.Context) {
+	var data apiKeyRequest
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	key, token, err := apikey.Create(c.Request.Context(), data.ID, data.Scopes, data.Servers)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalidScope):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "At least one scope must be provided, and every scope must be one of \"system.read\", \"power\", \"files\" or \"backups\".",
+			})
+		case errors.Is(err, apikey.ErrServerScope):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "The \"backups\" scope cannot be granted to a key that is restricted to a set of servers.",
+			})
+		case errors.Is(err, apikey.ErrExists):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "An API key with the provided ID already exists.",
+			})
+		default:
+			middleware.CaptureAndAbort(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"key": key, "token": token})
+}
+
+// Deletes an API key stored in the database.
+func deleteApiKey(c *gin.Context) {
+	if err := apikey.Delete(c.Request.Context(), c.Param("key")); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "There is no API key matching the provided ID.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Returns the token revocations that have not yet expired, optionally filtered
+// by the type and value of the revocation.
+func getTokenRevocations(c *gin.Context) {
+	revocations, err := tokens.Revocations(c.Request.Context(), c.Query("type"), c.Query("value"))
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, revocations)
+}
+
+type tokenRevocationRequest struct {
+	Type  string `binding:"required,oneof=jti user server" json:"type"`
+	Value string `binding:"required" json:"value"`
+}
+
+// Revokes every token issued before now with a JTI, or that was issued for a
+// user or server. The revocation is kept after Wings is restarted until every
+// token it applies to has expired.
+func postTokenRevocations(c *gin.Context) {
+	var data tokenRevocationRequest
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	revocation, err := tokens.Revoke(c.Request.Context(), data.Type, data.Value)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	// Revoking the tokens of a user or server also requires their SFTP logins to be
+	// checked with the Panel again, rather than using a cached login.
+	switch data.Type {
+	case tokens.RevokeServer:
+		sftp.InvalidateServer(data.Value)
+	case tokens.RevokeUser:
+		sftp.InvalidateUser(data.Value)
+	}
+	c.JSON(http.StatusCreated, revocation)
+}
+
 // Returns all the servers that are registered and configured correctly on
 // this wings instance.
 func getAllServers(c *gin.Context) {
@@ -131,6 +279,10 @@ func postUpdateConfiguration(c *gin.Context) {
 	if err := c.BindJSON(&cfg); err != nil {
 		return
 	}
+	if err := cfg.Tokens.Validate(); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Keep the SSL certificates the same since the Panel will send through Lets Encrypt
 	// default locations. However, if we picked a different location manually we don't
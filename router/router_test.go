@@ -0,0 +1,59 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/internal/apikey"
+)
+
+func TestServerRoutes(t *testing.T) {
+	g := Goblin(t)
+
+	engine, s := newTestEngine(t)
+	_, key, err := apikey.Create(context.Background(), "", []string{"files"}, []string{s.ID()})
+	if err != nil {
+		panic(err)
+	}
+	_, other, err := apikey.Create(context.Background(), "", []string{"files"}, []string{uuid.Must(uuid.NewRandom()).String()})
+	if err != nil {
+		panic(err)
+	}
+
+	request := func(server string, auth string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/servers/"+server+"/files/list-directory?directory=/", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	g.Describe("server routes", func() {
+		g.It("require authorization", func() {
+			cases := []struct {
+				name   string
+				server string
+				auth   string
+				code   int
+			}{
+				{"no token", s.ID(), "", http.StatusUnauthorized},
+				{"an unknown token", s.ID(), "Bearer unknown", http.StatusForbidden},
+				{"a key for another server", s.ID(), "Bearer " + other, http.StatusForbidden},
+				{"a key for the server", s.ID(), "Bearer " + key, http.StatusOK},
+				{"the node token", s.ID(), "Bearer node-token", http.StatusOK},
+				{"an unknown server", uuid.Must(uuid.NewRandom()).String(), "Bearer node-token", http.StatusNotFound},
+				{"an unknown server without a token", uuid.Must(uuid.NewRandom()).String(), "", http.StatusUnauthorized},
+			}
+			for _, c := range cases {
+				g.Assert(request(c.server, c.auth)).Equal(c.code, c.name)
+			}
+		})
+	})
+}
@@ -0,0 +1,225 @@
+package router
+
+import (
+	"encoding/base64"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/router/middleware"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/router/uploader"
+	"github.com/pterodactyl/wings/server"
+)
+
+// The version of the tus protocol that is supported for resumable uploads.
+//
+// @see https://tus.io/protocols/resumable-upload
+const tusVersion = "1.0.0"
+
+// Handles the start of a resumable upload using the tus protocol. The upload is
+// authorized using the same one-time signed URL as a regular upload, the returned
+// location is then used to send the file in as many requests as needed.
+func postTusUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	manager := middleware.ExtractManager(c)
+
+	token := tokens.UploadPayload{}
+	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	s, ok := manager.Get(token.ServerUuid)
+	if !ok || !token.IsUniqueRequest() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "A valid Upload-Length header must be provided.",
+		})
+		return
+	}
+	name := parseTusMetadata(c.GetHeader("Upload-Metadata"))["filename"]
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "A valid filename must be provided in the Upload-Metadata header.",
+		})
+		return
+	}
+
+	u, err := uploader.New(s, token.UserUuid, filepath.Join(c.Query("directory"), name), size)
+	if err != nil {
+		if errors.Is(err, uploader.ErrUploadTooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "The file is larger than the maximum upload size.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	// Empty files have nothing left to upload, so write them out straight away.
+	if size == 0 {
+		if !finishTusUpload(c, s, u) {
+			return
+		}
+	}
+
+	c.Header("Location", "/upload/tus/"+u.Identifier)
+	c.Header("Upload-Expires", u.Expiration().UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// Returns the current offset of a resumable upload, allowing the client to
+// determine where to resume the upload from.
+func headTusUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	u, _ := extractTusUpload(c)
+	if u == nil {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(u.CurrentOffset(), 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Size, 10))
+	c.Header("Upload-Expires", u.Expiration().UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// Appends the data in the request body to a resumable upload. Once all the data
+// has been received the file is written to the server.
+func patchTusUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "The request body must be of type application/offset+octet-stream.",
+		})
+		return
+	}
+	u, s := extractTusUpload(c)
+	if u == nil {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "A valid Upload-Offset header must be provided.",
+		})
+		return
+	}
+
+	if _, err := u.Write(c.Request.Body, offset); err != nil {
+		switch {
+		case errors.Is(err, uploader.ErrOffsetMismatch):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The provided offset does not match the current offset of the upload.",
+			})
+		case errors.Is(err, uploader.ErrUploadLocked):
+			c.AbortWithStatusJSON(http.StatusLocked, gin.H{
+				"error": "The upload is already being written to by another request.",
+			})
+		default:
+			middleware.CaptureAndAbort(c, err)
+		}
+		return
+	}
+
+	if u.Complete() {
+		if !finishTusUpload(c, s, u) {
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(u.CurrentOffset(), 10))
+	c.Header("Upload-Expires", u.Expiration().UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+// Cancels a resumable upload, removing any data that has been received so far.
+func deleteTusUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	u, _ := extractTusUpload(c)
+	if u == nil {
+		return
+	}
+	u.Remove()
+	c.Status(http.StatusNoContent)
+}
+
+// checkTusVersion sets the protocol version header on the response and aborts
+// the request if the client is using a version of the protocol that is not
+// supported.
+func checkTusVersion(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// extractTusUpload returns the upload matching the ID in the request along with
+// the server it belongs to. If there is no upload, or the server no longer exists
+// the request is aborted and nil is returned.
+func extractTusUpload(c *gin.Context) (*uploader.Upload, *server.Server) {
+	u := uploader.ByID(c.Param("upload"))
+	if u != nil {
+		if s, ok := middleware.ExtractManager(c).Get(u.ServerID); ok {
+			return u, s
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+		"error": "The requested resource was not found on this server.",
+	})
+	return nil, nil
+}
+
+// finishTusUpload writes a completed upload to the server filesystem, aborting
+// the request if it cannot be written.
+func finishTusUpload(c *gin.Context, s *server.Server, u *uploader.Upload) bool {
+	if err := u.Finish(s); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return false
+	}
+	s.SaveActivity(s.NewRequestActivity(u.UserID, c.ClientIP()), server.ActivityFileUploaded, models.ActivityMeta{
+		"file":      filepath.Base(u.Path),
+		"directory": filepath.Dir(u.Path),
+	})
+	return true
+}
+
+// parseTusMetadata parses the Upload-Metadata header, which is a comma separated
+// list of keys and base64 encoded values.
+func parseTusMetadata(header string) map[string]string {
+	out := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if k == "" {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			out[k] = string(b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package router
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/server"
+)
+
+var initTestDatabase sync.Once
+
+// newTestEngine returns the router for a manager containing a single server with
+// an empty data directory. The database is opened the first time this is called.
+func newTestEngine(t *testing.T) (*gin.Engine, *server.Server) {
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.RootDirectory = t.TempDir()
+	cfg.System.Data = t.TempDir()
+	cfg.System.UploadDirectory = t.TempDir()
+	cfg.System.BackupDirectory = t.TempDir()
+	config.Set(cfg)
+	initTestDatabase.Do(func() {
+		if err := database.Initialize(); err != nil {
+			panic(err)
+		}
+	})
+
+	manager := server.NewEmptyManager(nil)
+	id := uuid.Must(uuid.NewRandom()).String()
+	if err := os.MkdirAll(filepath.Join(cfg.System.Data, id), 0o755); err != nil {
+		panic(err)
+	}
+	s, err := manager.InitServer(remote.ServerConfigurationResponse{
+		Settings: []byte(fmt.Sprintf(`{"uuid":"%s"}`, id)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	manager.Add(s)
+	return Configure(manager, remote.New("http://127.0.0.1")), s
+}
+
+func TestTusUpload(t *testing.T) {
+	g := Goblin(t)
+
+	engine, s := newTestEngine(t)
+
+	sign := func() string {
+		token, err := jwt.Sign(tokens.UploadPayload{
+			Payload: jwt.Payload{
+				ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:       jwt.NumericDate(time.Now()),
+			},
+			ServerUuid: s.ID(),
+			UserUuid:   "user",
+			UniqueId:   uuid.Must(uuid.NewRandom()).String(),
+		}, config.GetJwtAlgorithm())
+		if err != nil {
+			panic(err)
+		}
+		return string(token)
+	}
+	request := func(method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Tus-Resumable", "1.0.0")
+		for k, v := range headers {
+			if v == "" {
+				r.Header.Del(k)
+			} else {
+				r.Header.Set(k, v)
+			}
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+	create := func(token string, name string, size int) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/upload/tus?directory=/&token="+token, "", map[string]string{
+			"Upload-Length":   fmt.Sprint(size),
+			"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(name)),
+		})
+	}
+	patch := func(location string, offset int, body string) *httptest.ResponseRecorder {
+		return request(http.MethodPatch, location, body, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": fmt.Sprint(offset),
+		})
+	}
+
+	g.Describe("tus uploads", func() {
+		g.It("uploads a file in multiple requests", func() {
+			w := create(sign(), "a.txt", 11)
+			g.Assert(w.Code).Equal(http.StatusCreated)
+			g.Assert(w.Header().Get("Tus-Resumable")).Equal("1.0.0")
+			location := w.Header().Get("Location")
+			g.Assert(strings.HasPrefix(location, "/upload/tus/")).IsTrue()
+
+			w = patch(location, 0, "hello")
+			g.Assert(w.Code).Equal(http.StatusNoContent)
+			g.Assert(w.Header().Get("Upload-Offset")).Equal("5")
+
+			w = request(http.MethodHead, location, "", nil)
+			g.Assert(w.Code).Equal(http.StatusOK)
+			g.Assert(w.Header().Get("Upload-Offset")).Equal("5")
+			g.Assert(w.Header().Get("Upload-Length")).Equal("11")
+
+			w = patch(location, 5, " world")
+			g.Assert(w.Code).Equal(http.StatusNoContent)
+			g.Assert(w.Header().Get("Upload-Offset")).Equal("11")
+
+			b, err := os.ReadFile(filepath.Join(s.Filesystem().Path(), "a.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("hello world")
+
+			// The upload is removed once it has been completed.
+			g.Assert(request(http.MethodHead, location, "", nil).Code).Equal(http.StatusNotFound)
+		})
+
+		g.It("rejects data that does not start at the current offset", func() {
+			location := create(sign(), "b.txt", 10).Header().Get("Location")
+			g.Assert(patch(location, 0, "hello").Code).Equal(http.StatusNoContent)
+
+			for _, offset := range []int{0, 3, 10} {
+				w := patch(location, offset, "world")
+				g.Assert(w.Code).Equal(http.StatusConflict, fmt.Sprint(offset))
+			}
+			g.Assert(request(http.MethodHead, location, "", nil).Header().Get("Upload-Offset")).Equal("5")
+			g.Assert(request(http.MethodDelete, location, "", nil).Code).Equal(http.StatusNoContent)
+		})
+
+		g.It("writes empty files straight away", func() {
+			w := create(sign(), "empty.txt", 0)
+			g.Assert(w.Code).Equal(http.StatusCreated)
+			st, err := os.Stat(filepath.Join(s.Filesystem().Path(), "empty.txt"))
+			g.Assert(err).IsNil()
+			g.Assert(st.Size()).Equal(int64(0))
+		})
+
+		g.It("only allows each token to be used once", func() {
+			token := sign()
+			w := create(token, "c.txt", 5)
+			g.Assert(w.Code).Equal(http.StatusCreated)
+			g.Assert(create(token, "c.txt", 5).Code).Equal(http.StatusNotFound)
+			g.Assert(request(http.MethodDelete, w.Header().Get("Location"), "", nil).Code).Equal(http.StatusNoContent)
+		})
+
+		g.It("rejects invalid requests", func() {
+			g.Assert(create(sign(), "a/b.txt", 5).Code).Equal(http.StatusBadRequest)
+			g.Assert(create(sign(), "", 5).Code).Equal(http.StatusBadRequest)
+			g.Assert(create(sign(), "d.txt", -1).Code).Equal(http.StatusBadRequest)
+
+			w := request(http.MethodPost, "/upload/tus?token="+sign(), "", map[string]string{"Tus-Resumable": ""})
+			g.Assert(w.Code).Equal(http.StatusPreconditionFailed)
+			g.Assert(w.Header().Get("Tus-Version")).Equal("1.0.0")
+
+			location := create(sign(), "d.txt", 5).Header().Get("Location")
+			w = request(http.MethodPatch, location, "hello", map[string]string{"Upload-Offset": "0"})
+			g.Assert(w.Code).Equal(http.StatusUnsupportedMediaType)
+			w = request(http.MethodPatch, location, "hello", map[string]string{"Content-Type": "application/offset+octet-stream"})
+			g.Assert(w.Code).Equal(http.StatusBadRequest)
+
+			g.Assert(request(http.MethodDelete, location, "", nil).Code).Equal(http.StatusNoContent)
+			g.Assert(patch(location, 0, "hello").Code).Equal(http.StatusNotFound)
+			g.Assert(request(http.MethodDelete, location, "", nil).Code).Equal(http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package tokens
+
+import (
+	"regexp"
+	"strings"
+)
+
+// regexRulePrefix marks a command rule as a regular expression rather than a
+// command prefix.
+const regexRulePrefix = "regex:"
+
+// CommandRules restricts the console commands a user is able to send to a
+// server. Each rule is either a command prefix, such as "kick", which matches
+// the command itself and the command followed by any arguments, or a regular
+// expression prefixed with "regex:" which is matched against the whole command.
+// Rules are not case-sensitive, and a leading "/" on a command is ignored.
+//
+// A command matching any deny rule is never allowed. If there are any allow
+// rules a command must also match one of them to be allowed.
+type CommandRules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Allowed returns true if the rules allow the command to be sent. Commands
+// containing multiple lines are only allowed if every line is, since each line
+// is run by the server as a separate command.
+func (r *CommandRules) Allowed(command string) bool {
+	if r == nil || (len(r.Allow) == 0 && len(r.Deny) == 0) {
+		return true
+	}
+	for _, line := range strings.FieldsFunc(command, func(c rune) bool { return c == '\n' || c == '\r' }) {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "/")
+		if line == "" {
+			continue
+		}
+		if matchesCommandRule(r.Deny, line, true) {
+			return false
+		}
+		if len(r.Allow) > 0 && !matchesCommandRule(r.Allow, line, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesCommandRule returns true if the command matches any of the rules. A
+// rule with an invalid regular expression is treated as matching if invalid is
+// true, so that an invalid deny rule denies every command rather than none.
+func matchesCommandRule(rules []string, command string, invalid bool) bool {
+	for _, rule := range rules {
+		if pattern, ok := strings.CutPrefix(rule, regexRulePrefix); ok {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				if invalid {
+					return true
+				}
+				continue
+			}
+			if re.MatchString(command) {
+				return true
+			}
+			continue
+		}
+		rule = strings.TrimPrefix(strings.TrimSpace(rule), "/")
+		if rule == "" || len(command) < len(rule) || !strings.EqualFold(command[:len(rule)], rule) {
+			continue
+		}
+		if len(command) == len(rule) || command[len(rule)] == ' ' || command[len(rule)] == '\t' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package tokens
+
+import (
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestCommandRules(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("CommandRules", func() {
+		g.It("allows every command without any rules", func() {
+			var r *CommandRules
+			g.Assert(r.Allowed("stop")).IsTrue()
+			g.Assert((&CommandRules{}).Allowed("stop")).IsTrue()
+		})
+
+		g.It("checks commands against the rules", func() {
+			cases := []struct {
+				name    string
+				rules   CommandRules
+				command string
+				allowed bool
+			}{
+				{"denies a matching command", CommandRules{Deny: []string{"stop"}}, "stop", false},
+				{"denies a matching command with arguments", CommandRules{Deny: []string{"kick"}}, "kick player", false},
+				{"denies a command separated by a tab", CommandRules{Deny: []string{"kick"}}, "kick\tplayer", false},
+				{"allows a command sharing a prefix", CommandRules{Deny: []string{"stop"}}, "stopwatch", true},
+				{"allows a shorter command", CommandRules{Deny: []string{"stop server"}}, "stop", true},
+				{"ignores the case of commands", CommandRules{Deny: []string{"stop"}}, "STOP", false},
+				{"ignores the case of rules", CommandRules{Deny: []string{"Stop"}}, "stop now", false},
+				{"ignores a leading slash on commands", CommandRules{Deny: []string{"stop"}}, "/stop", false},
+				{"ignores a leading slash on rules", CommandRules{Deny: []string{"/stop"}}, "stop", false},
+				{"ignores surrounding whitespace", CommandRules{Deny: []string{"stop"}}, "  stop  ", false},
+				{"ignores empty rules", CommandRules{Deny: []string{"", "/"}}, "say hello", true},
+				{"allows commands matching an allow rule", CommandRules{Allow: []string{"say"}}, "say hello", true},
+				{"denies commands not matching an allow rule", CommandRules{Allow: []string{"say"}}, "stop", false},
+				{"prefers deny rules to allow rules", CommandRules{Allow: []string{"say"}, Deny: []string{"say secret"}}, "say secret", false},
+				{"allows a command matching both lists with another argument", CommandRules{Allow: []string{"say"}, Deny: []string{"say secret"}}, "say secrets", true},
+				{"denies any denied line", CommandRules{Deny: []string{"stop"}}, "say hello\nstop", false},
+				{"denies any denied line after a carriage return", CommandRules{Deny: []string{"stop"}}, "say hello\r\n/stop", false},
+				{"requires every line to be allowed", CommandRules{Allow: []string{"say"}}, "say hello\nop player", false},
+				{"allows every line being allowed", CommandRules{Allow: []string{"say"}}, "say hello\nsay world\n", true},
+				{"skips empty lines", CommandRules{Allow: []string{"say"}}, "\n\nsay hello\n \n", true},
+				{"matches regular expressions", CommandRules{Deny: []string{"regex:^op\\s"}}, "op player", false},
+				{"matches regular expressions without the case", CommandRules{Deny: []string{"regex:^op\\s"}}, "OP player", false},
+				{"matches regular expressions after a slash", CommandRules{Deny: []string{"regex:^op\\s"}}, "/op player", false},
+				{"does not match other regular expressions", CommandRules{Deny: []string{"regex:^op\\s"}}, "deop player", true},
+				{"allows matching regular expressions", CommandRules{Allow: []string{"regex:^(say|me) "}}, "me waves", true},
+				{"denies every command for an invalid deny expression", CommandRules{Deny: []string{"regex:("}}, "say hello", false},
+				{"ignores an invalid allow expression", CommandRules{Allow: []string{"regex:(", "say"}}, "say hello", true},
+				{"does not allow commands for an invalid allow expression", CommandRules{Allow: []string{"regex:("}}, "say hello", false},
+			}
+			for _, c := range cases {
+				g.Assert(c.rules.Allowed(c.command)).Equal(c.allowed, c.name)
+			}
+		})
+	})
+
+	g.Describe("matchesCommandRule", func() {
+		g.It("matches rules against a single command", func() {
+			cases := []struct {
+				rules   []string
+				command string
+				invalid bool
+				matches bool
+			}{
+				{[]string{"kick"}, "kick", false, true},
+				{[]string{"kick"}, "kick player", false, true},
+				{[]string{"kick"}, "kicks", false, false},
+				{[]string{"kick"}, "kic", false, false},
+				{[]string{"KICK"}, "kick player", false, true},
+				{[]string{"ban", "kick"}, "kick player", false, true},
+				{[]string{"regex:player$"}, "kick player", false, true},
+				{[]string{"regex:["}, "kick player", false, false},
+				{[]string{"regex:["}, "kick player", true, true},
+				{nil, "kick", true, false},
+			}
+			for _, c := range cases {
+				g.Assert(matchesCommandRule(c.rules, c.command, c.invalid)).Equal(c.matches, c.command)
+			}
+		})
+	})
+}
@@ -16,10 +16,21 @@ func (p *FilePayload) GetPayload() *jwt.Payload {
 	return &p.Payload
 }
 
+func (p *FilePayload) subject() (string, string) {
+	return "", p.ServerUuid
+}
+
 // Determines if this JWT is valid for the given request cycle. If the
 // unique ID passed in the token has already been seen before this will
 // return false. This allows us to use this JWT as a one-time token that
 // validates all of the request.
 func (p *FilePayload) IsUniqueRequest() bool {
-	return getTokenStore().IsValidToken(p.UniqueId)
+	return getTokenStore().IsValidToken(p.UniqueId, p.ExpirationTime)
+}
+
+// Determines if this JWT is valid for a download requested by the given client.
+// The token is consumed by the first request, after which it can only be used by
+// the same client to resume the download.
+func (p *FilePayload) IsDownloadRequest(client string, resume bool) bool {
+	return getTokenStore().IsValidDownload(p.UniqueId, p.ExpirationTime, client, resume)
 }
@@ -0,0 +1,61 @@
+package tokens
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// NodeWebsocketPayload defines the JWT payload for a node websocket connection,
+// which allows a single connection to receive events from multiple servers on
+// the node. Permissions are granted per server, or for every server on the node
+// if the token has the admin scope.
+type NodeWebsocketPayload struct {
+	jwt.Payload
+	sync.RWMutex
+
+	UserUUID string `json:"user_uuid"`
+	// Servers maps the UUID of each server the token can access to the
+	// permissions the token has for that server.
+	Servers map[string][]string `json:"servers"`
+	// Admin grants every permission for every server on the node.
+	Admin bool `json:"admin"`
+}
+
+// Returns the JWT payload.
+func (p *NodeWebsocketPayload) GetPayload() *jwt.Payload {
+	p.RLock()
+	defer p.RUnlock()
+
+	return &p.Payload
+}
+
+// Check if the JWT has been marked as denied by the instance, see
+// WebsocketPayload.Denylisted for more details.
+func (p *NodeWebsocketPayload) Denylisted() bool {
+	return denylisted(&p.Payload, p.UserUUID, "")
+}
+
+func (p *NodeWebsocketPayload) subject() (string, string) {
+	return p.UserUUID, ""
+}
+
+// Checks if the token has a permission for the given server. The permission is
+// denied if the tokens for the server have been revoked, as well as if the token
+// itself or the tokens for the user have been.
+func (p *NodeWebsocketPayload) HasPermission(server string, permission string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.Admin {
+		return !denylisted(&p.Payload, p.UserUUID, server)
+	}
+	for _, k := range p.Servers[server] {
+		if k == permission || (!strings.HasPrefix(permission, "admin") && k == "*") {
+			return !denylisted(&p.Payload, p.UserUUID, server)
+		}
+	}
+
+	return false
+}
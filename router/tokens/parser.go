@@ -1,18 +1,26 @@
 package tokens
 
 import (
+	"strings"
 	"time"
 
+	"emperror.dev/errors"
 	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gbrlsnchs/jwt/v3/jwtutil"
 
 	"github.com/pterodactyl/wings/config"
 )
 
+var (
+	ErrUnknownKey          = errors.New("jwt: token signed with an unknown key")
+	ErrAlgorithmNotAllowed = errors.New("jwt: token signing algorithm is not allowed")
+)
+
 type TokenData interface {
 	GetPayload() *jwt.Payload
 }
 
-// Validates the provided JWT against the known secret for the Daemon and returns the
+// Validates the provided JWT against the known secret or public keys for the Daemon and returns the
 // parsed data. This function DOES NOT validate that the token is valid for the connected
 // server, nor does it ensure that the user providing the token is able to actually do things.
 //
@@ -23,7 +31,45 @@ func ParseToken(token []byte, data TokenData) error {
 		jwt.ExpirationTimeValidator(time.Now()),
 	)
 
-	_, err := jwt.Verify(token, config.GetJwtAlgorithm(), &data, verifyOptions)
+	// The algorithm in the header of the token must match the algorithm of the key
+	// it is verified with, otherwise a token could be signed using HMAC with one of
+	// the public keys as the secret.
+	alg := &jwtutil.Resolver{New: algorithm}
+	if _, err := jwt.Verify(token, alg, &data, jwt.ValidateHeader, verifyOptions); err != nil {
+		return err
+	}
+	if isRevoked(data) {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// algorithm returns the algorithm used to verify a token with the given header.
+// Tokens signed using HMAC are verified with the authentication token of the
+// node, and any other tokens with the public key matching their key ID.
+func algorithm(hd jwt.Header) (jwt.Algorithm, error) {
+	mode := config.Get().Tokens.Mode
+	if strings.HasPrefix(hd.Algorithm, "HS") {
+		if mode == config.TokenModeAsymmetric {
+			return nil, ErrAlgorithmNotAllowed
+		}
+		return config.GetJwtAlgorithm(), nil
+	}
+	if mode != config.TokenModeAsymmetric && mode != config.TokenModeAny {
+		return nil, ErrAlgorithmNotAllowed
+	}
 
-	return err
+	keys := config.GetJwtKeys()
+	if alg, ok := keys[hd.KeyID]; ok {
+		return alg, nil
+	}
+	// Tokens without a key ID are allowed as long as there is only one key they
+	// could have been signed with.
+	if hd.KeyID == "" && len(keys) == 1 {
+		for _, alg := range keys {
+			return alg, nil
+		}
+	}
+	return nil, ErrUnknownKey
 }
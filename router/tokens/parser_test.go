@@ -0,0 +1,152 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/gbrlsnchs/jwt/v3"
+
+	"github.com/pterodactyl/wings/config"
+)
+
+func publicKeyPem(pub crypto.PublicKey) string {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func TestParseToken(t *testing.T) {
+	g := Goblin(t)
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	hmac := jwt.NewHS256([]byte("node-token"))
+	ed := jwt.NewEd25519(jwt.Ed25519PrivateKey(edPriv))
+	other := jwt.NewEd25519(jwt.Ed25519PrivateKey(otherPriv))
+	rs256 := jwt.NewRS256(jwt.RSAPrivateKey(rsaPriv))
+	ps256 := jwt.NewPS256(jwt.RSAPrivateKey(rsaPriv))
+
+	configure := func(mode string, keys ...config.TokenPublicKey) {
+		cfg, err := config.NewAtPath("")
+		if err != nil {
+			panic(err)
+		}
+		cfg.AuthenticationToken = "node-token"
+		cfg.Tokens.Mode = mode
+		cfg.Tokens.PublicKeys = keys
+		config.Set(cfg)
+	}
+	edKey := config.TokenPublicKey{ID: "ed", Key: publicKeyPem(edPub)}
+	rsaKey := config.TokenPublicKey{ID: "rsa", Key: publicKeyPem(&rsaPriv.PublicKey)}
+	psKey := config.TokenPublicKey{ID: "ps", Algorithm: "PS256", Key: publicKeyPem(&rsaPriv.PublicKey)}
+
+	sign := func(alg jwt.Algorithm, kid string) []byte {
+		payload := FilePayload{
+			Payload: jwt.Payload{
+				ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:       jwt.NumericDate(time.Now()),
+			},
+			ServerUuid: "server",
+		}
+		var opts []jwt.SignOption
+		if kid != "" {
+			opts = append(opts, jwt.KeyID(kid))
+		}
+		token, err := jwt.Sign(payload, alg, opts...)
+		if err != nil {
+			panic(err)
+		}
+		return token
+	}
+	parse := func(token []byte) error {
+		return ParseToken(token, &FilePayload{})
+	}
+
+	g.Describe("ParseToken", func() {
+		g.It("accepts tokens signed with the node token in hmac mode", func() {
+			configure(config.TokenModeHMAC)
+			g.Assert(parse(sign(hmac, ""))).IsNil()
+		})
+
+		g.It("rejects asymmetric tokens in hmac mode", func() {
+			configure(config.TokenModeHMAC, edKey)
+			g.Assert(errors.Is(parse(sign(ed, "ed")), ErrAlgorithmNotAllowed)).IsTrue()
+		})
+
+		g.It("rejects hmac tokens in asymmetric mode", func() {
+			configure(config.TokenModeAsymmetric, edKey)
+			g.Assert(errors.Is(parse(sign(hmac, "")), ErrAlgorithmNotAllowed)).IsTrue()
+		})
+
+		g.It("accepts tokens signed with each type of key", func() {
+			configure(config.TokenModeAsymmetric, edKey, rsaKey, psKey)
+			g.Assert(parse(sign(ed, "ed"))).IsNil()
+			g.Assert(parse(sign(rs256, "rsa"))).IsNil()
+			g.Assert(parse(sign(ps256, "ps"))).IsNil()
+		})
+
+		g.It("accepts both types of token in any mode", func() {
+			configure(config.TokenModeAny, edKey)
+			g.Assert(parse(sign(hmac, ""))).IsNil()
+			g.Assert(parse(sign(ed, "ed"))).IsNil()
+		})
+
+		g.It("rejects tokens with an unknown key ID", func() {
+			configure(config.TokenModeAsymmetric, edKey)
+			g.Assert(errors.Is(parse(sign(ed, "missing")), ErrUnknownKey)).IsTrue()
+		})
+
+		g.It("accepts tokens without a key ID if there is only one key", func() {
+			configure(config.TokenModeAsymmetric, edKey)
+			g.Assert(parse(sign(ed, ""))).IsNil()
+		})
+
+		g.It("rejects tokens without a key ID if there are multiple keys", func() {
+			configure(config.TokenModeAsymmetric, edKey, rsaKey)
+			g.Assert(errors.Is(parse(sign(ed, "")), ErrUnknownKey)).IsTrue()
+		})
+
+		g.It("rejects tokens signed with a different private key", func() {
+			configure(config.TokenModeAsymmetric, edKey)
+			g.Assert(parse(sign(other, "ed"))).IsNotNil()
+		})
+
+		g.It("rejects tokens signed with a different algorithm to the key", func() {
+			configure(config.TokenModeAsymmetric, edKey, rsaKey, psKey)
+			g.Assert(errors.Is(parse(sign(rs256, "ps")), jwt.ErrAlgValidation)).IsTrue()
+			g.Assert(errors.Is(parse(sign(ed, "rsa")), jwt.ErrAlgValidation)).IsTrue()
+		})
+
+		g.It("rejects hmac tokens signed using a public key", func() {
+			configure(config.TokenModeAny, edKey)
+			forged := jwt.NewHS256([]byte(edKey.Key))
+			g.Assert(parse(sign(forged, "ed"))).IsNotNil()
+		})
+
+		g.It("rejects every asymmetric token if the keys cannot be loaded", func() {
+			configure(config.TokenModeAsymmetric, config.TokenPublicKey{ID: "ed", Key: "invalid"})
+			g.Assert(errors.Is(parse(sign(ed, "ed")), ErrUnknownKey)).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,159 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/gbrlsnchs/jwt/v3"
+	"gorm.io/gorm/clause"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
+)
+
+// The types of revocation, which deny tokens with a JTI, or that were issued
+// for a user or server.
+const (
+	RevokeJTI    = "jti"
+	RevokeUser   = "user"
+	RevokeServer = "server"
+)
+
+var (
+	ErrTokenRevoked          = errors.New("jwt: token has been revoked")
+	ErrInvalidRevocationType = errors.New("tokens: invalid revocation type")
+)
+
+// A map of every revocation stored in the database, by type and value. Any
+// token issued before the time the revocation was made for the revoked value is
+// denied.
+var revocations sync.Map
+
+type revocationKey struct {
+	kind  string
+	value string
+}
+
+type revocation struct {
+	revokedAt time.Time
+	expiresAt time.Time
+}
+
+// subject is implemented by tokens that are issued for a user or server, so
+// that they can be denied when all the tokens for the user or server are.
+type subject interface {
+	subject() (user string, server string)
+}
+
+// LoadRevocations loads the revocations stored in the database so that they
+// continue to apply after Wings is restarted.
+func LoadRevocations(ctx context.Context) error {
+	var rows []models.TokenRevocation
+	if tx := database.Instance().WithContext(ctx).Where("expires_at > ?", time.Now()).Find(&rows); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	for _, r := range rows {
+		revocations.Store(revocationKey{r.Type, r.Value}, revocation{r.RevokedAt, r.ExpiresAt})
+	}
+	return nil
+}
+
+// Revoke denies any token issued before the current time that has the JTI, or
+// that was issued for the user or server, depending on the type of revocation.
+// The revocation is stored in the database so that it still applies after Wings
+// is restarted.
+func Revoke(ctx context.Context, kind string, value string) (*models.TokenRevocation, error) {
+	switch kind {
+	case RevokeJTI, RevokeUser, RevokeServer:
+	default:
+		return nil, ErrInvalidRevocationType
+	}
+	log.WithField("type", kind).WithField("value", value).Debug("revoking tokens")
+
+	now := time.Now()
+	r := models.TokenRevocation{
+		Type:      kind,
+		Value:     value,
+		RevokedAt: now,
+		ExpiresAt: now.Add(time.Duration(config.Get().System.TokenRevocationRetention) * time.Second),
+	}
+	tx := database.Instance().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "type"}, {Name: "value"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_at", "expires_at"}),
+	}).Create(&r)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	revocations.Store(revocationKey{kind, value}, revocation{r.RevokedAt, r.ExpiresAt})
+	return &r, nil
+}
+
+// Revocations returns the revocations that have not yet expired, optionally
+// only those of the given type and value.
+func Revocations(ctx context.Context, kind string, value string) ([]models.TokenRevocation, error) {
+	tx := database.Instance().WithContext(ctx).Where("expires_at > ?", time.Now())
+	if kind != "" {
+		tx = tx.Where("type = ?", kind)
+	}
+	if value != "" {
+		tx = tx.Where("value = ?", value)
+	}
+	rows := []models.TokenRevocation{}
+	if tx = tx.Order("revoked_at DESC").Find(&rows); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return rows, nil
+}
+
+// PruneRevocations removes any revocations and used single use tokens that
+// have expired from the database.
+func PruneRevocations(ctx context.Context) error {
+	now := time.Now()
+	revocations.Range(func(k, v any) bool {
+		if !v.(revocation).expiresAt.After(now) {
+			revocations.Delete(k)
+		}
+		return true
+	})
+	if tx := database.Instance().WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.TokenRevocation{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	if tx := database.Instance().WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.UsedToken{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// revoked returns true if the token has been revoked, either by its JTI or for
+// the user or server it was issued for.
+func revoked(p *jwt.Payload, user string, server string) bool {
+	keys := []revocationKey{{RevokeJTI, p.JWTID}, {RevokeUser, user}, {RevokeServer, server}}
+	for _, k := range keys {
+		if k.value == "" {
+			continue
+		}
+		v, ok := revocations.Load(k)
+		if !ok {
+			continue
+		}
+		// Tokens without an issued time cannot be compared against the time of the
+		// revocation, so they are always denied.
+		if p.IssuedAt == nil || p.IssuedAt.Time.Before(v.(revocation).revokedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRevoked returns true if the token has been revoked.
+func isRevoked(data TokenData) bool {
+	var user, server string
+	if s, ok := data.(subject); ok {
+		user, server = s.subject()
+	}
+	return revoked(data.GetPayload(), user, server)
+}
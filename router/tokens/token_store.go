@@ -4,23 +4,35 @@ import (
 	"sync"
 	"time"
 
+	"github.com/apex/log"
+	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm/clause"
+
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
 )
 
+// How long a download can be resumed for after its token was first used.
+const resumeWindow = time.Minute * 10
+
 type TokenStore struct {
 	sync.Mutex
-	cache *cache.Cache
+	cache   *cache.Cache
+	resumes *cache.Cache
 }
 
 var _tokens *TokenStore
 
 // Returns the global unique token store cache. This is used to validate
 // one time token usage by storing any received tokens in a local memory
-// cache until they are ready to expire.
+// cache until they are ready to expire. The tokens are also stored in the
+// database so that they cannot be used again after Wings is restarted.
 func getTokenStore() *TokenStore {
 	if _tokens == nil {
 		_tokens = &TokenStore{
-			cache: cache.New(time.Minute*60, time.Minute*5),
+			cache:   cache.New(time.Minute*60, time.Minute*5),
+			resumes: cache.New(resumeWindow, time.Minute*5),
 		}
 	}
 
@@ -28,15 +40,45 @@ func getTokenStore() *TokenStore {
 }
 
 // Checks if a token is valid or not.
-func (t *TokenStore) IsValidToken(token string) bool {
+func (t *TokenStore) IsValidToken(token string, expires *jwt.Time) bool {
 	t.Lock()
 	defer t.Unlock()
 
-	_, exists := t.cache.Get(token)
+	if _, exists := t.cache.Get(token); exists {
+		return false
+	}
+	t.cache.Add(token, "", time.Minute*60)
 
-	if !exists {
-		t.cache.Add(token, "", time.Minute*60)
+	// Tokens without an expiry are kept for as long as they would be in the cache.
+	used := models.UsedToken{ID: token, ExpiresAt: time.Now().Add(time.Minute * 60)}
+	if expires != nil {
+		used.ExpiresAt = expires.Time
+	}
+	tx := database.Instance().Clauses(clause.OnConflict{DoNothing: true}).Create(&used)
+	if tx.Error != nil {
+		log.WithField("error", tx.Error).Error("tokens: failed to store used token")
+		return false
 	}
 
-	return !exists
+	// If nothing was created the token was already used before Wings was restarted.
+	return tx.RowsAffected > 0
+}
+
+// Checks if a download token is valid for a request from the given client. The
+// first request consumes the token, after which the token can only be used by the
+// same client to resume the download, within a short time of the first request.
+func (t *TokenStore) IsValidDownload(token string, expires *jwt.Time, client string, resume bool) bool {
+	key := token + "\x00" + client
+	if resume {
+		if _, ok := t.resumes.Get(key); ok {
+			return true
+		}
+	}
+	if !t.IsValidToken(token, expires) {
+		return false
+	}
+	// The window starts when the token is first used and is never extended, so a
+	// token cannot be kept alive by continuing to resume the download.
+	t.resumes.Set(key, "", resumeWindow)
+	return true
 }
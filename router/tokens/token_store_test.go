@@ -0,0 +1,81 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/gbrlsnchs/jwt/v3"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+)
+
+func TestTokenStore(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.RootDirectory = t.TempDir()
+	config.Set(cfg)
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+
+	expires := jwt.NumericDate(time.Now().Add(time.Hour))
+
+	g.Describe("TokenStore", func() {
+		g.It("only allows a token to be used once", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidToken("unique-once", expires)).IsTrue()
+			g.Assert(s.IsValidToken("unique-once", expires)).IsFalse()
+		})
+
+		g.It("allows the first client to resume a download", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-resume", expires, "10.0.0.1", false)).IsTrue()
+			g.Assert(s.IsValidDownload("unique-resume", expires, "10.0.0.1", true)).IsTrue()
+			g.Assert(s.IsValidDownload("unique-resume", expires, "10.0.0.1", true)).IsTrue()
+		})
+
+		g.It("consumes the token on a first range request", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-range", expires, "10.0.0.1", true)).IsTrue()
+			g.Assert(s.IsValidDownload("unique-range", expires, "10.0.0.1", false)).IsFalse()
+			g.Assert(s.IsValidDownload("unique-range", expires, "10.0.0.1", true)).IsTrue()
+		})
+
+		g.It("does not allow a used token to be downloaded again", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-again", expires, "10.0.0.1", false)).IsTrue()
+			g.Assert(s.IsValidDownload("unique-again", expires, "10.0.0.1", false)).IsFalse()
+		})
+
+		g.It("does not allow other clients to resume a download", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-client", expires, "10.0.0.1", false)).IsTrue()
+			g.Assert(s.IsValidDownload("unique-client", expires, "10.0.0.2", true)).IsFalse()
+			g.Assert(s.IsValidDownload("unique-client", expires, "10.0.0.2", false)).IsFalse()
+		})
+
+		g.It("does not extend the window when a download is resumed", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-window", expires, "10.0.0.1", false)).IsTrue()
+			key := "unique-window\x00" + "10.0.0.1"
+			expiration := s.resumes.Items()[key].Expiration
+			time.Sleep(time.Millisecond)
+			g.Assert(s.IsValidDownload("unique-window", expires, "10.0.0.1", true)).IsTrue()
+			g.Assert(s.resumes.Items()[key].Expiration).Equal(expiration)
+		})
+
+		g.It("does not allow a download to be resumed once the window has passed", func() {
+			s := getTokenStore()
+			g.Assert(s.IsValidDownload("unique-expired", expires, "10.0.0.1", false)).IsTrue()
+			s.resumes.Delete("unique-expired\x00" + "10.0.0.1")
+			g.Assert(s.IsValidDownload("unique-expired", expires, "10.0.0.1", true)).IsFalse()
+		})
+	})
+}
@@ -17,10 +17,14 @@ func (p *UploadPayload) GetPayload() *jwt.Payload {
 	return &p.Payload
 }
 
+func (p *UploadPayload) subject() (string, string) {
+	return p.UserUuid, p.ServerUuid
+}
+
 // Determines if this JWT is valid for the given request cycle. If the
 // unique ID passed in the token has already been seen before this will
 // return false. This allows us to use this JWT as a one-time token that
 // validates all of the request.
 func (p *UploadPayload) IsUniqueRequest() bool {
-	return getTokenStore().IsValidToken(p.UniqueId)
+	return getTokenStore().IsValidToken(p.UniqueId, p.ExpirationTime)
 }
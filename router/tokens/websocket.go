@@ -5,7 +5,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/apex/log"
 	"github.com/gbrlsnchs/jwt/v3"
 )
 
@@ -17,32 +16,16 @@ import (
 // reboot just needs to request a new token as if their old token had expired naturally.
 var wingsBootTime = time.Now()
 
-// A map that contains any JTI's that have been denied by the Panel and the time at which
-// they were marked as denied. Therefore any JWT with the same JTI and an IssuedTime that
-// is the same as or before this time should be considered invalid.
-//
-// This is used to allow the Panel to revoke tokens en-masse for a given user & server
-// combination since the JTI for tokens is just MD5(user.id + server.uuid). When a server
-// is booted this listing is fetched from the panel and the Websocket is dynamically updated.
-var denylist sync.Map
-
-// Adds a JTI to the denylist by marking any JWTs generated before the current time as
-// being invalid if they use the same JTI.
-func DenyJTI(jti string) {
-	log.WithField("jti", jti).Debugf("adding \"%s\" to JTI denylist", jti)
-
-	denylist.Store(jti, time.Now())
-}
-
 // WebsocketPayload defines the JWT payload for a websocket connection. This JWT is passed along to
 // the websocket after it has been connected to by sending an "auth" event.
 type WebsocketPayload struct {
 	jwt.Payload
 	sync.RWMutex
 
-	UserUUID    string   `json:"user_uuid"`
-	ServerUUID  string   `json:"server_uuid"`
-	Permissions []string `json:"permissions"`
+	UserUUID     string        `json:"user_uuid"`
+	ServerUUID   string        `json:"server_uuid"`
+	Permissions  []string      `json:"permissions"`
+	CommandRules *CommandRules `json:"command_rules,omitempty"`
 }
 
 // Returns the JWT payload.
@@ -61,10 +44,27 @@ func (p *WebsocketPayload) GetServerUuid() string {
 	return p.ServerUUID
 }
 
+// CommandAllowed returns true if the command rules of the token allow the
+// command to be sent to the server. Tokens without any rules allow every command.
+func (p *WebsocketPayload) CommandAllowed(command string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.CommandRules.Allowed(command)
+}
+
 // Check if the JWT has been marked as denied by the instance due to either being issued
-// before Wings was booted, or because we have denied all tokens with the same JTI
-// occurring before a set time.
+// before Wings was booted, or because we have denied all tokens with the same JTI, user
+// or server occurring before a set time.
 func (p *WebsocketPayload) Denylisted() bool {
+	return denylisted(&p.Payload, p.UserUUID, p.ServerUUID)
+}
+
+func (p *WebsocketPayload) subject() (string, string) {
+	return p.UserUUID, p.ServerUUID
+}
+
+func denylisted(p *jwt.Payload, user string, server string) bool {
 	// If there is no IssuedAt present for the token, we cannot validate the token so
 	// just immediately mark it as not valid.
 	if p.IssuedAt == nil {
@@ -78,14 +78,8 @@ func (p *WebsocketPayload) Denylisted() bool {
 	}
 
 	// Finally, if the token was issued before a time that is currently denied for this
-	// token instance, ignore the permissions response.
-	if t, ok := denylist.Load(p.JWTID); ok {
-		if p.IssuedAt.Time.Before(t.(time.Time)) {
-			return true
-		}
-	}
-
-	return false
+	// token instance, the user, or the server, ignore the permissions response.
+	return revoked(p, user, server)
 }
 
 // Checks if the given token payload has a permission string.
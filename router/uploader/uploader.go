@@ -0,0 +1,309 @@
+package uploader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+)
+
+const (
+	ErrOffsetMismatch = errors.Sentinel("uploader: offset does not match the current upload offset")
+	ErrUploadLocked   = errors.Sentinel("uploader: upload is already being written to")
+	ErrUploadTooLarge = errors.Sentinel("uploader: upload is larger than the maximum upload size")
+	ErrIncomplete     = errors.Sentinel("uploader: upload has not been completed")
+)
+
+var instance = &Uploader{
+	uploads: make(map[string]*Upload),
+}
+
+// Upload is a resumable upload of a single file to a server. The uploaded data
+// is stored in a partial file outside the server's data directory until all of
+// it has been received, at which point it is written into the server filesystem.
+type Upload struct {
+	Identifier string    `json:"id"`
+	ServerID   string    `json:"server_id"`
+	UserID     string    `json:"user_id"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Offset     int64     `json:"offset"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	mu      sync.Mutex
+	writing sync.Mutex
+}
+
+// New creates a new upload for a file of the given size, which is written to the
+// path in the server's filesystem once it has been completed. The full size of the
+// upload is reserved against the server's disk limit until the upload is completed
+// or removed.
+func New(s *server.Server, user string, p string, size int64) (*Upload, error) {
+	if max := config.Get().Api.ResumableUploads.MaxSize; max > 0 && size > max*1024*1024 {
+		return nil, ErrUploadTooLarge
+	}
+	if err := s.Filesystem().IsIgnored(p); err != nil {
+		return nil, err
+	}
+
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	if err := s.Filesystem().HasSpaceFor(size + instance.reserved(s.ID())); err != nil {
+		return nil, err
+	}
+	u := &Upload{
+		Identifier: uuid.Must(uuid.NewRandom()).String(),
+		ServerID:   s.ID(),
+		UserID:     user,
+		Path:       p,
+		Size:       size,
+		ExpiresAt:  expiry(),
+	}
+	f, err := os.OpenFile(u.partPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "uploader: failed to create upload file")
+	}
+	_ = f.Close()
+	if err := u.save(); err != nil {
+		_ = os.Remove(u.partPath())
+		return nil, err
+	}
+	instance.uploads[u.Identifier] = u
+	return u, nil
+}
+
+// ByID returns the upload matching the given identifier, or nil if there is no
+// upload or it has expired.
+func ByID(id string) *Upload {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	u, ok := instance.uploads[id]
+	if !ok || u.Expired() {
+		return nil
+	}
+	return u
+}
+
+// ByServer returns all the uploads in progress for the given server.
+func ByServer(sid string) []*Upload {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	var out []*Upload
+	for _, u := range instance.uploads {
+		if u.ServerID == sid {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// Load loads any incomplete uploads from the disk so that they can be resumed
+// after Wings has been restarted. Expired uploads are removed.
+func Load() error {
+	dir := config.Get().System.UploadDirectory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "uploader: failed to read upload directory")
+	}
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return errors.Wrap(err, "uploader: failed to read upload metadata")
+		}
+		u := &Upload{}
+		if err := json.Unmarshal(b, u); err != nil || u.Identifier+".json" != e.Name() {
+			log.WithField("file", e.Name()).Warn("uploader: removing invalid upload metadata file")
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		if u.Expired() {
+			u.removeFiles()
+			continue
+		}
+		instance.uploads[u.Identifier] = u
+	}
+	return nil
+}
+
+// Expire removes any uploads that have not received any data within the
+// configured expiration time.
+func Expire() {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	for id, u := range instance.uploads {
+		if u.Expired() {
+			log.WithFields(log.Fields{"upload_id": id, "server": u.ServerID}).Debug("uploader: removing expired upload")
+			u.removeFiles()
+			delete(instance.uploads, id)
+		}
+	}
+}
+
+// Expired returns true if the upload has not been written to within the
+// configured expiration time.
+func (u *Upload) Expired() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return time.Now().After(u.ExpiresAt)
+}
+
+// BelongsTo checks if the given upload belongs to the provided server.
+func (u *Upload) BelongsTo(s *server.Server) bool {
+	return u.ServerID == s.ID()
+}
+
+// CurrentOffset returns the number of bytes that have been received so far.
+func (u *Upload) CurrentOffset() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Offset
+}
+
+// Expiration returns the time at which the upload will be removed if no more
+// data is received.
+func (u *Upload) Expiration() time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.ExpiresAt
+}
+
+// Complete returns true if all the data for the upload has been received.
+func (u *Upload) Complete() bool {
+	return u.CurrentOffset() == u.Size
+}
+
+// Write appends data from the reader to the upload, starting at the given offset
+// which must match the current offset of the upload. Any data received is kept,
+// even if an error is returned, so that the client can resume from that point.
+// Only one write to an upload can happen at a time.
+func (u *Upload) Write(r io.Reader, offset int64) (int64, error) {
+	if !u.writing.TryLock() {
+		return 0, ErrUploadLocked
+	}
+	defer u.writing.Unlock()
+	if offset != u.CurrentOffset() {
+		return 0, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(u.partPath(), os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, errors.Wrap(err, "uploader: failed to open upload file")
+	}
+	defer f.Close()
+	// Truncate the file to the expected offset in case a previous write failed
+	// after writing some data to the disk but before the offset was saved.
+	if err := f.Truncate(offset); err != nil {
+		return 0, errors.Wrap(err, "uploader: failed to truncate upload file")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "uploader: failed to seek upload file")
+	}
+	n, err := io.Copy(f, io.LimitReader(r, u.Size-offset))
+	if serr := f.Sync(); serr != nil && err == nil {
+		err = serr
+	}
+
+	u.mu.Lock()
+	u.Offset += n
+	u.ExpiresAt = expiry()
+	u.mu.Unlock()
+	if serr := u.save(); serr != nil && err == nil {
+		err = serr
+	}
+	return n, errors.WithStackIf(err)
+}
+
+// Finish writes the completed upload into the server's filesystem and removes
+// the upload. The disk space reserved for the upload is released once this has
+// been called, even if writing the file fails.
+func (u *Upload) Finish(s *server.Server) error {
+	if !u.writing.TryLock() {
+		return ErrUploadLocked
+	}
+	defer u.writing.Unlock()
+	if !u.Complete() {
+		return ErrIncomplete
+	}
+	defer u.Remove()
+
+	f, err := os.Open(u.partPath())
+	if err != nil {
+		return errors.Wrap(err, "uploader: failed to open upload file")
+	}
+	defer f.Close()
+	return s.Filesystem().Write(u.Path, f, u.Size, 0o644)
+}
+
+// Remove removes the upload and any data that has been received for it.
+func (u *Upload) Remove() {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	u.removeFiles()
+	delete(instance.uploads, u.Identifier)
+}
+
+func (u *Upload) partPath() string {
+	return filepath.Join(config.Get().System.UploadDirectory, u.Identifier+".part")
+}
+
+func (u *Upload) metaPath() string {
+	return filepath.Join(config.Get().System.UploadDirectory, u.Identifier+".json")
+}
+
+// save writes the upload metadata to the disk alongside the partial file.
+func (u *Upload) save() error {
+	u.mu.Lock()
+	b, err := json.Marshal(u)
+	u.mu.Unlock()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp := u.metaPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return errors.Wrap(err, "uploader: failed to write upload metadata")
+	}
+	return errors.WithStack(os.Rename(tmp, u.metaPath()))
+}
+
+func (u *Upload) removeFiles() {
+	_ = os.Remove(u.partPath())
+	_ = os.Remove(u.metaPath())
+}
+
+func expiry() time.Time {
+	return time.Now().Add(time.Duration(config.Get().Api.ResumableUploads.Expiration) * time.Second)
+}
+
+// Uploader keeps track of all the resumable uploads in progress on this
+// instance.
+type Uploader struct {
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+// reserved returns the total size of all the uploads in progress for a server.
+// The caller must hold the lock.
+func (up *Uploader) reserved(sid string) int64 {
+	var total int64
+	for _, u := range up.uploads {
+		if u.ServerID == sid {
+			total += u.Size
+		}
+	}
+	return total
+}
@@ -0,0 +1,236 @@
+package uploader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+)
+
+// newTestServer returns a server with an empty data directory and the given disk
+// space limit in MB.
+func newTestServer(disk int64) *server.Server {
+	id := uuid.Must(uuid.NewRandom()).String()
+	if err := os.MkdirAll(filepath.Join(config.Get().System.Data, id), 0o755); err != nil {
+		panic(err)
+	}
+	s, err := server.NewEmptyManager(nil).InitServer(remote.ServerConfigurationResponse{
+		Settings: []byte(fmt.Sprintf(`{"uuid":"%s","build":{"disk_space":%d}}`, id, disk)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func readServerFile(s *server.Server, p string) string {
+	b, err := os.ReadFile(filepath.Join(s.Filesystem().Path(), p))
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return string(b)
+}
+
+func TestUploader(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.System.Data = t.TempDir()
+	cfg.System.UploadDirectory = t.TempDir()
+	config.Set(cfg)
+
+	g.Describe("Upload", func() {
+		g.AfterEach(func() {
+			for _, u := range instance.uploads {
+				u.Remove()
+			}
+		})
+
+		g.It("writes the data received to the server once complete", func() {
+			s := newTestServer(0)
+			u, err := New(s, "user", "/a.txt", 11)
+			g.Assert(err).IsNil()
+			g.Assert(ByID(u.Identifier)).Equal(u)
+			g.Assert(ByServer(s.ID())).Equal([]*Upload{u})
+
+			n, err := u.Write(strings.NewReader("hello"), 0)
+			g.Assert(err).IsNil()
+			g.Assert(n).Equal(int64(5))
+			g.Assert(u.Complete()).IsFalse()
+
+			// Any data past the end of the upload is ignored.
+			n, err = u.Write(strings.NewReader(" world and more"), 5)
+			g.Assert(err).IsNil()
+			g.Assert(n).Equal(int64(6))
+			g.Assert(u.Complete()).IsTrue()
+
+			g.Assert(u.Finish(s)).IsNil()
+			g.Assert(readServerFile(s, "a.txt")).Equal("hello world")
+			g.Assert(ByID(u.Identifier)).IsNil()
+			_, err = os.Stat(u.partPath())
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			_, err = os.Stat(u.metaPath())
+			g.Assert(os.IsNotExist(err)).IsTrue()
+		})
+
+		g.It("rejects data that does not start at the current offset", func() {
+			u, err := New(newTestServer(0), "user", "/a.txt", 10)
+			g.Assert(err).IsNil()
+			_, err = u.Write(strings.NewReader("hello"), 0)
+			g.Assert(err).IsNil()
+
+			for _, offset := range []int64{0, 4, 6, 10} {
+				n, err := u.Write(strings.NewReader("world"), offset)
+				g.Assert(err).Equal(ErrOffsetMismatch)
+				g.Assert(n).Equal(int64(0))
+			}
+			g.Assert(u.CurrentOffset()).Equal(int64(5))
+		})
+
+		g.It("does not finish an incomplete upload", func() {
+			s := newTestServer(0)
+			u, err := New(s, "user", "/a.txt", 10)
+			g.Assert(err).IsNil()
+			_, err = u.Write(strings.NewReader("hello"), 0)
+			g.Assert(err).IsNil()
+
+			g.Assert(u.Finish(s)).Equal(ErrIncomplete)
+			g.Assert(ByID(u.Identifier)).Equal(u)
+			_, err = os.Stat(filepath.Join(s.Filesystem().Path(), "a.txt"))
+			g.Assert(os.IsNotExist(err)).IsTrue()
+
+			// The upload can still be completed afterwards.
+			_, err = u.Write(strings.NewReader("world"), 5)
+			g.Assert(err).IsNil()
+			g.Assert(u.Finish(s)).IsNil()
+			g.Assert(readServerFile(s, "a.txt")).Equal("helloworld")
+		})
+
+		g.It("rejects uploads larger than the maximum size", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Api.ResumableUploads.MaxSize = 1
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.Api.ResumableUploads.MaxSize = 0
+			})
+			s := newTestServer(0)
+			_, err := New(s, "user", "/a.txt", 1024*1024+1)
+			g.Assert(err).Equal(ErrUploadTooLarge)
+			_, err = New(s, "user", "/a.txt", 1024*1024)
+			g.Assert(err).IsNil()
+		})
+
+		g.It("reserves disk space for the uploads in progress", func() {
+			s := newTestServer(1)
+			other := newTestServer(1)
+			a, err := New(s, "user", "/a.txt", 600*1024)
+			g.Assert(err).IsNil()
+
+			// The space reserved by the first upload leaves no room for another.
+			_, err = New(s, "user", "/b.txt", 600*1024)
+			g.Assert(err).IsNotNil()
+			_, err = New(other, "user", "/b.txt", 600*1024)
+			g.Assert(err).IsNil()
+
+			// Once the first upload is removed its space can be used again.
+			a.Remove()
+			b, err := New(s, "user", "/b.txt", 600*1024)
+			g.Assert(err).IsNil()
+			_, err = b.Write(strings.NewReader(strings.Repeat("a", 600*1024)), 0)
+			g.Assert(err).IsNil()
+			g.Assert(b.Finish(s)).IsNil()
+
+			// The finished file now takes up the space on the server instead.
+			_, err = New(s, "user", "/c.txt", 600*1024)
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("restores uploads from the saved metadata", func() {
+			s := newTestServer(0)
+			u, err := New(s, "user", "/a.txt", 10)
+			g.Assert(err).IsNil()
+			_, err = u.Write(strings.NewReader("hello"), 0)
+			g.Assert(err).IsNil()
+
+			expired, err := New(s, "user", "/b.txt", 10)
+			g.Assert(err).IsNil()
+			expired.ExpiresAt = time.Now().Add(-time.Second)
+			g.Assert(expired.save()).IsNil()
+
+			invalid := filepath.Join(config.Get().System.UploadDirectory, "invalid.json")
+			g.Assert(os.WriteFile(invalid, []byte("{}"), 0o600)).IsNil()
+
+			clear(instance.uploads)
+			g.Assert(Load()).IsNil()
+
+			restored := ByID(u.Identifier)
+			g.Assert(restored == nil).IsFalse()
+			g.Assert(restored == u).IsFalse()
+			g.Assert(restored.ServerID).Equal(s.ID())
+			g.Assert(restored.UserID).Equal("user")
+			g.Assert(restored.Path).Equal("/a.txt")
+			g.Assert(restored.CurrentOffset()).Equal(int64(5))
+			g.Assert(len(instance.uploads)).Equal(1)
+			_, err = os.Stat(expired.partPath())
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			_, err = os.Stat(invalid)
+			g.Assert(os.IsNotExist(err)).IsTrue()
+
+			_, err = restored.Write(strings.NewReader("world"), 5)
+			g.Assert(err).IsNil()
+			g.Assert(restored.Finish(s)).IsNil()
+			g.Assert(readServerFile(s, "a.txt")).Equal("helloworld")
+		})
+
+		g.It("removes uploads that have expired", func() {
+			s := newTestServer(0)
+			a, err := New(s, "user", "/a.txt", 10)
+			g.Assert(err).IsNil()
+			b, err := New(s, "user", "/b.txt", 10)
+			g.Assert(err).IsNil()
+
+			a.mu.Lock()
+			a.ExpiresAt = time.Now().Add(-time.Second)
+			a.mu.Unlock()
+			g.Assert(ByID(a.Identifier)).IsNil()
+
+			Expire()
+			g.Assert(ByServer(s.ID())).Equal([]*Upload{b})
+			_, err = os.Stat(a.partPath())
+			g.Assert(os.IsNotExist(err)).IsTrue()
+			_, err = os.Stat(b.partPath())
+			g.Assert(err).IsNil()
+		})
+
+		g.It("extends the expiration when data is received", func() {
+			config.Update(func(c *config.Configuration) {
+				c.Api.ResumableUploads.Expiration = 60
+			})
+			defer config.Update(func(c *config.Configuration) {
+				c.Api.ResumableUploads.Expiration = 86400
+			})
+			u, err := New(newTestServer(0), "user", "/a.txt", 10)
+			g.Assert(err).IsNil()
+			u.mu.Lock()
+			u.ExpiresAt = time.Now().Add(time.Second)
+			u.mu.Unlock()
+
+			_, err = u.Write(strings.NewReader("hello"), 0)
+			g.Assert(err).IsNil()
+			g.Assert(u.Expiration().After(time.Now().Add(59 * time.Second))).IsTrue()
+		})
+	})
+}
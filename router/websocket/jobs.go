@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"github.com/pterodactyl/wings/router/jobs"
+)
+
+// jobPermissions maps each type of job to the permission a user needs to
+// receive the status of jobs of that type.
+var jobPermissions = map[jobs.Type]string{
+	jobs.TypeCompress:   PermissionReadFiles,
+	jobs.TypeDecompress: PermissionReadFiles,
+	jobs.TypeBatch:      PermissionReadFiles,
+	jobs.TypeBackup:     PermissionReceiveBackups,
+	jobs.TypeRestore:    PermissionReceiveBackups,
+	jobs.TypeInstall:    PermissionReceiveInstall,
+	jobs.TypeReinstall:  PermissionReceiveInstall,
+	jobs.TypeTransfer:   PermissionReceiveTransfer,
+}
+
+// canReceiveJob returns true if the user connected to the socket is allowed to
+// receive the status of the job in the event data.
+func (h *Handler) canReceiveJob(data interface{}) bool {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	t, _ := m["type"].(string)
+	permission, ok := jobPermissions[jobs.Type(t)]
+	if !ok {
+		return false
+	}
+	j := h.GetJwt()
+	return j != nil && j.HasPermission(permission)
+}
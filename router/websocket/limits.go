@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/system"
+)
+
+// connectionLimits are the rate limits for messages sent over a single
+// websocket connection. A nil rate means there is no limit.
+type connectionLimits struct {
+	commands   *system.Rate
+	power      *system.Rate
+	violations *system.Rate
+}
+
+// userLimits are the rate limits for messages sent by a single user to a server
+// across all of their websocket connections.
+type userLimits struct {
+	commands *system.Rate
+	power    *system.Rate
+	used     time.Time
+}
+
+var users = struct {
+	sync.Mutex
+	limits map[string]*userLimits
+	swept  time.Time
+}{limits: make(map[string]*userLimits)}
+
+// newRate returns a rate limiter for the configured limit, or nil if the limit
+// is disabled.
+func newRate(limit uint64, period time.Duration) *system.Rate {
+	if limit == 0 {
+		return nil
+	}
+	return system.NewRate(limit, period)
+}
+
+func limitsPeriod(c config.WebsocketLimits) time.Duration {
+	return time.Duration(max(c.Period, 1)) * time.Second
+}
+
+func newConnectionLimits() connectionLimits {
+	c := config.Get().System.WebsocketLimits
+	period := limitsPeriod(c)
+	return connectionLimits{
+		commands:   newRate(c.CommandsPerConnection, period),
+		power:      newRate(c.PowerActionsPerConnection, period),
+		violations: newRate(c.MaxViolations, period),
+	}
+}
+
+// limitsForUser returns the rate limits for a user on a server. Limits that
+// have not been used for longer than the period are thrown away every so often
+// so the map does not keep growing.
+func limitsForUser(user, server string) *userLimits {
+	c := config.Get().System.WebsocketLimits
+	period := limitsPeriod(c)
+
+	users.Lock()
+	defer users.Unlock()
+	now := time.Now()
+	if now.Sub(users.swept) > period {
+		for k, l := range users.limits {
+			if now.Sub(l.used) > period {
+				delete(users.limits, k)
+			}
+		}
+		users.swept = now
+	}
+
+	key := user + "/" + server
+	l, ok := users.limits[key]
+	if !ok {
+		l = &userLimits{
+			commands: newRate(c.CommandsPerUser, period),
+			power:    newRate(c.PowerActionsPerUser, period),
+		}
+		users.limits[key] = l
+	}
+	l.used = now
+	return l
+}
+
+// setReadLimit limits the size of the messages that can be read from the
+// connection. Gorilla closes the connection if a larger message is received.
+func setReadLimit(conn *websocket.Conn) {
+	if n := config.Get().System.WebsocketLimits.MaxMessageSize; n > 0 {
+		conn.SetReadLimit(n)
+	}
+}
+
+// allow returns true if another message of the event type can be sent by the
+// connection and its user without exceeding the rate limits. If it cannot an
+// error is sent back to the client, and if the client keeps exceeding the
+// limits the connection is closed.
+func (h *Handler) allow(event string) bool {
+	var rates [2]*system.Rate
+	var user string
+	if j := h.GetJwt(); j != nil {
+		user = j.UserUUID
+	}
+	switch event {
+	case SendCommandEvent:
+		rates = [2]*system.Rate{h.limits.commands, limitsForUser(user, h.server.ID()).commands}
+	case SetStateEvent:
+		rates = [2]*system.Rate{h.limits.power, limitsForUser(user, h.server.ID()).power}
+	default:
+		return true
+	}
+
+	allowed := true
+	for _, r := range rates {
+		if r != nil && !r.Try() {
+			allowed = false
+			break
+		}
+	}
+	if allowed {
+		return true
+	}
+
+	if h.limits.violations != nil && !h.limits.violations.Try() {
+		h.Logger().WithField("event", event).Warn("closing websocket connection after repeatedly exceeding rate limits")
+		_ = h.Connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"), time.Now().Add(time.Second*5))
+		_ = h.Connection.Close()
+		return false
+	}
+
+	m, _ := h.GetErrorMessage("发送的请求过于频繁，请稍后重试")
+	_ = h.SendJson(Message{
+		Event: ErrorEvent,
+		Args:  []string{m},
+	})
+	return false
+}
@@ -78,6 +78,8 @@ var e = []string{
 	server.BackupRestoreCompletedEvent,
 	server.TransferLogsEvent,
 	server.TransferStatusEvent,
+	server.FileChangedEvent,
+	server.JobStatusEvent,
 }
 
 // ListenForServerEvents will listen for different events happening on a server
@@ -91,13 +93,20 @@ func (h *Handler) listenForServerEvents(ctx context.Context) error {
 	defer cancel()
 
 	eventChan := make(chan []byte)
-	logOutput := make(chan []byte, 8)
+	logOutput := make(chan system.Line, 8)
 	installOutput := make(chan []byte, 4)
 
 	h.server.Events().On(eventChan) // TODO: make a sinky
-	h.server.Sink(system.LogSink).On(logOutput)
+	h.server.Sink(system.LogSink).OnLine(logOutput)
 	h.server.Sink(system.InstallSink).On(installOutput)
 
+	// Stats are only sent as often as the client has asked for. If stats arrive
+	// before the interval has passed the latest stats are held until it has, so
+	// the client always ends up with the most recent stats.
+	var lastStats time.Time
+	var pendingStats *Message
+	var statsTimer <-chan time.Time
+
 	onError := func(evt string, err2 error) {
 		h.Logger().WithField("event", evt).WithField("error", err2).Error("failed to send event over server websocket")
 		// Avoid race conditions by only setting the error once and then canceling
@@ -113,13 +122,31 @@ func (h *Handler) listenForServerEvents(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			break
-		case b := <-logOutput:
-			sendErr := h.SendJson(Message{Event: server.ConsoleOutputEvent, Args: []string{string(b)}})
+		case <-statsTimer:
+			statsTimer = nil
+			if pendingStats == nil {
+				continue
+			}
+			lastStats = time.Now()
+			sendErr := h.SendJson(*pendingStats)
+			pendingStats = nil
+			if sendErr == nil {
+				continue
+			}
+			onError(server.StatsEvent, sendErr)
+		case l := <-logOutput:
+			if !h.wantsEvent(server.ConsoleOutputEvent) {
+				continue
+			}
+			sendErr := h.SendJson(Message{Event: server.ConsoleOutputEvent, Args: []string{string(l.Data)}, Sequence: l.Sequence})
 			if sendErr == nil {
 				continue
 			}
 			onError(server.ConsoleOutputEvent, sendErr)
 		case b := <-installOutput:
+			if !h.wantsEvent(server.InstallOutputEvent) {
+				continue
+			}
 			sendErr := h.SendJson(Message{Event: server.InstallOutputEvent, Args: []string{string(b)}})
 			if sendErr == nil {
 				continue
@@ -130,19 +157,30 @@ func (h *Handler) listenForServerEvents(ctx context.Context) error {
 			if err := events.DecodeTo(b, &e); err != nil {
 				continue
 			}
-			var sendErr error
+			if !h.wantsEvent(e.Topic) {
+				continue
+			}
+			// File changes are only sent for the directories the client is watching.
+			if e.Topic == server.FileChangedEvent && !h.shouldSendFileChange(e.Data) {
+				continue
+			}
+			// Jobs are only sent to users allowed to see the operation they perform.
+			if e.Topic == server.JobStatusEvent && !h.canReceiveJob(e.Data) {
+				continue
+			}
 			message := Message{Event: e.Topic}
-			if str, ok := e.Data.(string); ok {
-				message.Args = []string{str}
-			} else if b, ok := e.Data.([]byte); ok {
-				message.Args = []string{string(b)}
-			} else {
-				b, sendErr = json.Marshal(e.Data)
-				if sendErr == nil {
-					message.Args = []string{string(b)}
+			var sendErr error
+			message.Args, sendErr = eventArgs(e)
+			if sendErr == nil && e.Topic == server.StatsEvent {
+				if wait := h.statsWait(lastStats); wait > 0 {
+					pendingStats = &message
+					if statsTimer == nil {
+						statsTimer = time.After(wait)
+					}
+					continue
 				}
+				lastStats = time.Now()
 			}
-
 			if sendErr == nil {
 				sendErr = h.SendJson(message)
 				if sendErr == nil {
@@ -156,7 +194,7 @@ func (h *Handler) listenForServerEvents(ctx context.Context) error {
 
 	// These functions will automatically close the channel if it hasn't been already.
 	h.server.Events().Off(eventChan)
-	h.server.Sink(system.LogSink).Off(logOutput)
+	h.server.Sink(system.LogSink).OffLine(logOutput)
 	h.server.Sink(system.InstallSink).Off(installOutput)
 
 	// If the internal context is stopped it is either because the parent context
@@ -168,3 +206,19 @@ func (h *Handler) listenForServerEvents(ctx context.Context) error {
 
 	return nil
 }
+
+// eventArgs returns the arguments of the websocket message for an event that
+// was published on a server's event bus.
+func eventArgs(e events.Event) ([]string, error) {
+	if str, ok := e.Data.(string); ok {
+		return []string{str}, nil
+	}
+	if b, ok := e.Data.([]byte); ok {
+		return []string{string(b)}, nil
+	}
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(b)}, nil
+}
@@ -9,6 +9,11 @@ const (
 	SendServerLogsEvent        = "send logs"
 	SendCommandEvent           = "send command"
 	SendStatsEvent             = "send stats"
+	WatchDirectoryEvent        = "watch directory"
+	UnwatchDirectoryEvent      = "unwatch directory"
+	SubscribeEvent             = "subscribe"
+	UnsubscribeEvent           = "unsubscribe"
+	SubscribedEvent            = "subscribed"
 	ErrorEvent                 = "daemon error"
 	JwtErrorEvent              = "jwt error"
 )
@@ -20,4 +25,11 @@ type Message struct {
 	// The data to pass along, only used by power/command currently. Other requests
 	// should either omit the field or pass an empty value as it is ignored.
 	Args []string `json:"args,omitempty"`
+
+	// The server the event is for, only used by the node websocket.
+	Server string `json:"server,omitempty"`
+
+	// The sequence number of a console output line. Clients can send this back
+	// with a send logs event after reconnecting to receive the lines they missed.
+	Sequence uint64 `json:"sequence,omitempty"`
 }
@@ -0,0 +1,431 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/pterodactyl/wings/events"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/system"
+)
+
+// The types of events that can be subscribed to on the node websocket.
+const (
+	NodeEventStatus  = "status"
+	NodeEventStats   = "stats"
+	NodeEventConsole = "console"
+)
+
+var nodeEventTypes = []string{NodeEventStatus, NodeEventStats, NodeEventConsole}
+
+var ErrUnknownServer = errors.New("服务器不存在或无法访问")
+
+// NodeHandler is a websocket connection that receives events from any number
+// of servers on the node, rather than being bound to a single server. Clients
+// authenticate with a node token, and then subscribe to the event types they
+// want for each server they have access to.
+type NodeHandler struct {
+	sync.RWMutex `json:"-"`
+	Connection   *websocket.Conn `json:"-"`
+	jwt          *tokens.NodeWebsocketPayload
+	manager      *server.Manager
+	uuid         uuid.UUID
+
+	subsMu sync.Mutex
+	subs   map[string]*nodeSubscription
+	closed bool
+}
+
+// nodeSubscription is the set of event types a connection is receiving for a
+// single server.
+type nodeSubscription struct {
+	types  map[string]bool
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// NewNodeTokenPayload parses a JWT into a node websocket token payload.
+func NewNodeTokenPayload(token []byte) (*tokens.NodeWebsocketPayload, error) {
+	var payload tokens.NodeWebsocketPayload
+	if err := tokens.ParseToken(token, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.Denylisted() {
+		return nil, ErrJwtOnDenylist
+	}
+
+	if !payload.Admin && len(payload.Servers) == 0 {
+		return nil, ErrJwtNoConnectPerm
+	}
+
+	return &payload, nil
+}
+
+// GetNodeHandler returns a new node websocket handler for the request.
+func GetNodeHandler(m *server.Manager, w http.ResponseWriter, r *http.Request) (*NodeHandler, error) {
+	upgrader := newUpgrader()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	setReadLimit(conn)
+
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeHandler{
+		Connection: conn,
+		manager:    m,
+		uuid:       u,
+	}, nil
+}
+
+func (h *NodeHandler) Uuid() uuid.UUID {
+	return h.uuid
+}
+
+func (h *NodeHandler) Logger() *log.Entry {
+	return log.WithField("subsystem", "websocket").
+		WithField("connection", h.Uuid().String())
+}
+
+// GetJwt returns the JWT for the websocket in a race-safe manner.
+func (h *NodeHandler) GetJwt() *tokens.NodeWebsocketPayload {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.jwt
+}
+
+// TokenValid checks if the JWT is still valid.
+func (h *NodeHandler) TokenValid() error {
+	j := h.GetJwt()
+	if j == nil {
+		return ErrJwtNotPresent
+	}
+
+	if err := jwt.ExpirationTimeValidator(time.Now())(&j.Payload); err != nil {
+		return err
+	}
+
+	if j.Denylisted() {
+		return ErrJwtOnDenylist
+	}
+
+	return nil
+}
+
+// canAccess returns true if the token allows the connection to receive events
+// for the server.
+func (h *NodeHandler) canAccess(s string) bool {
+	j := h.GetJwt()
+	return j != nil && j.HasPermission(s, PermissionConnect)
+}
+
+// SendJson sends a message for a server over the websocket, as long as the
+// token is still valid and has access to the server.
+func (h *NodeHandler) SendJson(v Message) error {
+	if err := h.TokenValid(); err != nil {
+		_ = h.unsafeSendJson(Message{
+			Event: JwtErrorEvent,
+			Args:  []string{err.Error()},
+		})
+		return nil
+	}
+
+	if v.Server != "" && !h.canAccess(v.Server) {
+		return nil
+	}
+
+	if err := h.unsafeSendJson(v); err != nil {
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *NodeHandler) unsafeSendJson(v interface{}) error {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.Connection.WriteJSON(v)
+}
+
+// SendErrorJson sends an error back to the connected client. Only errors with
+// the token are sent as is, any other errors are logged and replaced with a
+// generic message.
+func (h *NodeHandler) SendErrorJson(msg Message, err error) error {
+	wsm := Message{Event: ErrorEvent, Server: msg.Server}
+	switch {
+	case IsJwtError(err):
+		wsm.Event = JwtErrorEvent
+		wsm.Args = []string{err.Error()}
+	case errors.Is(err, ErrUnknownServer):
+		wsm.Args = []string{err.Error()}
+	default:
+		m, u := h.GetErrorMessage("处理此请求时遇到意外错误")
+		h.Logger().WithFields(log.Fields{"event": msg.Event, "error_identifier": u.String(), "error": err}).
+			Error("error processing node websocket event")
+		wsm.Args = []string{m}
+	}
+	return h.unsafeSendJson(wsm)
+}
+
+// GetErrorMessage converts an error message into the same format used by the
+// server websocket, returning the UUID that identifies the error in the logs.
+func (h *NodeHandler) GetErrorMessage(msg string) (string, uuid.UUID) {
+	u := uuid.Must(uuid.NewRandom())
+
+	return fmt.Sprintf("错误事件 [%s]: %s", u.String(), msg), u
+}
+
+// HandleInbound handles an inbound socket request and routes it to the proper
+// action.
+func (h *NodeHandler) HandleInbound(ctx context.Context, m Message) error {
+	if m.Event != AuthenticationEvent {
+		if err := h.TokenValid(); err != nil {
+			_ = h.unsafeSendJson(Message{
+				Event: JwtErrorEvent,
+				Args:  []string{err.Error()},
+			})
+			return nil
+		}
+	}
+
+	switch m.Event {
+	case AuthenticationEvent:
+		token, err := NewNodeTokenPayload([]byte(strings.Join(m.Args, "")))
+		if err != nil {
+			return err
+		}
+
+		newConnection := h.GetJwt() == nil
+		h.Lock()
+		h.jwt = token
+		h.Unlock()
+		_ = h.unsafeSendJson(Message{Event: AuthenticationSuccessEvent})
+
+		if newConnection {
+			go h.listenForExpiration(ctx)
+		}
+		// The new token may not have access to every server the old token did.
+		h.subsMu.Lock()
+		for id, sub := range h.subs {
+			if !h.canAccess(id) {
+				sub.cancel()
+				delete(h.subs, id)
+			}
+		}
+		h.subsMu.Unlock()
+		return nil
+	case SubscribeEvent, UnsubscribeEvent:
+		// The server can either be sent with the message, in which case the arguments
+		// are the event types, or as the first argument followed by the event types.
+		id, types := m.Server, m.Args
+		if id == "" {
+			if len(m.Args) == 0 {
+				return nil
+			}
+			id, types = m.Args[0], m.Args[1:]
+		}
+		if m.Event == SubscribeEvent {
+			return h.subscribe(ctx, id, types)
+		}
+		h.unsubscribe(id, types)
+		return nil
+	}
+
+	return nil
+}
+
+// parseNodeEventTypes returns the event types that were requested, or every
+// event type if none were.
+func parseNodeEventTypes(types []string) map[string]bool {
+	out := make(map[string]bool)
+	for _, t := range types {
+		for _, v := range nodeEventTypes {
+			if t == v {
+				out[t] = true
+			}
+		}
+	}
+	if len(types) == 0 {
+		for _, v := range nodeEventTypes {
+			out[v] = true
+		}
+	}
+	return out
+}
+
+// subscribe starts sending events of the given types for a server, in addition
+// to any types already subscribed to.
+func (h *NodeHandler) subscribe(ctx context.Context, id string, types []string) error {
+	s, ok := h.manager.Get(id)
+	if !ok || !h.canAccess(id) {
+		return ErrUnknownServer
+	}
+
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+	if h.closed {
+		return nil
+	}
+
+	t := parseNodeEventTypes(types)
+	if sub, ok := h.subs[id]; ok {
+		for k := range sub.types {
+			t[k] = true
+		}
+		sub.cancel()
+	}
+	if len(t) == 0 {
+		return nil
+	}
+
+	if h.subs == nil {
+		h.subs = make(map[string]*nodeSubscription)
+	}
+	h.start(ctx, s, t)
+
+	if t[NodeEventStatus] {
+		_ = h.SendJson(Message{Event: server.StatusEvent, Server: id, Args: []string{s.Environment.State()}})
+	}
+	return nil
+}
+
+// unsubscribe stops sending events of the given types for a server, or every
+// event for the server if no types are provided.
+func (h *NodeHandler) unsubscribe(id string, types []string) {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	sub, ok := h.subs[id]
+	if !ok {
+		return
+	}
+	sub.cancel()
+	delete(h.subs, id)
+	if len(types) == 0 {
+		return
+	}
+
+	remove := parseNodeEventTypes(types)
+	t := make(map[string]bool)
+	for k := range sub.types {
+		if !remove[k] {
+			t[k] = true
+		}
+	}
+	if s, ok := h.manager.Get(id); ok && len(t) > 0 {
+		h.start(sub.parent, s, t)
+	}
+}
+
+// start begins sending the events of the given types for a server. The caller
+// must hold the subscriptions lock.
+func (h *NodeHandler) start(parent context.Context, s *server.Server, types map[string]bool) {
+	ctx, cancel := context.WithCancel(parent)
+	h.subs[s.ID()] = &nodeSubscription{types: types, parent: parent, cancel: cancel}
+	go h.listen(ctx, s, types)
+}
+
+// Close stops sending events for every server. This should be called once the
+// connection is closed.
+func (h *NodeHandler) Close() {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+	for _, sub := range h.subs {
+		sub.cancel()
+	}
+	h.subs = nil
+	h.closed = true
+}
+
+// listen sends the events of the given types for a server to the client until
+// the context is canceled or the server is deleted.
+func (h *NodeHandler) listen(ctx context.Context, s *server.Server, types map[string]bool) {
+	id := s.ID()
+	eventChan := make(chan []byte)
+	s.Events().On(eventChan)
+	defer s.Events().Off(eventChan)
+
+	// A nil channel is never ready, so console output is only received when it
+	// has been subscribed to.
+	var logOutput chan system.Line
+	if types[NodeEventConsole] {
+		logOutput = make(chan system.Line, 8)
+		s.Sink(system.LogSink).OnLine(logOutput)
+		defer s.Sink(system.LogSink).OffLine(logOutput)
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.Context().Done():
+			_ = h.SendJson(Message{Event: server.DeletedEvent, Server: id})
+			h.unsubscribe(id, nil)
+			return
+		case l := <-logOutput:
+			err = h.SendJson(Message{Event: server.ConsoleOutputEvent, Server: id, Args: []string{string(l.Data)}, Sequence: l.Sequence})
+		case b := <-eventChan:
+			var e events.Event
+			if err := events.DecodeTo(b, &e); err != nil {
+				continue
+			}
+			if (e.Topic == server.StatusEvent && !types[NodeEventStatus]) ||
+				(e.Topic == server.StatsEvent && !types[NodeEventStats]) ||
+				(e.Topic != server.StatusEvent && e.Topic != server.StatsEvent) {
+				continue
+			}
+			message := Message{Event: e.Topic, Server: id}
+			if message.Args, err = eventArgs(e); err == nil {
+				err = h.SendJson(message)
+			}
+		}
+		if err != nil {
+			h.Logger().WithField("server", id).WithField("error", err).Warn("failed to send event over node websocket; closing connection")
+			_ = h.Connection.Close()
+			return
+		}
+	}
+}
+
+// listenForExpiration sends a notice over the socket when the token is about
+// to expire, and once it has expired.
+func (h *NodeHandler) listenForExpiration(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if j := h.GetJwt(); j != nil {
+				if j.ExpirationTime.Unix()-time.Now().Unix() <= 0 {
+					_ = h.SendJson(Message{Event: TokenExpiredEvent})
+				} else if j.ExpirationTime.Unix()-time.Now().Unix() <= 60 {
+					_ = h.SendJson(Message{Event: TokenExpiringEvent})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/router/tokens"
+	"github.com/pterodactyl/wings/server"
+)
+
+// newTestNodeHandler returns a node websocket handler for the manager, along
+// with the client end of the connection.
+func newTestNodeHandler(m *server.Manager) (*NodeHandler, *websocket.Conn) {
+	handlers := make(chan *NodeHandler, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, err := GetNodeHandler(m, w, r)
+		if err != nil {
+			panic(err)
+		}
+		handlers <- h
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Origin": {config.Get().PanelLocation}})
+	if err != nil {
+		panic(err)
+	}
+	return <-handlers, conn
+}
+
+// signNodeToken returns a node websocket token with the connect permission for
+// each of the given servers.
+func signNodeToken(servers ...string) string {
+	p := tokens.NodeWebsocketPayload{
+		Payload: jwt.Payload{
+			ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+			// Tokens issued before Wings was booted are denied, and the time
+			// is only stored to the second.
+			IssuedAt: jwt.NumericDate(time.Now().Add(time.Second)),
+		},
+		UserUUID: "user",
+		Servers:  make(map[string][]string),
+	}
+	for _, s := range servers {
+		p.Servers[s] = []string{PermissionConnect}
+	}
+	token, err := jwt.Sign(&p, config.GetJwtAlgorithm())
+	if err != nil {
+		panic(err)
+	}
+	return string(token)
+}
+
+// readMessage returns the next message sent to the client.
+func readMessage(conn *websocket.Conn) Message {
+	var m Message
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if err := conn.ReadJSON(&m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// subscribedTypes returns the event types the connection is subscribed to for
+// each server.
+func subscribedTypes(h *NodeHandler) map[string][]string {
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+	out := make(map[string][]string)
+	for id, sub := range h.subs {
+		for t := range sub.types {
+			out[id] = append(out[id], t)
+		}
+		sort.Strings(out[id])
+	}
+	return out
+}
+
+func TestNodeHandler(t *testing.T) {
+	g := Goblin(t)
+
+	cfg, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg.AuthenticationToken = "node-token"
+	cfg.PanelLocation = "http://panel.test"
+	cfg.System.RootDirectory = t.TempDir()
+	cfg.System.Data = t.TempDir()
+	config.Set(cfg)
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+
+	manager := server.NewEmptyManager(nil)
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i] = uuid.Must(uuid.NewRandom()).String()
+		if err := os.MkdirAll(filepath.Join(cfg.System.Data, ids[i]), 0o755); err != nil {
+			panic(err)
+		}
+		s, err := manager.InitServer(remote.ServerConfigurationResponse{
+			Settings: []byte(fmt.Sprintf(`{"uuid":"%s"}`, ids[i])),
+		})
+		if err != nil {
+			panic(err)
+		}
+		manager.Add(s)
+	}
+	a, b, other := ids[0], ids[1], ids[2]
+	ctx := context.Background()
+
+	connect := func(servers ...string) (*NodeHandler, *websocket.Conn) {
+		h, conn := newTestNodeHandler(manager)
+		g.Assert(h.HandleInbound(ctx, Message{Event: AuthenticationEvent, Args: []string{signNodeToken(servers...)}})).IsNil()
+		g.Assert(readMessage(conn).Event).Equal(AuthenticationSuccessEvent)
+		return h, conn
+	}
+
+	g.Describe("NodeHandler#HandleInbound", func() {
+		g.It("merges the event types subscribed to for a server", func() {
+			h, conn := connect(a, b)
+			defer conn.Close()
+			defer h.Close()
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Server: a, Args: []string{NodeEventStatus}})).IsNil()
+			m := readMessage(conn)
+			g.Assert(m.Event).Equal(server.StatusEvent)
+			g.Assert(m.Server).Equal(a)
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{a: {NodeEventStatus}})
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{a, NodeEventStats, "unknown"}})).IsNil()
+			g.Assert(readMessage(conn).Event).Equal(server.StatusEvent)
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{a: {NodeEventStats, NodeEventStatus}})
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{b}})).IsNil()
+			g.Assert(readMessage(conn).Server).Equal(b)
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{
+				a: {NodeEventStats, NodeEventStatus},
+				b: {NodeEventConsole, NodeEventStats, NodeEventStatus},
+			})
+		})
+
+		g.It("removes the event types unsubscribed from", func() {
+			h, conn := connect(a, b)
+			defer conn.Close()
+			defer h.Close()
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{a}})).IsNil()
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{b, NodeEventConsole}})).IsNil()
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: UnsubscribeEvent, Server: a, Args: []string{NodeEventConsole, "unknown"}})).IsNil()
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{
+				a: {NodeEventStats, NodeEventStatus},
+				b: {NodeEventConsole},
+			})
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: UnsubscribeEvent, Args: []string{b, NodeEventConsole}})).IsNil()
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{a: {NodeEventStats, NodeEventStatus}})
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: UnsubscribeEvent, Args: []string{a}})).IsNil()
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{})
+
+			// Unsubscribing from a server that was not subscribed to does nothing.
+			g.Assert(h.HandleInbound(ctx, Message{Event: UnsubscribeEvent, Args: []string{b}})).IsNil()
+		})
+
+		g.It("does not subscribe to servers the token cannot access", func() {
+			h, conn := connect(a)
+			defer conn.Close()
+			defer h.Close()
+
+			for _, id := range []string{b, other, uuid.Must(uuid.NewRandom()).String()} {
+				err := h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{id}})
+				g.Assert(errors.Is(err, ErrUnknownServer)).IsTrue(id)
+			}
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{})
+		})
+
+		g.It("drops the subscriptions a new token cannot access", func() {
+			h, conn := connect(a, b)
+			defer conn.Close()
+			defer h.Close()
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{a}})).IsNil()
+			g.Assert(h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{b}})).IsNil()
+			g.Assert(len(subscribedTypes(h))).Equal(2)
+
+			g.Assert(h.HandleInbound(ctx, Message{Event: AuthenticationEvent, Args: []string{signNodeToken(a)}})).IsNil()
+			g.Assert(subscribedTypes(h)).Equal(map[string][]string{a: {NodeEventConsole, NodeEventStats, NodeEventStatus}})
+
+			err := h.HandleInbound(ctx, Message{Event: SubscribeEvent, Args: []string{b}})
+			g.Assert(errors.Is(err, ErrUnknownServer)).IsTrue()
+		})
+	})
+
+	g.Describe("NodeHandler#SendErrorJson", func() {
+		g.It("sends localized messages", func() {
+			h, conn := connect(a)
+			defer conn.Close()
+			defer h.Close()
+
+			g.Assert(h.SendErrorJson(Message{Event: SubscribeEvent, Server: b}, ErrUnknownServer)).IsNil()
+			m := readMessage(conn)
+			g.Assert(m.Event).Equal(ErrorEvent)
+			g.Assert(m.Server).Equal(b)
+			g.Assert(m.Args).Equal([]string{"服务器不存在或无法访问"})
+
+			g.Assert(h.SendErrorJson(Message{Event: SubscribeEvent}, errors.New("open /var/lib/secret: permission denied"))).IsNil()
+			m = readMessage(conn)
+			g.Assert(m.Event).Equal(ErrorEvent)
+			g.Assert(strings.HasPrefix(m.Args[0], "错误事件 [")).IsTrue(m.Args[0])
+			g.Assert(strings.HasSuffix(m.Args[0], "]: 处理此请求时遇到意外错误")).IsTrue(m.Args[0])
+			g.Assert(strings.Contains(m.Args[0], "/var/lib")).IsFalse()
+		})
+	})
+}
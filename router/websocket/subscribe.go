@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+)
+
+const (
+	// The shortest interval stats can be sent at, which is also the rate that
+	// stats are collected at.
+	minStatsInterval = time.Second
+	// The longest interval stats can be sent at.
+	maxStatsInterval = time.Minute * 5
+)
+
+// subscription is the set of server events a client has chosen to receive.
+type subscription struct {
+	// Events is the set of events to send, if empty every event is sent.
+	Events []string `json:"events"`
+	// StatsInterval is the minimum number of seconds between stats events.
+	StatsInterval float64 `json:"stats_interval"`
+}
+
+// subscribe changes the events the client receives. The subscription is sent
+// as JSON in the first argument, and any events that cannot be subscribed to
+// are ignored. The subscription that was applied, with the stats interval
+// clamped to the allowed range, is sent back to the client.
+func (h *Handler) subscribe(args []string) error {
+	var sub subscription
+	if len(args) > 0 && args[0] != "" {
+		if err := json.Unmarshal([]byte(args[0]), &sub); err != nil {
+			return errors.Wrap(err, "websocket: invalid subscription")
+		}
+	}
+
+	var events map[string]bool
+	if len(sub.Events) > 0 {
+		events = make(map[string]bool)
+		for _, v := range sub.Events {
+			for _, k := range e {
+				if v == k {
+					events[v] = true
+				}
+			}
+		}
+	}
+	interval := min(max(time.Duration(sub.StatsInterval*float64(time.Second)), minStatsInterval), maxStatsInterval)
+
+	h.subMu.Lock()
+	h.events = events
+	h.statsInterval = interval
+	h.subMu.Unlock()
+
+	sub.Events = make([]string, 0, len(events))
+	for _, k := range e {
+		if events == nil || events[k] {
+			sub.Events = append(sub.Events, k)
+		}
+	}
+	sub.StatsInterval = interval.Seconds()
+	b, _ := json.Marshal(sub)
+	return h.SendJson(Message{Event: SubscribedEvent, Args: []string{string(b)}})
+}
+
+// wantsEvent returns true if the client is subscribed to the event.
+func (h *Handler) wantsEvent(event string) bool {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	return h.events == nil || h.events[event]
+}
+
+// statsWait returns how long to wait before sending stats, given the time
+// stats were last sent to the client. Stats are sent as soon as they are
+// received if the client has not chosen an interval.
+func (h *Handler) statsWait(last time.Time) time.Duration {
+	h.subMu.Lock()
+	interval := h.statsInterval
+	h.subMu.Unlock()
+	if interval == 0 {
+		return 0
+	}
+	return interval - time.Since(last)
+}
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"path"
+	"strings"
+)
+
+type directoryWatch struct {
+	recursive bool
+	release   func()
+}
+
+// watchDirectory starts sending file changed events for a directory to the
+// connected client, replacing any existing watch on the same directory.
+func (h *Handler) watchDirectory(dir string, recursive bool) error {
+	dir = path.Clean("/" + dir)
+	release, err := h.server.WatchDirectory(dir, recursive)
+	if err != nil {
+		return err
+	}
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	// The connection may have been closed while the watch was being set up.
+	if h.watchesReleased {
+		release()
+		return nil
+	}
+	if h.watches == nil {
+		h.watches = make(map[string]directoryWatch)
+	}
+	if w, ok := h.watches[dir]; ok {
+		w.release()
+	}
+	h.watches[dir] = directoryWatch{recursive: recursive, release: release}
+	return nil
+}
+
+// unwatchDirectory stops sending file changed events for a directory.
+func (h *Handler) unwatchDirectory(dir string) {
+	dir = path.Clean("/" + dir)
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	if w, ok := h.watches[dir]; ok {
+		w.release()
+		delete(h.watches, dir)
+	}
+}
+
+// isWatching returns true if the client is watching the directory, either
+// directly or through a recursive watch on one of its parents.
+func (h *Handler) isWatching(dir string) bool {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	if _, ok := h.watches[dir]; ok {
+		return true
+	}
+	for p, w := range h.watches {
+		if w.recursive && (p == "/" || strings.HasPrefix(dir, p+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseWatches stops watching all the directories the client was watching.
+// This should be called once the connection is closed.
+func (h *Handler) ReleaseWatches() {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	for _, w := range h.watches {
+		w.release()
+	}
+	h.watches = nil
+	h.watchesReleased = true
+}
+
+// shouldSendFileChange returns true if the data of a file changed event is for
+// a directory that the client is watching.
+func (h *Handler) shouldSendFileChange(data interface{}) bool {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	dir, _ := m["directory"].(string)
+	return dir != "" && h.isWatching(dir)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +26,7 @@ import (
 	"github.com/pterodactyl/wings/environment/docker"
 	"github.com/pterodactyl/wings/router/tokens"
 	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/filesystem"
 )
 
 const (
@@ -37,6 +39,7 @@ const (
 	PermissionReceiveInstall   = "admin.websocket.install"
 	PermissionReceiveTransfer  = "admin.websocket.transfer"
 	PermissionReceiveBackups   = "backup.read"
+	PermissionReadFiles        = "file.read"
 )
 
 type Handler struct {
@@ -46,6 +49,16 @@ type Handler struct {
 	server       *server.Server
 	ra           server.RequestActivity
 	uuid         uuid.UUID
+
+	watchMu         sync.Mutex
+	watches         map[string]directoryWatch
+	watchesReleased bool
+
+	subMu         sync.Mutex
+	events        map[string]bool
+	statsInterval time.Duration
+
+	limits connectionLimits
 }
 
 var (
@@ -60,6 +73,7 @@ func IsJwtError(err error) bool {
 		errors.Is(err, ErrJwtNoConnectPerm) ||
 		errors.Is(err, ErrJwtUuidMismatch) ||
 		errors.Is(err, ErrJwtOnDenylist) ||
+		errors.Is(err, tokens.ErrTokenRevoked) ||
 		errors.Is(err, jwt.ErrExpValidation)
 }
 
@@ -81,9 +95,10 @@ func NewTokenPayload(token []byte) (*tokens.WebsocketPayload, error) {
 	return &payload, nil
 }
 
-// GetHandler returns a new websocket handler using the context provided.
-func GetHandler(s *server.Server, w http.ResponseWriter, r *http.Request, c *gin.Context) (*Handler, error) {
-	upgrader := websocket.Upgrader{
+// newUpgrader returns a websocket upgrader that only accepts connections from
+// the Panel and the configured allowed origins.
+func newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
 		// Ensure that the websocket request is originating from the Panel itself,
 		// and not some other location.
 		CheckOrigin: func(r *http.Request) bool {
@@ -99,12 +114,18 @@ func GetHandler(s *server.Server, w http.ResponseWriter, r *http.Request, c *gin
 			return false
 		},
 	}
+}
 
+// GetHandler returns a new websocket handler using the context provided.
+func GetHandler(s *server.Server, w http.ResponseWriter, r *http.Request, c *gin.Context) (*Handler, error) {
+	upgrader := newUpgrader()
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	setReadLimit(conn)
+
 	u, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
@@ -116,6 +137,7 @@ func GetHandler(s *server.Server, w http.ResponseWriter, r *http.Request, c *gin
 		server:     s,
 		ra:         s.NewRequestActivity("", c.ClientIP()),
 		uuid:       u,
+		limits:     newConnectionLimits(),
 	}, nil
 }
 
@@ -156,6 +178,13 @@ func (h *Handler) SendJson(v Message) error {
 			}
 		}
 
+		// Only send file changes to users that are able to read the server's files.
+		if v.Event == server.FileChangedEvent {
+			if !j.HasPermission(PermissionReadFiles) {
+				return nil
+			}
+		}
+
 		// If we are sending transfer output, only send it to the user if they have the required permissions.
 		if v.Event == server.TransferLogsEvent {
 			if !j.HasPermission(PermissionReceiveTransfer) {
@@ -360,6 +389,10 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 				}
 			}
 
+			if !h.allow(m.Event) {
+				return nil
+			}
+
 			err := h.server.HandlePowerAction(action)
 			if errors.Is(err, system.ErrLockerLocked) {
 				m, _ := h.GetErrorMessage("当前正在为此服务器处理另一个电源操作，请稍后重试")
@@ -380,6 +413,26 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 		}
 	case SendServerLogsEvent:
 		{
+			// Clients that have already received some console output send the
+			// sequence number of the last line they received, and are sent only
+			// the lines after it. If some of those lines are no longer kept the
+			// gap in the sequence numbers of the lines sent shows what was lost.
+			if len(m.Args) > 0 && m.Args[0] != "" {
+				since, err := strconv.ParseUint(m.Args[0], 10, 64)
+				if err != nil {
+					return nil
+				}
+				lines, _ := h.server.Sink(system.LogSink).Since(since)
+				for _, l := range lines {
+					_ = h.SendJson(Message{
+						Event:    server.ConsoleOutputEvent,
+						Args:     []string{string(l.Data)},
+						Sequence: l.Sequence,
+					})
+				}
+				return nil
+			}
+
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 			defer cancel()
 			if running, _ := h.server.Environment.IsRunning(ctx); !running {
@@ -416,6 +469,24 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 				return nil
 			}
 
+			if !h.allow(m.Event) {
+				return nil
+			}
+
+			if command := strings.Join(m.Args, ""); !h.GetJwt().CommandAllowed(command) {
+				h.server.SaveActivity(h.ra, server.ActivityConsoleDenied, models.ActivityMeta{
+					"command": command,
+				})
+				m, _ := h.GetErrorMessage("你没有权限发送此命令")
+
+				_ = h.SendJson(Message{
+					Event: ErrorEvent,
+					Args:  []string{m},
+				})
+
+				return nil
+			}
+
 			if h.server.Environment.State() == environment.ProcessOfflineState {
 				return nil
 			}
@@ -438,6 +509,38 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 			h.server.SaveActivity(h.ra, server.ActivityConsoleCommand, models.ActivityMeta{
 				"command": strings.Join(m.Args, ""),
 			})
+			return nil
+		}
+	case SubscribeEvent:
+		{
+			return h.subscribe(m.Args)
+		}
+	case WatchDirectoryEvent:
+		{
+			if !h.GetJwt().HasPermission(PermissionReadFiles) || len(m.Args) == 0 {
+				return nil
+			}
+
+			err := h.watchDirectory(m.Args[0], len(m.Args) > 1 && m.Args[1] == "recursive")
+			if errors.Is(err, filesystem.ErrWatchLimit) {
+				m, _ := h.GetErrorMessage("此服务器正在监视的目录过多，请取消监视部分目录后重试")
+
+				_ = h.SendJson(Message{
+					Event: ErrorEvent,
+					Args:  []string{m},
+				})
+
+				return nil
+			}
+
+			return err
+		}
+	case UnwatchDirectoryEvent:
+		{
+			if len(m.Args) > 0 {
+				h.unwatchDirectory(m.Args[0])
+			}
+
 			return nil
 		}
 	}
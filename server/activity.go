@@ -14,6 +14,7 @@ const ActivityPowerPrefix = "server:power."
 
 const (
 	ActivityConsoleCommand      = models.Event("server:console.command")
+	ActivityConsoleDenied       = models.Event("server:console.command-denied")
 	ActivitySftpWrite           = models.Event("server:sftp.write")
 	ActivitySftpCreate          = models.Event("server:sftp.create")
 	ActivitySftpCreateDirectory = models.Event("server:sftp.create-directory")
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"io"
 	"io/fs"
 	"os"
@@ -9,8 +10,11 @@ import (
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/docker/docker/client"
+	"gorm.io/gorm/clause"
 
 	"github.com/pterodactyl/wings/environment"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/internal/models"
 	"github.com/pterodactyl/wings/remote"
 	"github.com/pterodactyl/wings/server/backup"
 )
@@ -100,6 +104,15 @@ func (s *Server) Backup(b backup.BackupInterface) error {
 		s.Log().WithField("backup", b.Identifier()).Info("notified panel of successful backup state")
 	}
 
+	if _, ok := b.(*backup.LocalBackup); ok {
+		m := models.LocalBackup{ID: b.Identifier(), Server: s.ID(), CreatedAt: time.Now().UTC()}
+		if tx := database.Instance().Save(&m); tx.Error != nil {
+			s.Log().WithField("backup", b.Identifier()).
+				WithField("error", tx.Error).
+				Warn("failed to save local backup to database")
+		}
+	}
+
 	// Emit an event over the socket so we can update the backup in realtime on
 	// the frontend for the server.
 	s.Events().Publish(BackupCompletedEvent+":"+b.Identifier(), map[string]interface{}{
@@ -113,6 +126,38 @@ func (s *Server) Backup(b backup.BackupInterface) error {
 	return nil
 }
 
+// HasLocalBackup returns true if the local backup with the given UUID was
+// created for this server.
+func (s *Server) HasLocalBackup(ctx context.Context, uuid string) (bool, error) {
+	var count int64
+	tx := database.Instance().WithContext(ctx).Model(&models.LocalBackup{}).
+		Where("id = ? AND server = ?", uuid, s.ID()).
+		Count(&count)
+	if tx.Error != nil {
+		return false, errors.WithStack(tx.Error)
+	}
+	return count > 0, nil
+}
+
+// ClaimLocalBackup records a local backup as belonging to this server if no
+// server has been recorded for it yet, which is the case for backups created
+// before they were recorded. Returns true if the backup belongs to this server.
+func (s *Server) ClaimLocalBackup(ctx context.Context, uuid string) (bool, error) {
+	m := models.LocalBackup{ID: uuid, Server: s.ID(), CreatedAt: time.Now().UTC()}
+	tx := database.Instance().WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&m)
+	if tx.Error != nil {
+		return false, errors.WithStack(tx.Error)
+	}
+	return s.HasLocalBackup(ctx, uuid)
+}
+
+// ForgetLocalBackup removes the record of a local backup once it has been
+// deleted from the machine.
+func (s *Server) ForgetLocalBackup(ctx context.Context, uuid string) error {
+	tx := database.Instance().WithContext(ctx).Where("id = ?", uuid).Delete(&models.LocalBackup{})
+	return errors.WithStack(tx.Error)
+}
+
 // RestoreBackup calls the Restore function on the provided backup. Once this
 // restoration is completed an event is emitted to the websocket to notify the
 // Panel that is has been completed.
@@ -4,6 +4,8 @@ import (
 	"context"
 	"io"
 	"os"
+	"path"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/juju/ratelimit"
@@ -14,6 +16,10 @@ import (
 	"github.com/pterodactyl/wings/server/filesystem"
 )
 
+// ErrFileTooLarge is returned when a file read from a backup is larger than the
+// allowed size.
+const ErrFileTooLarge = errors.Sentinel("backup: file is too large")
+
 type LocalBackup struct {
 	Backup
 }
@@ -106,3 +112,39 @@ func (b *LocalBackup) Restore(ctx context.Context, _ io.Reader, callback Restore
 	}
 	return nil
 }
+
+// ReadFile returns the contents of a single file in the backup. An error is
+// returned if the file does not exist in the backup, or is larger than limit.
+func (b *LocalBackup) ReadFile(ctx context.Context, name string, limit int64) ([]byte, error) {
+	f, err := os.Open(b.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	var out []byte
+	found := false
+	if err := format.Extract(ctx, f, []string{name}, func(ctx context.Context, f archiver.File) error {
+		if f.NameInArchive != name || !f.Mode().IsRegular() {
+			return nil
+		}
+		if f.Size() > limit {
+			return ErrFileTooLarge
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		found = true
+		out, err = io.ReadAll(io.LimitReader(r, limit))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return out, nil
+}
@@ -19,6 +19,8 @@ const (
 	TransferLogsEvent           = "transfer logs"
 	TransferStatusEvent         = "transfer status"
 	DeletedEvent                = "deleted"
+	FileChangedEvent            = "file changed"
+	JobStatusEvent              = "job status"
 )
 
 // Events returns the server's emitter instance.
@@ -18,6 +18,7 @@ import (
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 
+	"github.com/pterodactyl/wings/internal/progress"
 	"github.com/pterodactyl/wings/internal/ufs"
 	"github.com/pterodactyl/wings/server/filesystem/archiverext"
 )
@@ -30,9 +31,11 @@ import (
 //
 // All paths are relative to the dir that is passed in as the first argument,
 // and the compressed file will be placed at that location named
-// `archive-{date}.tar.gz`.
-func (fs *Filesystem) CompressFiles(dir string, paths []string) (ufs.FileInfo, error) {
-	a := &Archive{Filesystem: fs, BaseDirectory: dir, Files: paths}
+// `archive-{date}.tar.gz`. If p is not nil the data written to the archive is
+// tracked through it, and the archive is removed if the context is canceled
+// before it has been completed.
+func (fs *Filesystem) CompressFiles(ctx context.Context, dir string, paths []string, p *progress.Progress) (ufs.FileInfo, error) {
+	a := &Archive{Filesystem: fs, BaseDirectory: dir, Files: paths, Progress: p}
 	d := path.Join(
 		dir,
 		fmt.Sprintf("archive-%s.tar.gz", strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", "")),
@@ -43,7 +46,8 @@ func (fs *Filesystem) CompressFiles(dir string, paths []string) (ufs.FileInfo, e
 	}
 	defer f.Close()
 	cw := ufs.NewCountedWriter(f)
-	if err := a.Stream(context.Background(), cw); err != nil {
+	if err := a.Stream(ctx, cw); err != nil {
+		_ = fs.unixFS.Remove(d)
 		return nil, err
 	}
 	if !fs.unixFS.CanFit(cw.BytesWritten()) {
@@ -54,29 +58,31 @@ func (fs *Filesystem) CompressFiles(dir string, paths []string) (ufs.FileInfo, e
 	return f.Stat()
 }
 
-func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS, error) {
+// archiverFileSystem opens an archive as a filesystem that can be walked. The
+// returned closer must be called once the filesystem is no longer needed.
+func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS, io.Closer, error) {
 	f, err := fs.unixFS.Open(p)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// Do not use defer to close `f`, it will likely be used later.
 
 	format, _, err := archiver.Identify(filepath.Base(p), f)
 	if err != nil && !errors.Is(err, archiver.ErrNoMatch) {
 		_ = f.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Reset the file reader.
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		_ = f.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
 	info, err := f.Stat()
 	if err != nil {
 		_ = f.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
 	if format != nil {
@@ -86,36 +92,140 @@ func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS
 			// and zip.Reader can open several content files concurrently because of io.ReaderAt requirement
 			// while ArchiveFS can't.
 			// zip.Reader doesn't suffer from issue #330 and #310 according to local test (but they should be fixed anyway)
-			return zip.NewReader(f, info.Size())
+			r, err := zip.NewReader(f, info.Size())
+			if err != nil {
+				_ = f.Close()
+				return nil, nil, err
+			}
+			return r, f, nil
 		case archiver.Archival:
-			return archiver.ArchiveFS{Stream: io.NewSectionReader(f, 0, info.Size()), Format: ff, Context: ctx}, nil
+			return archiver.ArchiveFS{Stream: io.NewSectionReader(f, 0, info.Size()), Format: ff, Context: ctx}, f, nil
 		case archiver.Compression:
-			return archiverext.FileFS{File: f, Compression: ff}, nil
+			return archiverext.FileFS{File: f, Compression: ff}, f, nil
 		}
 	}
 	_ = f.Close()
-	return nil, archiver.ErrNoMatch
+	return nil, nil, archiver.ErrNoMatch
+}
+
+// ArchiveEntry is a single file or directory inside an archive.
+type ArchiveEntry struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Directory bool      `json:"directory"`
+	Mode      string    `json:"mode"`
+	Modified  time.Time `json:"modified"`
+}
+
+// MaxArchiveEntries is the largest number of entries returned when listing the
+// contents of an archive.
+const MaxArchiveEntries = 10000
+
+// ListArchive returns the files and directories inside an archive without
+// extracting it. At most MaxArchiveEntries entries are returned, and if the
+// archive contains more than that the returned boolean is true. The names of
+// the entries are the paths that can be passed to DecompressFileTo.
+func (fs *Filesystem) ListArchive(ctx context.Context, dir string, file string) ([]ArchiveEntry, bool, error) {
+	fsys, closer, err := fs.archiverFileSystem(ctx, filepath.Join(dir, file))
+	if err != nil {
+		if errors.Is(err, archiver.ErrNoMatch) {
+			return nil, false, newFilesystemError(ErrCodeUnknownArchive, err)
+		}
+		return nil, false, err
+	}
+	defer closer.Close()
+
+	out := []ArchiveEntry{}
+	truncated := false
+	err = iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == "." && d.IsDir() {
+			return nil
+		}
+		if len(out) >= MaxArchiveEntries {
+			truncated = true
+			return iofs.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// A file that is only compressed, rather than being an archive, contains a
+		// single file named after the archive without its extension.
+		if p == "." {
+			p = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		out = append(out, ArchiveEntry{
+			Name:      p,
+			Size:      info.Size(),
+			Directory: d.IsDir(),
+			Mode:      info.Mode().String(),
+			Modified:  info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return out, truncated, nil
+}
+
+// cleanArchiveEntries cleans the paths of entries inside an archive so that
+// they can be compared against the names of the files in the archive.
+func cleanArchiveEntries(entries []string) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		e = strings.Trim(path.Clean("/"+e), "/")
+		if e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// archiveEntryIncluded returns true if the file at the given path inside an
+// archive is one of the entries, or is inside one of them. If no entries are
+// provided every file is included.
+func archiveEntryIncluded(p string, entries []string) bool {
+	if len(entries) == 0 {
+		return true
+	}
+	p = strings.Trim(path.Clean("/"+p), "/")
+	for _, e := range entries {
+		if p == e || strings.HasPrefix(p, e+"/") {
+			return true
+		}
+	}
+	return false
 }
 
 // SpaceAvailableForDecompression looks through a given archive and determines
 // if decompressing it would put the server over its allocated disk space limit.
-func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir string, file string) error {
+// If any entries are provided only the size of those entries is considered.
+func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir string, file string, entries ...string) error {
 	// Don't waste time trying to determine this if we know the server will have the space for
 	// it since there is no limit.
 	if fs.MaxDisk() <= 0 {
 		return nil
 	}
 
-	fsys, err := fs.archiverFileSystem(ctx, filepath.Join(dir, file))
+	fsys, closer, err := fs.archiverFileSystem(ctx, filepath.Join(dir, file))
 	if err != nil {
 		if errors.Is(err, archiver.ErrNoMatch) {
 			return newFilesystemError(ErrCodeUnknownArchive, err)
 		}
 		return err
 	}
+	defer closer.Close()
 
+	entries = cleanArchiveEntries(entries)
 	var size atomic.Int64
-	return iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
+	return iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -125,6 +235,9 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 			// Stop walking if the context is canceled.
 			return ctx.Err()
 		default:
+			if p != "." && !archiveEntryIncluded(p, entries) {
+				return nil
+			}
 			info, err := d.Info()
 			if err != nil {
 				return err
@@ -143,6 +256,14 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 // zip-slip attack being attempted by validating that the final path is within
 // the server data directory.
 func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file string) error {
+	return fs.DecompressFileTo(ctx, dir, file, dir, nil)
+}
+
+// DecompressFileTo decompresses a file in the given directory into the target
+// directory. If any entries are provided only those files, and the contents of
+// those directories, are extracted from the archive. The entries are the names
+// of the files inside the archive, as returned by ListArchive.
+func (fs *Filesystem) DecompressFileTo(ctx context.Context, dir string, file string, target string, entries []string) error {
 	f, err := fs.unixFS.Open(filepath.Join(dir, file))
 	if err != nil {
 		return err
@@ -160,9 +281,10 @@ func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file strin
 
 	return fs.extractStream(ctx, extractStreamOptions{
 		FileName:  file,
-		Directory: dir,
+		Directory: target,
 		Format:    format,
 		Reader:    input,
+		Entries:   cleanArchiveEntries(entries),
 	})
 }
 
@@ -191,6 +313,8 @@ type extractStreamOptions struct {
 	Format archiver.Format
 	// Reader for the archive.
 	Reader io.Reader
+	// Entries to extract from the archive, if empty every file is extracted.
+	Entries []string
 }
 
 func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptions) error {
@@ -259,7 +383,7 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 
 	// 解压缩并提取归档文件
 	return ex.Extract(ctx, opts.Reader, nil, func(ctx context.Context, f archiver.File) error {
-		if f.IsDir() {
+		if f.IsDir() || !archiveEntryIncluded(f.NameInArchive, opts.Entries) {
 			return nil
 		}
 		p := filepath.Join(opts.Directory, f.NameInArchive)
@@ -1,8 +1,12 @@
 package filesystem
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"errors"
 	"os"
+	"sort"
 	"testing"
 
 	. "github.com/franela/goblin"
@@ -52,3 +56,96 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 		})
 	})
 }
+
+func TestFilesystem_ListArchive(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("ListArchive", func() {
+		for _, ext := range []string{"zip", "rar", "tar", "tar.gz"} {
+			g.It("can list a "+ext, func() {
+				c, err := os.ReadFile("./testdata/test." + ext)
+				g.Assert(err).IsNil()
+				err = rfs.CreateServerFile("./test."+ext, c)
+				g.Assert(err).IsNil()
+
+				entries, truncated, err := fs.ListArchive(context.Background(), "/", "test."+ext)
+				g.Assert(err).IsNil()
+				g.Assert(truncated).IsFalse()
+
+				var files []string
+				for _, e := range entries {
+					if !e.Directory {
+						files = append(files, e.Name)
+					}
+				}
+				sort.Strings(files)
+				g.Assert(files).Equal([]string{"test/inside/finside.txt", "test/outside.txt"})
+
+				// Nothing should have been extracted.
+				_, err = rfs.StatServerFile("test")
+				g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+			})
+		}
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
+
+func TestFilesystem_DecompressFileTo(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("DecompressFileTo", func() {
+		g.BeforeEach(func() {
+			c, err := os.ReadFile("./testdata/test.zip")
+			if err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFile("./test.zip", c); err != nil {
+				panic(err)
+			}
+		})
+
+		g.It("only extracts the selected entries into the target", func() {
+			err := fs.DecompressFileTo(context.Background(), "/", "test.zip", "/extracted", []string{"/test/inside"})
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("extracted/test/inside/finside.txt")
+			g.Assert(err).IsNil()
+			_, err = rfs.StatServerFile("extracted/test/outside.txt")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+			_, err = rfs.StatServerFile("test")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("only counts the selected entries when checking for space", func() {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			for name, size := range map[string]int{"small.txt": 1, "large.bin": 100} {
+				w, err := zw.Create(name)
+				g.Assert(err).IsNil()
+				_, err = w.Write(bytes.Repeat([]byte("a"), size))
+				g.Assert(err).IsNil()
+			}
+			g.Assert(zw.Close()).IsNil()
+			err := rfs.CreateServerFile("sizes.zip", buf.Bytes())
+			g.Assert(err).IsNil()
+
+			fs.SetDiskLimit(50)
+			fs.unixFS.SetUsage(0)
+
+			err = fs.SpaceAvailableForDecompression(context.Background(), "/", "sizes.zip", "small.txt")
+			g.Assert(err).IsNil()
+			err = fs.SpaceAvailableForDecompression(context.Background(), "/", "sizes.zip")
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue("err is not ErrCodeDiskSpace")
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+			fs.SetDiskLimit(0)
+		})
+	})
+}
@@ -0,0 +1,311 @@
+package filesystem
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+// ErrCopyIntoSelf is returned when attempting to copy or move a directory into
+// itself, which would otherwise never finish.
+const ErrCopyIntoSelf = errors.Sentinel("filesystem: cannot copy or move a directory into itself")
+
+// ConflictPolicy determines what happens when the destination of a copy or move
+// already exists.
+type ConflictPolicy string
+
+const (
+	// ConflictFail returns an error if the destination already exists.
+	ConflictFail ConflictPolicy = ""
+	// ConflictSkip leaves the existing destination in place. When both the source
+	// and destination are directories their contents are merged, skipping any
+	// files that already exist.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing destination. When both the source
+	// and destination are directories their contents are merged, replacing any
+	// files that already exist.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename copies or moves to a new name alongside the existing
+	// destination, in the same way as Copy does.
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// Valid returns true if the policy is one of the known conflict policies.
+func (p ConflictPolicy) Valid() bool {
+	switch p {
+	case ConflictFail, ConflictSkip, ConflictOverwrite, ConflictRename:
+		return true
+	}
+	return false
+}
+
+// CopyTo copies a file or directory, along with everything inside it, to the
+// given destination. Symlinks are never followed while copying, and anything
+// that is not a regular file or directory is skipped. The total size of the
+// files being copied is checked against the disk limit of the server before
+// anything is copied.
+func (fs *Filesystem) CopyTo(ctx context.Context, from, to string, policy ConflictPolicy) error {
+	from, to, err := fs.cleanTransferPaths(from, to)
+	if err != nil {
+		return err
+	}
+
+	dirfd, name, closeFd, err := fs.unixFS.SafePath(from)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	st, err := fs.unixFS.Lstatat(dirfd, name)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() && !st.Mode().IsRegular() {
+		return ufs.ErrNotExist
+	}
+
+	to, exists, skip, err := fs.resolveConflict(to, st.IsDir(), policy)
+	if err != nil || skip {
+		return err
+	}
+
+	var size int64
+	if err := fs.unixFS.WalkDirat(dirfd, name, func(_ int, _, _ string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return ctx.Err()
+	}); err != nil {
+		return err
+	}
+	if !fs.unixFS.CanFit(size) {
+		return newFilesystemError(ErrCodeDiskSpace, nil)
+	}
+
+	if !st.IsDir() {
+		return fs.copyFileat(ctx, dirfd, name, st, to, exists)
+	}
+
+	// Anything nested inside the destination can only already exist when merging
+	// directories, in which case a new name is never used.
+	nested := policy
+	if nested == ConflictRename {
+		nested = ConflictFail
+	}
+	return fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		dst := to
+		if i := strings.IndexByte(relative, '/'); i != -1 {
+			dst = path.Join(to, relative[i+1:])
+		}
+
+		if !d.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		// The top level destination has already been resolved.
+		exists, skip := exists, false
+		if dst != to {
+			if _, exists, skip, err = fs.resolveConflict(dst, d.IsDir(), nested); err != nil {
+				return err
+			}
+		}
+		if skip {
+			if d.IsDir() {
+				return ufs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			return fs.copyFileat(ctx, dirfd, name, info, dst, exists)
+		}
+		if exists {
+			return nil
+		}
+		if err := fs.unixFS.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return fs.chownFile(dst)
+	})
+}
+
+// MoveTo moves a file or directory to the given destination. When a directory
+// is moved onto an existing directory using the skip or overwrite policies the
+// contents of the two are merged, and the source directory is only removed if
+// everything inside it was moved.
+func (fs *Filesystem) MoveTo(ctx context.Context, from, to string, policy ConflictPolicy) error {
+	from, to, err := fs.cleanTransferPaths(from, to)
+	if err != nil {
+		return err
+	}
+	st, err := fs.unixFS.Lstat(from)
+	if err != nil {
+		return err
+	}
+	to, exists, skip, err := fs.resolveConflict(to, st.IsDir(), policy)
+	if err != nil || skip {
+		return err
+	}
+	if !exists {
+		return fs.unixFS.Rename(from, to)
+	}
+	if !st.IsDir() {
+		return fs.RenameReplace(from, to)
+	}
+
+	entries, err := fs.unixFS.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	if policy == ConflictRename {
+		policy = ConflictFail
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fs.MoveTo(ctx, path.Join(from, e.Name()), path.Join(to, e.Name()), policy); err != nil {
+			return err
+		}
+	}
+	// Anything that was skipped is left behind in the source directory, in which
+	// case it cannot be removed.
+	if err := fs.unixFS.Remove(from); err != nil && !errors.Is(err, unix.ENOTEMPTY) {
+		return err
+	}
+	return nil
+}
+
+// cleanTransferPaths cleans the source and destination of a copy or move, and
+// checks that a directory is not being copied or moved into itself.
+func (fs *Filesystem) cleanTransferPaths(from, to string) (string, string, error) {
+	from = path.Clean("/" + from)
+	to = path.Clean("/" + to)
+	if from == "/" || to == "/" || to == from || strings.HasPrefix(to, from+"/") {
+		return "", "", ErrCopyIntoSelf
+	}
+	return from, to, nil
+}
+
+// resolveConflict determines where a file or directory should be copied or moved
+// to using the conflict policy. If the destination already exists and should be
+// merged with, or replaced by, the source then exists is true. If nothing should
+// be copied or moved then skip is true.
+func (fs *Filesystem) resolveConflict(to string, isDir bool, policy ConflictPolicy) (dst string, exists bool, skip bool, err error) {
+	existing, err := fs.unixFS.Lstat(to)
+	if err != nil {
+		if errors.Is(err, ufs.ErrNotExist) {
+			return to, false, false, nil
+		}
+		return "", false, false, err
+	}
+	// Only directories can be merged, and only regular files can be replaced
+	// in-place. Anything else must be removed before it can be overwritten.
+	mergeable := (isDir && existing.IsDir()) || (!isDir && existing.Mode().IsRegular())
+
+	switch policy {
+	case ConflictSkip:
+		return to, true, !(isDir && existing.IsDir()), nil
+	case ConflictOverwrite:
+		if mergeable {
+			return to, true, false, nil
+		}
+		if err := fs.unixFS.RemoveAll(to); err != nil {
+			return "", false, false, err
+		}
+		return to, false, false, nil
+	case ConflictRename:
+		dirfd, name, closeFd, err := fs.unixFS.SafePath(to)
+		defer closeFd()
+		if err != nil {
+			return "", false, false, err
+		}
+		base, extension := name, ""
+		if !isDir {
+			extension = filepath.Ext(name)
+			base = strings.TrimSuffix(name, extension)
+			if strings.HasSuffix(base, ".tar") {
+				extension = ".tar" + extension
+				base = strings.TrimSuffix(base, ".tar")
+			}
+		}
+		n, err := fs.findCopySuffix(dirfd, base, extension)
+		if err != nil {
+			return "", false, false, err
+		}
+		return path.Join(path.Dir(to), n), false, false, nil
+	}
+	return "", false, false, &ufs.PathError{Op: "copy", Path: to, Err: ufs.ErrExist}
+}
+
+// copyFileat copies a regular file, opened relative to the dirfd, to the given
+// destination. If replace is true the destination is truncated and its current
+// size is removed from the disk usage.
+func (fs *Filesystem) copyFileat(ctx context.Context, dirfd int, name string, info ufs.FileInfo, to string, replace bool) error {
+	source, err := fs.unixFS.OpenFileat(dirfd, name, ufs.O_RDONLY|ufs.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var current int64
+	flag := ufs.O_WRONLY | ufs.O_CREATE | ufs.O_EXCL
+	if replace {
+		flag = ufs.O_WRONLY | ufs.O_CREATE | ufs.O_TRUNC | ufs.O_NOFOLLOW
+		if st, err := fs.unixFS.Lstat(to); err == nil {
+			current = st.Size()
+		}
+	}
+	if !fs.unixFS.CanFit(info.Size() - current) {
+		return newFilesystemError(ErrCodeDiskSpace, nil)
+	}
+
+	dst, err := fs.unixFS.Touch(to, flag, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, io.LimitReader(&contextReader{ctx: ctx, r: source}, info.Size()))
+	fs.unixFS.Add(n - current)
+	if err != nil {
+		return err
+	}
+	return fs.chownFile(to)
+}
+
+// contextReader stops reading from the underlying reader once the context has
+// been canceled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,205 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+func TestFilesystem_CopyTo(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("CopyTo", func() {
+		g.BeforeEach(func() {
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/world/region"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("world/level.dat", "level"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("world/region/r.0.0.mca", "region"); err != nil {
+				panic(err)
+			}
+			fs.unixFS.SetUsage(11)
+		})
+
+		g.It("should copy a directory recursively and increment the disk used", func() {
+			err := fs.CopyTo(context.Background(), "world", "backups/world", ConflictFail)
+			g.Assert(err).IsNil()
+
+			b, err := os.ReadFile(filepath.Join(rfs.root, "server/backups/world/region/r.0.0.mca"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("region")
+			g.Assert(fs.CachedUsage()).Equal(int64(22))
+		})
+
+		g.It("should return an error if copying a directory into itself", func() {
+			err := fs.CopyTo(context.Background(), "world", "world/region/world", ConflictFail)
+			g.Assert(errors.Is(err, ErrCopyIntoSelf)).IsTrue("err is not ErrCopyIntoSelf")
+		})
+
+		g.It("should keep the destination inside the root", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "../level.dat", ConflictFail)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("level.dat")
+			g.Assert(err).IsNil()
+			_, err = os.Stat(filepath.Join(rfs.root, "level.dat"))
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("should return an error if there is not space to copy the directory", func() {
+			fs.SetDiskLimit(15)
+
+			err := fs.CopyTo(context.Background(), "world", "world2", ConflictFail)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue("err is not ErrCodeDiskSpace")
+
+			_, err = rfs.StatServerFile("world2")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("should return an error if the destination exists", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "world/region/r.0.0.mca", ConflictFail)
+			g.Assert(errors.Is(err, ufs.ErrExist)).IsTrue("err is not ErrExist")
+		})
+
+		g.It("should skip files that already exist when merging directories", func() {
+			err := os.MkdirAll(filepath.Join(rfs.root, "server/copy"), 0o755)
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFileFromString("copy/level.dat", "existing")
+			g.Assert(err).IsNil()
+
+			err = fs.CopyTo(context.Background(), "world", "copy", ConflictSkip)
+			g.Assert(err).IsNil()
+
+			b, _ := os.ReadFile(filepath.Join(rfs.root, "server/copy/level.dat"))
+			g.Assert(string(b)).Equal("existing")
+			_, err = rfs.StatServerFile("copy/region/r.0.0.mca")
+			g.Assert(err).IsNil()
+		})
+
+		g.It("should overwrite files that already exist when merging directories", func() {
+			err := os.MkdirAll(filepath.Join(rfs.root, "server/copy"), 0o755)
+			g.Assert(err).IsNil()
+			err = rfs.CreateServerFileFromString("copy/level.dat", "existing")
+			g.Assert(err).IsNil()
+			fs.unixFS.SetUsage(19)
+
+			err = fs.CopyTo(context.Background(), "world", "copy", ConflictOverwrite)
+			g.Assert(err).IsNil()
+
+			b, _ := os.ReadFile(filepath.Join(rfs.root, "server/copy/level.dat"))
+			g.Assert(string(b)).Equal("level")
+			g.Assert(fs.CachedUsage()).Equal(int64(22))
+		})
+
+		g.It("should copy to a new name if the destination exists", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "world/region/r.0.0.mca", ConflictRename)
+			g.Assert(err).IsNil()
+
+			b, err := os.ReadFile(filepath.Join(rfs.root, "server/world/region/r.0.0 copy.mca"))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("level")
+		})
+
+		g.It("should not follow symlinks", func() {
+			err := os.Symlink("/etc", filepath.Join(rfs.root, "server/world/etc"))
+			g.Assert(err).IsNil()
+
+			err = fs.CopyTo(context.Background(), "world", "world2", ConflictFail)
+			g.Assert(err).IsNil()
+
+			_, err = os.Lstat(filepath.Join(rfs.root, "server/world2/etc"))
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("should stop copying if the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := fs.CopyTo(ctx, "world", "world2", ConflictFail)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue("err is not context.Canceled")
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+			fs.SetDiskLimit(0)
+		})
+	})
+}
+
+func TestFilesystem_MoveTo(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("MoveTo", func() {
+		g.BeforeEach(func() {
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/plugins/example"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/disabled/example"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("plugins/example/config.yml", "new"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("disabled/example/config.yml", "old"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("plugins/example/data.yml", "data"); err != nil {
+				panic(err)
+			}
+		})
+
+		g.It("should move a directory to a different parent directory", func() {
+			err := fs.MoveTo(context.Background(), "plugins/example", "archive/example", ConflictFail)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("archive/example/data.yml")
+			g.Assert(err).IsNil()
+			_, err = rfs.StatServerFile("plugins/example")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("should merge directories and leave skipped files behind", func() {
+			err := fs.MoveTo(context.Background(), "plugins/example", "disabled/example", ConflictSkip)
+			g.Assert(err).IsNil()
+
+			b, _ := os.ReadFile(filepath.Join(rfs.root, "server/disabled/example/config.yml"))
+			g.Assert(string(b)).Equal("old")
+			_, err = rfs.StatServerFile("disabled/example/data.yml")
+			g.Assert(err).IsNil()
+			_, err = rfs.StatServerFile("plugins/example/config.yml")
+			g.Assert(err).IsNil()
+		})
+
+		g.It("should merge directories and replace existing files", func() {
+			err := fs.MoveTo(context.Background(), "plugins/example", "disabled/example", ConflictOverwrite)
+			g.Assert(err).IsNil()
+
+			b, _ := os.ReadFile(filepath.Join(rfs.root, "server/disabled/example/config.yml"))
+			g.Assert(string(b)).Equal("new")
+			_, err = rfs.StatServerFile("plugins/example")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("should move to a new name if the destination exists", func() {
+			err := fs.MoveTo(context.Background(), "plugins/example", "disabled/example", ConflictRename)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("disabled/example copy/data.yml")
+			g.Assert(err).IsNil()
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
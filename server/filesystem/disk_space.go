@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -126,7 +127,8 @@ func (fs *Filesystem) DiskUsage(allowStaleValue bool) (int64, error) {
 	return fs.unixFS.Usage(), nil
 }
 
-// Updates the currently used disk space for a server.
+// Updates the currently used disk space for a server. This also refreshes the
+// usage tree returned by UsageTree, as both are calculated by the same walk.
 func (fs *Filesystem) updateCachedDiskUsage() (int64, error) {
 	// Obtain an exclusive lock on this process so that we don't unintentionally run it at the same
 	// time as another running process. Once the lock is available it'll read from the cache for the
@@ -134,26 +136,11 @@ func (fs *Filesystem) updateCachedDiskUsage() (int64, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	// Signal that we're currently updating the disk size so that other calls to the disk checking
-	// functions can determine if they should queue up additional calls to this function. Ensure that
-	// we always set this back to "false" when this process is done executing.
-	fs.lookupInProgress.Store(true)
-	defer fs.lookupInProgress.Store(false)
-
 	// If there is no size its either because there is no data (in which case running this function
 	// will have effectively no impact), or there is nothing in the cache, in which case we need to
 	// grab the size of their data directory. This is a taxing operation, so we want to store it in
 	// the cache once we've gotten it.
-	size, err := fs.DirectorySize("/")
-
-	// Always cache the size, even if there is an error. We want to always return that value
-	// so that we don't cause an endless loop of determining the disk size if there is a temporary
-	// error encountered.
-	fs.lastLookupTime.Set(time.Now())
-
-	fs.unixFS.SetUsage(size)
-
-	return size, err
+	return fs.calculateUsage(context.Background())
 }
 
 // DirectorySize calculates the size of a directory and its descendants.
@@ -27,6 +27,7 @@ type Filesystem struct {
 	mu                sync.RWMutex
 	lastLookupTime    *usageLookupTime
 	lookupInProgress  atomic.Bool
+	usage             atomic.Pointer[usageSnapshot]
 	diskCheckInterval time.Duration
 	denylist          *ignore.GitIgnore
 
@@ -196,10 +197,30 @@ func (fs *Filesystem) Rename(oldpath, newpath string) error {
 	return fs.unixFS.Rename(oldpath, newpath)
 }
 
+// RenameReplace renames oldpath to newpath, replacing newpath if it already
+// exists. The size of any file that is replaced is removed from the disk usage
+// of the server.
+func (fs *Filesystem) RenameReplace(oldpath, newpath string) error {
+	var replaced int64
+	if st, err := fs.unixFS.Lstat(newpath); err == nil && st.Mode().IsRegular() {
+		replaced = st.Size()
+	}
+	if err := fs.unixFS.RenameReplace(oldpath, newpath); err != nil {
+		return err
+	}
+	fs.unixFS.Add(-replaced)
+	return nil
+}
+
 func (fs *Filesystem) Symlink(oldpath, newpath string) error {
 	return fs.unixFS.Symlink(oldpath, newpath)
 }
 
+// Link creates newpath as a hard link to the oldpath file.
+func (fs *Filesystem) Link(oldpath, newpath string) error {
+	return fs.unixFS.Link(oldpath, newpath)
+}
+
 func (fs *Filesystem) chownFile(name string) error {
 	if fs.isTest {
 		return nil
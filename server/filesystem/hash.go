@@ -0,0 +1,34 @@
+package filesystem
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/crc32"
+)
+
+// HashAlgorithms are the algorithms that can be used to calculate the checksum
+// of a file on the server, in order of preference.
+var HashAlgorithms = []string{"sha256", "sha512", "sha384", "sha224", "sha1", "md5", "crc32"}
+
+var hashes = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// NewHash returns a new hash for the given algorithm, or false if the algorithm
+// is not one of the supported HashAlgorithms.
+func NewHash(algorithm string) (hash.Hash, bool) {
+	fn, ok := hashes[algorithm]
+	if !ok {
+		return nil, false
+	}
+	return fn(), true
+}
@@ -0,0 +1,260 @@
+package filesystem
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/gabriel-vasile/mimetype"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+// ErrInvalidCursor is returned when a cursor passed to ListDirectoryPage could
+// not be decoded, or was created for a listing with a different sort order.
+const ErrInvalidCursor = errors.Sentinel("filesystem: invalid directory listing cursor")
+
+// ListSort is the field that a directory listing is sorted by.
+type ListSort string
+
+const (
+	ListSortName     ListSort = "name"
+	ListSortSize     ListSort = "size"
+	ListSortModified ListSort = "modified"
+)
+
+// Valid returns true if the sort is one that is supported.
+func (s ListSort) Valid() bool {
+	return s == ListSortName || s == ListSortSize || s == ListSortModified
+}
+
+const (
+	// DefaultListLimit is the number of entries returned in a page of a directory
+	// listing when no limit is provided.
+	DefaultListLimit = 100
+	// MaxListLimit is the largest number of entries that can be returned in a
+	// single page of a directory listing.
+	MaxListLimit = 1000
+)
+
+// ListOptions controls which entries of a directory are returned by
+// ListDirectoryPage, and the order they are returned in.
+type ListOptions struct {
+	// Cursor is the cursor returned with the previous page. If empty the first
+	// page is returned.
+	Cursor string
+	// Limit is the maximum number of entries to return.
+	Limit int
+	// Sort is the field entries are sorted by, after directories which are
+	// always returned first.
+	Sort ListSort
+	// Descending reverses the order the entries are sorted in.
+	Descending bool
+	// Filter only returns entries that contain the value in their name, ignoring
+	// the case of the name.
+	Filter string
+	// SkipMimetype disables reading the start of each file to detect its type,
+	// in which case the mimetype is left empty for regular files.
+	SkipMimetype bool
+}
+
+// DirectoryPage is a single page of entries from a directory listing.
+type DirectoryPage struct {
+	Entries []Stat `json:"entries"`
+	// Total is the number of entries in the directory that match the filter.
+	Total int `json:"total"`
+	// NextCursor is the cursor used to fetch the next page, which is empty if
+	// this is the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listEntry is the information about a directory entry used to sort it. The
+// key is only set when not sorting by name.
+type listEntry struct {
+	name string
+	dir  bool
+	key  int64
+	info ufs.FileInfo
+}
+
+// listCursor is the position of the last entry on a page, encoded into the
+// cursor returned to the caller. As entries are compared against the cursor,
+// rather than skipping a number of entries, files being created or deleted do
+// not cause entries to be skipped or repeated between pages.
+type listCursor struct {
+	Sort       ListSort `json:"s"`
+	Descending bool     `json:"r,omitempty"`
+	Dir        bool     `json:"d,omitempty"`
+	Key        int64    `json:"k,omitempty"`
+	Name       string   `json:"n"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ListDirectoryPage returns a single page of the entries in a directory. The
+// directory is streamed rather than read all at once, only the entries that
+// are after the cursor are kept, and mimetypes are only detected for the
+// entries that are returned, which allows directories containing a very large
+// number of files to be listed.
+func (fs *Filesystem) ListDirectoryPage(p string, opts ListOptions) (*DirectoryPage, error) {
+	if opts.Sort == "" {
+		opts.Sort = ListSortName
+	}
+	if !opts.Sort.Valid() {
+		return nil, errors.New("filesystem: invalid directory listing sort")
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	opts.Limit = min(opts.Limit, MaxListLimit)
+
+	var cursor *listEntry
+	if opts.Cursor != "" {
+		c, err := decodeListCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != opts.Sort || c.Descending != opts.Descending {
+			return nil, ErrInvalidCursor
+		}
+		cursor = &listEntry{name: c.Name, dir: c.Dir, key: c.Key}
+	}
+
+	compare := func(a, b listEntry) int {
+		if a.dir != b.dir {
+			if a.dir {
+				return -1
+			}
+			return 1
+		}
+		var v int
+		if a.key != b.key {
+			v = 1
+			if a.key < b.key {
+				v = -1
+			}
+		} else if opts.Sort == ListSortName {
+			v = strings.Compare(a.name, b.name)
+		}
+		if opts.Descending {
+			v = -v
+		}
+		if v == 0 {
+			v = strings.Compare(a.name, b.name)
+		}
+		return v
+	}
+
+	filter := strings.ToLower(opts.Filter)
+	page := &DirectoryPage{Entries: []Stat{}}
+	var out []listEntry
+	err := fs.unixFS.ReadDirFunc(p, func(e ufs.DirEntry) error {
+		if filter != "" && !strings.Contains(strings.ToLower(e.Name()), filter) {
+			return nil
+		}
+		entry := listEntry{name: e.Name(), dir: e.IsDir()}
+		if opts.Sort != ListSortName {
+			info, err := e.Info()
+			if err != nil {
+				// The file was removed while the directory was being read.
+				if errors.Is(err, ufs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			entry.info = info
+			if opts.Sort == ListSortSize {
+				entry.key = info.Size()
+			} else {
+				entry.key = info.ModTime().UnixNano()
+			}
+		}
+		page.Total++
+		if cursor != nil && compare(entry, *cursor) <= 0 {
+			return nil
+		}
+		out = append(out, entry)
+		// Only the entries that will be on this page need to be kept, so every so
+		// often the entries are sorted and the rest are thrown away.
+		if len(out) >= (opts.Limit+1)*4 {
+			slices.SortFunc(out, compare)
+			out = out[:opts.Limit+1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(out, compare)
+	if len(out) > opts.Limit {
+		out = out[:opts.Limit]
+		last := out[len(out)-1]
+		page.NextCursor = listCursor{
+			Sort:       opts.Sort,
+			Descending: opts.Descending,
+			Dir:        last.dir,
+			Key:        last.key,
+			Name:       last.name,
+		}.encode()
+	}
+
+	for _, e := range out {
+		name := filepath.Join(p, e.name)
+		info := e.info
+		if info == nil {
+			info, err = fs.unixFS.Lstat(name)
+			if err != nil {
+				if errors.Is(err, ufs.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+		}
+		st := Stat{FileInfo: info}
+		switch {
+		case info.IsDir():
+			st.Mimetype = "inode/directory"
+		case !info.Mode().IsRegular():
+			st.Mimetype = "application/octet-stream"
+		case !opts.SkipMimetype:
+			st.Mimetype = fs.detectMimetype(name)
+		}
+		page.Entries = append(page.Entries, st)
+	}
+	return page, nil
+}
+
+// detectMimetype returns the mimetype of a file based on its contents.
+func (fs *Filesystem) detectMimetype(name string) string {
+	f, err := fs.unixFS.OpenFile(name, ufs.O_RDONLY|ufs.O_NOFOLLOW, 0)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	m, err := mimetype.DetectReader(f)
+	if err != nil {
+		log.Error(err.Error())
+		return "application/octet-stream"
+	}
+	return m.String()
+}
@@ -0,0 +1,89 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_ListDirectoryPage(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	names := func(p *DirectoryPage) []string {
+		out := make([]string, len(p.Entries))
+		for i, e := range p.Entries {
+			out[i] = e.Name()
+		}
+		return out
+	}
+
+	g.Describe("ListDirectoryPage", func() {
+		g.BeforeEach(func() {
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/region"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/region/zz"), 0o755); err != nil {
+				panic(err)
+			}
+			for i := 0; i < 5; i++ {
+				n := "r." + strconv.Itoa(i) + ".mca"
+				if err := rfs.CreateServerFileFromString("region/"+n, n[:i+1]); err != nil {
+					panic(err)
+				}
+			}
+		})
+
+		g.It("should return directories first and then files by name", func() {
+			p, err := fs.ListDirectoryPage("region", ListOptions{})
+			g.Assert(err).IsNil()
+			g.Assert(names(p)).Equal([]string{"zz", "r.0.mca", "r.1.mca", "r.2.mca", "r.3.mca", "r.4.mca"})
+			g.Assert(p.Total).Equal(6)
+			g.Assert(p.NextCursor).Equal("")
+			g.Assert(p.Entries[0].Mimetype).Equal("inode/directory")
+			g.Assert(p.Entries[1].Mimetype).Equal("text/plain; charset=utf-8")
+		})
+
+		g.It("should return every entry once when paginating", func() {
+			var seen []string
+			opts := ListOptions{Limit: 2, Sort: ListSortSize, Descending: true}
+			for {
+				p, err := fs.ListDirectoryPage("region", opts)
+				g.Assert(err).IsNil()
+				seen = append(seen, names(p)...)
+				if p.NextCursor == "" {
+					break
+				}
+				opts.Cursor = p.NextCursor
+			}
+			g.Assert(seen).Equal([]string{"zz", "r.4.mca", "r.3.mca", "r.2.mca", "r.1.mca", "r.0.mca"})
+		})
+
+		g.It("should filter entries by name", func() {
+			p, err := fs.ListDirectoryPage("region", ListOptions{Filter: "R.3", SkipMimetype: true})
+			g.Assert(err).IsNil()
+			g.Assert(names(p)).Equal([]string{"r.3.mca"})
+			g.Assert(p.Total).Equal(1)
+			g.Assert(p.Entries[0].Mimetype).Equal("")
+		})
+
+		g.It("should return an error for a cursor from a different sort", func() {
+			p, err := fs.ListDirectoryPage("region", ListOptions{Limit: 1})
+			g.Assert(err).IsNil()
+
+			_, err = fs.ListDirectoryPage("region", ListOptions{Limit: 1, Sort: ListSortModified, Cursor: p.NextCursor})
+			g.Assert(errors.Is(err, ErrInvalidCursor)).IsTrue("err is not ErrInvalidCursor")
+
+			_, err = fs.ListDirectoryPage("region", ListOptions{Cursor: "not a cursor"})
+			g.Assert(errors.Is(err, ErrInvalidCursor)).IsTrue("err is not ErrInvalidCursor")
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
@@ -0,0 +1,284 @@
+package filesystem
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+// usageMaxFiles is the number of the largest files kept for each directory in
+// the usage tree. Smaller files are still included in the size of the directory.
+const usageMaxFiles = 100
+
+// usageMaxDepth is the number of levels of directories kept in the usage tree.
+// Anything nested deeper is included in the size of the deepest directory kept.
+const usageMaxDepth = 16
+
+// UsageNode is a file or directory in the disk usage tree of a server. The size
+// of a directory includes the size of everything inside it.
+type UsageNode struct {
+	Name      string       `json:"name"`
+	Directory bool         `json:"directory"`
+	Size      int64        `json:"size"`
+	Files     int64        `json:"files,omitempty"`
+	Children  []*UsageNode `json:"children,omitempty"`
+}
+
+// usageSnapshot is the most recently calculated usage tree for a filesystem.
+type usageSnapshot struct {
+	root    *UsageNode
+	updated time.Time
+}
+
+var (
+	usageWalkersOnce sync.Once
+	usageWalkers     chan struct{}
+)
+
+// acquireUsageWalker waits until the usage tree of another server is allowed to
+// be calculated, which limits how many servers are walking their files for the
+// API at the same time across the node. The returned function must be called
+// once the walk has finished.
+func acquireUsageWalker(ctx context.Context) (func(), error) {
+	usageWalkersOnce.Do(func() {
+		if n := config.Get().System.DiskUsageConcurrency; n > 0 {
+			usageWalkers = make(chan struct{}, n)
+		}
+	})
+	if usageWalkers == nil {
+		return func() {}, nil
+	}
+	select {
+	case usageWalkers <- struct{}{}:
+		return func() { <-usageWalkers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// UsageTree returns the disk usage of a directory and the files and directories
+// inside it, largest first, along with the time the usage was calculated. Only
+// depth levels of the tree are returned, with at most limit children at each
+// level. The tree is cached, and is only calculated again once it is older than
+// the disk check interval. Directories nested deeper than usageMaxDepth are not
+// kept in the tree, so are reported as not existing.
+func (fs *Filesystem) UsageTree(ctx context.Context, dir string, depth, limit int) (*UsageNode, time.Time, error) {
+	snap, err := fs.refreshUsageTree(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	node := snap.root
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+dir), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var next *UsageNode
+		for _, c := range node.Children {
+			if c.Directory && c.Name == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil, time.Time{}, &ufs.PathError{Op: "usage", Path: dir, Err: ufs.ErrNotExist}
+		}
+		node = next
+	}
+	return node.prune(depth, limit), snap.updated, nil
+}
+
+// prune returns a copy of the node with only depth levels of children, and at
+// most limit children at each level.
+func (n *UsageNode) prune(depth, limit int) *UsageNode {
+	out := &UsageNode{Name: n.Name, Directory: n.Directory, Size: n.Size, Files: n.Files}
+	if depth <= 0 {
+		return out
+	}
+	for _, c := range n.Children[:min(len(n.Children), limit)] {
+		out.Children = append(out.Children, c.prune(depth-1, limit))
+	}
+	return out
+}
+
+// refreshUsageTree returns the cached usage tree, calculating it again if it
+// is missing or out of date.
+func (fs *Filesystem) refreshUsageTree(ctx context.Context) (*usageSnapshot, error) {
+	maxAge := time.Second * fs.diskCheckInterval
+	if maxAge <= 0 {
+		maxAge = time.Minute
+	}
+	fresh := func() *usageSnapshot {
+		if snap := fs.usage.Load(); snap != nil && time.Since(snap.updated) < maxAge {
+			return snap
+		}
+		return nil
+	}
+	if snap := fresh(); snap != nil {
+		return snap, nil
+	}
+
+	// Only these walks are limited across the node, since the periodic checks
+	// of the disk usage are needed to enforce the disk space limit of servers.
+	release, err := acquireUsageWalker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// Another caller may have calculated the usage while waiting for the lock.
+	if snap := fresh(); snap != nil {
+		return snap, nil
+	}
+	if _, err := fs.calculateUsage(ctx); err != nil {
+		return nil, err
+	}
+	return fs.usage.Load(), nil
+}
+
+// calculateUsage walks the filesystem to build the usage tree, and updates the
+// cached disk usage for the filesystem. The caller must hold the filesystem
+// lock. If the walk is canceled nothing is updated.
+func (fs *Filesystem) calculateUsage(ctx context.Context) (int64, error) {
+	fs.lookupInProgress.Store(true)
+	defer fs.lookupInProgress.Store(false)
+
+	root, err := fs.walkUsage(ctx)
+	if ctx.Err() != nil {
+		return fs.unixFS.Usage(), ctx.Err()
+	}
+
+	// Always cache the size, even if there is an error. We want to always return that value
+	// so that we don't cause an endless loop of determining the disk size if there is a temporary
+	// error encountered.
+	now := time.Now()
+	fs.lastLookupTime.Set(now)
+	fs.unixFS.SetUsage(root.Size)
+	if err == nil {
+		fs.usage.Store(&usageSnapshot{root: root, updated: now})
+	}
+	return root.Size, err
+}
+
+// walkUsage walks every file in the filesystem and builds the usage tree. If an
+// error is encountered the tree built so far is returned with the error.
+func (fs *Filesystem) walkUsage(ctx context.Context) (*UsageNode, error) {
+	root := &UsageNode{Name: "/", Directory: true}
+
+	dirfd, rootName, closeFd, err := fs.unixFS.SafePath("/")
+	defer closeFd()
+	if err != nil {
+		return root, err
+	}
+
+	type usageDir struct {
+		node  *UsageNode
+		depth int
+	}
+	dirs := map[string]usageDir{rootName: {node: root}}
+	err = fs.unixFS.WalkDirat(dirfd, rootName, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "walkdirat err")
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if relative == rootName {
+			return nil
+		}
+		parent, ok := dirs[path.Dir(relative)]
+		if d.IsDir() {
+			if !ok {
+				return nil
+			}
+			// Directories that are nested too deeply are counted towards the
+			// deepest directory kept, rather than being given a node.
+			if parent.depth >= usageMaxDepth {
+				dirs[relative] = usageDir{node: parent.node, depth: parent.depth + 1}
+				return nil
+			}
+			n := &UsageNode{Name: d.Name(), Directory: true}
+			parent.node.Children = append(parent.node.Children, n)
+			dirs[relative] = usageDir{node: n, depth: parent.depth + 1}
+			return nil
+		}
+
+		// Only calculate the size of regular files.
+		if !d.Type().IsRegular() || !ok {
+			return nil
+		}
+		info, err := fs.unixFS.Lstatat(dirfd, name)
+		if err != nil {
+			return errors.Wrap(err, "lstatat err")
+		}
+
+		// TODO: detect if info is a hard-link and de-duplicate it.
+		// ref; https://github.com/pterodactyl/wings/pull/181/files
+
+		parent.node.Size += info.Size()
+		parent.node.Files++
+		if parent.depth > usageMaxDepth {
+			return nil
+		}
+		parent.node.Children = append(parent.node.Children, &UsageNode{Name: d.Name(), Size: info.Size()})
+		// Only the largest files in each directory are kept, so every so often
+		// the smaller files are thrown away.
+		if len(parent.node.Children) >= usageMaxFiles*4 {
+			parent.node.Children = trimUsageFiles(parent.node.Children)
+		}
+		return nil
+	})
+	root.total()
+	return root, errors.WrapIf(err, "server/filesystem: usage: failed to walk directory")
+}
+
+// total adds the size and number of files of every directory inside the node
+// to the node, sorts the children largest first, and drops all but the largest
+// files.
+func (n *UsageNode) total() {
+	for _, c := range n.Children {
+		if c.Directory {
+			c.total()
+			n.Size += c.Size
+			n.Files += c.Files
+		}
+	}
+	n.Children = trimUsageFiles(n.Children)
+}
+
+// trimUsageFiles sorts the nodes largest first and removes all but the largest
+// files, keeping every directory.
+func trimUsageFiles(nodes []*UsageNode) []*UsageNode {
+	slices.SortStableFunc(nodes, func(a, b *UsageNode) int {
+		switch {
+		case a.Size > b.Size:
+			return -1
+		case a.Size < b.Size:
+			return 1
+		default:
+			return strings.Compare(a.Name, b.Name)
+		}
+	})
+	out := nodes[:0]
+	var files int
+	for _, n := range nodes {
+		if !n.Directory {
+			if files >= usageMaxFiles {
+				continue
+			}
+			files++
+		}
+		out = append(out, n)
+	}
+	return out
+}
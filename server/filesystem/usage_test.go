@@ -0,0 +1,137 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_UsageTree(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("UsageTree", func() {
+		g.BeforeEach(func() {
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/world/region"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/logs"), 0o755); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("world/region/r.0.0.mca", "regionregion"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("world/level.dat", "level"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("logs/latest.log", "log"); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString("server.jar", "jarjar"); err != nil {
+				panic(err)
+			}
+			fs.usage.Store(nil)
+		})
+
+		g.It("should return the largest entries first", func() {
+			tree, _, err := fs.UsageTree(context.Background(), "/", 2, 10)
+			g.Assert(err).IsNil()
+			g.Assert(tree.Size).Equal(int64(26))
+			g.Assert(tree.Files).Equal(int64(4))
+			g.Assert(len(tree.Children)).Equal(3)
+			g.Assert(tree.Children[0].Name).Equal("world")
+			g.Assert(tree.Children[0].Size).Equal(int64(17))
+			g.Assert(tree.Children[0].Children[0].Name).Equal("region")
+			g.Assert(tree.Children[0].Children[0].Children == nil).IsTrue()
+			g.Assert(tree.Children[1].Name).Equal("server.jar")
+			g.Assert(fs.CachedUsage()).Equal(int64(26))
+		})
+
+		g.It("should return a subdirectory limited to the requested children", func() {
+			tree, _, err := fs.UsageTree(context.Background(), "world", 1, 1)
+			g.Assert(err).IsNil()
+			g.Assert(tree.Name).Equal("world")
+			g.Assert(len(tree.Children)).Equal(1)
+			g.Assert(tree.Children[0].Name).Equal("region")
+		})
+
+		g.It("should return an error for a missing directory", func() {
+			_, _, err := fs.UsageTree(context.Background(), "missing", 1, 10)
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue("err is not ErrNotExist")
+		})
+
+		g.It("should not update the usage if canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, _, err := fs.UsageTree(ctx, "/", 1, 10)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue("err is not context.Canceled")
+			g.Assert(fs.usage.Load() == nil).IsTrue()
+		})
+
+		g.It("should not keep directories nested too deeply", func() {
+			// The world directory is the first level, so this is the deepest level
+			// kept in the tree.
+			last := "world"
+			for i := 1; i < usageMaxDepth; i++ {
+				last = filepath.Join(last, fmt.Sprintf("d%d", i))
+			}
+			deep := filepath.Join(last, "a", "b")
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server", deep), 0o755); err != nil {
+				panic(err)
+			}
+			if err := rfs.CreateServerFileFromString(filepath.Join(deep, "deep.txt"), "deep"); err != nil {
+				panic(err)
+			}
+
+			tree, _, err := fs.UsageTree(context.Background(), "/", 1, 10)
+			g.Assert(err).IsNil()
+			g.Assert(tree.Size).Equal(int64(30))
+			g.Assert(tree.Files).Equal(int64(5))
+
+			// The deepest directory kept includes everything nested inside it.
+			tree, _, err = fs.UsageTree(context.Background(), last, 10, 10)
+			g.Assert(err).IsNil()
+			g.Assert(tree.Size).Equal(int64(4))
+			g.Assert(tree.Files).Equal(int64(1))
+			g.Assert(tree.Children == nil).IsTrue()
+
+			_, _, err = fs.UsageTree(context.Background(), filepath.Join(last, "a"), 1, 10)
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue("err is not ErrNotExist")
+		})
+
+		g.It("should only limit the walks for the usage tree", func() {
+			usageWalkersOnce.Do(func() {})
+			usageWalkers = make(chan struct{}, 1)
+			usageWalkers <- struct{}{}
+			defer func() {
+				usageWalkers = nil
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+			defer cancel()
+			_, _, err := fs.UsageTree(ctx, "/", 1, 10)
+			g.Assert(errors.Is(err, context.DeadlineExceeded)).IsTrue("err is not context.DeadlineExceeded")
+
+			size, err := fs.updateCachedDiskUsage()
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(26))
+
+			<-usageWalkers
+			tree, _, err := fs.UsageTree(context.Background(), "/", 1, 10)
+			g.Assert(err).IsNil()
+			g.Assert(tree.Size).Equal(int64(26))
+			g.Assert(len(usageWalkers)).Equal(0)
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
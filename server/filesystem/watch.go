@@ -0,0 +1,316 @@
+package filesystem
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+// ErrWatchLimit is returned when watching a directory would exceed the maximum
+// number of directories that can be watched for a server.
+const ErrWatchLimit = errors.Sentinel("filesystem: too many directories are being watched")
+
+// How long changes are collected for before they are sent out, which avoids a
+// flood of events when a file is written to repeatedly.
+const watchDebounce = time.Millisecond * 500
+
+// The events that are watched for. The watch is added through the file
+// descriptor of a directory that has already been opened safely, so the link in
+// /proc must be followed to reach it.
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// The actions that are reported for a changed file.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change is a single file that has been changed within a watched directory.
+type Change struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// Watcher watches directories in a server's filesystem for changes using
+// inotify. Directories are watched for as long as there is at least one
+// subscription that includes them, and changes are collected and passed to the
+// callback for each directory.
+type Watcher struct {
+	fs       *Filesystem
+	file     *os.File
+	limit    int
+	onChange func(dir string, changes []Change)
+
+	mu        sync.Mutex
+	byWd      map[int]string
+	byPath    map[string]int
+	subs      map[string]int
+	recursive map[string]int
+	pending   map[string]map[string]string
+	timer     *time.Timer
+}
+
+// NewWatcher creates a new watcher for the filesystem that will watch at most
+// limit directories at once.
+func (fs *Filesystem) NewWatcher(limit int, onChange func(dir string, changes []Change)) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "filesystem: failed to initialize inotify")
+	}
+	w := &Watcher{
+		fs: fs,
+		// The file descriptor is non-blocking so reads go through the runtime poller,
+		// which allows the read loop to be stopped by closing the file.
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		limit:     limit,
+		onChange:  onChange,
+		byWd:      make(map[int]string),
+		byPath:    make(map[string]int),
+		subs:      make(map[string]int),
+		recursive: make(map[string]int),
+		pending:   make(map[string]map[string]string),
+	}
+	go w.read()
+	return w, nil
+}
+
+// Watch starts watching a directory for changes, and if recursive is true, all
+// the directories inside it as well. The returned function must be called once
+// the changes are no longer needed.
+func (w *Watcher) Watch(dir string, recursive bool) (func(), error) {
+	dir = path.Clean("/" + dir)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subs := w.subs
+	if recursive {
+		subs = w.recursive
+	}
+	subs[dir]++
+	var err error
+	if recursive {
+		err = w.addRecursive(dir)
+	} else {
+		err = w.add(dir)
+	}
+	if err != nil {
+		w.unsubscribe(subs, dir)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.unsubscribe(subs, dir)
+		})
+	}, nil
+}
+
+// unsubscribe removes a subscription to a directory and stops watching anything
+// that is no longer needed. The caller must hold the lock.
+func (w *Watcher) unsubscribe(subs map[string]int, dir string) {
+	if subs[dir]--; subs[dir] <= 0 {
+		delete(subs, dir)
+	}
+	w.prune()
+}
+
+// Watching returns the number of directories currently being watched.
+func (w *Watcher) Watching() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.byPath)
+}
+
+// Close stops watching all directories.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.file.Close()
+}
+
+// add starts watching a single directory. The directory is opened using the
+// openat based filesystem so that it is never resolved outside the server root,
+// and the watch is then added using the file descriptor. The caller must hold
+// the lock.
+func (w *Watcher) add(dir string) error {
+	if _, ok := w.byPath[dir]; ok {
+		return nil
+	}
+	if len(w.byPath) >= w.limit {
+		return ErrWatchLimit
+	}
+	f, err := w.fs.unixFS.OpenFile(dir, ufs.O_RDONLY|ufs.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return errors.New("filesystem: cannot watch directory")
+	}
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), "/proc/self/fd/"+strconv.Itoa(int(fd.Fd())), watchMask)
+	if err != nil {
+		return errors.Wrap(err, "filesystem: failed to watch directory")
+	}
+	// The same directory may already be watched under a different path if it was
+	// moved, in which case the watch descriptor is reused.
+	if old, ok := w.byWd[wd]; ok {
+		delete(w.byPath, old)
+	}
+	w.byWd[wd] = dir
+	w.byPath[dir] = wd
+	return nil
+}
+
+// addRecursive watches a directory and every directory inside of it. The caller
+// must hold the lock.
+func (w *Watcher) addRecursive(dir string) error {
+	if err := w.add(dir); err != nil {
+		return err
+	}
+	entries, err := w.fs.unixFS.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			if err := w.addRecursive(path.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// covered returns true if there is a subscription that includes the directory.
+// The caller must hold the lock.
+func (w *Watcher) covered(dir string) bool {
+	if w.subs[dir] > 0 {
+		return true
+	}
+	for r := range w.recursive {
+		if r == "/" || dir == r || strings.HasPrefix(dir, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// prune stops watching any directories that are no longer included in any of
+// the subscriptions. The caller must hold the lock.
+func (w *Watcher) prune() {
+	for dir, wd := range w.byPath {
+		if !w.covered(dir) {
+			_, _ = unix.InotifyRmWatch(int(w.file.Fd()), uint32(wd))
+			delete(w.byPath, dir)
+			delete(w.byWd, wd)
+		}
+	}
+}
+
+// read reads events from inotify until the watcher is closed.
+func (w *Watcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		w.mu.Lock()
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := strings.TrimRight(string(buf[off+unix.SizeofInotifyEvent:off+unix.SizeofInotifyEvent+int(ev.Len)]), "\x00")
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+			w.handle(int(ev.Wd), ev.Mask, name)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// handle records a single inotify event. The caller must hold the lock.
+func (w *Watcher) handle(wd int, mask uint32, name string) {
+	dir, ok := w.byWd[wd]
+	if !ok {
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		// The directory was removed, or is no longer being watched.
+		delete(w.byWd, wd)
+		if w.byPath[dir] == wd {
+			delete(w.byPath, dir)
+		}
+		return
+	}
+	if name == "" {
+		return
+	}
+
+	var action string
+	switch {
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		action = ChangeCreated
+		// Start watching new directories that are inside a recursive watch.
+		if mask&unix.IN_ISDIR != 0 {
+			if p := path.Join(dir, name); w.covered(p) {
+				_ = w.addRecursive(p)
+			}
+		}
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		action = ChangeDeleted
+		if mask&unix.IN_ISDIR != 0 {
+			p := path.Join(dir, name)
+			if wd, ok := w.byPath[p]; ok {
+				delete(w.byPath, p)
+				delete(w.byWd, wd)
+			}
+		}
+	default:
+		action = ChangeModified
+	}
+
+	if w.pending[dir] == nil {
+		w.pending[dir] = make(map[string]string)
+	}
+	// A file that was created and then modified before the changes are sent out
+	// is still reported as created.
+	if prev := w.pending[dir][name]; prev != ChangeCreated || action == ChangeDeleted {
+		w.pending[dir][name] = action
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(watchDebounce, w.flush)
+	}
+}
+
+// flush sends out all the changes collected since the last flush.
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]map[string]string)
+	w.timer = nil
+	w.mu.Unlock()
+
+	for dir, files := range pending {
+		changes := make([]Change, 0, len(files))
+		for name, action := range files {
+			changes = append(changes, Change{Name: name, Action: action})
+		}
+		w.onChange(dir, changes)
+	}
+}
@@ -0,0 +1,102 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_Watcher(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("Watcher", func() {
+		var mu sync.Mutex
+		var changes map[string][]Change
+		var w *Watcher
+
+		received := func(dir string) []Change {
+			for i := 0; i < 40; i++ {
+				mu.Lock()
+				c, ok := changes[dir]
+				mu.Unlock()
+				if ok {
+					return c
+				}
+				time.Sleep(time.Millisecond * 50)
+			}
+			return nil
+		}
+
+		g.BeforeEach(func() {
+			if err := os.MkdirAll(filepath.Join(rfs.root, "server/world/region"), 0o755); err != nil {
+				panic(err)
+			}
+			changes = make(map[string][]Change)
+			var err error
+			w, err = fs.NewWatcher(2, func(dir string, c []Change) {
+				mu.Lock()
+				changes[dir] = append(changes[dir], c...)
+				mu.Unlock()
+			})
+			if err != nil {
+				panic(err)
+			}
+		})
+
+		g.It("should report files created in a watched directory", func() {
+			_, err := w.Watch("world", false)
+			g.Assert(err).IsNil()
+
+			err = rfs.CreateServerFileFromString("world/level.dat", "level")
+			g.Assert(err).IsNil()
+
+			g.Assert(received("/world")).Equal([]Change{{Name: "level.dat", Action: ChangeCreated}})
+		})
+
+		g.It("should report changes in subdirectories of a recursive watch", func() {
+			_, err := w.Watch("world", true)
+			g.Assert(err).IsNil()
+			g.Assert(w.Watching()).Equal(2)
+
+			err = rfs.CreateServerFileFromString("world/region/r.0.0.mca", "region")
+			g.Assert(err).IsNil()
+
+			g.Assert(received("/world/region")).Equal([]Change{{Name: "r.0.0.mca", Action: ChangeCreated}})
+		})
+
+		g.It("should return an error if too many directories are watched", func() {
+			err := os.MkdirAll(filepath.Join(rfs.root, "server/world/data"), 0o755)
+			g.Assert(err).IsNil()
+
+			_, err = w.Watch("world", true)
+			g.Assert(errors.Is(err, ErrWatchLimit)).IsTrue("err is not ErrWatchLimit")
+			g.Assert(w.Watching()).Equal(0)
+		})
+
+		g.It("should stop watching once the watch is released", func() {
+			release, err := w.Watch("world", false)
+			g.Assert(err).IsNil()
+			release()
+			g.Assert(w.Watching()).Equal(0)
+		})
+
+		g.It("should not watch directories outside the root", func() {
+			err := os.Symlink("/etc", filepath.Join(rfs.root, "server/etc"))
+			g.Assert(err).IsNil()
+
+			_, err = w.Watch("etc", false)
+			g.Assert(err).IsNotNil()
+		})
+
+		g.AfterEach(func() {
+			_ = w.Close()
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
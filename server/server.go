@@ -70,6 +70,11 @@ type Server struct {
 	wsBag       *WebsocketBag
 	wsBagLocker sync.Mutex
 
+	// Watches the server's files for changes while websockets are subscribed.
+	watcher     *filesystem.Watcher
+	watchers    int
+	watcherLock sync.Mutex
+
 	sinks map[system.SinkName]*system.SinkPool
 
 	logSink     *system.SinkPool
@@ -89,7 +94,7 @@ func New(client remote.Client) (*Server, error) {
 		restoring:    system.NewAtomicBool(false),
 		powerLock:    system.NewLocker(),
 		sinks: map[system.SinkName]*system.SinkPool{
-			system.LogSink:     system.NewSinkPool(),
+			system.LogSink:     system.NewSinkPoolWithHistory(config.Get().System.ConsoleHistorySize),
 			system.InstallSink: system.NewSinkPool(),
 		},
 	}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server/filesystem"
+)
+
+// WatchDirectory starts watching a directory in the server's filesystem for
+// changes, which are published as file changed events on the server's event
+// bus. If recursive is true, all the directories inside it are watched as well.
+// The returned function must be called once the changes are no longer needed,
+// and once nothing is being watched the underlying watcher is closed.
+func (s *Server) WatchDirectory(dir string, recursive bool) (func(), error) {
+	limit := config.Get().System.FileWatchLimit
+	if limit <= 0 {
+		return nil, filesystem.ErrWatchLimit
+	}
+
+	s.watcherLock.Lock()
+	defer s.watcherLock.Unlock()
+	if s.watcher == nil {
+		w, err := s.Filesystem().NewWatcher(limit, func(dir string, changes []filesystem.Change) {
+			s.Events().Publish(FileChangedEvent, map[string]interface{}{
+				"directory": dir,
+				"changes":   changes,
+			})
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.watcher = w
+	}
+
+	release, err := s.watcher.Watch(dir, recursive)
+	if err != nil {
+		s.closeWatcher()
+		return nil, err
+	}
+	s.watchers++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			release()
+			s.watcherLock.Lock()
+			defer s.watcherLock.Unlock()
+			s.watchers--
+			s.closeWatcher()
+		})
+	}, nil
+}
+
+// closeWatcher closes the watcher if nothing is being watched anymore. The
+// caller must hold the watcher lock.
+func (s *Server) closeWatcher() {
+	if s.watcher != nil && s.watchers <= 0 {
+		if err := s.watcher.Close(); err != nil {
+			s.Log().WithField("error", err).Warn("failed to close file watcher")
+		}
+		s.watcher = nil
+	}
+}
@@ -0,0 +1,122 @@
+package sftp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+)
+
+type cachedLogin struct {
+	resp    remote.SftpAuthResponse
+	created time.Time
+}
+
+// loginCache is a small, bounded, in-memory cache of successful logins. Entries
+// are keyed by a keyed hash of the username, authentication type and password or
+// public key, so credentials are never stored as-is.
+type loginCache struct {
+	mu      sync.Mutex
+	secret  []byte
+	entries map[string]*cachedLogin
+}
+
+var logins = newLoginCache()
+
+func newLoginCache() *loginCache {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &loginCache{secret: secret, entries: make(map[string]*cachedLogin)}
+}
+
+// InvalidateServer removes all the cached logins for the given server, forcing
+// the next login to be validated by the Panel. This is called whenever the Panel
+// indicates that the permissions for a server have changed.
+func InvalidateServer(uuid string) {
+	logins.invalidate(func(l *cachedLogin) bool {
+		return l.resp.Server == uuid
+	})
+}
+
+// InvalidateUser removes all the cached logins for the given user.
+func InvalidateUser(uuid string) {
+	logins.invalidate(func(l *cachedLogin) bool {
+		return l.resp.User == uuid
+	})
+}
+
+func (c *loginCache) key(r remote.SftpAuthRequest) string {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(r.Type))
+	h.Write([]byte{0})
+	h.Write([]byte(r.User))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Pass))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the cached response for the request if one exists and is younger
+// than the given age.
+func (c *loginCache) get(r remote.SftpAuthRequest, age time.Duration) (remote.SftpAuthResponse, bool) {
+	if age <= 0 {
+		return remote.SftpAuthResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.entries[c.key(r)]
+	if !ok || time.Since(l.created) > age {
+		return remote.SftpAuthResponse{}, false
+	}
+	return l.resp, true
+}
+
+// put caches a successful response from the Panel. If the cache is full any
+// expired entries are removed, followed by the oldest entry if that was not
+// enough to make space.
+func (c *loginCache) put(r remote.SftpAuthRequest, resp remote.SftpAuthResponse) {
+	cfg := config.Get().System.Sftp.CredentialCache
+	if cfg.TTL <= 0 || cfg.Size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= cfg.Size {
+		expiry := time.Duration(max(cfg.TTL, cfg.StaleTTL)) * time.Second
+		var oldest string
+		for k, l := range c.entries {
+			if time.Since(l.created) > expiry {
+				delete(c.entries, k)
+			} else if oldest == "" || l.created.Before(c.entries[oldest].created) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= cfg.Size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[c.key(r)] = &cachedLogin{resp: resp, created: time.Now()}
+}
+
+// remove removes the cached response for the request, if there is one.
+func (c *loginCache) remove(r remote.SftpAuthRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, c.key(r))
+}
+
+func (c *loginCache) invalidate(fn func(l *cachedLogin) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, l := range c.entries {
+		if fn(l) {
+			delete(c.entries, k)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+	"github.com/pterodactyl/wings/server"
+)
+
+// testPanel is a Panel client that only implements validating SFTP credentials,
+// returning the configured response and counting how many times it was called.
+type testPanel struct {
+	remote.Client
+	calls int
+	resp  remote.SftpAuthResponse
+	err   error
+}
+
+func (p *testPanel) ValidateSftpCredentials(_ context.Context, _ remote.SftpAuthRequest) (remote.SftpAuthResponse, error) {
+	p.calls++
+	return p.resp, p.err
+}
+
+type testConnMetadata struct {
+	user string
+}
+
+func (c testConnMetadata) User() string          { return c.user }
+func (c testConnMetadata) SessionID() []byte     { return []byte("session") }
+func (c testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (c testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-wings") }
+func (c testConnMetadata) RemoteAddr() net.Addr  { return &net.TCPAddr{IP: net.ParseIP("203.0.113.10"), Port: 4000} }
+func (c testConnMetadata) LocalAddr() net.Addr   { return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2022} }
+
+// ageLogins moves the creation time of every cached login back by the given
+// duration.
+func ageLogins(d time.Duration) {
+	for _, l := range logins.entries {
+		l.created = l.created.Add(-d)
+	}
+}
+
+func loginRequest(user string, pass string) remote.SftpAuthRequest {
+	return remote.SftpAuthRequest{Type: remote.SftpAuthPassword, User: user, Pass: pass}
+}
+
+func TestLoginCache(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("loginCache", func() {
+		g.BeforeEach(func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {})
+			logins = newLoginCache()
+		})
+
+		g.It("returns logins younger than the given age", func() {
+			r := loginRequest("user.abcd1234", "password")
+			logins.put(r, remote.SftpAuthResponse{Server: "server", User: "user"})
+
+			resp, ok := logins.get(r, time.Minute)
+			g.Assert(ok).IsTrue()
+			g.Assert(resp.Server).Equal("server")
+
+			_, ok = logins.get(r, 0)
+			g.Assert(ok).IsFalse()
+			_, ok = logins.get(loginRequest("user.abcd1234", "other"), time.Minute)
+			g.Assert(ok).IsFalse()
+			_, ok = logins.get(remote.SftpAuthRequest{Type: remote.SftpAuthPublicKey, User: "user.abcd1234", Pass: "password"}, time.Minute)
+			g.Assert(ok).IsFalse()
+
+			ageLogins(2 * time.Minute)
+			_, ok = logins.get(r, time.Minute)
+			g.Assert(ok).IsFalse()
+			_, ok = logins.get(r, 5*time.Minute)
+			g.Assert(ok).IsTrue()
+		})
+
+		g.It("does not cache logins when disabled", func() {
+			for _, fn := range []func(c *config.SftpConfiguration){
+				func(c *config.SftpConfiguration) { c.CredentialCache.TTL = 0 },
+				func(c *config.SftpConfiguration) { c.CredentialCache.Size = 0 },
+			} {
+				setSftpConfig(fn)
+				logins.put(loginRequest("user.abcd1234", "password"), remote.SftpAuthResponse{})
+				g.Assert(len(logins.entries)).Equal(0)
+			}
+		})
+
+		g.It("removes the oldest login once full", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.CredentialCache.Size = 2
+			})
+			a, b, c := loginRequest("a.abcd1234", "a"), loginRequest("b.abcd1234", "b"), loginRequest("c.abcd1234", "c")
+			logins.put(a, remote.SftpAuthResponse{})
+			ageLogins(time.Second)
+			logins.put(b, remote.SftpAuthResponse{})
+			logins.put(c, remote.SftpAuthResponse{})
+
+			g.Assert(len(logins.entries)).Equal(2)
+			_, ok := logins.get(a, time.Hour)
+			g.Assert(ok).IsFalse()
+			_, ok = logins.get(b, time.Hour)
+			g.Assert(ok).IsTrue()
+			_, ok = logins.get(c, time.Hour)
+			g.Assert(ok).IsTrue()
+		})
+
+		g.It("removes expired logins before the oldest login", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.CredentialCache.Size = 3
+			})
+			a, b, c, d := loginRequest("a.abcd1234", "a"), loginRequest("b.abcd1234", "b"), loginRequest("c.abcd1234", "c"), loginRequest("d.abcd1234", "d")
+			logins.put(a, remote.SftpAuthResponse{})
+			logins.put(b, remote.SftpAuthResponse{})
+			ageLogins(time.Hour)
+			logins.put(c, remote.SftpAuthResponse{})
+			ageLogins(time.Second)
+			logins.put(d, remote.SftpAuthResponse{})
+
+			g.Assert(len(logins.entries)).Equal(2)
+			_, ok := logins.get(c, 2*time.Hour)
+			g.Assert(ok).IsTrue()
+			_, ok = logins.get(d, 2*time.Hour)
+			g.Assert(ok).IsTrue()
+		})
+
+		g.It("removes the logins for a server or user", func() {
+			logins.put(loginRequest("a.abcd1234", "a"), remote.SftpAuthResponse{Server: "s1", User: "u1"})
+			logins.put(loginRequest("b.abcd1234", "b"), remote.SftpAuthResponse{Server: "s1", User: "u2"})
+			logins.put(loginRequest("c.abcd1234", "c"), remote.SftpAuthResponse{Server: "s2", User: "u1"})
+			logins.put(loginRequest("d.abcd1234", "d"), remote.SftpAuthResponse{Server: "s2", User: "u2"})
+
+			InvalidateServer("s1")
+			g.Assert(len(logins.entries)).Equal(2)
+			_, ok := logins.get(loginRequest("b.abcd1234", "b"), time.Hour)
+			g.Assert(ok).IsFalse()
+
+			InvalidateUser("u1")
+			g.Assert(len(logins.entries)).Equal(1)
+			_, ok = logins.get(loginRequest("d.abcd1234", "d"), time.Hour)
+			g.Assert(ok).IsTrue()
+		})
+	})
+
+	g.Describe("SFTPServer#makeCredentialsRequest", func() {
+		var panel *testPanel
+		var s *SFTPServer
+		conn := testConnMetadata{user: "user.abcd1234"}
+
+		g.BeforeEach(func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {})
+			ClearBans()
+			logins = newLoginCache()
+			panel = &testPanel{resp: remote.SftpAuthResponse{Server: "server", User: "user", Permissions: []string{"*"}}}
+			s = &SFTPServer{manager: server.NewEmptyManager(panel)}
+		})
+
+		g.It("uses a cached login until the TTL has passed", func() {
+			p, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+			g.Assert(p.Extensions["uuid"]).Equal("server")
+			g.Assert(panel.calls).Equal(1)
+
+			p, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+			g.Assert(p.Extensions["uuid"]).Equal("server")
+			g.Assert(panel.calls).Equal(1)
+
+			ageLogins(61 * time.Second)
+			_, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+			g.Assert(panel.calls).Equal(2)
+		})
+
+		g.It("does not use a cached login for different credentials", func() {
+			_, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+			panel.err = &remote.SftpInvalidCredentialsError{}
+			_, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "wrong")
+			g.Assert(err).IsNotNil()
+			g.Assert(panel.calls).Equal(2)
+		})
+
+		g.It("uses a stale login if the Panel cannot be reached", func() {
+			_, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+
+			ageLogins(10 * time.Minute)
+			panel.err = errors.New("connection refused")
+			p, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+			g.Assert(p.Extensions["uuid"]).Equal("server")
+			g.Assert(panel.calls).Equal(2)
+
+			ageLogins(10 * time.Minute)
+			_, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).Equal(panel.err)
+		})
+
+		g.It("does not use a stale login if the Panel rejects the credentials", func() {
+			_, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNil()
+
+			ageLogins(10 * time.Minute)
+			panel.err = &remote.SftpInvalidCredentialsError{}
+			_, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNotNil()
+			g.Assert(len(logins.entries)).Equal(0)
+
+			// The rejected login is removed, so it cannot be used during an outage either.
+			panel.err = errors.New("connection refused")
+			_, err = s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).Equal(panel.err)
+		})
+
+		g.It("does not cache failed logins", func() {
+			panel.err = errors.New("connection refused")
+			_, err := s.makeCredentialsRequest(conn, remote.SftpAuthPassword, "password")
+			g.Assert(err).IsNotNil()
+			g.Assert(len(logins.entries)).Equal(0)
+		})
+	})
+}
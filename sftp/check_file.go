@@ -0,0 +1,286 @@
+package sftp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/pterodactyl/wings/server/filesystem"
+)
+
+const (
+	sshFxpVersion       = 2
+	sshFxpStatus        = 101
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxOpUnsupported    = 8
+
+	// sftpMaxPacket is the largest packet that will be accepted from a client,
+	// this matches the limit imposed by the sftp package.
+	sftpMaxPacket = 256 * 1024
+	// checkFileMaxReply is the largest amount of hash data that will be sent in
+	// response to a single check-file request.
+	checkFileMaxReply = 64 * 1024
+)
+
+// extendedConn wraps an SFTP channel so that extended requests which are not
+// supported by the sftp package can be handled here. Incoming packets are read
+// whole, and any extended request this understands is answered directly rather
+// than being passed along to the request server. Outgoing packets are also only
+// written whole so the two sets of responses are never interleaved.
+//
+// Currently this only adds support for the "check-file-name" request, which is
+// used by clients such as WinSCP to calculate file checksums on the server.
+type extendedConn struct {
+	io.ReadWriteCloser
+	h *Handler
+	r *bufio.Reader
+
+	in []byte
+
+	mu      sync.Mutex
+	out     []byte
+	started bool
+	wg      sync.WaitGroup
+}
+
+func newExtendedConn(rw io.ReadWriteCloser, h *Handler) *extendedConn {
+	return &extendedConn{ReadWriteCloser: rw, h: h, r: bufio.NewReaderSize(rw, 64*1024)}
+}
+
+// Read returns the next packets sent by the client that should be handled by
+// the request server.
+func (c *extendedConn) Read(p []byte) (int, error) {
+	for len(c.in) == 0 {
+		var l [4]byte
+		if _, err := io.ReadFull(c.r, l[:]); err != nil {
+			return 0, err
+		}
+		n := binary.BigEndian.Uint32(l[:])
+		if n == 0 || n > sftpMaxPacket {
+			return 0, errors.New("sftp: received packet with invalid length")
+		}
+		pkt := make([]byte, 4+n)
+		copy(pkt, l[:])
+		if _, err := io.ReadFull(c.r, pkt[4:]); err != nil {
+			return 0, err
+		}
+		if !c.handle(pkt[4:]) {
+			c.in = pkt
+		}
+	}
+	n := copy(p, c.in)
+	c.in = c.in[n:]
+	return n, nil
+}
+
+// Write buffers data written by the request server and sends it to the client
+// once a complete packet has been written.
+func (c *extendedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out = append(c.out, p...)
+	for len(c.out) >= 4 {
+		n := 4 + int(binary.BigEndian.Uint32(c.out))
+		if len(c.out) < n {
+			break
+		}
+		pkt := c.out[:n]
+		// Advertise the additional extensions in the version packet which is the
+		// first thing sent to the client.
+		if !c.started {
+			c.started = true
+			if len(pkt) > 4 && pkt[4] == sshFxpVersion {
+				pkt = appendString(appendString(slices.Clone(pkt), "check-file"), strings.Join(filesystem.HashAlgorithms, ","))
+				binary.BigEndian.PutUint32(pkt, uint32(len(pkt)-4))
+			}
+		}
+		if _, err := c.ReadWriteCloser.Write(pkt); err != nil {
+			return 0, err
+		}
+		c.out = c.out[n:]
+	}
+	// Avoid holding onto a large buffer once everything has been written.
+	if len(c.out) == 0 {
+		c.out = nil
+	}
+	return len(p), nil
+}
+
+// Close waits for any extended requests to finish before closing the channel.
+func (c *extendedConn) Close() error {
+	c.wg.Wait()
+	return c.ReadWriteCloser.Close()
+}
+
+// handle handles an extended request from the client, returning false if the
+// packet should be passed along to the request server.
+func (c *extendedConn) handle(pkt []byte) bool {
+	if pkt[0] != sshFxpExtended {
+		return false
+	}
+	b := pkt[1:]
+	id, b, ok := takeUint32(b)
+	if !ok {
+		return false
+	}
+	name, b, ok := takeString(b)
+	if !ok || name != "check-file-name" {
+		return false
+	}
+	var req checkFileRequest
+	if req.path, b, ok = takeString(b); !ok {
+		return false
+	}
+	if req.algorithms, b, ok = takeString(b); !ok {
+		return false
+	}
+	if req.offset, b, ok = takeUint64(b); !ok {
+		return false
+	}
+	if req.length, b, ok = takeUint64(b); !ok {
+		return false
+	}
+	if req.blockSize, _, ok = takeUint32(b); !ok {
+		return false
+	}
+
+	// Hashing a large file can take some time, so do it in the background to
+	// avoid blocking any other requests from the client.
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		algorithm, sum, code := c.h.checkFile(req)
+		var resp []byte
+		if code != 0 {
+			resp = []byte{sshFxpStatus}
+			resp = binary.BigEndian.AppendUint32(resp, id)
+			resp = binary.BigEndian.AppendUint32(resp, code)
+			resp = appendString(resp, "")
+			resp = appendString(resp, "")
+		} else {
+			resp = []byte{sshFxpExtendedReply}
+			resp = binary.BigEndian.AppendUint32(resp, id)
+			resp = appendString(resp, algorithm)
+			resp = append(resp, sum...)
+		}
+		_, _ = c.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(resp))), resp...))
+	}()
+	return true
+}
+
+type checkFileRequest struct {
+	path       string
+	algorithms string
+	offset     uint64
+	length     uint64
+	blockSize  uint32
+}
+
+// checkFile calculates the hash of a file for a check-file request. The first
+// algorithm requested by the client that is supported is used. If a block size
+// is provided a hash is returned for each block of that size, otherwise a single
+// hash of the entire requested range is returned. A non-zero status code is
+// returned if the request cannot be completed.
+func (h *Handler) checkFile(req checkFileRequest) (string, []byte, uint32) {
+	if !h.can(PermissionFileReadContent) {
+		return "", nil, sshFxPermissionDenied
+	}
+	var algorithm string
+	for _, a := range strings.Split(req.algorithms, ",") {
+		if slices.Contains(filesystem.HashAlgorithms, a) {
+			algorithm = a
+			break
+		}
+	}
+	if algorithm == "" || (req.blockSize != 0 && req.blockSize < 256) {
+		return "", nil, sshFxOpUnsupported
+	}
+
+	p := path.Clean("/" + req.path)
+	f, st, err := h.fs.File(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, sshFxNoSuchFile
+		}
+		h.logger.WithField("source", p).WithField("error", err).Error("error processing check-file request")
+		return "", nil, sshFxFailure
+	}
+	defer f.Close()
+	if st.IsDir() {
+		return "", nil, sshFxFailure
+	}
+
+	size := uint64(st.Size())
+	if req.offset > size {
+		req.offset = size
+	}
+	if req.length == 0 || req.length > size-req.offset {
+		req.length = size - req.offset
+	}
+	block := req.length
+	if req.blockSize != 0 {
+		block = uint64(req.blockSize)
+	}
+
+	r := io.NewSectionReader(f, int64(req.offset), int64(req.length))
+	var out []byte
+	for {
+		hash, _ := filesystem.NewHash(algorithm)
+		n, err := io.CopyN(hash, r, int64(block))
+		if err != nil && err != io.EOF {
+			h.logger.WithField("source", p).WithField("error", err).Error("error reading file for check-file request")
+			return "", nil, sshFxFailure
+		}
+		// Always return at least one hash, even for an empty range.
+		if n == 0 && len(out) > 0 {
+			break
+		}
+		out = hash.Sum(out)
+		if len(out) > checkFileMaxReply {
+			return "", nil, sshFxFailure
+		}
+		if uint64(n) < block || block == 0 {
+			break
+		}
+	}
+	return algorithm, out, 0
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func takeUint32(b []byte) (uint32, []byte, bool) {
+	if len(b) < 4 {
+		return 0, b, false
+	}
+	return binary.BigEndian.Uint32(b), b[4:], true
+}
+
+func takeUint64(b []byte) (uint64, []byte, bool) {
+	if len(b) < 8 {
+		return 0, b, false
+	}
+	return binary.BigEndian.Uint64(b), b[8:], true
+}
+
+func takeString(b []byte) (string, []byte, bool) {
+	n, b, ok := takeUint32(b)
+	if !ok || uint64(len(b)) < uint64(n) {
+		return "", b, false
+	}
+	return string(b[:n]), b[n:], true
+}
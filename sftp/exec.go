@@ -0,0 +1,168 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/ufs"
+	"github.com/pterodactyl/wings/server"
+)
+
+var (
+	errExecReadOnly         = errors.New("the server is in read-only mode")
+	errExecPermissionDenied = errors.New("permission denied")
+	errExecIsDirectory      = errors.New("is a directory")
+	errExecNotRegular       = errors.New("not a regular file")
+)
+
+// Exec runs a command sent by the client in an SSH "exec" request and returns
+// the exit status to send back. Only the server side of scp and rsync are
+// supported, both of which run against the server filesystem using the same
+// permission checks as the SFTP handler. Anything else is rejected.
+func (h *Handler) Exec(ch ssh.Channel, command string) uint32 {
+	args, err := splitCommand(command)
+	if err != nil || len(args) == 0 {
+		execError(ch, "invalid command")
+		return 1
+	}
+
+	l := h.logger.WithField("command", args[0])
+	switch path.Base(args[0]) {
+	case "scp":
+		l.Debug("running scp command for client")
+		return newScp(h, ch).Run(args[1:])
+	case "rsync":
+		l.Debug("running rsync command for client")
+		return newRsync(h, ch).Run(args[1:])
+	default:
+		l.Warn("rejected unsupported exec request")
+		execError(ch, "this server only supports the sftp subsystem, scp and rsync")
+		return 1
+	}
+}
+
+// execError writes an error message to the stderr stream of the channel, which
+// is displayed to the user by their client.
+func execError(ch ssh.Channel, format string, a ...any) {
+	_, _ = fmt.Fprintf(ch.Stderr(), format+"\n", a...)
+}
+
+// splitCommand splits a command line received in an exec request into its
+// arguments. Clients quote paths for a POSIX shell, so single quotes, double
+// quotes and backslash escapes are handled. No other shell features are
+// supported.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var b strings.Builder
+	var inArg bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			i++
+			b.WriteByte(s[i])
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("sftp: unterminated quote in command")
+			}
+			b.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\\"$`", s[i+1]) >= 0 {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("sftp: unterminated quote in command")
+			}
+			inArg = true
+		default:
+			b.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// checkWrite determines if the user is allowed to write a file to the given path,
+// returning the activity event that should be logged once the file is written.
+func (h *Handler) checkWrite(p string) (models.Event, error) {
+	if h.ro {
+		return "", errExecReadOnly
+	}
+	if err := h.fs.IsIgnored(p); err != nil {
+		return "", err
+	}
+	permission, event := PermissionFileUpdate, server.ActivitySftpWrite
+	st, err := h.fs.UnixFS().Lstat(p)
+	if err != nil {
+		if !errors.Is(err, ufs.ErrNotExist) {
+			return "", err
+		}
+		permission, event = PermissionFileCreate, server.ActivitySftpCreate
+	} else if st.IsDir() {
+		return "", errExecIsDirectory
+	}
+	if !h.can(permission) {
+		return "", errExecPermissionDenied
+	}
+	return event, nil
+}
+
+// checkMkdir determines if the user is allowed to create a directory at the given
+// path. False is returned if the directory already exists.
+func (h *Handler) checkMkdir(p string) (bool, error) {
+	if h.ro {
+		return false, errExecReadOnly
+	}
+	if err := h.fs.IsIgnored(p); err != nil {
+		return false, err
+	}
+	st, err := h.fs.UnixFS().Stat(p)
+	if err == nil {
+		if !st.IsDir() {
+			return false, errors.New("not a directory")
+		}
+		return false, nil
+	}
+	if !errors.Is(err, ufs.ErrNotExist) {
+		return false, err
+	}
+	if !h.can(PermissionFileCreate) {
+		return false, errExecPermissionDenied
+	}
+	return true, nil
+}
+
+// checkRead determines if the user is allowed to read the contents of the file
+// at the given path.
+func (h *Handler) checkRead(p string) error {
+	if !h.can(PermissionFileRead) || !h.can(PermissionFileReadContent) {
+		return errExecPermissionDenied
+	}
+	return h.fs.IsIgnored(p)
+}
@@ -0,0 +1,191 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apex/log"
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/internal/database"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/server/filesystem"
+)
+
+// testChannel is an in-memory ssh.Channel. Pipes are used rather than io.Pipe so
+// that writes are buffered, as they are on a real channel.
+type testChannel struct {
+	r      io.Reader
+	w      io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func (c *testChannel) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *testChannel) Write(b []byte) (int, error) { return c.w.Write(b) }
+func (c *testChannel) Close() error                { return c.w.Close() }
+func (c *testChannel) CloseWrite() error           { return c.w.Close() }
+func (c *testChannel) Stderr() io.ReadWriter       { return &c.stderr }
+
+func (c *testChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return false, nil
+}
+
+// channelPair returns two channels that are connected to each other.
+func channelPair() (*testChannel, *testChannel) {
+	ar, bw, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	br, aw, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	return &testChannel{r: ar, w: aw}, &testChannel{r: br, w: bw}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// scriptedChannel returns a channel that reads the given input and records
+// everything written to it.
+func scriptedChannel(input string) (*testChannel, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &testChannel{r: strings.NewReader(input), w: nopWriteCloser{&out}}, &out
+}
+
+var initTestEnvironment sync.Once
+
+// setupTestEnvironment sets the configuration and opens the database used by
+// the tests, this is only done once for all the tests in the package.
+func setupTestEnvironment() {
+	initTestEnvironment.Do(func() {
+		root, err := os.MkdirTemp("", "wings-sftp")
+		if err != nil {
+			panic(err)
+		}
+		cfg, err := config.NewAtPath("")
+		if err != nil {
+			panic(err)
+		}
+		cfg.AuthenticationToken = "node-token"
+		cfg.System.RootDirectory = root
+		cfg.System.User.Uid = os.Getuid()
+		cfg.System.User.Gid = os.Getgid()
+		config.Set(cfg)
+		if err := database.Initialize(); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// newTestHandler returns a handler for a new, empty, server filesystem with the
+// given permissions.
+func newTestHandler(permissions ...string) *Handler {
+	setupTestEnvironment()
+	dir, err := os.MkdirTemp("", "wings-sftp-server")
+	if err != nil {
+		panic(err)
+	}
+	fs, err := filesystem.New(dir, 0, nil)
+	if err != nil {
+		panic(err)
+	}
+	s, err := server.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	return &Handler{
+		server:      s,
+		fs:          fs,
+		events:      &eventHandler{user: "user", server: s.ID()},
+		permissions: permissions,
+		logger:      log.WithField("subsystem", "sftp"),
+	}
+}
+
+// writeTestFile writes a file to the server filesystem of the handler.
+func writeTestFile(h *Handler, p string, content string) {
+	if err := h.fs.Write(p, strings.NewReader(content), int64(len(content)), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+// readTestFile returns the contents of a file on the server filesystem of the
+// handler.
+func readTestFile(h *Handler, p string) string {
+	f, err := h.fs.UnixFS().Open(p)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return string(b)
+}
+
+func TestExec(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("splitCommand", func() {
+		g.It("splits commands into arguments", func() {
+			cases := []struct {
+				command string
+				args    []string
+			}{
+				{"scp -t /data", []string{"scp", "-t", "/data"}},
+				{"  scp \t -f\n/data  ", []string{"scp", "-f", "/data"}},
+				{"scp -t 'my files/a b.txt'", []string{"scp", "-t", "my files/a b.txt"}},
+				{`scp -t "my \"quoted\" \$file"`, []string{"scp", "-t", `my "quoted" $file`}},
+				{`scp -t "keep \n"`, []string{"scp", "-t", `keep \n`}},
+				{`scp -t a\ b`, []string{"scp", "-t", "a b"}},
+				{`scp -t a'b'"c"`, []string{"scp", "-t", "abc"}},
+				{`scp -t ''`, []string{"scp", "-t", ""}},
+				{"", nil},
+			}
+			for _, c := range cases {
+				args, err := splitCommand(c.command)
+				g.Assert(err).IsNil(c.command)
+				g.Assert(args).Equal(c.args, c.command)
+			}
+		})
+
+		g.It("returns an error for unterminated quotes and escapes", func() {
+			for _, c := range []string{`scp -t 'a`, `scp -t "a`, `scp -t "a\"`, `scp -t a\`} {
+				_, err := splitCommand(c)
+				g.Assert(err).IsNotNil(c)
+			}
+		})
+	})
+
+	g.Describe("Handler.Exec", func() {
+		g.It("rejects commands other than scp and rsync", func() {
+			h := newTestHandler("*")
+			for _, c := range []string{"ls -la", "/bin/sh -c 'scp -t /'", "", "'unterminated"} {
+				ch, _ := scriptedChannel("")
+				g.Assert(h.Exec(ch, c)).Equal(uint32(1), c)
+				g.Assert(ch.stderr.Len() > 0).IsTrue(c)
+			}
+		})
+
+		g.It("runs scp and rsync from any path", func() {
+			h := newTestHandler("*")
+			ch, _ := scriptedChannel("")
+			g.Assert(h.Exec(ch, "/usr/bin/scp -x /")).Equal(uint32(1))
+			g.Assert(ch.stderr.String()).Equal("scp: unsupported option -x\n")
+
+			ch, _ = scriptedChannel("")
+			g.Assert(h.Exec(ch, "/usr/local/bin/rsync -z")).Equal(uint32(rsyncExitSyntax))
+			g.Assert(strings.HasPrefix(ch.stderr.String(), "rsync: ")).IsTrue()
+		})
+	})
+}
@@ -0,0 +1,261 @@
+package sftp
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+)
+
+const (
+	BanTypeIP       = "ip"
+	BanTypeUsername = "username"
+)
+
+var (
+	ErrAddressNotAllowed = errors.Sentinel("sftp: connections are not allowed from this address")
+	ErrBanned            = errors.Sentinel("sftp: too many failed login attempts")
+	ErrTooManySessions   = errors.Sentinel("sftp: too many concurrent sessions")
+)
+
+// Ban is a temporary ban placed on an IP address or username after too many
+// failed login attempts.
+type Ban struct {
+	Type      string    `json:"type"`
+	Value     string    `json:"value"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type attempts struct {
+	count  int
+	start  time.Time
+	banned time.Time
+}
+
+// guard tracks failed login attempts and open sessions for the SFTP server. Login
+// attempts are checked against the guard before being sent to the Panel, so that
+// credential stuffing against the SFTP server does not also hit the Panel.
+type guard struct {
+	mu        sync.Mutex
+	ips       map[string]*attempts
+	usernames map[string]*attempts
+	users     map[string]int
+	servers   map[string]int
+	pruned    time.Time
+}
+
+var limiter = newGuard()
+
+func newGuard() *guard {
+	return &guard{
+		ips:       make(map[string]*attempts),
+		usernames: make(map[string]*attempts),
+		users:     make(map[string]int),
+		servers:   make(map[string]int),
+	}
+}
+
+// Bans returns all the IP addresses and usernames that are currently banned from
+// the SFTP server.
+func Bans() []Ban {
+	return limiter.bans()
+}
+
+// Unban removes a ban on the given IP address or username, returning false if
+// there was no ban to remove.
+func Unban(t string, value string) bool {
+	return limiter.unban(t, value)
+}
+
+// ClearBans removes all bans and resets all failed login attempt counts.
+func ClearBans() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	clear(limiter.ips)
+	clear(limiter.usernames)
+}
+
+// host returns the IP address portion of a remote address.
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// checkAddress returns an error if connections from the given address are not
+// allowed, either because of the configured allow and deny lists, or because the
+// address is currently banned.
+func (g *guard) checkAddress(addr string) error {
+	cfg := config.Get().System.Sftp
+	rules := config.GetSftpIPRules()
+	ip := net.ParseIP(host(addr))
+	if ip == nil {
+		return ErrAddressNotAllowed
+	}
+	if rules.Denied.Contains(ip) {
+		return ErrAddressNotAllowed
+	}
+	if len(cfg.AllowedIPs) > 0 && !rules.Allowed.Contains(ip) {
+		return ErrAddressNotAllowed
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isBanned(g.ips, ip.String()) {
+		return ErrBanned
+	}
+	return nil
+}
+
+// checkLogin returns an error if either the address or username attempting to
+// log in are currently banned.
+func (g *guard) checkLogin(addr string, username string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isBanned(g.ips, host(addr)) || g.isBanned(g.usernames, strings.ToLower(username)) {
+		return ErrBanned
+	}
+	return nil
+}
+
+func (g *guard) isBanned(m map[string]*attempts, key string) bool {
+	a, ok := m[key]
+	return ok && a.banned.After(time.Now())
+}
+
+// failure records a failed login attempt for the given address and username,
+// banning either of them if they have exceeded the allowed number of attempts.
+//
+// Rejected public keys are not counted, clients will offer every key they have
+// available before falling back to a password, so a user with a handful of keys
+// loaded would otherwise quickly ban themselves.
+func (g *guard) failure(addr string, username string, t remote.SftpAuthRequestType) {
+	if t != remote.SftpAuthPassword {
+		return
+	}
+	cfg := config.Get().System.Sftp.Limits
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune()
+	g.record(g.ips, BanTypeIP, host(addr), cfg.MaxIPAttempts, cfg)
+	g.record(g.usernames, BanTypeUsername, strings.ToLower(username), cfg.MaxUserAttempts, cfg)
+}
+
+func (g *guard) record(m map[string]*attempts, t string, key string, max int, cfg config.SftpLimits) {
+	if max <= 0 || key == "" {
+		return
+	}
+	now := time.Now()
+	a, ok := m[key]
+	if !ok || now.Sub(a.start) > time.Duration(cfg.AttemptWindow)*time.Second {
+		a = &attempts{start: now}
+		m[key] = a
+	}
+	a.count++
+	if a.count >= max && !a.banned.After(now) {
+		a.banned = now.Add(time.Duration(cfg.BanDuration) * time.Second)
+		log.WithFields(log.Fields{"subsystem": "sftp", "type": t, "value": key, "attempts": a.count}).
+			Warn("too many failed login attempts, temporarily banning from sftp server")
+	}
+}
+
+// success resets the failed login attempts for a username after it has been
+// used to log in successfully.
+func (g *guard) success(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.usernames, strings.ToLower(username))
+}
+
+// prune removes any entries that are no longer banned and whose attempt window
+// has passed. This is run at most once a minute.
+func (g *guard) prune() {
+	now := time.Now()
+	if now.Sub(g.pruned) < time.Minute {
+		return
+	}
+	g.pruned = now
+	window := time.Duration(config.Get().System.Sftp.Limits.AttemptWindow) * time.Second
+	for _, m := range []map[string]*attempts{g.ips, g.usernames} {
+		for k, a := range m {
+			if !a.banned.After(now) && now.Sub(a.start) > window {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+func (g *guard) bans() []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	out := []Ban{}
+	for t, m := range map[string]map[string]*attempts{BanTypeIP: g.ips, BanTypeUsername: g.usernames} {
+		for k, a := range m {
+			if a.banned.After(now) {
+				out = append(out, Ban{Type: t, Value: k, Attempts: a.count, ExpiresAt: a.banned})
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b Ban) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return out
+}
+
+func (g *guard) unban(t string, value string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m := g.ips
+	if t == BanTypeUsername {
+		m = g.usernames
+		value = strings.ToLower(value)
+	} else if ip := net.ParseIP(value); ip != nil {
+		value = ip.String()
+	}
+	if !g.isBanned(m, value) {
+		return false
+	}
+	delete(m, value)
+	return true
+}
+
+// acquire registers a new session for the given user and server, returning an
+// error if either has reached the maximum number of concurrent sessions. The
+// returned function must be called once the session is closed.
+func (g *guard) acquire(user string, server string) (func(), error) {
+	cfg := config.Get().System.Sftp.Limits
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if cfg.MaxSessionsPerUser > 0 && g.users[user] >= cfg.MaxSessionsPerUser {
+		return nil, ErrTooManySessions
+	}
+	if cfg.MaxSessionsPerServer > 0 && g.servers[server] >= cfg.MaxSessionsPerServer {
+		return nil, ErrTooManySessions
+	}
+	g.users[user]++
+	g.servers[server]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.users[user]--; g.users[user] <= 0 {
+				delete(g.users, user)
+			}
+			if g.servers[server]--; g.servers[server] <= 0 {
+				delete(g.servers, server)
+			}
+		})
+	}, nil
+}
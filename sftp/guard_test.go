@@ -0,0 +1,263 @@
+package sftp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/remote"
+)
+
+// setSftpConfig resets the SFTP configuration to the defaults and then applies
+// the given changes to it.
+func setSftpConfig(fn func(c *config.SftpConfiguration)) {
+	setupTestEnvironment()
+	def, err := config.NewAtPath("")
+	if err != nil {
+		panic(err)
+	}
+	cfg := config.Get()
+	cfg.System.Sftp = def.System.Sftp
+	fn(&cfg.System.Sftp)
+	config.Set(cfg)
+}
+
+func TestGuard(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("guard#checkAddress", func() {
+		g.It("accepts any address by default", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {})
+			g.Assert(newGuard().checkAddress("203.0.113.10:4000")).IsNil()
+			g.Assert(newGuard().checkAddress("[2001:db8::1]:4000")).IsNil()
+		})
+
+		g.It("checks the allow and deny lists", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.AllowedIPs = []string{"203.0.113.0/24", "2001:db8::1"}
+				c.DeniedIPs = []string{"203.0.113.66"}
+			})
+			cases := []struct {
+				addr string
+				err  error
+			}{
+				{"203.0.113.10:4000", nil},
+				{"[2001:db8::1]:4000", nil},
+				{"203.0.113.66:4000", ErrAddressNotAllowed},
+				{"198.51.100.1:4000", ErrAddressNotAllowed},
+				{"[2001:db8::2]:4000", ErrAddressNotAllowed},
+				{"not an address", ErrAddressNotAllowed},
+			}
+			for _, c := range cases {
+				g.Assert(newGuard().checkAddress(c.addr)).Equal(c.err, c.addr)
+			}
+		})
+
+		g.It("denies every address if none of the allowed addresses are valid", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.AllowedIPs = []string{"invalid"}
+			})
+			g.Assert(newGuard().checkAddress("203.0.113.10:4000")).Equal(ErrAddressNotAllowed)
+		})
+
+		g.It("rejects banned addresses", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 1
+			})
+			l := newGuard()
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			g.Assert(l.checkAddress("203.0.113.10:5000")).Equal(ErrBanned)
+			g.Assert(l.checkAddress("203.0.113.11:4000")).IsNil()
+		})
+	})
+
+	g.Describe("guard#failure", func() {
+		g.It("bans an address once it reaches the maximum attempts", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 3
+			})
+			l := newGuard()
+			for i := 0; i < 2; i++ {
+				l.failure("203.0.113.10:4000", fmt.Sprintf("user%d", i), remote.SftpAuthPassword)
+				g.Assert(l.checkLogin("203.0.113.10:4000", "other")).IsNil()
+			}
+			l.failure("203.0.113.10:4000", "user2", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.10:4000", "other")).Equal(ErrBanned)
+			g.Assert(l.checkLogin("203.0.113.11:4000", "other")).IsNil()
+
+			bans := l.bans()
+			g.Assert(len(bans)).Equal(1)
+			g.Assert(bans[0].Type).Equal(BanTypeIP)
+			g.Assert(bans[0].Value).Equal("203.0.113.10")
+			g.Assert(bans[0].Attempts).Equal(3)
+		})
+
+		g.It("does not ban usernames by default", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {})
+			l := newGuard()
+			for i := 0; i < 100; i++ {
+				l.failure(fmt.Sprintf("203.0.113.%d:4000", i%10), "user", remote.SftpAuthPassword)
+			}
+			g.Assert(l.checkLogin("198.51.100.1:4000", "user")).IsNil()
+		})
+
+		g.It("bans usernames when enabled", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 0
+				c.Limits.MaxUserAttempts = 2
+			})
+			l := newGuard()
+			l.failure("203.0.113.1:4000", "User", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.3:4000", "user")).IsNil()
+			l.failure("203.0.113.2:4000", "user", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.3:4000", "USER")).Equal(ErrBanned)
+			g.Assert(l.checkLogin("203.0.113.3:4000", "other")).IsNil()
+		})
+
+		g.It("does not count rejected public keys", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 1
+				c.Limits.MaxUserAttempts = 1
+			})
+			l := newGuard()
+			for i := 0; i < 5; i++ {
+				l.failure("203.0.113.10:4000", "user", remote.SftpAuthPublicKey)
+			}
+			g.Assert(l.checkLogin("203.0.113.10:4000", "user")).IsNil()
+			g.Assert(len(l.bans())).Equal(0)
+		})
+
+		g.It("starts counting again once the attempt window has passed", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 2
+				c.Limits.AttemptWindow = 60
+			})
+			l := newGuard()
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			l.ips["203.0.113.10"].start = time.Now().Add(-61 * time.Second)
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			g.Assert(l.ips["203.0.113.10"].count).Equal(1)
+			g.Assert(l.checkLogin("203.0.113.10:4000", "user")).IsNil()
+		})
+
+		g.It("lifts bans once they expire", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 1
+			})
+			l := newGuard()
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.10:4000", "user")).Equal(ErrBanned)
+			l.ips["203.0.113.10"].banned = time.Now().Add(-time.Second)
+			g.Assert(l.checkLogin("203.0.113.10:4000", "user")).IsNil()
+			g.Assert(len(l.bans())).Equal(0)
+		})
+	})
+
+	g.Describe("guard#success", func() {
+		g.It("resets the failed attempts for the username", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 3
+				c.Limits.MaxUserAttempts = 2
+			})
+			l := newGuard()
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			l.success("USER")
+			l.failure("203.0.113.10:4000", "user", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.11:4000", "user")).IsNil()
+
+			// The attempts from the address are still counted.
+			l.failure("203.0.113.10:4000", "other", remote.SftpAuthPassword)
+			g.Assert(l.checkLogin("203.0.113.10:4000", "user")).Equal(ErrBanned)
+		})
+	})
+
+	g.Describe("Unban", func() {
+		g.BeforeEach(func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxIPAttempts = 1
+				c.Limits.MaxUserAttempts = 1
+			})
+			ClearBans()
+			limiter.failure("[2001:db8::1]:4000", "User", remote.SftpAuthPassword)
+			limiter.failure("203.0.113.10:4000", "other", remote.SftpAuthPassword)
+		})
+
+		g.After(func() {
+			ClearBans()
+		})
+
+		g.It("removes a single ban", func() {
+			g.Assert(len(Bans())).Equal(4)
+			g.Assert(Unban(BanTypeIP, "2001:0db8::0001")).IsTrue()
+			g.Assert(Unban(BanTypeIP, "2001:db8::1")).IsFalse()
+			g.Assert(Unban(BanTypeUsername, "USER")).IsTrue()
+			g.Assert(Unban(BanTypeUsername, "user")).IsFalse()
+			g.Assert(Unban(BanTypeIP, "198.51.100.1")).IsFalse()
+			g.Assert(limiter.checkLogin("[2001:db8::1]:4000", "user")).IsNil()
+			g.Assert(limiter.checkLogin("203.0.113.10:4000", "user")).Equal(ErrBanned)
+			g.Assert(len(Bans())).Equal(2)
+		})
+
+		g.It("removes every ban with ClearBans", func() {
+			ClearBans()
+			g.Assert(len(Bans())).Equal(0)
+			g.Assert(limiter.checkLogin("203.0.113.10:4000", "other")).IsNil()
+		})
+	})
+
+	g.Describe("guard#acquire", func() {
+		g.It("does not limit sessions by default", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {})
+			l := newGuard()
+			for i := 0; i < 50; i++ {
+				_, err := l.acquire("user", "server")
+				g.Assert(err).IsNil()
+			}
+		})
+
+		g.It("limits the sessions for each user", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxSessionsPerUser = 2
+			})
+			l := newGuard()
+			release, err := l.acquire("user", "a")
+			g.Assert(err).IsNil()
+			_, err = l.acquire("user", "b")
+			g.Assert(err).IsNil()
+			_, err = l.acquire("user", "c")
+			g.Assert(err).Equal(ErrTooManySessions)
+			_, err = l.acquire("other", "a")
+			g.Assert(err).IsNil()
+
+			// Releasing the same session more than once only frees one slot.
+			release()
+			release()
+			_, err = l.acquire("user", "c")
+			g.Assert(err).IsNil()
+			_, err = l.acquire("user", "d")
+			g.Assert(err).Equal(ErrTooManySessions)
+		})
+
+		g.It("limits the sessions for each server", func() {
+			setSftpConfig(func(c *config.SftpConfiguration) {
+				c.Limits.MaxSessionsPerServer = 1
+			})
+			l := newGuard()
+			release, err := l.acquire("a", "server")
+			g.Assert(err).IsNil()
+			_, err = l.acquire("b", "server")
+			g.Assert(err).Equal(ErrTooManySessions)
+			_, err = l.acquire("b", "other")
+			g.Assert(err).IsNil()
+
+			release()
+			g.Assert(l.servers["server"]).Equal(0)
+			g.Assert(l.users["a"]).Equal(0)
+			_, err = l.acquire("b", "server")
+			g.Assert(err).IsNil()
+		})
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/apex/log"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
 
 	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
@@ -25,6 +26,10 @@ const (
 	PermissionFileDelete      = "file.delete"
 )
 
+// statvfsReadOnly is the flag set in a statvfs response when the filesystem is
+// mounted read-only.
+const statvfsReadOnly = 0x1
+
 type Handler struct {
 	mu          sync.Mutex
 	server      *server.Server
@@ -213,6 +218,24 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		}
 		h.events.MustLog(server.ActivitySftpCreateDirectory, FileAction{Entity: request.Filepath})
 		break
+	// Support creating hard links between files. Unlike symlinks both the source and the
+	// target must be within the server home directory.
+	case "Link":
+		if !h.can(PermissionFileCreate) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if err := h.fs.IsIgnored(request.Filepath, request.Target); err != nil {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if err := h.fs.Link(request.Filepath, request.Target); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return sftp.ErrSSHFxNoSuchFile
+			}
+			l.WithField("error", err).Error("failed to create hard link")
+			return sftp.ErrSSHFxFailure
+		}
+		h.events.MustLog(server.ActivitySftpCreate, FileAction{Entity: request.Target})
+		break
 	// Support creating symlinks between files. The source and target must resolve within
 	// the server home directory.
 	case "Symlink":
@@ -256,6 +279,70 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	return sftp.ErrSSHFxOk
 }
 
+// PosixRename handles the "posix-rename@openssh.com" extension, which unlike a
+// standard SFTP rename will replace the target if it already exists.
+func (h *Handler) PosixRename(request *sftp.Request) error {
+	if h.ro {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if !h.can(PermissionFileUpdate) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	l := h.logger.WithField("source", request.Filepath).WithField("target", request.Target)
+	if err := h.fs.RenameReplace(request.Filepath, request.Target); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sftp.ErrSSHFxNoSuchFile
+		}
+		l.WithField("error", err).Error("failed to rename file")
+		return sftp.ErrSSHFxFailure
+	}
+	h.events.MustLog(server.ActivitySftpRename, FileAction{Entity: request.Filepath, Target: request.Target})
+	if err := h.fs.Chown(request.Target); err != nil {
+		l.WithField("error", err).Warn("error chowning file")
+	}
+	return nil
+}
+
+// StatVFS handles the "statvfs@openssh.com" extension, which is used by clients
+// to display the disk space available. If the server has a disk limit the space
+// is reported based on that limit, otherwise the values for the underlying disk
+// are returned.
+func (h *Handler) StatVFS(request *sftp.Request) (*sftp.StatVFS, error) {
+	if !h.can(PermissionFileRead) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(h.fs.Path(), &st); err != nil {
+		h.logger.WithField("error", err).Error("failed to stat filesystem")
+		return nil, sftp.ErrSSHFxFailure
+	}
+	out := &sftp.StatVFS{
+		Bsize:   uint64(st.Bsize),
+		Frsize:  uint64(st.Frsize),
+		Blocks:  st.Blocks,
+		Bfree:   st.Bfree,
+		Bavail:  st.Bavail,
+		Files:   st.Files,
+		Ffree:   st.Ffree,
+		Favail:  st.Ffree,
+		Namemax: uint64(st.Namelen),
+	}
+	if h.ro {
+		out.Flag |= statvfsReadOnly
+	}
+	if limit := h.fs.MaxDisk(); limit > 0 && out.Frsize > 0 {
+		usage, err := h.fs.DiskUsage(true)
+		if err != nil {
+			h.logger.WithField("error", err).Warn("failed to determine disk usage for statvfs request")
+		}
+		free := uint64(max(limit-usage, 0)) / out.Frsize
+		out.Blocks = uint64(limit) / out.Frsize
+		out.Bfree = min(free, out.Bfree)
+		out.Bavail = min(free, out.Bavail)
+	}
+	return out, nil
+}
+
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
 func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
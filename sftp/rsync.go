@@ -0,0 +1,981 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	ignore "github.com/sabhiram/go-gitignore"
+	"golang.org/x/crypto/md4"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+
+	"github.com/pterodactyl/wings/internal/models"
+	"github.com/pterodactyl/wings/internal/ufs"
+	"github.com/pterodactyl/wings/server"
+)
+
+const (
+	// rsyncProtocolVersion is the version of the rsync protocol spoken by Wings.
+	// Clients negotiate down to the lowest version supported by both sides, and
+	// every rsync release since 2.6.0 supports version 27. It predates the
+	// compatibility flags and checksum negotiation added in later versions.
+	rsyncProtocolVersion = 27
+	// rsyncChunkSize is the size of the literal data blocks sent to the client.
+	rsyncChunkSize = 32 * 1024
+	// rsyncSumLength is the length of the MD4 file checksum sent after each file.
+	rsyncSumLength = md4.Size
+)
+
+// Exit codes returned to the rsync client, these match the codes used by rsync
+// itself so that the client can display a useful message.
+const (
+	rsyncExitSyntax  = 1
+	rsyncExitProto   = 2
+	rsyncExitStream  = 12
+	rsyncExitPartial = 23
+)
+
+// Flags sent before each entry in the file list, indicating which fields are
+// the same as the previous entry and therefore not sent.
+const (
+	rsyncXmitTopDir   = 1 << 0
+	rsyncXmitSameMode = 1 << 1
+	rsyncXmitSameRdev = 1 << 2
+	rsyncXmitSameUID  = 1 << 3
+	rsyncXmitSameGID  = 1 << 4
+	rsyncXmitSameName = 1 << 5
+	rsyncXmitLongName = 1 << 6
+	rsyncXmitSameTime = 1 << 7
+)
+
+type rsyncOptions struct {
+	server     bool
+	sender     bool
+	recursive  bool
+	dirs       bool
+	links      bool
+	perms      bool
+	times      bool
+	owner      bool
+	group      bool
+	devices    bool
+	numericIDs bool
+	dryRun     bool
+}
+
+// rsyncFile is a single entry in the file list exchanged with the client.
+type rsyncFile struct {
+	// name is the name of the file as sent over the wire.
+	name string
+	// path is the location of the file within the server filesystem.
+	path  string
+	mode  uint32
+	size  int64
+	mtime int32
+	uid   int32
+	gid   int32
+	link  string
+	event models.Event
+}
+
+func (f *rsyncFile) isDir() bool     { return f.mode&unix.S_IFMT == unix.S_IFDIR }
+func (f *rsyncFile) isRegular() bool { return f.mode&unix.S_IFMT == unix.S_IFREG }
+func (f *rsyncFile) isLink() bool    { return f.mode&unix.S_IFMT == unix.S_IFLNK }
+
+func (f *rsyncFile) isDevice() bool {
+	switch f.mode & unix.S_IFMT {
+	case unix.S_IFCHR, unix.S_IFBLK, unix.S_IFIFO, unix.S_IFSOCK:
+		return true
+	}
+	return false
+}
+
+type rsyncFilter struct {
+	include bool
+	rule    *ignore.GitIgnore
+}
+
+// rsync implements the server side of the rsync protocol, as started by a client
+// running "rsync -e ssh". Files are always transferred whole, the client's block
+// checksums are ignored, which keeps the implementation simple at the cost of
+// sending unchanged parts of modified files again.
+type rsync struct {
+	h       *Handler
+	ch      ssh.Channel
+	c       *rsyncConn
+	opts    rsyncOptions
+	seed    int32
+	filters []rsyncFilter
+	failed  bool
+}
+
+func newRsync(h *Handler, ch ssh.Channel) *rsync {
+	return &rsync{h: h, ch: ch, c: newRsyncConn(ch)}
+}
+
+// Run parses the arguments passed to "rsync --server" and performs the transfer.
+func (r *rsync) Run(args []string) uint32 {
+	paths, err := r.parseArgs(args)
+	if err != nil {
+		execError(r.ch, "rsync: %s", err)
+		return rsyncExitSyntax
+	}
+	if err := r.handshake(); err != nil {
+		r.h.logger.WithField("error", err).Warn("rsync: failed to negotiate protocol with client")
+		execError(r.ch, "rsync: %s", err)
+		return rsyncExitProto
+	}
+
+	if r.opts.sender {
+		err = r.send(paths)
+	} else {
+		err = r.receive(paths)
+	}
+	if err != nil {
+		r.h.logger.WithField("error", err).Warn("rsync: transfer failed")
+		r.c.sendError(err.Error())
+		_ = r.c.Flush()
+		return rsyncExitStream
+	}
+	if r.failed {
+		return rsyncExitPartial
+	}
+	return 0
+}
+
+// parseArgs parses the options passed to the server by the client, returning the
+// paths that follow them. The client always sends "." as the first argument
+// after the options.
+func (r *rsync) parseArgs(args []string) ([]string, error) {
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			if !r.opts.server {
+				return nil, errors.New("only server mode is supported")
+			}
+			return args[i+1:], nil
+		}
+		if strings.HasPrefix(a, "--") {
+			name, _, _ := strings.Cut(a[2:], "=")
+			switch name {
+			case "server":
+				r.opts.server = true
+			case "sender":
+				r.opts.sender = true
+			case "numeric-ids":
+				r.opts.numericIDs = true
+			case "recursive":
+				r.opts.recursive = true
+			case "links":
+				r.opts.links = true
+			case "perms":
+				r.opts.perms = true
+			case "times":
+				r.opts.times = true
+			case "owner":
+				r.opts.owner = true
+			case "group":
+				r.opts.group = true
+			case "dry-run":
+				r.opts.dryRun = true
+			case "partial", "inplace", "whole-file", "no-whole-file", "timeout", "bwlimit",
+				"log-format", "out-format", "info", "debug", "size-only", "ignore-times",
+				"modify-window", "omit-dir-times", "omit-link-times", "no-implied-dirs",
+				"safe-links", "sparse", "one-file-system":
+			default:
+				if strings.HasPrefix(name, "delete") {
+					return nil, errors.New("deleting files is not supported by this server")
+				}
+				return nil, errors.Errorf("unsupported option --%s", name)
+			}
+			continue
+		}
+	flags:
+		for _, c := range a[1:] {
+			switch c {
+			case 'r':
+				r.opts.recursive = true
+			case 'd':
+				r.opts.dirs = true
+			case 'l':
+				r.opts.links = true
+			case 'p':
+				r.opts.perms = true
+			case 't':
+				r.opts.times = true
+			case 'o':
+				r.opts.owner = true
+			case 'g':
+				r.opts.group = true
+			case 'D':
+				r.opts.devices = true
+			case 'n':
+				r.opts.dryRun = true
+			case 'v', 'q', 'i', 'W', 'S', 'x', 'O', 'J', 'I', 'C', 'E', 'L', 'k', 'K':
+			case 'e':
+				// Everything after "e" describes the capabilities of the client
+				// for newer protocol versions, none of which are used here.
+				break flags
+			case 'z':
+				return nil, errors.New("compression is not supported by this server, remove -z")
+			default:
+				return nil, errors.Errorf("unsupported option -%c", c)
+			}
+		}
+	}
+	return nil, errors.New("missing arguments")
+}
+
+// handshake exchanges protocol versions with the client and sends the checksum
+// seed. All data sent after this point is multiplexed.
+func (r *rsync) handshake() error {
+	r.c.writeInt(rsyncProtocolVersion)
+	if err := r.c.Flush(); err != nil {
+		return err
+	}
+	v, err := r.c.readInt()
+	if err != nil {
+		return errors.Wrap(err, "failed to read protocol version")
+	}
+	if v < rsyncProtocolVersion {
+		return errors.Errorf("protocol version %d is not supported, version %d or newer is required", v, rsyncProtocolVersion)
+	}
+	r.seed = int32(time.Now().Unix())
+	r.c.writeInt(r.seed)
+	return r.c.startMultiplex()
+}
+
+// fail reports a problem with a single file to the client without stopping the
+// transfer.
+func (r *rsync) fail(p string, err error) {
+	r.failed = true
+	r.h.logger.WithField("source", p).WithField("error", err).Debug("rsync: error processing file")
+	r.c.sendError(fmt.Sprintf("%s: %s", p, err))
+}
+
+// checksum returns a new hash for computing the checksum sent after each file.
+func (r *rsync) checksum() hash.Hash {
+	h := md4.New()
+	_, _ = h.Write(binary.LittleEndian.AppendUint32(nil, uint32(r.seed)))
+	return h
+}
+
+// sortFiles sorts the file list in the same order as the client, as files are
+// referred to by their index in the sorted list.
+func sortFiles(files []*rsyncFile) {
+	slices.SortStableFunc(files, func(a, b *rsyncFile) int {
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+// receive handles uploads from the client into the given destination.
+func (r *rsync) receive(paths []string) error {
+	dest := "."
+	if len(paths) > 0 && paths[0] != "" {
+		dest = paths[0]
+	}
+	files, err := r.recvFileList()
+	if err != nil {
+		return err
+	}
+	sortFiles(files)
+	if err := r.resolveDestination(dest, files); err != nil {
+		return err
+	}
+
+	// Create any directories and symlinks, and work out which files need to be
+	// requested from the client.
+	var want []int
+	for i, f := range files {
+		if r.opts.dryRun {
+			continue
+		}
+		switch {
+		case f.isDir():
+			if err := r.mkdir(f); err != nil {
+				r.fail(f.path, err)
+			}
+		case f.isLink():
+			if err := r.symlink(f); err != nil {
+				r.fail(f.path, err)
+			}
+		case f.isRegular():
+			event, err := r.h.checkWrite(f.path)
+			if err != nil {
+				r.fail(f.path, err)
+				continue
+			}
+			f.event = event
+			want = append(want, i)
+		}
+	}
+
+	// Requests for files are written in the background while the data is being
+	// received, so that neither side blocks waiting for the other.
+	done := make(chan error, 1)
+	go func() {
+		for _, i := range want {
+			r.c.writeInt(int32(i))
+			// An empty checksum header, as there is no basis file the client
+			// sends the whole file.
+			for j := 0; j < 4; j++ {
+				r.c.writeInt(0)
+			}
+		}
+		// The end of the transfer phase, the end of the redo phase and the final
+		// goodbye. Files with a bad checksum are reported rather than retried.
+		for j := 0; j < 3; j++ {
+			r.c.writeInt(-1)
+		}
+		done <- r.c.Flush()
+	}()
+
+	for phase := 0; phase < 2; {
+		ndx, err := r.c.readInt()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if ndx == -1 {
+			phase++
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(files) || !files[ndx].isRegular() {
+			return errors.Errorf("protocol error: invalid file index %d", ndx)
+		}
+		if err := r.receiveFile(files[ndx]); err != nil {
+			return err
+		}
+	}
+	return <-done
+}
+
+// resolveDestination sets the path that each received file is written to. This
+// follows rsync, if a single file is sent and the destination is not a directory
+// the file is written to the destination, otherwise the destination is treated
+// as a directory and created if needed.
+func (r *rsync) resolveDestination(dest string, files []*rsyncFile) error {
+	root := path.Clean("/" + dest)
+	single := len(files) == 1 && !files[0].isDir()
+	st, err := r.h.fs.UnixFS().Stat(root)
+	switch {
+	case err == nil && st.IsDir():
+		single = false
+	case err == nil:
+		if !single {
+			return errors.New("destination must be a directory when copying more than 1 file")
+		}
+	case errors.Is(err, ufs.ErrNotExist):
+		if strings.HasSuffix(dest, "/") {
+			single = false
+		}
+		if !single && !r.opts.dryRun {
+			if err := r.mkdir(&rsyncFile{path: root, mode: unix.S_IFDIR | 0o755}); err != nil {
+				return errors.Wrap(err, "failed to create destination directory")
+			}
+		}
+	default:
+		return err
+	}
+	for _, f := range files {
+		if single {
+			f.path = root
+		} else {
+			f.path = path.Join(root, f.name)
+		}
+	}
+	return nil
+}
+
+// recvFileList reads the list of files being uploaded from the client.
+func (r *rsync) recvFileList() ([]*rsyncFile, error) {
+	var files []*rsyncFile
+	var last rsyncFile
+	for {
+		flags, err := r.c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if flags == 0 {
+			break
+		}
+		var l1, l2 int
+		if flags&rsyncXmitSameName != 0 {
+			b, err := r.c.readByte()
+			if err != nil {
+				return nil, err
+			}
+			l1 = int(b)
+		}
+		if flags&rsyncXmitLongName != 0 {
+			v, err := r.c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			l2 = int(v)
+		} else {
+			b, err := r.c.readByte()
+			if err != nil {
+				return nil, err
+			}
+			l2 = int(b)
+		}
+		if l1 > len(last.name) || l2 < 0 || l1+l2 > 4096 {
+			return nil, errors.New("protocol error: invalid file name length")
+		}
+		b, err := r.c.readBytes(l2)
+		if err != nil {
+			return nil, err
+		}
+
+		f := last
+		f.name = last.name[:l1] + string(b)
+		f.link = ""
+		if f.size, err = r.c.readLong(); err != nil {
+			return nil, err
+		}
+		if flags&rsyncXmitSameTime == 0 {
+			if f.mtime, err = r.c.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if flags&rsyncXmitSameMode == 0 {
+			v, err := r.c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			f.mode = uint32(v)
+		}
+		if r.opts.owner && flags&rsyncXmitSameUID == 0 {
+			if f.uid, err = r.c.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if r.opts.group && flags&rsyncXmitSameGID == 0 {
+			if f.gid, err = r.c.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if r.opts.devices && f.isDevice() && flags&rsyncXmitSameRdev == 0 {
+			if _, err := r.c.readInt(); err != nil {
+				return nil, err
+			}
+		}
+		if r.opts.links && f.isLink() {
+			n, err := r.c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || n > 4096 {
+				return nil, errors.New("protocol error: invalid symlink length")
+			}
+			b, err := r.c.readBytes(int(n))
+			if err != nil {
+				return nil, err
+			}
+			f.link = string(b)
+		}
+		last = f
+
+		name := path.Clean(f.name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, errors.Errorf("protocol error: invalid file name %q", f.name)
+		}
+		f.name = name
+		files = append(files, &f)
+	}
+
+	if !r.opts.numericIDs {
+		for _, enabled := range []bool{r.opts.owner, r.opts.group} {
+			if !enabled {
+				continue
+			}
+			for {
+				id, err := r.c.readInt()
+				if err != nil {
+					return nil, err
+				}
+				if id == 0 {
+					break
+				}
+				n, err := r.c.readByte()
+				if err != nil {
+					return nil, err
+				}
+				if err := r.c.discard(int64(n)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// The I/O error flag from the client, which is not used by the server.
+	if _, err := r.c.readInt(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// mkdir creates a directory received from the client.
+func (r *rsync) mkdir(f *rsyncFile) error {
+	create, err := r.h.checkMkdir(f.path)
+	if err != nil {
+		return err
+	}
+	if create {
+		if err := r.h.fs.CreateDirectory(path.Base(f.path), path.Dir(f.path)); err != nil {
+			return err
+		}
+		if err := r.h.fs.Chown(f.path); err != nil {
+			r.h.logger.WithField("source", f.path).WithField("error", err).Warn("error chowning file")
+		}
+		r.h.events.MustLog(server.ActivitySftpCreateDirectory, FileAction{Entity: f.path})
+	}
+	if r.opts.perms && f.path != "/" {
+		return r.h.fs.Chmod(f.path, ufs.FileMode(f.mode&0o777))
+	}
+	return nil
+}
+
+// symlink creates a symlink received from the client. Existing symlinks are
+// left as they are.
+func (r *rsync) symlink(f *rsyncFile) error {
+	if st, err := r.h.fs.UnixFS().Lstat(f.path); err == nil && st.Mode()&ufs.ModeSymlink != 0 {
+		return nil
+	}
+	if _, err := r.h.checkWrite(f.path); err != nil {
+		return err
+	}
+	if err := r.h.fs.Symlink(f.link, f.path); err != nil {
+		return err
+	}
+	r.h.events.MustLog(server.ActivitySftpCreate, FileAction{Entity: f.path})
+	return nil
+}
+
+// receiveFile reads the contents of a single file from the client and writes it
+// to the disk.
+func (r *rsync) receiveFile(f *rsyncFile) error {
+	// The client echoes back the checksum header we sent for the file.
+	for j := 0; j < 4; j++ {
+		if _, err := r.c.readInt(); err != nil {
+			return err
+		}
+	}
+
+	mode := ufs.FileMode(0o644)
+	if r.opts.perms {
+		mode = ufs.FileMode(f.mode & 0o777)
+	}
+	tr := &rsyncTokenReader{c: r.c, sum: r.checksum()}
+	werr := r.h.fs.Write(f.path, tr, f.size, mode)
+	if tr.err != nil {
+		return tr.err
+	}
+	// Read anything the filesystem did not consume, either because the write
+	// failed or the file grew after the client built the file list.
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		return err
+	}
+	sum, err := r.c.readBytes(rsyncSumLength)
+	if err != nil {
+		return err
+	}
+
+	if werr != nil {
+		r.fail(f.path, werr)
+		return nil
+	}
+	if !bytes.Equal(sum, tr.sum.Sum(nil)) {
+		r.fail(f.path, errors.New("file checksum does not match the data received"))
+		return nil
+	}
+	if r.opts.times {
+		mtime := time.Unix(int64(f.mtime), 0)
+		if err := r.h.fs.Chtimes(f.path, mtime, mtime); err != nil {
+			r.h.logger.WithField("source", f.path).WithField("error", err).Warn("rsync: failed to set file times")
+		}
+	}
+	r.h.events.MustLog(f.event, FileAction{Entity: f.path})
+	return nil
+}
+
+// rsyncTokenReader reads the stream of tokens that make up the contents of a
+// file. Each token is either a block of literal data or a reference to a block
+// in the basis file. As Wings never sends block checksums to the client only
+// literal data is accepted.
+type rsyncTokenReader struct {
+	c         *rsyncConn
+	sum       hash.Hash
+	remaining int
+	done      bool
+	err       error
+}
+
+func (t *rsyncTokenReader) Read(p []byte) (int, error) {
+	for t.remaining == 0 {
+		if t.done || t.err != nil {
+			return 0, io.EOF
+		}
+		v, err := t.c.readInt()
+		if err != nil {
+			t.err = err
+			return 0, err
+		}
+		switch {
+		case v == 0:
+			t.done = true
+		case v > 0:
+			t.remaining = int(v)
+		default:
+			t.err = errors.New("protocol error: received block reference without a basis file")
+			return 0, t.err
+		}
+	}
+	n := min(len(p), t.remaining)
+	if err := t.c.readFull(p[:n]); err != nil {
+		t.err = err
+		return 0, err
+	}
+	t.remaining -= n
+	_, _ = t.sum.Write(p[:n])
+	return n, nil
+}
+
+// send handles downloads of the given paths by the client.
+func (r *rsync) send(paths []string) error {
+	if !r.h.can(PermissionFileRead) || !r.h.can(PermissionFileReadContent) {
+		return errExecPermissionDenied
+	}
+	if err := r.recvFilters(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var files []*rsyncFile
+	for _, p := range paths {
+		files = r.buildFileList(files, p)
+	}
+	sortFiles(files)
+	r.sendFileList(files)
+	if err := r.c.Flush(); err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range files {
+		if f.isRegular() {
+			size += f.size
+		}
+	}
+
+	for phase := 0; ; {
+		ndx, err := r.c.readInt()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if ndx == -1 {
+			if phase++; phase > 1 {
+				break
+			}
+			r.c.writeInt(-1)
+			if err := r.c.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(files) || !files[ndx].isRegular() {
+			return errors.Errorf("protocol error: invalid file index %d", ndx)
+		}
+		if err := r.sendFile(ndx, files[ndx]); err != nil {
+			return err
+		}
+	}
+	r.c.writeInt(-1)
+
+	// Transfer statistics displayed by the client. Reads and writes are from the
+	// perspective of the client, so they are swapped.
+	if err := r.c.Flush(); err != nil {
+		return err
+	}
+	r.c.writeLong(r.c.read)
+	r.c.writeLong(r.c.written)
+	r.c.writeLong(size)
+	if err := r.c.Flush(); err != nil {
+		return err
+	}
+	if v, err := r.c.readInt(); err != nil {
+		return errors.WithStack(err)
+	} else if v != -1 {
+		return errors.New("protocol error: invalid final message from client")
+	}
+	return nil
+}
+
+// recvFilters reads the exclude and include rules sent by the client.
+func (r *rsync) recvFilters() error {
+	for {
+		n, err := r.c.readInt()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n < 0 || n > 4096 {
+			return errors.New("protocol error: invalid filter length")
+		}
+		b, err := r.c.readBytes(int(n))
+		if err != nil {
+			return err
+		}
+		rule := string(b)
+		switch {
+		case rule == "!":
+			r.filters = nil
+		case strings.HasPrefix(rule, "+ "):
+			r.filters = append(r.filters, rsyncFilter{include: true, rule: ignore.CompileIgnoreLines(rule[2:])})
+		case strings.HasPrefix(rule, "- "):
+			r.filters = append(r.filters, rsyncFilter{rule: ignore.CompileIgnoreLines(rule[2:])})
+		default:
+			r.filters = append(r.filters, rsyncFilter{rule: ignore.CompileIgnoreLines(rule)})
+		}
+	}
+}
+
+// excluded returns true if the file should not be sent to the client, either
+// because of the rules sent by the client or the server's denylist.
+func (r *rsync) excluded(name, p string, dir bool) bool {
+	if r.h.fs.IsIgnored(p) != nil {
+		return true
+	}
+	if dir {
+		name += "/"
+	}
+	for _, f := range r.filters {
+		if f.rule.MatchesPath(name) {
+			return !f.include
+		}
+	}
+	return false
+}
+
+// buildFileList adds the given path to the list of files to send. A directory
+// with a trailing slash sends the contents of the directory, otherwise the
+// directory itself is sent, which matches the behavior of rsync.
+func (r *rsync) buildFileList(files []*rsyncFile, arg string) []*rsyncFile {
+	p := path.Clean("/" + arg)
+	contents := arg == "." || strings.HasSuffix(arg, "/") || strings.HasSuffix(arg, "/.")
+	st, err := r.h.fs.UnixFS().Lstat(p)
+	if err != nil {
+		r.fail(arg, err)
+		return files
+	}
+	name := path.Base(p)
+	if contents {
+		name = "."
+	}
+	if !st.IsDir() {
+		if f := r.newFile(name, p, st); f != nil && !r.excluded(name, p, false) {
+			files = append(files, f)
+		}
+		return files
+	}
+	if !r.opts.recursive && !r.opts.dirs {
+		r.fail(arg, errors.New("skipping directory, use -r to transfer directories"))
+		return files
+	}
+	if r.excluded(name, p, true) {
+		return files
+	}
+	files = append(files, r.newFile(name, p, st))
+	if r.opts.recursive || contents {
+		files = r.walk(files, name, p)
+	}
+	return files
+}
+
+// walk adds the contents of a directory to the list of files to send.
+func (r *rsync) walk(files []*rsyncFile, name, p string) []*rsyncFile {
+	entries, err := r.h.fs.ReadDirStat(p)
+	if err != nil {
+		r.fail(p, err)
+		return files
+	}
+	for _, st := range entries {
+		n, ep := path.Join(name, st.Name()), path.Join(p, st.Name())
+		if r.excluded(n, ep, st.IsDir()) {
+			continue
+		}
+		f := r.newFile(n, ep, st)
+		if f == nil {
+			continue
+		}
+		files = append(files, f)
+		if f.isDir() && r.opts.recursive {
+			files = r.walk(files, n, ep)
+		}
+	}
+	return files
+}
+
+// newFile returns a file list entry for the given file, or nil if the file is
+// not a regular file or directory. Symlinks are not followed or sent.
+func (r *rsync) newFile(name, p string, st ufs.FileInfo) *rsyncFile {
+	f := &rsyncFile{
+		name:  name,
+		path:  p,
+		mode:  uint32(st.Mode().Perm()),
+		mtime: int32(st.ModTime().Unix()),
+	}
+	switch {
+	case st.IsDir():
+		f.mode |= unix.S_IFDIR
+	case st.Mode().IsRegular():
+		f.mode |= unix.S_IFREG
+		f.size = st.Size()
+	default:
+		return nil
+	}
+	if sys, ok := st.Sys().(*unix.Stat_t); ok {
+		f.uid, f.gid = int32(sys.Uid), int32(sys.Gid)
+	}
+	return f
+}
+
+// sendFileList sends the list of files to the client. Fields that match the
+// previous entry are omitted to reduce the size of the list.
+func (r *rsync) sendFileList(files []*rsyncFile) {
+	var last rsyncFile
+	for _, f := range files {
+		var flags byte
+		if f.mode == last.mode {
+			flags |= rsyncXmitSameMode
+		}
+		if f.mtime == last.mtime {
+			flags |= rsyncXmitSameTime
+		}
+		if f.uid == last.uid {
+			flags |= rsyncXmitSameUID
+		}
+		if f.gid == last.gid {
+			flags |= rsyncXmitSameGID
+		}
+		l1 := 0
+		for l1 < len(f.name) && l1 < len(last.name) && l1 < 255 && f.name[l1] == last.name[l1] {
+			l1++
+		}
+		l2 := len(f.name) - l1
+		if l1 > 0 {
+			flags |= rsyncXmitSameName
+		}
+		if l2 > 255 {
+			flags |= rsyncXmitLongName
+		}
+		// A zero byte marks the end of the list, so make sure some flag is set.
+		if flags == 0 {
+			if f.isDir() {
+				flags |= rsyncXmitLongName
+			} else {
+				flags |= rsyncXmitTopDir
+			}
+		}
+
+		r.c.writeByte(flags)
+		if flags&rsyncXmitSameName != 0 {
+			r.c.writeByte(byte(l1))
+		}
+		if flags&rsyncXmitLongName != 0 {
+			r.c.writeInt(int32(l2))
+		} else {
+			r.c.writeByte(byte(l2))
+		}
+		r.c.write([]byte(f.name[l1:]))
+		r.c.writeLong(f.size)
+		if flags&rsyncXmitSameTime == 0 {
+			r.c.writeInt(f.mtime)
+		}
+		if flags&rsyncXmitSameMode == 0 {
+			r.c.writeInt(int32(f.mode))
+		}
+		if r.opts.owner && flags&rsyncXmitSameUID == 0 {
+			r.c.writeInt(f.uid)
+		}
+		if r.opts.group && flags&rsyncXmitSameGID == 0 {
+			r.c.writeInt(f.gid)
+		}
+		last = *f
+	}
+	r.c.writeByte(0)
+
+	// Empty user and group name lists, ids are used as-is by the client.
+	if !r.opts.numericIDs {
+		if r.opts.owner {
+			r.c.writeInt(0)
+		}
+		if r.opts.group {
+			r.c.writeInt(0)
+		}
+	}
+	// The I/O error flag, errors are reported to the client as they happen.
+	r.c.writeInt(0)
+}
+
+// sendFile sends the contents of a file requested by the client.
+func (r *rsync) sendFile(ndx int32, f *rsyncFile) error {
+	var head [4]int32
+	for j := range head {
+		v, err := r.c.readInt()
+		if err != nil {
+			return err
+		}
+		head[j] = v
+	}
+	// The client sends checksums for the blocks of its existing copy of the file,
+	// which are skipped as the whole file is always sent.
+	count, s2length := head[0], head[2]
+	if count < 0 || s2length < 0 || s2length > rsyncSumLength {
+		return errors.New("protocol error: invalid checksum header")
+	}
+	if err := r.c.discard(int64(count) * int64(4+s2length)); err != nil {
+		return err
+	}
+
+	file, err := r.h.fs.UnixFS().Open(f.path)
+	if err != nil {
+		r.fail(f.path, err)
+		return nil
+	}
+	defer file.Close()
+
+	r.c.writeInt(ndx)
+	for _, v := range head {
+		r.c.writeInt(v)
+	}
+	sum := r.checksum()
+	buf := make([]byte, rsyncChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			r.c.writeInt(int32(n))
+			r.c.write(buf[:n])
+			_, _ = sum.Write(buf[:n])
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.fail(f.path, err)
+			}
+			break
+		}
+	}
+	r.c.writeInt(0)
+	r.c.write(sum.Sum(nil))
+	return r.c.Flush()
+}
@@ -0,0 +1,176 @@
+package sftp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"emperror.dev/errors"
+)
+
+const (
+	// rsyncMplexBase is added to the message tag in the header of every multiplexed
+	// packet sent to the client.
+	rsyncMplexBase = 7
+	// rsyncMaxPacket is the largest payload that can be sent in a single packet.
+	rsyncMaxPacket = 0xFFFFFF
+
+	rsyncMsgData  = 0
+	rsyncMsgError = 3
+)
+
+// rsyncConn wraps the SSH channel with the primitives used by the rsync wire
+// protocol. Everything is little-endian. Data sent from the client is read as a
+// plain stream, while data sent to the client is multiplexed so that error
+// messages can be interleaved with the file data.
+type rsyncConn struct {
+	r *bufio.Reader
+	w io.Writer
+
+	mu   sync.Mutex
+	buf  []byte
+	mux  bool
+	werr error
+
+	read    int64
+	written int64
+}
+
+func newRsyncConn(rw io.ReadWriter) *rsyncConn {
+	return &rsyncConn{r: bufio.NewReaderSize(rw, 64*1024), w: rw}
+}
+
+func (c *rsyncConn) readFull(b []byte) error {
+	n, err := io.ReadFull(c.r, b)
+	c.read += int64(n)
+	return err
+}
+
+func (c *rsyncConn) readByte() (byte, error) {
+	var b [1]byte
+	err := c.readFull(b[:])
+	return b[0], err
+}
+
+func (c *rsyncConn) readInt() (int32, error) {
+	var b [4]byte
+	if err := c.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b[:])), nil
+}
+
+// readLong reads a 64-bit integer which is sent as a single 32-bit value if it
+// fits, otherwise as a -1 marker followed by the full 64-bit value.
+func (c *rsyncConn) readLong() (int64, error) {
+	v, err := c.readInt()
+	if err != nil || v != -1 {
+		return int64(v), err
+	}
+	var b [8]byte
+	if err := c.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+func (c *rsyncConn) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	return b, c.readFull(b)
+}
+
+// discard skips the next n bytes from the client.
+func (c *rsyncConn) discard(n int64) error {
+	d, err := io.CopyN(io.Discard, c.r, n)
+	c.read += d
+	return err
+}
+
+// write queues data to be sent to the client, flushing it once enough data has
+// been buffered.
+func (c *rsyncConn) write(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= 64*1024 {
+		c.flushLocked()
+	}
+}
+
+func (c *rsyncConn) writeByte(v byte) {
+	c.write([]byte{v})
+}
+
+func (c *rsyncConn) writeInt(v int32) {
+	c.write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+}
+
+func (c *rsyncConn) writeLong(v int64) {
+	if v >= 0 && v <= 0x7FFFFFFF {
+		c.writeInt(int32(v))
+		return
+	}
+	b := binary.LittleEndian.AppendUint32(nil, 0xFFFFFFFF)
+	c.write(binary.LittleEndian.AppendUint64(b, uint64(v)))
+}
+
+// startMultiplex switches the outgoing stream over to multiplexed packets. Any
+// data written before this point is sent as-is.
+func (c *rsyncConn) startMultiplex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+	c.mux = true
+	return c.werr
+}
+
+// sendError sends an error message to the client, which is displayed to the
+// user. Messages can only be sent once the stream has been multiplexed.
+func (c *rsyncConn) sendError(msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+	if c.mux {
+		c.packet(rsyncMsgError, []byte("rsync: "+msg+"\n"))
+	}
+}
+
+// Flush sends any buffered data to the client.
+func (c *rsyncConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+	return c.werr
+}
+
+func (c *rsyncConn) flushLocked() {
+	if len(c.buf) == 0 {
+		return
+	}
+	c.written += int64(len(c.buf))
+	if !c.mux {
+		c.raw(c.buf)
+	} else {
+		for b := c.buf; len(b) > 0; {
+			n := min(len(b), rsyncMaxPacket)
+			c.packet(rsyncMsgData, b[:n])
+			b = b[n:]
+		}
+	}
+	c.buf = c.buf[:0]
+}
+
+func (c *rsyncConn) packet(tag int, b []byte) {
+	h := binary.LittleEndian.AppendUint32(nil, uint32((rsyncMplexBase+tag)<<24|len(b)))
+	c.raw(append(h, b...))
+}
+
+func (c *rsyncConn) raw(b []byte) {
+	if c.werr != nil {
+		return
+	}
+	if _, err := c.w.Write(b); err != nil {
+		c.werr = errors.WithStack(err)
+	}
+}
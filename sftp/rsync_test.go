@@ -0,0 +1,452 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"golang.org/x/sys/unix"
+)
+
+// rsyncDemux reads the multiplexed stream sent by the server, returning the data
+// and keeping any messages.
+type rsyncDemux struct {
+	r         io.Reader
+	remaining int
+	messages  []string
+}
+
+func (d *rsyncDemux) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		var h [4]byte
+		if _, err := io.ReadFull(d.r, h[:]); err != nil {
+			return 0, err
+		}
+		v := binary.LittleEndian.Uint32(h[:])
+		tag, n := int(v>>24)-rsyncMplexBase, int(v&0xFFFFFF)
+		if tag == rsyncMsgData {
+			d.remaining = n
+			continue
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return 0, err
+		}
+		d.messages = append(d.messages, string(b))
+	}
+	n, err := d.r.Read(p[:min(len(p), d.remaining)])
+	d.remaining -= n
+	return n, err
+}
+
+// rsyncTestClient plays the part of the rsync client, using the same wire format
+// as the server.
+type rsyncTestClient struct {
+	*rsync
+	ch    *testChannel
+	demux *rsyncDemux
+	done  chan uint32
+}
+
+// startRsync runs the server with the given arguments and completes the
+// handshake with it.
+func startRsync(h *Handler, args ...string) *rsyncTestClient {
+	a, b := channelPair()
+	done := make(chan uint32, 1)
+	go func() {
+		code := newRsync(h, a).Run(args)
+		_ = a.Close()
+		done <- code
+	}()
+
+	var v [4]byte
+	if _, err := io.ReadFull(b, v[:]); err != nil {
+		panic(err)
+	}
+	binary.LittleEndian.PutUint32(v[:], rsyncProtocolVersion)
+	if _, err := b.Write(v[:]); err != nil {
+		panic(err)
+	}
+	if _, err := io.ReadFull(b, v[:]); err != nil {
+		panic(err)
+	}
+
+	d := &rsyncDemux{r: b}
+	c := &rsyncTestClient{
+		rsync: &rsync{c: newRsyncConn(struct {
+			io.Reader
+			io.Writer
+		}{d, b}), seed: int32(binary.LittleEndian.Uint32(v[:]))},
+		ch:    b,
+		demux: d,
+		done:  done,
+	}
+	return c
+}
+
+// wait closes the connection to the server and returns its exit code, once
+// any remaining messages from the server have been read.
+func (c *rsyncTestClient) wait() uint32 {
+	_ = c.c.Flush()
+	_ = c.ch.Close()
+	code := <-c.done
+	_, _ = io.Copy(io.Discard, c.demux)
+	return code
+}
+
+// upload sends the files to the server, with the given contents for each of
+// the regular files.
+func (c *rsyncTestClient) upload(files []*rsyncFile, contents map[string]string) error {
+	sortFiles(files)
+	c.sendFileList(files)
+	if err := c.c.Flush(); err != nil {
+		return err
+	}
+
+	var want []int32
+	for {
+		ndx, err := c.c.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == -1 {
+			break
+		}
+		for j := 0; j < 4; j++ {
+			if _, err := c.c.readInt(); err != nil {
+				return err
+			}
+		}
+		want = append(want, ndx)
+	}
+	for _, ndx := range want {
+		data := contents[files[ndx].name]
+		c.c.writeInt(ndx)
+		for j := 0; j < 4; j++ {
+			c.c.writeInt(0)
+		}
+		if len(data) > 0 {
+			c.c.writeInt(int32(len(data)))
+			c.c.write([]byte(data))
+		}
+		c.c.writeInt(0)
+		sum := c.checksum()
+		_, _ = sum.Write([]byte(data))
+		c.c.write(sum.Sum(nil))
+	}
+	c.c.writeInt(-1)
+	c.c.writeInt(-1)
+	if err := c.c.Flush(); err != nil {
+		return err
+	}
+	for j := 0; j < 2; j++ {
+		if v, err := c.c.readInt(); err != nil || v != -1 {
+			return errors.Errorf("unexpected message %d: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// download requests every regular file in the list sent by the server and
+// returns their contents, by name.
+func (c *rsyncTestClient) download(filters ...string) ([]*rsyncFile, map[string]string, error) {
+	for _, f := range filters {
+		c.c.writeInt(int32(len(f)))
+		c.c.write([]byte(f))
+	}
+	c.c.writeInt(0)
+	if err := c.c.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	files, err := c.recvFileList()
+	if err != nil {
+		return nil, nil, err
+	}
+	var want int
+	for i, f := range files {
+		if f.isRegular() {
+			c.c.writeInt(int32(i))
+			for j := 0; j < 4; j++ {
+				c.c.writeInt(0)
+			}
+			want++
+		}
+	}
+	c.c.writeInt(-1)
+	if err := c.c.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	contents := make(map[string]string)
+	for ; want > 0; want-- {
+		ndx, err := c.c.readInt()
+		if err != nil {
+			return nil, nil, err
+		}
+		if ndx < 0 || int(ndx) >= len(files) {
+			return nil, nil, errors.Errorf("invalid file index %d", ndx)
+		}
+		for j := 0; j < 4; j++ {
+			if _, err := c.c.readInt(); err != nil {
+				return nil, nil, err
+			}
+		}
+		tr := &rsyncTokenReader{c: c.c, sum: c.checksum()}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		sum, err := c.c.readBytes(rsyncSumLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(sum, tr.sum.Sum(nil)) {
+			return nil, nil, errors.Errorf("checksum mismatch for %s", files[ndx].name)
+		}
+		contents[files[ndx].name] = string(b)
+	}
+
+	// The end of the transfer phase and the end of the redo phase.
+	if v, err := c.c.readInt(); err != nil || v != -1 {
+		return nil, nil, errors.Errorf("unexpected message %d: %v", v, err)
+	}
+	c.c.writeInt(-1)
+	if err := c.c.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if v, err := c.c.readInt(); err != nil || v != -1 {
+		return nil, nil, errors.Errorf("unexpected message %d: %v", v, err)
+	}
+	for j := 0; j < 3; j++ {
+		if _, err := c.c.readLong(); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.c.writeInt(-1)
+	return files, contents, c.c.Flush()
+}
+
+func testRsyncDir(name string) *rsyncFile {
+	return &rsyncFile{name: name, mode: unix.S_IFDIR | 0o755}
+}
+
+func testRsyncFile(name string, size int, mtime time.Time) *rsyncFile {
+	return &rsyncFile{name: name, mode: unix.S_IFREG | 0o644, size: int64(size), mtime: int32(mtime.Unix())}
+}
+
+func TestRsync(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("rsync arguments", func() {
+		g.It("parses the options sent by the client", func() {
+			r := newRsync(nil, nil)
+			paths, err := r.parseArgs([]string{"--server", "--sender", "-vlogDtpre.iLsfxC", "--numeric-ids", "--timeout=30", ".", "/src", "/other"})
+			g.Assert(err).IsNil()
+			g.Assert(paths).Equal([]string{"/src", "/other"})
+			g.Assert(r.opts).Equal(rsyncOptions{
+				server:     true,
+				sender:     true,
+				recursive:  true,
+				links:      true,
+				perms:      true,
+				times:      true,
+				owner:      true,
+				group:      true,
+				devices:    true,
+				numericIDs: true,
+			})
+		})
+
+		g.It("parses long options", func() {
+			r := newRsync(nil, nil)
+			paths, err := r.parseArgs([]string{"--server", "--recursive", "--links", "--perms", "--times", "--dry-run", "--partial", ".", "dst"})
+			g.Assert(err).IsNil()
+			g.Assert(paths).Equal([]string{"dst"})
+			g.Assert(r.opts).Equal(rsyncOptions{server: true, recursive: true, links: true, perms: true, times: true, dryRun: true})
+		})
+
+		g.It("rejects unsupported options", func() {
+			cases := []struct {
+				args []string
+				err  string
+			}{
+				{[]string{"-r", ".", "dst"}, "only server mode is supported"},
+				{[]string{"--server", "-rz", ".", "dst"}, "compression is not supported by this server, remove -z"},
+				{[]string{"--server", "--delete", ".", "dst"}, "deleting files is not supported by this server"},
+				{[]string{"--server", "--delete-after", ".", "dst"}, "deleting files is not supported by this server"},
+				{[]string{"--server", "--checksum", ".", "dst"}, "unsupported option --checksum"},
+				{[]string{"--server", "-rc", ".", "dst"}, "unsupported option -c"},
+				{[]string{"--server", "-r"}, "missing arguments"},
+			}
+			for _, c := range cases {
+				_, err := newRsync(nil, nil).parseArgs(c.args)
+				g.Assert(err).IsNotNil(strings.Join(c.args, " "))
+				g.Assert(err.Error()).Equal(c.err)
+			}
+		})
+
+		g.It("reports invalid arguments to the client", func() {
+			ch, out := scriptedChannel("")
+			g.Assert(newRsync(newTestHandler("*"), ch).Run([]string{"--server", "--delete", ".", "dst"})).Equal(uint32(rsyncExitSyntax))
+			g.Assert(ch.stderr.String()).Equal("rsync: deleting files is not supported by this server\n")
+			g.Assert(out.Len()).Equal(0)
+		})
+
+		g.It("rejects old protocol versions", func() {
+			ch, _ := scriptedChannel("\x1a\x00\x00\x00")
+			g.Assert(newRsync(newTestHandler("*"), ch).Run([]string{"--server", ".", "dst"})).Equal(uint32(rsyncExitProto))
+			g.Assert(ch.stderr.String()).Equal("rsync: protocol version 26 is not supported, version 27 or newer is required\n")
+		})
+	})
+
+	g.Describe("rsync upload", func() {
+		g.It("writes the files sent by the client", func() {
+			h := newTestHandler("*")
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			c := startRsync(h, "--server", "-rte.iLsfxC", ".", "dst/")
+			contents := map[string]string{
+				"a.txt":         "first",
+				"sub/b.txt":     strings.Repeat("second", 20000),
+				"sub/empty.txt": "",
+			}
+			err := c.upload([]*rsyncFile{
+				testRsyncDir("."),
+				testRsyncFile("a.txt", len(contents["a.txt"]), mtime),
+				testRsyncDir("sub"),
+				testRsyncFile("sub/b.txt", len(contents["sub/b.txt"]), mtime),
+				testRsyncFile("sub/empty.txt", 0, mtime),
+			}, contents)
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(0))
+			g.Assert(len(c.demux.messages)).Equal(0)
+
+			for name, content := range contents {
+				g.Assert(readTestFile(h, "/dst/"+name)).Equal(content, name)
+			}
+			st, err := h.fs.UnixFS().Stat("/dst/sub/b.txt")
+			g.Assert(err).IsNil()
+			g.Assert(st.ModTime().Equal(mtime)).IsTrue()
+		})
+
+		g.It("writes a single file to the destination", func() {
+			h := newTestHandler("*")
+			c := startRsync(h, "--server", "-t", ".", "renamed.txt")
+			err := c.upload([]*rsyncFile{testRsyncFile("a.txt", 5, time.Now())}, map[string]string{"a.txt": "hello"})
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(0))
+			g.Assert(readTestFile(h, "/renamed.txt")).Equal("hello")
+		})
+
+		g.It("rejects files outside of the destination", func() {
+			h := newTestHandler("*")
+			g.Assert(h.fs.CreateDirectory("dst", "/")).IsNil()
+			c := startRsync(h, "--server", "-r", ".", "dst")
+			c.sendFileList([]*rsyncFile{testRsyncFile("../a.txt", 5, time.Now())})
+			g.Assert(c.c.Flush()).IsNil()
+			g.Assert(c.wait()).Equal(uint32(rsyncExitStream))
+			_, err := h.fs.UnixFS().Stat("/a.txt")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("skips files the user cannot create", func() {
+			h := newTestHandler(PermissionFileRead)
+			writeTestFile(h, "/b.txt", "old")
+			c := startRsync(h, "--server", "-r", ".", "/")
+			err := c.upload([]*rsyncFile{
+				testRsyncFile("a.txt", 5, time.Now()),
+				testRsyncFile("b.txt", 3, time.Now()),
+			}, map[string]string{"a.txt": "hello", "b.txt": "new"})
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(rsyncExitPartial))
+			g.Assert(c.demux.messages).Equal([]string{
+				"rsync: /a.txt: permission denied\n",
+				"rsync: /b.txt: permission denied\n",
+			})
+			_, err = h.fs.UnixFS().Stat("/a.txt")
+			g.Assert(err).IsNotNil()
+			g.Assert(readTestFile(h, "/b.txt")).Equal("old")
+		})
+
+		g.It("reports files with an invalid checksum", func() {
+			h := newTestHandler("*")
+			c := startRsync(h, "--server", ".", "/a.txt")
+			c.seed++
+			err := c.upload([]*rsyncFile{testRsyncFile("a.txt", 5, time.Now())}, map[string]string{"a.txt": "hello"})
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(rsyncExitPartial))
+			g.Assert(c.demux.messages).Equal([]string{"rsync: /a.txt: file checksum does not match the data received\n"})
+		})
+	})
+
+	g.Describe("rsync download", func() {
+		g.It("sends the files requested by the client", func() {
+			h := newTestHandler("*")
+			g.Assert(h.fs.CreateDirectory("src", "/")).IsNil()
+			g.Assert(h.fs.CreateDirectory("sub", "/src")).IsNil()
+			writeTestFile(h, "/src/a.txt", "first")
+			writeTestFile(h, "/src/sub/b.txt", strings.Repeat("second", 20000))
+			writeTestFile(h, "/src/sub/empty.txt", "")
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			g.Assert(h.fs.Chtimes("/src/a.txt", mtime, mtime)).IsNil()
+
+			c := startRsync(h, "--server", "--sender", "-rte.iLsfxC", ".", "src/")
+			files, contents, err := c.download()
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(0))
+			g.Assert(len(c.demux.messages)).Equal(0)
+
+			var names []string
+			for _, f := range files {
+				names = append(names, f.name)
+			}
+			g.Assert(names).Equal([]string{".", "a.txt", "sub", "sub/b.txt", "sub/empty.txt"})
+			g.Assert(files[1].mtime).Equal(int32(mtime.Unix()))
+			g.Assert(contents).Equal(map[string]string{
+				"a.txt":         "first",
+				"sub/b.txt":     strings.Repeat("second", 20000),
+				"sub/empty.txt": "",
+			})
+		})
+
+		g.It("sends a directory by name without a trailing slash", func() {
+			h := newTestHandler("*")
+			g.Assert(h.fs.CreateDirectory("src", "/")).IsNil()
+			writeTestFile(h, "/src/a.txt", "first")
+
+			c := startRsync(h, "--server", "--sender", "-r", ".", "src")
+			files, contents, err := c.download()
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(0))
+			g.Assert(len(files)).Equal(2)
+			g.Assert(files[0].name).Equal("src")
+			g.Assert(contents).Equal(map[string]string{"src/a.txt": "first"})
+		})
+
+		g.It("does not send files excluded by the client", func() {
+			h := newTestHandler("*")
+			writeTestFile(h, "/a.txt", "first")
+			writeTestFile(h, "/b.log", "second")
+
+			c := startRsync(h, "--server", "--sender", "-r", ".", "/")
+			_, contents, err := c.download("- *.log")
+			g.Assert(err).IsNil()
+			g.Assert(c.wait()).Equal(uint32(0))
+			g.Assert(contents).Equal(map[string]string{"a.txt": "first"})
+		})
+
+		g.It("requires permission to read files", func() {
+			h := newTestHandler(PermissionFileRead)
+			writeTestFile(h, "/a.txt", "first")
+
+			c := startRsync(h, "--server", "--sender", "-r", ".", "/")
+			g.Assert(c.wait()).Equal(uint32(rsyncExitStream))
+			g.Assert(c.demux.messages).Equal([]string{"rsync: permission denied\n"})
+		})
+	})
+}
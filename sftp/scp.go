@@ -0,0 +1,403 @@
+package sftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+	"github.com/pterodactyl/wings/server"
+)
+
+// scp implements the remote side of the legacy scp protocol, which is used by
+// clients that run "scp -t" (upload) or "scp -f" (download) over an exec request
+// rather than using the SFTP subsystem.
+type scp struct {
+	h  *Handler
+	ch ssh.Channel
+	r  *bufio.Reader
+
+	recursive bool
+	preserve  bool
+	targetDir bool
+
+	failed bool
+}
+
+func newScp(h *Handler, ch ssh.Channel) *scp {
+	return &scp{h: h, ch: ch, r: bufio.NewReader(ch)}
+}
+
+// Run parses the scp arguments and then runs as either the sink or source side
+// of a transfer.
+func (s *scp) Run(args []string) uint32 {
+	var sink, source bool
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			paths = append(paths, a)
+			continue
+		}
+		for _, c := range a[1:] {
+			switch c {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				s.recursive = true
+			case 'p':
+				s.preserve = true
+			case 'd':
+				s.targetDir = true
+			case 'v', 'q', 'E':
+			default:
+				execError(s.ch, "scp: unsupported option -%c", c)
+				return 1
+			}
+		}
+	}
+	if sink == source || len(paths) == 0 {
+		execError(s.ch, "scp: usage: scp -t|-f [-prd] path...")
+		return 1
+	}
+
+	var err error
+	if sink {
+		if len(paths) != 1 {
+			execError(s.ch, "scp: ambiguous target")
+			return 1
+		}
+		err = s.sink(paths[0])
+	} else {
+		err = s.source(paths)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.h.logger.WithField("error", err).Warn("scp: transfer failed")
+		return 1
+	}
+	if s.failed {
+		return 1
+	}
+	return 0
+}
+
+// ack sends a positive response to the client.
+func (s *scp) ack() error {
+	_, err := s.ch.Write([]byte{0})
+	return err
+}
+
+// warn sends a non-fatal error to the client, which will display it and then
+// continue with the next file.
+func (s *scp) warn(name string, err error) error {
+	s.failed = true
+	msg := strings.ReplaceAll(fmt.Sprintf("scp: %s: %s", name, err), "\n", " ")
+	_, werr := s.ch.Write([]byte("\x01" + msg + "\n"))
+	return werr
+}
+
+// response reads a single response from the client, returning an error if the
+// client reported a problem.
+func (s *scp) response() error {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		return errors.New(strings.TrimSpace(msg))
+	default:
+		return errors.Errorf("scp: unexpected response byte %#x", b)
+	}
+}
+
+// sink receives files from the client and writes them to the target path.
+func (s *scp) sink(target string) error {
+	target = path.Clean("/" + target)
+	st, err := s.h.fs.UnixFS().Stat(target)
+	isDir := err == nil && st.IsDir()
+	if s.targetDir && !isDir {
+		_ = s.warn(target, errors.New("not a directory"))
+		return nil
+	}
+	return s.sinkInto(target, isDir)
+}
+
+// sinkInto processes the commands sent by the client for a single directory level.
+// Nested directories are handled by calling this function recursively.
+func (s *scp) sinkInto(target string, isDir bool) error {
+	if err := s.ack(); err != nil {
+		return err
+	}
+	var mtime time.Time
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("scp: protocol error: empty command")
+		}
+		switch line[0] {
+		case 1, 2:
+			s.failed = true
+			s.h.logger.WithField("message", line[1:]).Debug("scp: client reported an error")
+			if line[0] == 2 {
+				return nil
+			}
+			continue
+		case 'E':
+			return s.ack()
+		case 'T':
+			var mt, mu, at, au int64
+			if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mt, &mu, &at, &au); err != nil {
+				return errors.Wrap(err, "scp: protocol error: invalid times")
+			}
+			mtime = time.Unix(mt, mu*1000)
+			if err := s.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return errors.Errorf("scp: protocol error: unexpected command %q", line)
+		}
+
+		mode, size, name, err := parseScpHeader(line[1:])
+		if err != nil {
+			return err
+		}
+		p := target
+		if isDir {
+			p = path.Join(target, name)
+		}
+		if line[0] == 'D' {
+			if !s.recursive {
+				return errors.New("scp: protocol error: received directory without -r")
+			}
+			if err := s.mkdir(p); err != nil {
+				if err := s.warn(p, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.sinkInto(p, true); err != nil {
+				return err
+			}
+			if !mtime.IsZero() && s.preserve {
+				_ = s.h.fs.Chtimes(p, mtime, mtime)
+			}
+			mtime = time.Time{}
+			continue
+		}
+		if err := s.receiveFile(p, mode, size, mtime); err != nil {
+			return err
+		}
+		mtime = time.Time{}
+	}
+}
+
+// mkdir creates a directory sent by the client if it does not already exist.
+func (s *scp) mkdir(p string) error {
+	create, err := s.h.checkMkdir(p)
+	if err != nil || !create {
+		return err
+	}
+	if err := s.h.fs.CreateDirectory(path.Base(p), path.Dir(p)); err != nil {
+		return err
+	}
+	if err := s.h.fs.Chown(p); err != nil {
+		s.h.logger.WithField("source", p).WithField("error", err).Warn("error chowning file")
+	}
+	s.h.events.MustLog(server.ActivitySftpCreateDirectory, FileAction{Entity: p})
+	return nil
+}
+
+// receiveFile writes a single file sent by the client to the disk.
+func (s *scp) receiveFile(p string, mode ufs.FileMode, size int64, mtime time.Time) error {
+	event, err := s.h.checkWrite(p)
+	if err == nil {
+		err = s.h.fs.HasSpaceFor(size)
+	}
+	if err != nil {
+		// The client will not send the file contents if we respond with an error
+		// at this point, so just move on to the next command.
+		return s.warn(p, err)
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	if !s.preserve {
+		mode = 0o644
+	}
+	r := io.LimitReader(s.r, size)
+	werr := s.h.fs.Write(p, r, size, mode)
+	// If the write failed partway through, the rest of the file still needs to be
+	// read off the connection before the next command.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if err := s.response(); err != nil {
+		return err
+	}
+	if werr != nil {
+		s.h.logger.WithField("source", p).WithField("error", werr).Error("scp: failed to write file")
+		return s.warn(p, werr)
+	}
+	if s.preserve {
+		if !mtime.IsZero() {
+			_ = s.h.fs.Chtimes(p, mtime, mtime)
+		}
+		_ = s.h.fs.Chmod(p, mode)
+	}
+	s.h.events.MustLog(event, FileAction{Entity: p})
+	return s.ack()
+}
+
+// parseScpHeader parses the "<mode> <size> <name>" portion of a C or D command.
+func parseScpHeader(line string) (ufs.FileMode, int64, string, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.New("scp: protocol error: invalid file header")
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", errors.New("scp: protocol error: invalid file mode")
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", errors.New("scp: protocol error: invalid file size")
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", errors.Errorf("scp: protocol error: unexpected filename %q", name)
+	}
+	return ufs.FileMode(mode) & ufs.ModePerm, size, name, nil
+}
+
+// source sends the requested files to the client.
+func (s *scp) source(paths []string) error {
+	if err := s.response(); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := s.send(path.Clean("/" + p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends a single file, or a directory and its contents, to the client.
+func (s *scp) send(p string) error {
+	if err := s.h.checkRead(p); err != nil {
+		return s.warn(p, err)
+	}
+	st, err := s.h.fs.UnixFS().Lstat(p)
+	if err != nil {
+		return s.warn(p, err)
+	}
+	if s.preserve {
+		mt := st.ModTime().Unix()
+		if _, err := fmt.Fprintf(s.ch, "T%d 0 %d 0\n", mt, mt); err != nil {
+			return err
+		}
+		if err := s.response(); err != nil {
+			return err
+		}
+	}
+
+	if st.IsDir() {
+		if !s.recursive {
+			return s.warn(p, errExecIsDirectory)
+		}
+		entries, err := s.h.fs.ReadDir(p)
+		if err != nil {
+			return s.warn(p, err)
+		}
+		if _, err := fmt.Fprintf(s.ch, "D%04o 0 %s\n", st.Mode().Perm(), path.Base(p)); err != nil {
+			return err
+		}
+		if err := s.response(); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := s.send(path.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+		if _, err := s.ch.Write([]byte("E\n")); err != nil {
+			return err
+		}
+		return s.response()
+	}
+	if !st.Mode().IsRegular() {
+		return s.warn(p, errExecNotRegular)
+	}
+
+	f, err := s.h.fs.UnixFS().Open(p)
+	if err != nil {
+		return s.warn(p, err)
+	}
+	defer f.Close()
+	size := st.Size()
+	if _, err := fmt.Fprintf(s.ch, "C%04o %d %s\n", st.Mode().Perm(), size, path.Base(p)); err != nil {
+		return err
+	}
+	if err := s.response(); err != nil {
+		// The client was unable to write the file, it has already told the user
+		// so skip to the next one.
+		s.failed = true
+		return nil
+	}
+	// If the file shrinks while it is being sent, pad it out to the size that was
+	// already sent to the client to keep the stream in sync.
+	n, rerr := io.Copy(s.ch, io.LimitReader(f, size))
+	if n < size {
+		if _, err := io.CopyN(s.ch, zeroReader{}, size-n); err != nil {
+			return err
+		}
+		if rerr == nil {
+			rerr = io.ErrUnexpectedEOF
+		}
+	}
+	if rerr != nil {
+		if err := s.warn(p, rerr); err != nil {
+			return err
+		}
+	} else if err := s.ack(); err != nil {
+		return err
+	}
+	if err := s.response(); err != nil {
+		s.failed = true
+	}
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,241 @@
+package sftp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pterodactyl/wings/internal/ufs"
+)
+
+// scpRoundTrip runs the source side of scp on one handler and the sink side on
+// another, connected over an in-memory channel, and returns their exit codes.
+func scpRoundTrip(source *Handler, sourceArgs []string, sink *Handler, sinkArgs []string) (uint32, uint32) {
+	a, b := channelPair()
+	done := make(chan uint32)
+	go func() {
+		code := newScp(source, a).Run(sourceArgs)
+		_ = a.Close()
+		done <- code
+	}()
+	code := newScp(sink, b).Run(sinkArgs)
+	_ = b.Close()
+	return <-done, code
+}
+
+func TestScp(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("scp arguments", func() {
+		g.It("rejects invalid arguments", func() {
+			cases := []struct {
+				args   []string
+				stderr string
+			}{
+				{[]string{"-t", "-x", "/"}, "scp: unsupported option -x\n"},
+				{[]string{"-tz", "/"}, "scp: unsupported option -z\n"},
+				{[]string{"/"}, "scp: usage: scp -t|-f [-prd] path...\n"},
+				{[]string{"-t", "-f", "/"}, "scp: usage: scp -t|-f [-prd] path...\n"},
+				{[]string{"-t"}, "scp: usage: scp -t|-f [-prd] path...\n"},
+				{[]string{"-f", "--"}, "scp: usage: scp -t|-f [-prd] path...\n"},
+				{[]string{"-t", "/a", "/b"}, "scp: ambiguous target\n"},
+			}
+			h := newTestHandler("*")
+			for _, c := range cases {
+				ch, out := scriptedChannel("")
+				g.Assert(newScp(h, ch).Run(c.args)).Equal(uint32(1), strings.Join(c.args, " "))
+				g.Assert(ch.stderr.String()).Equal(c.stderr, strings.Join(c.args, " "))
+				g.Assert(out.Len()).Equal(0)
+			}
+		})
+
+		g.It("parses combined and ignored options", func() {
+			h := newTestHandler("*")
+			ch, _ := scriptedChannel("")
+			s := newScp(h, ch)
+			g.Assert(s.Run([]string{"-vqEprdt", "/"})).Equal(uint32(0))
+			g.Assert(s.recursive).IsTrue()
+			g.Assert(s.preserve).IsTrue()
+			g.Assert(s.targetDir).IsTrue()
+			g.Assert(ch.stderr.Len()).Equal(0)
+		})
+
+		g.It("treats everything after -- as a path", func() {
+			h := newTestHandler("*")
+			writeTestFile(h, "/-t", "hello")
+			ch, out := scriptedChannel("\x00\x00\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-f", "--", "-t"})).Equal(uint32(0))
+			g.Assert(out.String()).Equal("C0644 5 -t\nhello\x00")
+		})
+	})
+
+	g.Describe("parseScpHeader", func() {
+		g.It("parses the mode, size and name", func() {
+			mode, size, name, err := parseScpHeader("0640 12 my file.txt")
+			g.Assert(err).IsNil()
+			g.Assert(mode).Equal(ufs.FileMode(0o640))
+			g.Assert(size).Equal(int64(12))
+			g.Assert(name).Equal("my file.txt")
+		})
+
+		g.It("only keeps the permission bits of the mode", func() {
+			mode, _, _, err := parseScpHeader("104755 0 a")
+			g.Assert(err).IsNil()
+			g.Assert(mode).Equal(ufs.FileMode(0o755))
+		})
+
+		g.It("rejects invalid headers", func() {
+			for _, line := range []string{"", "0644", "0644 5", "0999 5 a", "rw 5 a", "0644 -1 a", "0644 five a"} {
+				_, _, _, err := parseScpHeader(line)
+				g.Assert(err).IsNotNil(line)
+			}
+		})
+
+		g.It("rejects names that are not a single path element", func() {
+			for _, name := range []string{"", ".", "..", "../a", "a/b", "/a", "a/"} {
+				_, _, _, err := parseScpHeader("0644 5 " + name)
+				g.Assert(err).IsNotNil(name)
+			}
+		})
+	})
+
+	g.Describe("scp sink", func() {
+		g.It("writes a file sent by the client", func() {
+			h := newTestHandler("*")
+			ch, out := scriptedChannel("C0755 5 a.txt\nhello\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "/a.txt"})).Equal(uint32(0))
+			g.Assert(out.String()).Equal("\x00\x00\x00")
+			g.Assert(readTestFile(h, "/a.txt")).Equal("hello")
+
+			st, err := h.fs.UnixFS().Stat("/a.txt")
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(ufs.FileMode(0o644))
+		})
+
+		g.It("does not write outside of the target directory", func() {
+			h := newTestHandler("*")
+			g.Assert(h.fs.CreateDirectory("dir", "/")).IsNil()
+			ch, _ := scriptedChannel("C0644 5 ../a.txt\nhello\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "/dir"})).Equal(uint32(1))
+			_, err := h.fs.UnixFS().Stat("/a.txt")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("rejects directories without -r", func() {
+			h := newTestHandler("*")
+			ch, _ := scriptedChannel("D0755 0 dir\nE\n")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "/"})).Equal(uint32(1))
+			_, err := h.fs.UnixFS().Stat("/dir")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("requires the target to be a directory with -d", func() {
+			h := newTestHandler("*")
+			ch, out := scriptedChannel("")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "-d", "/missing"})).Equal(uint32(1))
+			g.Assert(out.String()).Equal("\x01scp: /missing: not a directory\n")
+		})
+
+		g.It("skips files the user cannot create", func() {
+			h := newTestHandler(PermissionFileRead)
+			ch, out := scriptedChannel("C0644 5 a.txt\nC0644 0 b.txt\n")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "/"})).Equal(uint32(1))
+			g.Assert(out.String()).Equal("\x00\x01scp: /a.txt: permission denied\n\x01scp: /b.txt: permission denied\n")
+			_, err := h.fs.UnixFS().Stat("/a.txt")
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("does not write files in read-only mode", func() {
+			h := newTestHandler("*")
+			h.ro = true
+			ch, out := scriptedChannel("C0644 5 a.txt\n")
+			g.Assert(newScp(h, ch).Run([]string{"-t", "/"})).Equal(uint32(1))
+			g.Assert(out.String()).Equal("\x00\x01scp: /a.txt: the server is in read-only mode\n")
+		})
+	})
+
+	g.Describe("scp source", func() {
+		g.It("does not send files the user cannot read", func() {
+			h := newTestHandler(PermissionFileRead)
+			writeTestFile(h, "/a.txt", "hello")
+			ch, out := scriptedChannel("\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-f", "/a.txt"})).Equal(uint32(1))
+			g.Assert(out.String()).Equal("\x01scp: /a.txt: permission denied\n")
+		})
+
+		g.It("does not send directories without -r", func() {
+			h := newTestHandler("*")
+			g.Assert(h.fs.CreateDirectory("dir", "/")).IsNil()
+			ch, out := scriptedChannel("\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-f", "/dir"})).Equal(uint32(1))
+			g.Assert(out.String()).Equal("\x01scp: /dir: is a directory\n")
+		})
+
+		g.It("reports files that do not exist", func() {
+			h := newTestHandler("*")
+			ch, out := scriptedChannel("\x00")
+			g.Assert(newScp(h, ch).Run([]string{"-f", "/missing"})).Equal(uint32(1))
+			g.Assert(strings.HasPrefix(out.String(), "\x01scp: /missing: ")).IsTrue()
+		})
+	})
+
+	g.Describe("scp round trip", func() {
+		g.It("copies a single file", func() {
+			src, dst := newTestHandler("*"), newTestHandler("*")
+			writeTestFile(src, "/a.txt", "hello world")
+			sourceCode, sinkCode := scpRoundTrip(src, []string{"-f", "/a.txt"}, dst, []string{"-t", "/b.txt"})
+			g.Assert(sourceCode).Equal(uint32(0))
+			g.Assert(sinkCode).Equal(uint32(0))
+			g.Assert(readTestFile(dst, "/b.txt")).Equal("hello world")
+		})
+
+		g.It("copies multiple files into a directory", func() {
+			src, dst := newTestHandler("*"), newTestHandler("*")
+			writeTestFile(src, "/a.txt", "first")
+			writeTestFile(src, "/b.txt", strings.Repeat("second", 1000))
+			writeTestFile(src, "/empty.txt", "")
+			g.Assert(dst.fs.CreateDirectory("into", "/")).IsNil()
+			sourceCode, sinkCode := scpRoundTrip(src, []string{"-f", "/a.txt", "/b.txt", "/empty.txt"}, dst, []string{"-t", "-d", "/into"})
+			g.Assert(sourceCode).Equal(uint32(0))
+			g.Assert(sinkCode).Equal(uint32(0))
+			g.Assert(readTestFile(dst, "/into/a.txt")).Equal("first")
+			g.Assert(readTestFile(dst, "/into/b.txt")).Equal(strings.Repeat("second", 1000))
+			g.Assert(readTestFile(dst, "/into/empty.txt")).Equal("")
+		})
+
+		g.It("copies directories recursively and preserves times and modes", func() {
+			src, dst := newTestHandler("*"), newTestHandler("*")
+			g.Assert(src.fs.CreateDirectory("dir", "/")).IsNil()
+			g.Assert(src.fs.CreateDirectory("nested", "/dir")).IsNil()
+			writeTestFile(src, "/dir/a.txt", "first")
+			writeTestFile(src, "/dir/nested/b.sh", "second")
+			g.Assert(src.fs.Chmod("/dir/nested/b.sh", 0o750)).IsNil()
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			g.Assert(src.fs.Chtimes("/dir/nested/b.sh", mtime, mtime)).IsNil()
+
+			sourceCode, sinkCode := scpRoundTrip(src, []string{"-f", "-rp", "/dir"}, dst, []string{"-t", "-rp", "/"})
+			g.Assert(sourceCode).Equal(uint32(0))
+			g.Assert(sinkCode).Equal(uint32(0))
+			g.Assert(readTestFile(dst, "/dir/a.txt")).Equal("first")
+			g.Assert(readTestFile(dst, "/dir/nested/b.sh")).Equal("second")
+
+			st, err := dst.fs.UnixFS().Stat("/dir/nested/b.sh")
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(ufs.FileMode(0o750))
+			g.Assert(st.ModTime().Equal(mtime)).IsTrue()
+		})
+
+		g.It("continues after a file the sink cannot write", func() {
+			src, dst := newTestHandler("*"), newTestHandler("*")
+			writeTestFile(src, "/a.txt", "first")
+			writeTestFile(src, "/b.txt", "second")
+			g.Assert(dst.fs.CreateDirectory("a.txt", "/")).IsNil()
+			sourceCode, sinkCode := scpRoundTrip(src, []string{"-f", "/a.txt", "/b.txt"}, dst, []string{"-t", "/"})
+			g.Assert(sourceCode).Equal(uint32(1))
+			g.Assert(sinkCode).Equal(uint32(1))
+			g.Assert(readTestFile(dst, "/b.txt")).Equal("second")
+		})
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"emperror.dev/errors"
 	"github.com/apex/log"
@@ -104,6 +105,13 @@ func (c *SFTPServer) Run() error {
 
 	for {
 		if conn, _ := listener.Accept(); conn != nil {
+			// Drop connections from addresses that are not allowed, or have been banned,
+			// before performing the SSH handshake.
+			if err := limiter.checkAddress(conn.RemoteAddr().String()); err != nil {
+				log.WithField("ip", conn.RemoteAddr().String()).WithField("error", err).Debug("sftp: rejected inbound connection")
+				_ = conn.Close()
+				continue
+			}
 			go func(conn net.Conn) {
 				defer conn.Close()
 				if err := c.AcceptInbound(conn, conf); err != nil {
@@ -125,6 +133,12 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 	defer sconn.Close()
 	go ssh.DiscardRequests(reqs)
 
+	release, err := limiter.acquire(sconn.Permissions.Extensions["user"], sconn.Permissions.Extensions["uuid"])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
+
 	for ch := range chans {
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
@@ -138,15 +152,40 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 			continue
 		}
 
+		// Wait for the client to tell us what it wants to run on this channel before
+		// doing anything with it. SFTP clients request the "sftp" subsystem, while scp
+		// and rsync send an "exec" request containing the command to run.
+		selected := make(chan *ssh.Request, 1)
 		go func(in <-chan *ssh.Request) {
+			defer close(selected)
+			var done bool
 			for req := range in {
 				// Channels have a type that is dependent on the protocol. For SFTP
 				// this is "subsystem" with a payload that (should) be "sftp". Discard
 				// anything else we receive ("pty", "shell", etc)
-				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+				ok := false
+				if !done {
+					switch req.Type {
+					case "subsystem":
+						ok = requestPayload(req) == "sftp"
+					case "exec":
+						ok = true
+					}
+				}
+				req.Reply(ok, nil)
+				if ok {
+					done = true
+					selected <- req
+				}
 			}
 		}(requests)
 
+		req, ok := <-selected
+		if !ok {
+			_ = channel.Close()
+			continue
+		}
+
 		// If no UUID has been set on this inbound request then we can assume we
 		// have screwed up something in the authentication code. This is a sanity
 		// check, but should never be encountered (ideally...).
@@ -161,6 +200,7 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 			return s.ID() == uuid
 		})
 		if srv == nil {
+			_ = channel.Close()
 			continue
 		}
 
@@ -170,7 +210,17 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 		if err != nil {
 			return errors.WithStackIf(err)
 		}
-		rs := sftp.NewRequestServer(channel, handler.Handlers())
+
+		// Exec requests are limited to the scp and rsync commands, which are run against
+		// the server filesystem in the same way as any other SFTP request.
+		if req.Type == "exec" {
+			status := handler.Exec(channel, requestPayload(req))
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			_ = channel.Close()
+			continue
+		}
+
+		rs := sftp.NewRequestServer(newExtendedConn(channel, handler), handler.Handlers())
 		if err := rs.Serve(); err == io.EOF {
 			_ = rs.Close()
 		}
@@ -179,6 +229,16 @@ func (c *SFTPServer) AcceptInbound(conn net.Conn, config *ssh.ServerConfig) erro
 	return nil
 }
 
+// requestPayload returns the string value sent in the payload of a "subsystem"
+// or "exec" channel request, or an empty string if it cannot be parsed.
+func requestPayload(req *ssh.Request) string {
+	var payload struct{ Value string }
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		return ""
+	}
+	return payload.Value
+}
+
 // Generates a new ED25519 private key that is used for host authentication when
 // a user connects to the SFTP server.
 func (c *SFTPServer) generateED25519PrivateKey() error {
@@ -218,21 +278,50 @@ func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, t remote.Sftp
 	logger := log.WithFields(log.Fields{"subsystem": "sftp", "method": request.Type, "username": request.User, "ip": request.IP})
 	logger.Debug("validating credentials for SFTP connection")
 
+	// Don't send the request to the Panel at all if the address or username has been
+	// banned for making too many failed attempts.
+	if err := limiter.checkLogin(request.IP, request.User); err != nil {
+		logger.Debug("rejected login attempt from banned address or username")
+		return nil, &remote.SftpInvalidCredentialsError{}
+	}
+
 	if !validUsernameRegexp.MatchString(request.User) {
 		logger.Warn("failed to validate user credentials (invalid format)")
+		limiter.failure(request.IP, request.User, t)
 		return nil, &remote.SftpInvalidCredentialsError{}
 	}
 
-	resp, err := c.manager.Client().ValidateSftpCredentials(context.Background(), request)
-	if err != nil {
-		if _, ok := err.(*remote.SftpInvalidCredentialsError); ok {
-			logger.Warn("failed to validate user credentials (invalid username or password)")
+	// Reuse a recent successful login for the same credentials rather than asking
+	// the Panel again, which makes clients that open many connections much faster.
+	cache := config.Get().System.Sftp.CredentialCache
+	resp, ok := logins.get(request, time.Duration(cache.TTL)*time.Second)
+	if ok {
+		logger.Debug("using cached credentials for SFTP connection")
+	} else {
+		var err error
+		resp, err = c.manager.Client().ValidateSftpCredentials(context.Background(), request)
+		if err != nil {
+			if _, ok := err.(*remote.SftpInvalidCredentialsError); ok {
+				logger.Warn("failed to validate user credentials (invalid username or password)")
+				logins.remove(request)
+				limiter.failure(request.IP, request.User, t)
+				return nil, err
+			}
+			// If the Panel could not be reached fall back to a cached login, if there
+			// is one that has not gone completely stale.
+			stale, ok := logins.get(request, time.Duration(cache.StaleTTL)*time.Second)
+			if !ok {
+				logger.WithField("error", err).Error("encountered an error while trying to validate user credentials")
+				return nil, err
+			}
+			logger.WithField("error", err).Warn("failed to validate user credentials with the Panel, using stale cached credentials")
+			resp = stale
 		} else {
-			logger.WithField("error", err).Error("encountered an error while trying to validate user credentials")
+			logins.put(request, resp)
 		}
-		return nil, err
 	}
 
+	limiter.success(request.User)
 	logger.WithField("server", resp.Server).Debug("credentials validated and matched to server instance")
 	permissions := ssh.Permissions{
 		Extensions: map[string]string{
@@ -16,10 +16,25 @@ const (
 	InstallSink SinkName = "install"
 )
 
+// Line is a message pushed to a sink pool along with its sequence number. The
+// sequence number of each line is one greater than the line pushed before it.
+type Line struct {
+	Sequence uint64
+	Data     []byte
+}
+
 // SinkPool represents a pool with sinks.
 type SinkPool struct {
 	mu    sync.RWMutex
 	sinks []chan []byte
+	lines []chan Line
+
+	// The most recent lines pushed to the pool are kept in a ring so that a
+	// client which missed some of them can catch up.
+	historyMu sync.Mutex
+	history   []Line
+	next      int
+	sequence  uint64
 }
 
 // NewSinkPool returns a new empty SinkPool. A sink pool generally lives with a
@@ -28,6 +43,16 @@ func NewSinkPool() *SinkPool {
 	return &SinkPool{}
 }
 
+// NewSinkPoolWithHistory returns a new empty SinkPool that keeps the last size
+// lines pushed to it, which can be retrieved using Since.
+func NewSinkPoolWithHistory(size int) *SinkPool {
+	p := &SinkPool{}
+	if size > 0 {
+		p.history = make([]Line, 0, size)
+	}
+	return p
+}
+
 // On adds a channel to the sink pool instance.
 func (p *SinkPool) On(c chan []byte) {
 	p.mu.Lock()
@@ -64,6 +89,81 @@ func (p *SinkPool) Off(c chan []byte) {
 	}
 }
 
+// OnLine adds a channel to the sink pool instance which receives each message
+// along with its sequence number.
+func (p *SinkPool) OnLine(c chan Line) {
+	p.mu.Lock()
+	p.lines = append(p.lines, c)
+	p.mu.Unlock()
+}
+
+// OffLine removes a channel added with OnLine from the sink pool, closing it.
+// If no matching sink is found this function is a no-op.
+func (p *SinkPool) OffLine(c chan Line) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, sink := range p.lines {
+		if c != sink {
+			continue
+		}
+		p.lines = append(p.lines[:i], p.lines[i+1:]...)
+		if c != nil {
+			close(c)
+		}
+		return
+	}
+}
+
+// Sequence returns the sequence number of the last line pushed to the pool, or
+// zero if nothing has been pushed yet.
+func (p *SinkPool) Sequence() uint64 {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+	return p.sequence
+}
+
+// Since returns the lines pushed to the pool after the line with the given
+// sequence number, oldest first. Only the lines still in the history of the
+// pool can be returned, so if some of the lines after the sequence number have
+// already been dropped from the history the second value is false.
+func (p *SinkPool) Since(sequence uint64) ([]Line, bool) {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+
+	if sequence >= p.sequence {
+		return nil, true
+	}
+	var out []Line
+	for i := range p.history {
+		l := p.history[(p.next+i)%len(p.history)]
+		if l.Sequence > sequence {
+			out = append(out, l)
+		}
+	}
+	return out, p.sequence-sequence == uint64(len(out))
+}
+
+// record assigns the next sequence number to the data and adds it to the
+// history of the pool.
+func (p *SinkPool) record(data []byte) Line {
+	p.historyMu.Lock()
+	defer p.historyMu.Unlock()
+
+	p.sequence++
+	l := Line{Sequence: p.sequence, Data: data}
+	if cap(p.history) == 0 {
+		return l
+	}
+	if len(p.history) < cap(p.history) {
+		p.history = append(p.history, l)
+	} else {
+		p.history[p.next] = l
+		p.next = (p.next + 1) % len(p.history)
+	}
+	return l
+}
+
 // Destroy destroys the pool by removing and closing all sinks and destroying
 // all of the channels that are present.
 func (p *SinkPool) Destroy() {
@@ -75,11 +175,19 @@ func (p *SinkPool) Destroy() {
 			close(c)
 		}
 	}
+	for _, c := range p.lines {
+		if c != nil {
+			close(c)
+		}
+	}
 
 	p.sinks = nil
+	p.lines = nil
 }
 
-// Push sends a given message to each of the channels registered in the pool.
+// Push sends a given message to each of the channels registered in the pool,
+// assigning it the next sequence number and adding it to the history of the
+// pool if one is kept.
 // This will use a Ring Buffer channel in order to avoid blocking the channel
 // sends, and attempt to push though the most recent messages in the queue in
 // favor of the oldest messages.
@@ -96,26 +204,40 @@ func (p *SinkPool) Destroy() {
 // to attempt its send concurrently thus making the total blocking time of this
 // function "O(1)" instead of "O(n)".
 func (p *SinkPool) Push(data []byte) {
+	l := p.record(data)
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	var wg sync.WaitGroup
-	wg.Add(len(p.sinks))
+	wg.Add(len(p.sinks) + len(p.lines))
 	for _, c := range p.sinks {
 		go func(c chan []byte) {
 			defer wg.Done()
-			select {
-			case c <- data:
-			case <-time.After(time.Millisecond * 10):
-				// If there is nothing in the channel to read, but we also cannot write
-				// to the channel, just skip over sending data. If we don't do this you'll
-				// end up blocking the application on the channel read below.
-				if len(c) == 0 {
-					break
-				}
-				<-c
-				c <- data
-			}
+			send(c, data)
+		}(c)
+	}
+	for _, c := range p.lines {
+		go func(c chan Line) {
+			defer wg.Done()
+			send(c, l)
 		}(c)
 	}
 	wg.Wait()
 }
+
+// send pushes a value onto the channel, dropping the oldest value in the channel
+// if it is full and not being drained.
+func send[T any](c chan T, v T) {
+	select {
+	case c <- v:
+	case <-time.After(time.Millisecond * 10):
+		// If there is nothing in the channel to read, but we also cannot write
+		// to the channel, just skip over sending data. If we don't do this you'll
+		// end up blocking the application on the channel read below.
+		if len(c) == 0 {
+			break
+		}
+		<-c
+		c <- v
+	}
+}
@@ -243,3 +243,65 @@ func TestSink(t *testing.T) {
 		})
 	})
 }
+
+func TestSinkPool_History(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("SinkPool#Since", func() {
+		var pool *SinkPool
+		g.BeforeEach(func() {
+			pool = NewSinkPoolWithHistory(3)
+		})
+
+		g.It("assigns increasing sequence numbers to pushed lines", func() {
+			ch := make(chan Line, 2)
+			pool.OnLine(ch)
+
+			pool.Push([]byte("first"))
+			pool.Push([]byte("second"))
+
+			g.Assert(<-ch).Equal(Line{Sequence: 1, Data: []byte("first")})
+			g.Assert(<-ch).Equal(Line{Sequence: 2, Data: []byte("second")})
+			g.Assert(pool.Sequence()).Equal(uint64(2))
+		})
+
+		g.It("returns only the lines after the sequence number", func() {
+			pool.Push([]byte("first"))
+			pool.Push([]byte("second"))
+
+			lines, complete := pool.Since(1)
+			g.Assert(complete).IsTrue()
+			g.Assert(lines).Equal([]Line{{Sequence: 2, Data: []byte("second")}})
+
+			lines, complete = pool.Since(2)
+			g.Assert(complete).IsTrue()
+			g.Assert(len(lines)).IsZero()
+		})
+
+		g.It("drops the oldest lines once the history is full", func() {
+			for i := 1; i <= 5; i++ {
+				pool.Push([]byte(fmt.Sprintf("line %d", i)))
+			}
+
+			lines, complete := pool.Since(0)
+			g.Assert(complete).IsFalse()
+			g.Assert(len(lines)).Equal(3)
+			g.Assert(lines[0].Sequence).Equal(uint64(3))
+			g.Assert(lines[2].Data).Equal([]byte("line 5"))
+
+			lines, complete = pool.Since(3)
+			g.Assert(complete).IsTrue()
+			g.Assert(len(lines)).Equal(2)
+		})
+
+		g.It("keeps no history when created without it", func() {
+			pool = NewSinkPool()
+			pool.Push([]byte("first"))
+
+			lines, complete := pool.Since(0)
+			g.Assert(complete).IsFalse()
+			g.Assert(len(lines)).IsZero()
+			g.Assert(pool.Sequence()).Equal(uint64(1))
+		})
+	})
+}